@@ -15,6 +15,8 @@ import (
 type appInfo struct {
 	Name    string `json:"name"`
 	Version string `json:"version"`
+	// bundle format, empty for nix or [formatRootfs] for a portable bundle
+	Format string `json:"format,omitempty"`
 
 	// passed through to [fst.Config]
 	ID string `json:"id"`
@@ -43,6 +45,13 @@ type appInfo struct {
 	// passed through to [fst.Config]
 	Enablements system.Enablement `json:"enablements"`
 
+	// passed through to [fst.Config], relative paths are resolved against the bundle metadata;
+	// resolved on installation and stored with the generation as part of its permission grant
+	Extends []string `json:"extends,omitempty"`
+
+	// passed through to [fst.ContainerConfig]
+	Env map[string]string `json:"env,omitempty"`
+
 	// passed through to [fst.Config]
 	Multiarch bool `json:"multiarch,omitempty"`
 	// passed through to [fst.Config]
@@ -54,6 +63,13 @@ type appInfo struct {
 	Mesa string `json:"mesa,omitempty"`
 	// store path to nixGL source
 	NixGL string `json:"nix_gl,omitempty"`
+	// path to root filesystem within a portable bundle, directory tree or tar archive
+	Rootfs string `json:"rootfs,omitempty"`
+	// absolute path to program in the root filesystem of a portable bundle
+	Path string `json:"path,omitempty"`
+	// args of program in the root filesystem of a portable bundle, defaults to path
+	Args []string `json:"args,omitempty"`
+
 	// store path to activate-and-exec script
 	Launcher string `json:"launcher"`
 	// store path to /run/current-system
@@ -62,7 +78,7 @@ type appInfo struct {
 	ActivationPackage string `json:"activation_package"`
 }
 
-func (app *appInfo) toFst(pathSet *appPathSet, argv []string, flagDropShell bool) *fst.Config {
+func (app *appInfo) toFst(pathSet *appPathSet, base *fst.Config, argv []string, flagDropShell bool) *fst.Config {
 	config := &fst.Config{
 		ID: app.ID,
 
@@ -89,30 +105,37 @@ func (app *appInfo) toFst(pathSet *appPathSet, argv []string, flagDropShell bool
 			Userns:     app.Userns,
 			Net:        app.Net,
 			Device:     app.Device,
+			GPU:        app.GPU,
 			Tty:        app.Tty || flagDropShell,
 			MapRealUID: app.MapRealUID,
-			Filesystem: []*fst.FilesystemConfig{
-				{Src: path.Join(pathSet.nixPath, "store"), Dst: "/nix/store", Must: true},
-				{Src: pathSet.metaPath, Dst: path.Join(fst.Tmp, "app"), Must: true},
-				{Src: "/etc/resolv.conf"},
-				{Src: "/sys/block"},
-				{Src: "/sys/bus"},
-				{Src: "/sys/class"},
-				{Src: "/sys/dev"},
-				{Src: "/sys/devices"},
-			},
-			Link: [][2]string{
-				{app.CurrentSystem, "/run/current-system"},
-				{"/run/current-system/sw/bin", "/bin"},
-				{"/run/current-system/sw/bin", "/usr/bin"},
-			},
-			Etc:     path.Join(pathSet.cacheDir, "etc"),
-			AutoEtc: true,
-		},
-		ExtraPerms: []*fst.ExtraPermConfig{
-			{Path: dataHome, Execute: true},
-			{Ensure: true, Path: pathSet.baseDir, Read: true, Write: true, Execute: true},
+			Env:        app.Env,
 		},
+		ExtraPerms: pathSet.extraPerms(),
+	}
+	if app.isPortable() {
+		config.Shell = rootfsShell
+		if !flagDropShell {
+			config.Path = app.Path
+		}
+		app.applyRootfs(config.Container, pathSet)
+	} else {
+		config.Container.Filesystem = []*fst.FilesystemConfig{
+			{Src: path.Join(pathSet.nixPath, "store"), Dst: "/nix/store", Must: true},
+			{Src: pathSet.metaPath, Dst: path.Join(fst.Tmp, "app"), Must: true},
+			{Src: "/etc/resolv.conf"},
+			{Src: "/sys/block"},
+			{Src: "/sys/bus"},
+			{Src: "/sys/class"},
+			{Src: "/sys/dev"},
+			{Src: "/sys/devices"},
+		}
+		config.Container.Link = [][2]string{
+			{app.CurrentSystem, "/run/current-system"},
+			{"/run/current-system/sw/bin", "/bin"},
+			{"/run/current-system/sw/bin", "/usr/bin"},
+		}
+		config.Container.Etc = path.Join(pathSet.cacheDir, "etc")
+		config.Container.AutoEtc = true
 	}
 	if app.Multiarch {
 		config.Container.Seccomp |= seccomp.FilterMultiarch
@@ -120,9 +143,50 @@ func (app *appInfo) toFst(pathSet *appPathSet, argv []string, flagDropShell bool
 	if app.Bluetooth {
 		config.Container.Seccomp |= seccomp.FilterBluetooth
 	}
+	if base != nil {
+		config = fst.Merge(base, config)
+	}
 	return config
 }
 
+// resolveBase merges base configurations named by Extends of app, which is loaded from name.
+// A nil config is returned if app does not extend any configuration.
+func (app *appInfo) resolveBase(name string) (*fst.Config, error) {
+	if len(app.Extends) == 0 {
+		return nil, nil
+	}
+	base := &fst.Config{Extends: app.Extends}
+	if err := base.ResolveExtends(name); err != nil {
+		return nil, err
+	}
+	return base, nil
+}
+
+// loadBase reads the base configuration of app stored with the generation described by pathSet.
+// A nil config is returned if app does not extend any configuration.
+func loadBase(app *appInfo, pathSet *appPathSet) (*fst.Config, error) {
+	if len(app.Extends) == 0 {
+		return nil, nil
+	}
+	base := new(fst.Config)
+	if data, err := os.ReadFile(pathSet.basePath); err != nil {
+		return nil, err
+	} else if err = json.Unmarshal(data, base); err != nil {
+		return nil, err
+	}
+	return base, nil
+}
+
+// storeBase writes the resolved base configuration of an app generation to name.
+func storeBase(name string, base *fst.Config) error {
+	if data, err := json.Marshal(base); err != nil {
+		return err
+	} else if err = os.WriteFile(name+"~", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(name+"~", name)
+}
+
 func loadAppInfo(name string, beforeFail func()) *appInfo {
 	bundle := new(appInfo)
 	if f, err := os.Open(name); err != nil {
@@ -140,6 +204,15 @@ func loadAppInfo(name string, beforeFail func()) *appInfo {
 		beforeFail()
 		log.Fatal("application identifier must not be empty")
 	}
+	if bundle.isPortable() {
+		if err := bundle.checkPortable(); err != nil {
+			beforeFail()
+			log.Fatalf("invalid portable bundle: %v", err)
+		}
+	} else if bundle.Format != "" {
+		beforeFail()
+		log.Fatalf("unsupported bundle format %q", bundle.Format)
+	}
 
 	return bundle
 }
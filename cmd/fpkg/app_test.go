@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/system"
+)
+
+func TestResolveBase(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(path.Join(dir, "base.json"),
+		[]byte(`{"enablements":8,"groups":["video"],"container":{"devel":true}}`), 0644); err != nil {
+		t.Fatalf("WriteFile: error = %v", err)
+	}
+
+	if base, err := (&appInfo{ID: "org.example"}).resolveBase(path.Join(dir, "bundle.json")); err != nil || base != nil {
+		t.Errorf("resolveBase: %#v, error = %v", base, err)
+	}
+
+	a := &appInfo{ID: "org.example", Extends: []string{"base.json"}}
+	base, err := a.resolveBase(path.Join(dir, "bundle.json"))
+	if err != nil {
+		t.Fatalf("resolveBase: error = %v", err)
+	}
+	if base.Extends != nil || base.Enablements != system.EPulse || !base.Container.Devel {
+		t.Fatalf("resolveBase: %#v", base)
+	}
+
+	pathSet := (&appPathSet{baseDir: path.Join(dir, "org.example")}).atGeneration(0)
+	if err = os.MkdirAll(pathSet.genDir, 0755); err != nil {
+		t.Fatalf("MkdirAll: error = %v", err)
+	}
+	if err = storeBase(pathSet.basePath, base); err != nil {
+		t.Fatalf("storeBase: error = %v", err)
+	}
+	// the stored configuration is authoritative once installed
+	if err = os.Remove(path.Join(dir, "base.json")); err != nil {
+		t.Fatalf("Remove: error = %v", err)
+	}
+	if got, err := loadBase(a, pathSet); err != nil {
+		t.Fatalf("loadBase: error = %v", err)
+	} else if !reflect.DeepEqual(got, base) {
+		t.Errorf("loadBase: %#v, want %#v", got, base)
+	}
+
+	config := a.toFst(pathSet, base, []string{"/bin/sh"}, false)
+	if config.Enablements != system.EPulse || !config.Container.Devel || !reflect.DeepEqual(config.Groups, []string{"video"}) {
+		t.Errorf("toFst: %#v", config)
+	}
+	if !reflect.DeepEqual(a.permissions(base), []string{"devel", "enablement pulseaudio", "group video"}) {
+		t.Errorf("permissions: %q", a.permissions(base))
+	}
+}
+
+func TestLoadBaseMissing(t *testing.T) {
+	pathSet := (&appPathSet{baseDir: t.TempDir()}).atGeneration(0)
+	if _, err := loadBase(&appInfo{Extends: []string{"base.json"}}, pathSet); !os.IsNotExist(err) {
+		t.Errorf("loadBase: error = %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+
+	"git.gensokyo.uk/security/fortify/internal/zstd"
+)
+
+var (
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	gzipMagic = []byte{0x1f, 0x8b}
+)
+
+// archiveError describes an archive entry rejected by extract.
+type archiveError struct {
+	// name of the entry in the archive
+	Name string
+	Err  error
+}
+
+func (e *archiveError) Error() string { return fmt.Sprintf("archive entry %q: %v", e.Name, e.Err) }
+func (e *archiveError) Unwrap() error { return e.Err }
+
+var (
+	errTraversal = errors.New("path escapes extraction root")
+	errDevice    = errors.New("device nodes are not allowed")
+	errType      = errors.New("unsupported entry type")
+)
+
+// decompress returns a reader of the tar stream held by r, which is optionally compressed with zstd or gzip.
+func decompress(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(zstdMagic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, zstdMagic):
+		return zstd.NewReader(br), nil
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	default:
+		return br, nil
+	}
+}
+
+// extract extracts the tar stream r to the existing directory root.
+// Entries are never written through symbolic links, and entries escaping root or describing
+// device nodes are rejected with [archiveError]. Directory modes are applied after all entries are extracted.
+func extract(r io.Reader, root string) error {
+	type dirMode struct {
+		name string
+		mode fs.FileMode
+	}
+	var dirs []dirMode
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return err
+		}
+
+		name, err := entryPath(root, hdr.Name)
+		if err != nil {
+			return &archiveError{hdr.Name, err}
+		}
+		if name == root {
+			// top level directory entry
+			continue
+		}
+		if err = ensureParent(root, name); err != nil {
+			return &archiveError{hdr.Name, err}
+		}
+		mode := hdr.FileInfo().Mode().Perm()
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err = os.Mkdir(name, 0700); err != nil && !errors.Is(err, fs.ErrExist) {
+				return err
+			} else if err != nil {
+				if s, err := os.Lstat(name); err != nil {
+					return err
+				} else if !s.IsDir() {
+					return &archiveError{hdr.Name, syscall.ENOTDIR}
+				}
+			}
+			dirs = append(dirs, dirMode{name, mode})
+
+		case tar.TypeReg:
+			if err = writeFile(name, tr, mode); err != nil {
+				return err
+			}
+			if err = os.Chtimes(name, hdr.ModTime, hdr.ModTime); err != nil {
+				return err
+			}
+
+		case tar.TypeSymlink:
+			// link targets are never followed during extraction
+			if err = os.Symlink(hdr.Linkname, name); err != nil {
+				return err
+			}
+
+		case tar.TypeLink:
+			var target string
+			if target, err = entryPath(root, hdr.Linkname); err != nil {
+				return &archiveError{hdr.Name, err}
+			}
+			if err = ensureParent(root, target); err != nil {
+				return &archiveError{hdr.Name, err}
+			}
+			if s, err := os.Lstat(target); err != nil {
+				return err
+			} else if !s.Mode().IsRegular() {
+				return &archiveError{hdr.Name, errType}
+			}
+			if err = os.Link(target, name); err != nil {
+				return err
+			}
+
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			return &archiveError{hdr.Name, errDevice}
+
+		case tar.TypeXGlobalHeader:
+			// pax global headers are handled by the reader
+
+		default:
+			return &archiveError{hdr.Name, errType}
+		}
+	}
+
+	// restore modes of read-only directories last
+	for _, d := range slices.Backward(dirs) {
+		if err := os.Chmod(d.name, d.mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryPath returns the path of an archive entry named name within root.
+func entryPath(root, name string) (string, error) {
+	if path.IsAbs(name) || strings.Contains(name, "\x00") || slices.Contains(strings.Split(name, "/"), "..") {
+		return "", errTraversal
+	}
+	return path.Join(root, name), nil
+}
+
+// ensureParent checks that every parent directory of name within root is a directory and not a symbolic link.
+// Missing parent directories are created.
+func ensureParent(root, name string) error {
+	rel := strings.TrimPrefix(path.Dir(name), root)
+	p := root
+	for _, segment := range strings.Split(rel, "/") {
+		if segment == "" {
+			continue
+		}
+		p = path.Join(p, segment)
+		if s, err := os.Lstat(p); errors.Is(err, fs.ErrNotExist) {
+			if err = os.Mkdir(p, 0700); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		} else if !s.IsDir() {
+			return errTraversal
+		}
+	}
+	return nil
+}
+
+// writeFile creates the regular file name holding data read from r.
+func writeFile(name string, r io.Reader, mode fs.FileMode) error {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_EXCL|os.O_WRONLY|syscall.O_NOFOLLOW, mode|0200)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	// mode is not subject to umask
+	return os.Chmod(name, mode)
+}
+
+// removeAll removes name and any children it contains, including read-only directories.
+// Symbolic links are removed without following them.
+func removeAll(name string) error {
+	if s, err := os.Lstat(name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return err
+	} else if !s.IsDir() {
+		return os.Remove(name)
+	}
+
+	if err := fs.WalkDir(os.DirFS(name), ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.Chmod(path.Join(name, p), 0700)
+		}
+		return nil
+	}); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return os.RemoveAll(name)
+}
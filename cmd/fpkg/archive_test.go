@@ -0,0 +1,188 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"testing"
+)
+
+type tarEntry struct {
+	name     string
+	typeflag byte
+	mode     int64
+	linkname string
+	data     string
+}
+
+func mustTar(t *testing.T, entries []tarEntry) []byte {
+	buf := new(bytes.Buffer)
+	w := tar.NewWriter(buf)
+	for _, e := range entries {
+		if err := w.WriteHeader(&tar.Header{
+			Name:     e.name,
+			Typeflag: e.typeflag,
+			Mode:     e.mode,
+			Linkname: e.linkname,
+			Size:     int64(len(e.data)),
+		}); err != nil {
+			t.Fatalf("WriteHeader: error = %v", err)
+		}
+		if _, err := w.Write([]byte(e.data)); err != nil {
+			t.Fatalf("Write: error = %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtract(t *testing.T) {
+	// replaced with a directory outside the extraction root
+	const outsidePlaceholder = "@outside"
+
+	testCases := []struct {
+		name    string
+		entries []tarEntry
+		wantErr error
+	}{
+		{"valid", []tarEntry{
+			{name: "./", typeflag: tar.TypeDir, mode: 0755},
+			{name: "./nix/", typeflag: tar.TypeDir, mode: 0555},
+			{name: "./nix/store/", typeflag: tar.TypeDir, mode: 0555},
+			{name: "./nix/store/hello", typeflag: tar.TypeReg, mode: 0444, data: "hello world\n"},
+			{name: "./nix/store/link", typeflag: tar.TypeLink, linkname: "nix/store/hello"},
+			{name: "./etc/os-release", typeflag: tar.TypeSymlink, linkname: "/nix/store/os-release"},
+			{name: "bundle.json", typeflag: tar.TypeReg, mode: 0644, data: "{}"},
+		}, nil},
+
+		{"absolute", []tarEntry{
+			{name: "/etc/passwd", typeflag: tar.TypeReg, mode: 0644},
+		}, errTraversal},
+		{"parent", []tarEntry{
+			{name: "etc/../../passwd", typeflag: tar.TypeReg, mode: 0644},
+		}, errTraversal},
+		{"symlink parent", []tarEntry{
+			{name: "etc", typeflag: tar.TypeSymlink, linkname: outsidePlaceholder},
+			{name: "etc/passwd", typeflag: tar.TypeReg, mode: 0644},
+		}, errTraversal},
+		{"symlink overwrite", []tarEntry{
+			{name: "passwd", typeflag: tar.TypeSymlink, linkname: outsidePlaceholder + "/passwd"},
+			{name: "passwd", typeflag: tar.TypeReg, mode: 0644},
+		}, fs.ErrExist},
+		{"hard link parent", []tarEntry{
+			{name: "link", typeflag: tar.TypeLink, linkname: "../passwd"},
+		}, errTraversal},
+		{"hard link symlink", []tarEntry{
+			{name: "etc", typeflag: tar.TypeSymlink, linkname: outsidePlaceholder},
+			{name: "passwd", typeflag: tar.TypeLink, linkname: "etc/passwd"},
+		}, errTraversal},
+		{"char", []tarEntry{
+			{name: "dev/null", typeflag: tar.TypeChar, mode: 0666},
+		}, errDevice},
+		{"block", []tarEntry{
+			{name: "dev/sda", typeflag: tar.TypeBlock, mode: 0660},
+		}, errDevice},
+		{"fifo", []tarEntry{
+			{name: "fifo", typeflag: tar.TypeFifo, mode: 0600},
+		}, errDevice},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			root, outside := t.TempDir(), t.TempDir()
+			t.Cleanup(func() {
+				if err := removeAll(root); err != nil {
+					t.Errorf("removeAll: error = %v", err)
+				}
+			})
+			entries := slices.Clone(tc.entries)
+			for i := range entries {
+				entries[i].linkname = strings.Replace(entries[i].linkname, outsidePlaceholder, outside, 1)
+			}
+
+			err := extract(bytes.NewReader(mustTar(t, entries)), root)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("extract: error = %v, want %v", err, tc.wantErr)
+			}
+			if d, err := os.ReadDir(outside); err != nil {
+				t.Fatalf("ReadDir: error = %v", err)
+			} else if len(d) != 0 {
+				t.Fatalf("extract: wrote %d entries outside root", len(d))
+			}
+			if tc.wantErr != nil {
+				return
+			}
+
+			if data, err := os.ReadFile(path.Join(root, "nix/store/link")); err != nil {
+				t.Fatalf("ReadFile: error = %v", err)
+			} else if string(data) != "hello world\n" {
+				t.Errorf("ReadFile: %q", string(data))
+			}
+			if s, err := os.Stat(path.Join(root, "nix/store")); err != nil {
+				t.Fatalf("Stat: error = %v", err)
+			} else if s.Mode().Perm() != 0555 {
+				t.Errorf("Stat: mode = %v", s.Mode())
+			}
+			if target, err := os.Readlink(path.Join(root, "etc/os-release")); err != nil {
+				t.Fatalf("Readlink: error = %v", err)
+			} else if target != "/nix/store/os-release" {
+				t.Errorf("Readlink: %q", target)
+			}
+		})
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	want := mustTar(t, []tarEntry{{name: "hello", typeflag: tar.TypeReg, mode: 0444, data: "hello world\n"}})
+	gz := new(bytes.Buffer)
+	w := gzip.NewWriter(gz)
+	if _, err := w.Write(want); err != nil {
+		t.Fatalf("Write: error = %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: error = %v", err)
+	}
+	zst, err := os.ReadFile("testdata/hello.tar.zst")
+	if err != nil {
+		t.Fatalf("ReadFile: error = %v", err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		data []byte
+	}{
+		{"tar", want},
+		{"gzip", gz.Bytes()},
+		{"zstd", zst},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := decompress(bytes.NewReader(tc.data))
+			if err != nil {
+				t.Fatalf("decompress: error = %v", err)
+			}
+			root := t.TempDir()
+			t.Cleanup(func() { _ = removeAll(root) })
+			if err = extract(r, root); err != nil {
+				t.Fatalf("extract: error = %v", err)
+			}
+
+			name := path.Join(root, "hello")
+			if tc.name == "zstd" {
+				name = path.Join(root, "dir/hello")
+			}
+			if data, err := os.ReadFile(name); err != nil {
+				t.Fatalf("ReadFile: error = %v", err)
+			} else if string(data) != "hello world\n" {
+				t.Errorf("ReadFile: %q", string(data))
+			}
+		})
+	}
+}
@@ -41,11 +41,13 @@
   direct_wayland ? false,
   system_bus ? null,
   session_bus ? null,
+  extends ? [ ],
 
   allow_wayland ? true,
   allow_x11 ? false,
   allow_dbus ? true,
   allow_pulse ? true,
+  allow_pipewire ? false,
   gpu ? allow_wayland || allow_x11,
 }:
 
@@ -158,6 +160,7 @@ let
       direct_wayland
       system_bus
       gpu
+      extends
       ;
 
     session_bus =
@@ -171,7 +174,7 @@ let
           broadcast = { };
         });
 
-    enablements = (if allow_wayland then 1 else 0) + (if allow_x11 then 2 else 0) + (if allow_dbus then 4 else 0) + (if allow_pulse then 8 else 0);
+    enablements = (if allow_wayland then 1 else 0) + (if allow_x11 then 2 else 0) + (if allow_dbus then 4 else 0) + (if allow_pulse then 8 else 0) + (if allow_pipewire then 16 else 0);
 
     mesa = if gpu then mesaWrappers else null;
     nix_gl = if gpu then nixGL else null;
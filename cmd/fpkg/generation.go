@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+/*
+	Every installation of an app creates a new generation located at ${baseDir}/${generation}.
+	The symbolic link ${baseDir}/current names the generation in use, followed by the generation
+	it replaced if any, as in 3:2. Both are recorded by the same link so a switch is a single
+	rename(2). Apps installed before generations were introduced hold their metadata and cache
+	directly in baseDir, which is treated as generation 0.
+*/
+
+// genCurrent names the link recording the generation in use and the generation it replaced.
+const genCurrent = "current"
+
+// readGeneration returns the generation in use and the generation it replaced, recorded in baseDir.
+// If the link does not exist, ok is false and current is 0. If no generation was replaced, prev is -1.
+func readGeneration(baseDir string) (current, prev int, ok bool, err error) {
+	var target string
+	if target, err = os.Readlink(path.Join(baseDir, genCurrent)); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return 0, -1, false, err
+	}
+
+	s, p, hasPrev := strings.Cut(target, ":")
+	prev = -1
+	if current, err = parseGeneration(s); err != nil {
+		return 0, -1, false, fmt.Errorf("invalid generation %q", target)
+	}
+	if hasPrev {
+		if prev, err = parseGeneration(p); err != nil {
+			return 0, -1, false, fmt.Errorf("invalid generation %q", target)
+		}
+	}
+	return current, prev, true, nil
+}
+
+// parseGeneration parses the canonical decimal representation of a generation.
+func parseGeneration(s string) (int, error) {
+	gen, err := strconv.Atoi(s)
+	if err == nil && (gen < 0 || strconv.Itoa(gen) != s) {
+		err = strconv.ErrSyntax
+	}
+	return gen, err
+}
+
+// setGeneration atomically records generation current as in use in baseDir, replacing generation prev,
+// which is -1 if no generation was replaced.
+func setGeneration(baseDir string, current, prev int) error {
+	target := strconv.Itoa(current)
+	if prev >= 0 {
+		target += ":" + strconv.Itoa(prev)
+	}
+
+	p := path.Join(baseDir, genCurrent)
+	if err := os.Remove(p + "~"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Symlink(target, p+"~"); err != nil {
+		return err
+	}
+	return os.Rename(p+"~", p)
+}
+
+// generations returns generations present in baseDir in ascending order, including incomplete ones.
+func generations(baseDir string) ([]int, error) {
+	d, err := os.ReadDir(baseDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var gens []int
+	for _, ent := range d {
+		switch {
+		case ent.Name() == "app" || ent.Name() == "cache":
+			// generation 0 predates generation directories
+			if !slices.Contains(gens, 0) {
+				gens = append(gens, 0)
+			}
+		case ent.IsDir():
+			if gen, err := strconv.Atoi(ent.Name()); err == nil && gen > 0 && strconv.Itoa(gen) == ent.Name() {
+				gens = append(gens, gen)
+			}
+		}
+	}
+	slices.Sort(gens)
+	return gens, nil
+}
+
+// nextGeneration returns the generation following every generation present in baseDir.
+func nextGeneration(baseDir string) (int, error) {
+	gens, err := generations(baseDir)
+	if err != nil || len(gens) == 0 {
+		return 1, err
+	}
+	return gens[len(gens)-1] + 1, nil
+}
+
+// generationPaths returns paths making up generation gen of the app described by pathSet,
+// ordered so each path is removed before its parent.
+func (pathSet *appPathSet) generationPaths(gen int) []string {
+	p := pathSet.atGeneration(gen)
+	names := []string{p.cacheDir, p.metaPath, p.metaPath + "~", p.grantPath, p.grantPath + "~", p.basePath, p.basePath + "~"}
+	if p.genDir != p.baseDir {
+		names = append(names, p.genDir)
+	}
+	return names
+}
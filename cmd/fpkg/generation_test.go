@@ -0,0 +1,156 @@
+package main
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"testing"
+)
+
+func TestGenerations(t *testing.T) {
+	testCases := []struct {
+		name     string
+		dirs     []string
+		files    []string
+		want     []int
+		wantNext int
+	}{
+		{"empty", nil, nil, nil, 1},
+		{"legacy", []string{"cache", "files"}, []string{"app"}, []int{0}, 1},
+		{"legacy cache", []string{"cache"}, nil, []int{0}, 1},
+		{"generations", []string{"files", "3", "1", "10"}, nil, []int{1, 3, 10}, 11},
+		{"mixed", []string{"cache", "2"}, []string{"app", "current"}, []int{0, 2}, 3},
+		{"ignored", []string{"files", "0", "-1", "01", "+2", "x"}, []string{"4"}, nil, 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			baseDir := t.TempDir()
+			for _, name := range tc.dirs {
+				if err := os.Mkdir(path.Join(baseDir, name), 0700); err != nil {
+					t.Fatalf("Mkdir: error = %v", err)
+				}
+			}
+			for _, name := range tc.files {
+				if err := os.WriteFile(path.Join(baseDir, name), nil, 0600); err != nil {
+					t.Fatalf("WriteFile: error = %v", err)
+				}
+			}
+
+			if got, err := generations(baseDir); err != nil {
+				t.Fatalf("generations: error = %v", err)
+			} else if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("generations: %v, want %v", got, tc.want)
+			}
+			if got, err := nextGeneration(baseDir); err != nil {
+				t.Fatalf("nextGeneration: error = %v", err)
+			} else if got != tc.wantNext {
+				t.Errorf("nextGeneration: %d, want %d", got, tc.wantNext)
+			}
+		})
+	}
+
+	t.Run("nonexistent", func(t *testing.T) {
+		if got, err := generations(path.Join(t.TempDir(), "app")); err != nil || got != nil {
+			t.Errorf("generations: %v, error = %v", got, err)
+		}
+	})
+}
+
+func TestGeneration(t *testing.T) {
+	baseDir := t.TempDir()
+
+	if gen, prev, ok, err := readGeneration(baseDir); err != nil || ok || gen != 0 || prev != -1 {
+		t.Fatalf("readGeneration: %d, %d, %v, error = %v", gen, prev, ok, err)
+	}
+
+	for _, want := range [][2]int{{1, -1}, {0, 1}, {7, 0}, {8, 7}} {
+		if err := setGeneration(baseDir, want[0], want[1]); err != nil {
+			t.Fatalf("setGeneration: error = %v", err)
+		}
+		if gen, prev, ok, err := readGeneration(baseDir); err != nil || !ok || gen != want[0] || prev != want[1] {
+			t.Fatalf("readGeneration: %d, %d, %v, error = %v, want %v", gen, prev, ok, err, want)
+		}
+	}
+	if target, err := os.Readlink(path.Join(baseDir, genCurrent)); err != nil || target != "8:7" {
+		t.Errorf("Readlink: %q, error = %v", target, err)
+	}
+	if _, err := os.Lstat(path.Join(baseDir, genCurrent+"~")); !os.IsNotExist(err) {
+		t.Errorf("Lstat: error = %v", err)
+	}
+
+	for _, target := range []string{"-1", "current", "1x", "1:", ":1", "1:-1", "01", "1:2:3"} {
+		dir := t.TempDir()
+		if err := os.Symlink(target, path.Join(dir, genCurrent)); err != nil {
+			t.Fatalf("Symlink: error = %v", err)
+		}
+		if _, _, _, err := readGeneration(dir); err == nil {
+			t.Errorf("readGeneration: %q unexpected success", target)
+		}
+	}
+}
+
+func TestAtGeneration(t *testing.T) {
+	pathSet := &appPathSet{baseDir: "/var/lib/fortify/0/org.example", homeDir: "/var/lib/fortify/0/org.example/files"}
+
+	testCases := []struct {
+		gen  int
+		want *appPathSet
+		perm int
+		rm   []string
+	}{
+		{0, &appPathSet{
+			baseDir:    "/var/lib/fortify/0/org.example",
+			homeDir:    "/var/lib/fortify/0/org.example/files",
+			genDir:     "/var/lib/fortify/0/org.example",
+			metaPath:   "/var/lib/fortify/0/org.example/app",
+			grantPath:  "/var/lib/fortify/0/org.example/grant",
+			basePath:   "/var/lib/fortify/0/org.example/base",
+			cacheDir:   "/var/lib/fortify/0/org.example/cache",
+			nixPath:    "/var/lib/fortify/0/org.example/cache/nix",
+			rootfsPath: "/var/lib/fortify/0/org.example/cache/rootfs",
+		}, 2, []string{
+			"/var/lib/fortify/0/org.example/cache",
+			"/var/lib/fortify/0/org.example/app",
+			"/var/lib/fortify/0/org.example/app~",
+			"/var/lib/fortify/0/org.example/grant",
+			"/var/lib/fortify/0/org.example/grant~",
+			"/var/lib/fortify/0/org.example/base",
+			"/var/lib/fortify/0/org.example/base~",
+		}},
+		{2, &appPathSet{
+			baseDir:    "/var/lib/fortify/0/org.example",
+			homeDir:    "/var/lib/fortify/0/org.example/files",
+			generation: 2,
+			genDir:     "/var/lib/fortify/0/org.example/2",
+			metaPath:   "/var/lib/fortify/0/org.example/2/app",
+			grantPath:  "/var/lib/fortify/0/org.example/2/grant",
+			basePath:   "/var/lib/fortify/0/org.example/2/base",
+			cacheDir:   "/var/lib/fortify/0/org.example/2/cache",
+			nixPath:    "/var/lib/fortify/0/org.example/2/cache/nix",
+			rootfsPath: "/var/lib/fortify/0/org.example/2/cache/rootfs",
+		}, 3, []string{
+			"/var/lib/fortify/0/org.example/2/cache",
+			"/var/lib/fortify/0/org.example/2/app",
+			"/var/lib/fortify/0/org.example/2/app~",
+			"/var/lib/fortify/0/org.example/2/grant",
+			"/var/lib/fortify/0/org.example/2/grant~",
+			"/var/lib/fortify/0/org.example/2/base",
+			"/var/lib/fortify/0/org.example/2/base~",
+			"/var/lib/fortify/0/org.example/2",
+		}},
+	}
+
+	for _, tc := range testCases {
+		got := pathSet.atGeneration(tc.gen)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("atGeneration(%d): %#v, want %#v", tc.gen, got, tc.want)
+		}
+		if perms := got.extraPerms(); len(perms) != tc.perm {
+			t.Errorf("extraPerms(%d): %d entries, want %d", tc.gen, len(perms), tc.perm)
+		}
+		if names := pathSet.generationPaths(tc.gen); !reflect.DeepEqual(names, tc.rm) {
+			t.Errorf("generationPaths(%d): %v, want %v", tc.gen, names, tc.rm)
+		}
+	}
+}
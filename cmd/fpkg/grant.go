@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+
+	"git.gensokyo.uk/security/fortify/dbus"
+	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+	"git.gensokyo.uk/security/fortify/system"
+)
+
+/*
+	Permissions requested by an app are reviewed on installation and the accepted set is stored
+	alongside its metadata as the grant of that generation. An app requesting permissions outside
+	its grant is refused by start. Base configurations an app extends are resolved on installation,
+	stored with the generation and their privileges are part of its grant. Apps installed before grants were recorded have their permissions
+	reviewed on first start instead.
+*/
+
+var (
+	// errDeclined is returned by reviewGrant if new permissions are not accepted.
+	errDeclined = errors.New("permissions not accepted")
+)
+
+// permissions returns the sorted set of privileges requested by app merged on top of base, which may be nil.
+func (app *appInfo) permissions(base *fst.Config) []string {
+	var perms []string
+	flag := func(v bool, name string) {
+		if v {
+			perms = append(perms, name)
+		}
+	}
+	flag(app.Devel, "devel")
+	flag(app.Userns, "userns")
+	flag(app.Net, "net")
+	flag(app.Device, "dev")
+	flag(app.Tty, "tty")
+	flag(app.MapRealUID, "map_real_uid")
+	flag(app.DirectWayland, "direct_wayland")
+	flag(app.Multiarch, "multiarch")
+	flag(app.Bluetooth, "bluetooth")
+	flag(app.GPU, "gpu")
+
+	for i := system.Enablement(1); i < system.EM; i <<= 1 {
+		if app.Enablements&i != 0 {
+			perms = append(perms, "enablement "+i.String())
+		}
+	}
+	for _, group := range app.Groups {
+		perms = append(perms, "group "+group)
+	}
+	perms = append(perms, busPermissions("system_bus", app.SystemBus)...)
+	perms = append(perms, busPermissions("session_bus", app.SessionBus)...)
+	if base != nil {
+		perms = append(perms, baseConfigPermissions(base)...)
+	}
+
+	slices.Sort(perms)
+	return slices.Compact(perms)
+}
+
+// baseConfigPermissions returns privileges requested by a base configuration, named like those of [appInfo].
+func baseConfigPermissions(config *fst.Config) []string {
+	app := &appInfo{
+		Groups:        config.Groups,
+		DirectWayland: config.DirectWayland,
+		SystemBus:     config.SystemBus,
+		SessionBus:    config.SessionBus,
+		Enablements:   config.Enablements,
+	}
+	var perms []string
+	for _, p := range config.ExtraPerms {
+		perms = append(perms, "extra_perms "+p.String())
+	}
+	if c := config.Container; c != nil {
+		app.Devel, app.Userns, app.Net, app.Device, app.Tty = c.Devel, c.Userns, c.Net, c.Device, c.Tty
+		app.MapRealUID, app.GPU = c.MapRealUID, c.GPU
+		app.Multiarch = c.Multiarch || c.Seccomp&seccomp.FilterMultiarch != 0
+		app.Bluetooth = c.Seccomp&seccomp.FilterBluetooth != 0
+		if c.SeccompAudit {
+			perms = append(perms, "seccomp_audit")
+		}
+		for _, r := range c.SeccompRules {
+			if r.Action == seccomp.ActionAllow {
+				perms = append(perms, "seccomp allow "+r.Syscall)
+			}
+		}
+		for _, fs := range c.Filesystem {
+			if fs == nil {
+				continue
+			}
+			p := "filesystem " + fs.Src
+			if fs.Write {
+				p += " rw"
+			}
+			if fs.Device {
+				p += " dev"
+			}
+			perms = append(perms, p)
+		}
+	}
+	return append(perms, app.permissions(nil)...)
+}
+
+func busPermissions(bus string, c *dbus.Config) []string {
+	if c == nil {
+		return nil
+	}
+
+	var perms []string
+	if !c.Filter {
+		perms = append(perms, bus+" unfiltered")
+	}
+	for _, name := range c.See {
+		perms = append(perms, bus+" see "+name)
+	}
+	for _, name := range c.Talk {
+		perms = append(perms, bus+" talk "+name)
+	}
+	for _, name := range c.Own {
+		perms = append(perms, bus+" own "+name)
+	}
+	for name, rule := range c.Call {
+		perms = append(perms, bus+" call "+name+"="+rule)
+	}
+	for name, rule := range c.Broadcast {
+		perms = append(perms, bus+" broadcast "+name+"="+rule)
+	}
+	return perms
+}
+
+// diffPermissions returns permissions in requested absent from granted, and permissions in granted no longer requested.
+func diffPermissions(granted, requested []string) (added, removed []string) {
+	for _, p := range requested {
+		if !slices.Contains(granted, p) {
+			added = append(added, p)
+		}
+	}
+	for _, p := range granted {
+		if !slices.Contains(requested, p) {
+			removed = append(removed, p)
+		}
+	}
+	return
+}
+
+// reviewGrant writes the difference between granted and requested permissions of app id to w.
+// If permissions are added and accept is false, confirmation is read from r.
+func reviewGrant(r io.Reader, w io.Writer, id string, granted, requested []string, accept bool) error {
+	added, removed := diffPermissions(granted, requested)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	buf := new(strings.Builder)
+	_, _ = fmt.Fprintf(buf, "permissions of %q:\n", id)
+	for _, p := range requested {
+		if slices.Contains(added, p) {
+			buf.WriteString("  + " + p + "\n")
+		} else {
+			buf.WriteString("    " + p + "\n")
+		}
+	}
+	for _, p := range removed {
+		buf.WriteString("  - " + p + "\n")
+	}
+	if _, err := io.WriteString(w, buf.String()); err != nil {
+		return err
+	}
+
+	if len(added) == 0 || accept {
+		return nil
+	}
+	if _, err := fmt.Fprintf(w, "accept %d new permissions? [y/N] ", len(added)); err != nil {
+		return err
+	}
+	answer, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return nil
+	default:
+		return errDeclined
+	}
+}
+
+// loadGrant reads permissions accepted for an app generation from name. ok is false if no grant was stored.
+func loadGrant(name string) (perms []string, ok bool, err error) {
+	var data []byte
+	if data, err = os.ReadFile(name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		return
+	}
+	if err = json.Unmarshal(data, &perms); err != nil {
+		return
+	}
+	return perms, true, nil
+}
+
+// storeGrant writes perms accepted for an app generation to name.
+func storeGrant(name string, perms []string) error {
+	if perms == nil {
+		// distinguish an empty grant from a missing one
+		perms = make([]string, 0)
+	}
+	if data, err := json.Marshal(perms); err != nil {
+		return err
+	} else if err = os.WriteFile(name+"~", data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(name+"~", name)
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/dbus"
+	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+	"git.gensokyo.uk/security/fortify/system"
+)
+
+func TestPermissions(t *testing.T) {
+	testCases := []struct {
+		name string
+		app  *appInfo
+		want []string
+	}{
+		{"none", &appInfo{ID: "org.example"}, nil},
+		{"flags", &appInfo{
+			Devel: true, Userns: true, Net: true, Device: true, Tty: true,
+			MapRealUID: true, DirectWayland: true, Multiarch: true, Bluetooth: true, GPU: true,
+		}, []string{
+			"bluetooth", "dev", "devel", "direct_wayland", "gpu",
+			"map_real_uid", "multiarch", "net", "tty", "userns",
+		}},
+		{"foot", &appInfo{
+			Net:         true,
+			Groups:      []string{"video", "video"},
+			Enablements: system.EWayland | system.EDBus | system.EPulse,
+			SessionBus: &dbus.Config{
+				Talk:   []string{"org.freedesktop.Notifications"},
+				Own:    []string{"org.codeberg.dnkl.foot.*"},
+				Call:   map[string]string{"org.freedesktop.portal.*": "*"},
+				Filter: true,
+			},
+			SystemBus: &dbus.Config{},
+		}, []string{
+			"enablement dbus",
+			"enablement pulseaudio",
+			"enablement wayland",
+			"group video",
+			"net",
+			"session_bus call org.freedesktop.portal.*=*",
+			"session_bus own org.codeberg.dnkl.foot.*",
+			"session_bus talk org.freedesktop.Notifications",
+			"system_bus unfiltered",
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.app.permissions(nil); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("permissions: %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestBasePermissions(t *testing.T) {
+	testCases := []struct {
+		name string
+		app  *appInfo
+		base *fst.Config
+		want []string
+	}{
+		{"empty", &appInfo{Net: true}, new(fst.Config), []string{"net"}},
+		{"container", &appInfo{Net: true}, &fst.Config{
+			Enablements: system.EPipeWire,
+			Groups:      []string{"audio"},
+			ExtraPerms:  []*fst.ExtraPermConfig{{Path: "/var/lib/example", Read: true, Write: true}},
+			Container: &fst.ContainerConfig{
+				Net:          true,
+				Devel:        true,
+				Seccomp:      seccomp.FilterBluetooth,
+				SeccompAudit: true,
+				SeccompRules: []seccomp.Rule{
+					{Syscall: "chown", Action: seccomp.ActionAllow},
+					{Syscall: "ptrace", Action: seccomp.ActionKill},
+				},
+				Filesystem: []*fst.FilesystemConfig{
+					{Src: "/dev/dri", Device: true},
+					{Src: "/srv/share", Write: true},
+					nil,
+				},
+			},
+		}, []string{
+			"bluetooth",
+			"devel",
+			"enablement pipewire",
+			"extra_perms rw-:/var/lib/example",
+			"filesystem /dev/dri dev",
+			"filesystem /srv/share rw",
+			"group audio",
+			"net",
+			"seccomp allow chown",
+			"seccomp_audit",
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.app.permissions(tc.base); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("permissions: %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReviewGrant(t *testing.T) {
+	testCases := []struct {
+		name      string
+		granted   []string
+		requested []string
+		accept    bool
+		input     string
+		want      string
+		wantErr   error
+	}{
+		{"unchanged", []string{"net"}, []string{"net"}, false, "", "", nil},
+		{"reduced", []string{"devel", "net"}, []string{"net"}, false, "",
+			"permissions of \"org.example\":\n    net\n  - devel\n", nil},
+		{"accept flag", []string{"net"}, []string{"devel", "net"}, true, "",
+			"permissions of \"org.example\":\n  + devel\n    net\n", nil},
+		{"confirm", nil, []string{"net"}, false, "Y\n",
+			"permissions of \"org.example\":\n  + net\naccept 1 new permissions? [y/N] ", nil},
+		{"decline", nil, []string{"net"}, false, "n\n",
+			"permissions of \"org.example\":\n  + net\naccept 1 new permissions? [y/N] ", errDeclined},
+		{"eof", []string{"net"}, []string{"dev", "userns"}, false, "",
+			"permissions of \"org.example\":\n  + dev\n  + userns\n  - net\naccept 2 new permissions? [y/N] ", errDeclined},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := new(strings.Builder)
+			if err := reviewGrant(strings.NewReader(tc.input), w, "org.example",
+				tc.granted, tc.requested, tc.accept); !errors.Is(err, tc.wantErr) {
+				t.Errorf("reviewGrant: error = %v, want %v", err, tc.wantErr)
+			}
+			if w.String() != tc.want {
+				t.Errorf("reviewGrant: %q, want %q", w.String(), tc.want)
+			}
+		})
+	}
+}
+
+func TestGrant(t *testing.T) {
+	name := path.Join(t.TempDir(), "grant")
+
+	if perms, ok, err := loadGrant(name); err != nil || ok || perms != nil {
+		t.Fatalf("loadGrant: %q, %v, error = %v", perms, ok, err)
+	}
+
+	for _, want := range [][]string{nil, {"gpu", "net"}} {
+		if err := storeGrant(name, want); err != nil {
+			t.Fatalf("storeGrant: error = %v", err)
+		}
+		if perms, ok, err := loadGrant(name); err != nil || !ok || len(perms) != len(want) {
+			t.Fatalf("loadGrant: %q, %v, error = %v, want %q", perms, ok, err, want)
+		} else if len(want) > 0 && !reflect.DeepEqual(perms, want) {
+			t.Errorf("loadGrant: %q, want %q", perms, want)
+		}
+	}
+}
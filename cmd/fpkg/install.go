@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"path"
+	"syscall"
+
+	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/internal/fmsg"
+)
+
+// installPackage installs the package at pkgPath to a new generation and switches to it.
+// If update is true, the application must already be installed. Permissions growing compared to the
+// current generation are confirmed on standard input unless accept is true.
+func installPackage(ctx context.Context, pkgPath string, update, accept, dropShell, dropShellActivate bool) error {
+	if !path.IsAbs(pkgPath) {
+		if dir, err := os.Getwd(); err != nil {
+			log.Printf("cannot get current directory: %v", err)
+			return err
+		} else {
+			pkgPath = path.Join(dir, pkgPath)
+		}
+	}
+
+	/*
+		Verify package before extracting any of its contents.
+	*/
+
+	var (
+		pkg    *os.File
+		claims *signedPackage
+	)
+	if t, err := loadTrusted(trustedKeysPath); err != nil {
+		log.Printf("cannot load trusted keys: %v", err)
+		return err
+	} else if pkg, err = os.Open(pkgPath); err != nil {
+		log.Printf("cannot open package: %v", err)
+		return err
+	} else if claims, err = t.verify(pkg, pkgPath); err != nil {
+		_ = pkg.Close()
+		log.Printf("cannot verify package %q: %v", pkgPath, err)
+		return err
+	}
+
+	/*
+		Extract package and set up for cleanup.
+	*/
+
+	var workDir string
+	// created in data home so the root filesystem of portable bundles can be moved into place
+	if p, err := os.MkdirTemp(dataHome, ".fpkg.*"); err != nil {
+		_ = pkg.Close()
+		log.Printf("cannot create temporary directory: %v", err)
+		return err
+	} else {
+		workDir = p
+	}
+	cleanup := func() {
+		if err := removeAll(workDir); err != nil {
+			log.Printf("cannot remove temporary directory: %v", err)
+		}
+	}
+
+	if r, err := decompress(pkg); err != nil {
+		_ = pkg.Close()
+		cleanup()
+		log.Printf("cannot decompress package: %v", err)
+		return err
+	} else if err = extract(r, workDir); err != nil {
+		_ = pkg.Close()
+		cleanup()
+		log.Printf("cannot extract package: %v", err)
+		return err
+	} else if err = pkg.Close(); err != nil {
+		log.Printf("cannot close package: %v", err)
+		// not fatal
+	}
+
+	/*
+		Parse bundle and app metadata, do pre-install checks.
+	*/
+
+	bundle := loadAppInfo(path.Join(workDir, "bundle.json"), cleanup)
+	if err := claims.check(bundle); err != nil {
+		cleanup()
+		log.Printf("cannot verify package %q: %v", pkgPath, err)
+		return err
+	}
+	pathSet := pathSetByApp(bundle.ID)
+
+	a := bundle
+	if s, err := os.Stat(pathSet.metaPath); err != nil {
+		if !os.IsNotExist(err) {
+			cleanup()
+			log.Printf("cannot access %q: %v", pathSet.metaPath, err)
+			return err
+		}
+		// did not modify app, clean installation condition met later
+	} else if s.IsDir() {
+		cleanup()
+		log.Printf("metadata path %q is not a file", pathSet.metaPath)
+		return syscall.EBADMSG
+	} else {
+		a = loadAppInfo(pathSet.metaPath, cleanup)
+		if a.ID != bundle.ID {
+			cleanup()
+			log.Printf("app %q claims to have identifier %q",
+				bundle.ID, a.ID)
+			return syscall.EBADE
+		}
+		// sec: should verify credentials
+	}
+
+	if a == bundle && update {
+		cleanup()
+		log.Printf("application %q is not installed", bundle.ID)
+		return syscall.ENOENT
+	}
+
+	var (
+		granted  []string
+		hasGrant bool
+	)
+	if a != bundle {
+		var err error
+		if granted, hasGrant, err = loadGrant(pathSet.grantPath); err != nil {
+			cleanup()
+			log.Printf("cannot load permission grant: %v", err)
+			return err
+		}
+
+		if a.isPortable() != bundle.isPortable() {
+			cleanup()
+			log.Printf("package %q format %q differs from installed %q",
+				pkgPath, bundle.Format, a.Format)
+			return syscall.EBADE
+		}
+
+		// do not try to re-install, unless permissions were never reviewed
+		if hasGrant && a.isPortable() && a.Version == bundle.Version {
+			cleanup()
+			log.Printf("package %q is identical to local application %q",
+				pkgPath, a.ID)
+			return errSuccess
+		}
+		if hasGrant && !a.isPortable() &&
+			a.NixGL == bundle.NixGL &&
+			a.CurrentSystem == bundle.CurrentSystem &&
+			a.Launcher == bundle.Launcher &&
+			a.ActivationPackage == bundle.ActivationPackage {
+			cleanup()
+			log.Printf("package %q is identical to local application %q",
+				pkgPath, a.ID)
+			return errSuccess
+		}
+
+		// identity determines uid
+		if a.Identity != bundle.Identity {
+			cleanup()
+			log.Printf("package %q identity %d differs from installed %d",
+				pkgPath, bundle.Identity, a.Identity)
+			return syscall.EBADE
+		}
+
+		// older generations are only reachable via rollback
+		if compareVersions(bundle.Version, a.Version) < 0 {
+			cleanup()
+			log.Printf("package %q version %q is older than installed %q",
+				pkgPath, bundle.Version, a.Version)
+			return syscall.EPERM
+		}
+
+		fmsg.Verbosef("installing application %q version %q over local %q",
+			bundle.ID, bundle.Version, a.Version)
+	} else {
+		fmsg.Verbosef("application %q clean installation", bundle.ID)
+		// sec: should install credentials
+	}
+
+	/*
+		Review permissions requested by the bundle.
+	*/
+
+	base, err := bundle.resolveBase(path.Join(workDir, "bundle.json"))
+	if err != nil {
+		cleanup()
+		log.Printf("cannot resolve base configuration: %v", err)
+		return err
+	}
+	requested := bundle.permissions(base)
+	if err = reviewGrant(os.Stdin, os.Stderr, bundle.ID, granted, requested, accept); err != nil {
+		cleanup()
+		if errors.Is(err, errDeclined) {
+			log.Printf("permissions of %q were not accepted", bundle.ID)
+			return syscall.EPERM
+		}
+		log.Printf("cannot review permissions: %v", err)
+		return err
+	}
+
+	/*
+		Install to a new generation, leaving the current generation intact until the switch.
+	*/
+
+	// an incomplete generation left behind by a failed installation is removed after the next switch
+	var target *appPathSet
+	if gen, err := nextGeneration(pathSet.baseDir); err != nil {
+		cleanup()
+		log.Printf("cannot read generations: %v", err)
+		return err
+	} else {
+		target = pathSet.atGeneration(gen)
+	}
+	if err := os.MkdirAll(target.genDir, 0755); err != nil {
+		cleanup()
+		log.Printf("cannot create generation directory: %v", err)
+		return err
+	}
+
+	if bundle.isPortable() {
+		/*
+			Set up root filesystem, nothing runs in the container during install.
+		*/
+
+		if err := installRootfs(workDir, bundle, target); err != nil {
+			cleanup()
+			return err
+		}
+	} else {
+		installNix(ctx, workDir, bundle, target, dropShell, dropShellActivate, cleanup)
+	}
+
+	/*
+		Installation complete. Write metadata to block re-installs or downgrades.
+	*/
+
+	// serialise metadata to ensure consistency
+	if f, err := os.OpenFile(target.metaPath+"~", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
+		cleanup()
+		log.Printf("cannot create metadata file: %v", err)
+		return err
+	} else if err = json.NewEncoder(f).Encode(bundle); err != nil {
+		cleanup()
+		log.Printf("cannot write metadata: %v", err)
+		return err
+	} else if err = f.Close(); err != nil {
+		log.Printf("cannot close metadata file: %v", err)
+		// not fatal
+	}
+
+	if err := os.Rename(target.metaPath+"~", target.metaPath); err != nil {
+		cleanup()
+		log.Printf("cannot rename metadata file: %v", err)
+		return err
+	}
+	if base != nil {
+		if err = storeBase(target.basePath, base); err != nil {
+			cleanup()
+			log.Printf("cannot store base configuration: %v", err)
+			return err
+		}
+	}
+	if err = storeGrant(target.grantPath, requested); err != nil {
+		cleanup()
+		log.Printf("cannot store permission grant: %v", err)
+		return err
+	}
+	cleanup()
+
+	/*
+		Switch to the new generation and remove generations no longer referenced.
+	*/
+
+	prev := -1
+	if a != bundle {
+		prev = pathSet.generation
+	}
+	if err = setGeneration(pathSet.baseDir, target.generation, prev); err != nil {
+		log.Printf("cannot switch to generation %d: %v", target.generation, err)
+		return err
+	}
+	fmsg.Verbosef("application %q switched to generation %d", bundle.ID, target.generation)
+
+	return collectGenerations(ctx, bundle, target)
+}
+
+// installNix sets up the nix store and home-manager generation of a bundle extracted to workDir.
+func installNix(
+	ctx context.Context,
+	workDir string, bundle *appInfo, pathSet *appPathSet,
+	dropShell, dropShellActivate bool, beforeFail func(),
+) {
+	/*
+		Setup steps for files owned by the target user.
+	*/
+
+	withCacheDir(ctx, "install", []string{
+		// export inner bundle path in the environment
+		"export BUNDLE=" + fst.Tmp + "/bundle",
+		// replace inner /etc
+		"mkdir -p etc",
+		"chmod -R +w etc",
+		"rm -rf etc",
+		"cp -dRf $BUNDLE/etc etc",
+		// replace inner /nix
+		"mkdir -p nix",
+		"chmod -R +w nix",
+		"rm -rf nix",
+		"cp -dRf /nix nix",
+		// copy from binary cache
+		"nix copy --offline --no-check-sigs --all --from file://$BUNDLE/res --to $PWD",
+		// deduplicate nix store
+		"nix store --offline --store $PWD optimise",
+		// make cache directory world-readable for autoetc
+		"chmod 0755 .",
+	}, workDir, bundle, pathSet, dropShell, beforeFail)
+
+	if bundle.GPU {
+		withCacheDir(ctx, "mesa-wrappers", []string{
+			// link nixGL mesa wrappers
+			"mkdir -p nix/.nixGL",
+			"ln -s " + bundle.Mesa + "/bin/nixGLIntel nix/.nixGL/nixGL",
+			"ln -s " + bundle.Mesa + "/bin/nixVulkanIntel nix/.nixGL/nixVulkan",
+		}, workDir, bundle, pathSet, false, beforeFail)
+	}
+
+	activateNix(ctx, bundle, pathSet, dropShellActivate, beforeFail)
+}
+
+// activateNix activates the home-manager generation of the nix app generation described by pathSet.
+func activateNix(ctx context.Context, app *appInfo, pathSet *appPathSet, dropShell bool, beforeFail func()) {
+	withNixDaemon(ctx, "activate", []string{
+		// clean up broken links
+		"mkdir -p .local/state/{nix,home-manager}",
+		"chmod -R +w .local/state/{nix,home-manager}",
+		"rm -rf .local/state/{nix,home-manager}",
+		// run activation script
+		app.ActivationPackage + "/activate",
+	}, false, func(config *fst.Config) *fst.Config { return config },
+		app, pathSet, dropShell, beforeFail)
+}
+
+// collectGenerations removes every generation of app other than the one described by pathSet and its previous generation.
+func collectGenerations(ctx context.Context, app *appInfo, pathSet *appPathSet) error {
+	gens, err := generations(pathSet.baseDir)
+	if err != nil {
+		log.Printf("cannot read generations: %v", err)
+		return err
+	}
+	_, prev, _, err := readGeneration(pathSet.baseDir)
+	if err != nil {
+		log.Printf("cannot read previous generation: %v", err)
+		return err
+	}
+
+	var names []string
+	for _, gen := range gens {
+		if gen == pathSet.generation || gen == prev {
+			continue
+		}
+		names = append(names, pathSet.generationPaths(gen)...)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return removePaths(ctx, app, pathSet, names, func() {
+		log.Printf("switched to generation %d, cannot remove unused generations", pathSet.generation)
+	})
+}
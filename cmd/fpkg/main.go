@@ -2,13 +2,17 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
+	"fmt"
+	"io/fs"
 	"log"
 	"os"
 	"os/signal"
 	"path"
+	"slices"
+	"strings"
 	"syscall"
+	"text/tabwriter"
 
 	"git.gensokyo.uk/security/fortify/command"
 	"git.gensokyo.uk/security/fortify/fst"
@@ -67,188 +71,32 @@ func main() {
 	{
 		var (
 			flagDropShellActivate bool
+			flagAccept            bool
 		)
 		c.NewCommand("install", "Install an application from its package", func(args []string) error {
 			if len(args) != 1 {
 				log.Println("invalid argument")
 				return syscall.EINVAL
 			}
-			pkgPath := args[0]
-			if !path.IsAbs(pkgPath) {
-				if dir, err := os.Getwd(); err != nil {
-					log.Printf("cannot get current directory: %v", err)
-					return err
-				} else {
-					pkgPath = path.Join(dir, pkgPath)
-				}
-			}
-
-			/*
-				Look up paths to programs started by fpkg.
-				This is done here to ease error handling as cleanup is not yet required.
-			*/
-
-			var (
-				_     = lookPath("zstd")
-				tar   = lookPath("tar")
-				chmod = lookPath("chmod")
-				rm    = lookPath("rm")
-			)
-
-			/*
-				Extract package and set up for cleanup.
-			*/
-
-			var workDir string
-			if p, err := os.MkdirTemp("", "fpkg.*"); err != nil {
-				log.Printf("cannot create temporary directory: %v", err)
+			if err := installPackage(ctx, args[0], false, flagAccept, flagDropShell, flagDropShellActivate); err != nil {
 				return err
-			} else {
-				workDir = p
-			}
-			cleanup := func() {
-				// should be faster than a native implementation
-				mustRun(chmod, "-R", "+w", workDir)
-				mustRun(rm, "-rf", workDir)
 			}
-			beforeRunFail.Store(&cleanup)
-
-			mustRun(tar, "-C", workDir, "-xf", pkgPath)
-
-			/*
-				Parse bundle and app metadata, do pre-install checks.
-			*/
-
-			bundle := loadAppInfo(path.Join(workDir, "bundle.json"), cleanup)
-			pathSet := pathSetByApp(bundle.ID)
-
-			a := bundle
-			if s, err := os.Stat(pathSet.metaPath); err != nil {
-				if !os.IsNotExist(err) {
-					cleanup()
-					log.Printf("cannot access %q: %v", pathSet.metaPath, err)
-					return err
-				}
-				// did not modify app, clean installation condition met later
-			} else if s.IsDir() {
-				cleanup()
-				log.Printf("metadata path %q is not a file", pathSet.metaPath)
-				return syscall.EBADMSG
-			} else {
-				a = loadAppInfo(pathSet.metaPath, cleanup)
-				if a.ID != bundle.ID {
-					cleanup()
-					log.Printf("app %q claims to have identifier %q",
-						bundle.ID, a.ID)
-					return syscall.EBADE
-				}
-				// sec: should verify credentials
-			}
-
-			if a != bundle {
-				// do not try to re-install
-				if a.NixGL == bundle.NixGL &&
-					a.CurrentSystem == bundle.CurrentSystem &&
-					a.Launcher == bundle.Launcher &&
-					a.ActivationPackage == bundle.ActivationPackage {
-					cleanup()
-					log.Printf("package %q is identical to local application %q",
-						pkgPath, a.ID)
-					return errSuccess
-				}
-
-				// identity determines uid
-				if a.Identity != bundle.Identity {
-					cleanup()
-					log.Printf("package %q identity %d differs from installed %d",
-						pkgPath, bundle.Identity, a.Identity)
-					return syscall.EBADE
-				}
-
-				// sec: should compare version string
-				fmsg.Verbosef("installing application %q version %q over local %q",
-					bundle.ID, bundle.Version, a.Version)
-			} else {
-				fmsg.Verbosef("application %q clean installation", bundle.ID)
-				// sec: should install credentials
-			}
-
-			/*
-				Setup steps for files owned by the target user.
-			*/
-
-			withCacheDir(ctx, "install", []string{
-				// export inner bundle path in the environment
-				"export BUNDLE=" + fst.Tmp + "/bundle",
-				// replace inner /etc
-				"mkdir -p etc",
-				"chmod -R +w etc",
-				"rm -rf etc",
-				"cp -dRf $BUNDLE/etc etc",
-				// replace inner /nix
-				"mkdir -p nix",
-				"chmod -R +w nix",
-				"rm -rf nix",
-				"cp -dRf /nix nix",
-				// copy from binary cache
-				"nix copy --offline --no-check-sigs --all --from file://$BUNDLE/res --to $PWD",
-				// deduplicate nix store
-				"nix store --offline --store $PWD optimise",
-				// make cache directory world-readable for autoetc
-				"chmod 0755 .",
-			}, workDir, bundle, pathSet, flagDropShell, cleanup)
-
-			if bundle.GPU {
-				withCacheDir(ctx, "mesa-wrappers", []string{
-					// link nixGL mesa wrappers
-					"mkdir -p nix/.nixGL",
-					"ln -s " + bundle.Mesa + "/bin/nixGLIntel nix/.nixGL/nixGL",
-					"ln -s " + bundle.Mesa + "/bin/nixVulkanIntel nix/.nixGL/nixVulkan",
-				}, workDir, bundle, pathSet, false, cleanup)
-			}
-
-			/*
-				Activate home-manager generation.
-			*/
-
-			withNixDaemon(ctx, "activate", []string{
-				// clean up broken links
-				"mkdir -p .local/state/{nix,home-manager}",
-				"chmod -R +w .local/state/{nix,home-manager}",
-				"rm -rf .local/state/{nix,home-manager}",
-				// run activation script
-				bundle.ActivationPackage + "/activate",
-			}, false, func(config *fst.Config) *fst.Config { return config },
-				bundle, pathSet, flagDropShellActivate, cleanup)
-
-			/*
-				Installation complete. Write metadata to block re-installs or downgrades.
-			*/
-
-			// serialise metadata to ensure consistency
-			if f, err := os.OpenFile(pathSet.metaPath+"~", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644); err != nil {
-				cleanup()
-				log.Printf("cannot create metadata file: %v", err)
-				return err
-			} else if err = json.NewEncoder(f).Encode(bundle); err != nil {
-				cleanup()
-				log.Printf("cannot write metadata: %v", err)
-				return err
-			} else if err = f.Close(); err != nil {
-				log.Printf("cannot close metadata file: %v", err)
-				// not fatal
+			return errSuccess
+		}).
+			Flag(&flagDropShellActivate, "s", command.BoolFlag(false), "Drop to a shell on activation").
+			Flag(&flagAccept, "accept", command.BoolFlag(false), "Accept requested permissions without confirmation")
+		c.NewCommand("update", "Install a new version of an installed application and switch to it", func(args []string) error {
+			if len(args) != 1 {
+				log.Println("invalid argument")
+				return syscall.EINVAL
 			}
-
-			if err := os.Rename(pathSet.metaPath+"~", pathSet.metaPath); err != nil {
-				cleanup()
-				log.Printf("cannot rename metadata file: %v", err)
+			if err := installPackage(ctx, args[0], true, flagAccept, flagDropShell, flagDropShellActivate); err != nil {
 				return err
 			}
-
-			cleanup()
 			return errSuccess
 		}).
-			Flag(&flagDropShellActivate, "s", command.BoolFlag(false), "Drop to a shell on activation")
+			Flag(&flagDropShellActivate, "s", command.BoolFlag(false), "Drop to a shell on activation").
+			Flag(&flagAccept, "accept", command.BoolFlag(false), "Accept requested permissions without confirmation")
 	}
 
 	{
@@ -274,11 +122,45 @@ func main() {
 				return syscall.EBADE
 			}
 
+			base, err := loadBase(a, pathSet)
+			if err != nil {
+				log.Printf("cannot load base configuration: %v", err)
+				return err
+			}
+
+			/*
+				Check requested permissions against the accepted grant.
+			*/
+
+			if granted, ok, err := loadGrant(pathSet.grantPath); err != nil {
+				log.Printf("cannot load permission grant: %v", err)
+				return err
+			} else if !ok {
+				// installed before permissions were recorded, review them once
+				requested := a.permissions(base)
+				if err = reviewGrant(os.Stdin, os.Stderr, id, nil, requested, false); err != nil {
+					if errors.Is(err, errDeclined) {
+						log.Printf("permissions of %q were not accepted", id)
+						return syscall.EPERM
+					}
+					log.Printf("cannot review permissions: %v", err)
+					return err
+				}
+				if err = storeGrant(pathSet.grantPath, requested); err != nil {
+					log.Printf("cannot store permission grant: %v", err)
+					return err
+				}
+			} else if added, _ := diffPermissions(granted, a.permissions(base)); len(added) > 0 {
+				log.Printf("application %q requests permissions not accepted: %s",
+					id, strings.Join(added, ", "))
+				return syscall.EPERM
+			}
+
 			/*
 				Prepare nixGL.
 			*/
 
-			if a.GPU && flagAutoDrivers {
+			if a.GPU && flagAutoDrivers && !a.isPortable() {
 				withNixDaemon(ctx, "nix-gl", []string{
 					"mkdir -p /nix/.nixGL/auto",
 					"rm -rf /nix/.nixGL/auto",
@@ -300,7 +182,7 @@ func main() {
 						{Src: "/sys/dev"},
 						{Src: "/sys/devices"},
 					}...)
-					appendGPUFilesystem(config)
+					config.Container.GPU = true
 					return config
 				}, a, pathSet, flagDropShellNixGL, func() {})
 			}
@@ -309,24 +191,30 @@ func main() {
 				Create app configuration.
 			*/
 
-			argv := make([]string, 1, len(args))
-			if !flagDropShell {
-				argv[0] = a.Launcher
-			} else {
-				argv[0] = shellPath
+			var argv []string
+			switch {
+			case flagDropShell && a.isPortable():
+				argv = []string{rootfsShell}
+			case flagDropShell:
+				argv = []string{shellPath}
+			case a.isPortable() && len(a.Args) > 0:
+				argv = slices.Clone(a.Args)
+			case a.isPortable():
+				argv = []string{a.Path}
+			default:
+				argv = []string{a.Launcher}
 			}
 			argv = append(argv, args[1:]...)
 
-			config := a.toFst(pathSet, argv, flagDropShell)
+			config := a.toFst(pathSet, base, argv, flagDropShell)
 
 			/*
-				Expose GPU devices.
+				Expose nixGL wrappers.
 			*/
 
-			if a.GPU {
+			if a.GPU && !a.isPortable() {
 				config.Container.Filesystem = append(config.Container.Filesystem,
 					&fst.FilesystemConfig{Src: path.Join(pathSet.nixPath, ".nixGL"), Dst: path.Join(fst.Tmp, "nixGL")})
-				appendGPUFilesystem(config)
 			}
 
 			/*
@@ -340,6 +228,130 @@ func main() {
 			Flag(&flagAutoDrivers, "auto-drivers", command.BoolFlag(false), "Attempt automatic opengl driver detection")
 	}
 
+	{
+		var (
+			flagDropShellActivate bool
+		)
+		c.NewCommand("rollback", "Switch an application back to its previous generation", func(args []string) error {
+			if len(args) != 1 {
+				log.Println("invalid argument")
+				return syscall.EINVAL
+			}
+
+			id := args[0]
+			pathSet := pathSetByApp(id)
+			_, prev, _, err := readGeneration(pathSet.baseDir)
+			if err != nil {
+				log.Printf("cannot read previous generation: %v", err)
+				return err
+			} else if prev < 0 {
+				log.Printf("application %q has no previous generation", id)
+				return syscall.ENOENT
+			}
+
+			target := pathSet.atGeneration(prev)
+			a := loadAppInfo(target.metaPath, func() {})
+			if a.ID != id {
+				log.Printf("app %q claims to have identifier %q", id, a.ID)
+				return syscall.EBADE
+			}
+
+			/*
+				Activate home-manager generation of the previous generation before switching to it.
+			*/
+
+			if !a.isPortable() {
+				activateNix(ctx, a, target, flagDropShellActivate, func() {})
+			}
+
+			if err = setGeneration(pathSet.baseDir, target.generation, pathSet.generation); err != nil {
+				log.Printf("cannot switch to generation %d: %v", target.generation, err)
+				return err
+			}
+			fmsg.Verbosef("application %q switched to generation %d", id, target.generation)
+			return errSuccess
+		}).
+			Flag(&flagDropShellActivate, "s", command.BoolFlag(false), "Drop to a shell on activation")
+	}
+
+	{
+		var (
+			flagKeepData bool
+		)
+		c.NewCommand("uninstall", "Remove an application", func(args []string) error {
+			if len(args) != 1 {
+				log.Println("invalid argument")
+				return syscall.EINVAL
+			}
+
+			id := args[0]
+			pathSet := pathSetByApp(id)
+			a := loadAppInfo(pathSet.metaPath, func() {})
+			if a.ID != id {
+				log.Printf("app %q claims to have identifier %q", id, a.ID)
+				return syscall.EBADE
+			}
+
+			gens, err := generations(pathSet.baseDir)
+			if err != nil {
+				log.Printf("cannot read generations: %v", err)
+				return err
+			}
+
+			// the current generation is removed last as its metadata identifies the app
+			var names []string
+			for _, gen := range gens {
+				if gen != pathSet.generation {
+					names = append(names, pathSet.generationPaths(gen)...)
+				}
+			}
+			names = append(names, pathSet.generationPaths(pathSet.generation)...)
+			names = append(names, path.Join(pathSet.baseDir, genCurrent), path.Join(pathSet.baseDir, genCurrent+"~"))
+			if !flagKeepData {
+				names = append(names, pathSet.homeDir, pathSet.baseDir)
+			}
+
+			if err = removePaths(ctx, a, pathSet, names, func() {}); err != nil {
+				return err
+			}
+			return errSuccess
+		}).
+			Flag(&flagKeepData, "keep-data", command.BoolFlag(false), "Keep application data")
+	}
+
+	c.NewCommand("list", "List installed applications", func([]string) error {
+		d, err := os.ReadDir(dataHome)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("cannot read data home: %v", err)
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 1, 4, ' ', 0)
+		_, _ = fmt.Fprintln(w, "ID\tVersion\tIdentity\tGeneration\tEnablements")
+		for _, ent := range d {
+			// skip temporary directories of ongoing installations
+			if !ent.IsDir() || strings.HasPrefix(ent.Name(), ".") {
+				continue
+			}
+
+			pathSet := pathSetByApp(ent.Name())
+			if _, err = os.Stat(pathSet.metaPath); err != nil {
+				if !errors.Is(err, fs.ErrNotExist) {
+					log.Printf("cannot access %q: %v", pathSet.metaPath, err)
+				}
+				continue
+			}
+			a := loadAppInfo(pathSet.metaPath, func() {})
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n",
+				a.ID, a.Version, a.Identity, pathSet.generation, a.Enablements)
+		}
+		if err = w.Flush(); err != nil {
+			log.Printf("cannot write application list: %v", err)
+			return err
+		}
+		return errSuccess
+	})
+
 	c.MustParse(os.Args[1:], func(err error) {
 		fmsg.Verbosef("command returned %v", err)
 		if errors.Is(err, errSuccess) {
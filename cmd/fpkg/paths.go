@@ -3,17 +3,15 @@ package main
 import (
 	"log"
 	"os"
-	"os/exec"
 	"path"
 	"strconv"
-	"sync/atomic"
 
 	"git.gensokyo.uk/security/fortify/fst"
-	"git.gensokyo.uk/security/fortify/internal/fmsg"
 )
 
 var (
-	dataHome string
+	dataHome        string
+	trustedKeysPath string
 )
 
 func init() {
@@ -23,79 +21,75 @@ func init() {
 	} else {
 		dataHome = "/var/lib/fortify/" + strconv.Itoa(os.Getuid())
 	}
-}
 
-func lookPath(file string) string {
-	if p, err := exec.LookPath(file); err != nil {
-		log.Fatalf("%s: command not found", file)
-		return ""
+	// trustedKeysPath
+	if p, ok := os.LookupEnv("FPKG_TRUSTED_KEYS"); ok {
+		trustedKeysPath = p
 	} else {
-		return p
-	}
-}
-
-var beforeRunFail = new(atomic.Pointer[func()])
-
-func mustRun(name string, arg ...string) {
-	fmsg.Verbosef("spawning process: %q %q", name, arg)
-	cmd := exec.Command(name, arg...)
-	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
-	if err := cmd.Run(); err != nil {
-		if f := beforeRunFail.Swap(nil); f != nil {
-			(*f)()
-		}
-		log.Fatalf("%s: %v", name, err)
+		trustedKeysPath = "/etc/fpkg/trusted_keys"
 	}
 }
 
 type appPathSet struct {
 	// ${dataHome}/${id}
 	baseDir string
-	// ${baseDir}/app
-	metaPath string
 	// ${baseDir}/files
 	homeDir string
-	// ${baseDir}/cache
+
+	// generation described by this path set
+	generation int
+	// ${baseDir}/${generation}, or baseDir for generation 0
+	genDir string
+	// ${genDir}/app
+	metaPath string
+	// ${genDir}/grant
+	grantPath string
+	// ${genDir}/base
+	basePath string
+	// ${genDir}/cache
 	cacheDir string
-	// ${baseDir}/cache/nix
+	// ${genDir}/cache/nix
 	nixPath string
+	// ${genDir}/cache/rootfs
+	rootfsPath string
 }
 
+// pathSetByApp returns the path set of the current generation of app id.
 func pathSetByApp(id string) *appPathSet {
 	pathSet := new(appPathSet)
 	pathSet.baseDir = path.Join(dataHome, id)
-	pathSet.metaPath = path.Join(pathSet.baseDir, "app")
 	pathSet.homeDir = path.Join(pathSet.baseDir, "files")
-	pathSet.cacheDir = path.Join(pathSet.baseDir, "cache")
-	pathSet.nixPath = path.Join(pathSet.cacheDir, "nix")
-	return pathSet
+
+	gen, _, _, err := readGeneration(pathSet.baseDir)
+	if err != nil {
+		log.Fatalf("cannot read current generation of %q: %v", id, err)
+	}
+	return pathSet.atGeneration(gen)
 }
 
-func appendGPUFilesystem(config *fst.Config) {
-	config.Container.Filesystem = append(config.Container.Filesystem, []*fst.FilesystemConfig{
-		// flatpak commit 763a686d874dd668f0236f911de00b80766ffe79
-		{Src: "/dev/dri", Device: true},
-		// mali
-		{Src: "/dev/mali", Device: true},
-		{Src: "/dev/mali0", Device: true},
-		{Src: "/dev/umplock", Device: true},
-		// nvidia
-		{Src: "/dev/nvidiactl", Device: true},
-		{Src: "/dev/nvidia-modeset", Device: true},
-		// nvidia OpenCL/CUDA
-		{Src: "/dev/nvidia-uvm", Device: true},
-		{Src: "/dev/nvidia-uvm-tools", Device: true},
+// atGeneration returns the path set of generation gen of the same app.
+func (pathSet *appPathSet) atGeneration(gen int) *appPathSet {
+	p := &appPathSet{baseDir: pathSet.baseDir, homeDir: pathSet.homeDir, generation: gen, genDir: pathSet.baseDir}
+	if gen > 0 {
+		p.genDir = path.Join(p.baseDir, strconv.Itoa(gen))
+	}
+	p.metaPath = path.Join(p.genDir, "app")
+	p.grantPath = path.Join(p.genDir, "grant")
+	p.basePath = path.Join(p.genDir, "base")
+	p.cacheDir = path.Join(p.genDir, "cache")
+	p.nixPath = path.Join(p.cacheDir, "nix")
+	p.rootfsPath = path.Join(p.cacheDir, "rootfs")
+	return p
+}
 
-		// flatpak commit d2dff2875bb3b7e2cd92d8204088d743fd07f3ff
-		{Src: "/dev/nvidia0", Device: true}, {Src: "/dev/nvidia1", Device: true},
-		{Src: "/dev/nvidia2", Device: true}, {Src: "/dev/nvidia3", Device: true},
-		{Src: "/dev/nvidia4", Device: true}, {Src: "/dev/nvidia5", Device: true},
-		{Src: "/dev/nvidia6", Device: true}, {Src: "/dev/nvidia7", Device: true},
-		{Src: "/dev/nvidia8", Device: true}, {Src: "/dev/nvidia9", Device: true},
-		{Src: "/dev/nvidia10", Device: true}, {Src: "/dev/nvidia11", Device: true},
-		{Src: "/dev/nvidia12", Device: true}, {Src: "/dev/nvidia13", Device: true},
-		{Src: "/dev/nvidia14", Device: true}, {Src: "/dev/nvidia15", Device: true},
-		{Src: "/dev/nvidia16", Device: true}, {Src: "/dev/nvidia17", Device: true},
-		{Src: "/dev/nvidia18", Device: true}, {Src: "/dev/nvidia19", Device: true},
-	}...)
+// extraPerms returns [fst.ExtraPermConfig] granting the target user access to the generation described by pathSet.
+func (pathSet *appPathSet) extraPerms() []*fst.ExtraPermConfig {
+	perms := []*fst.ExtraPermConfig{
+		{Path: dataHome, Execute: true},
+		{Ensure: true, Path: pathSet.baseDir, Read: true, Write: true, Execute: true},
+	}
+	if pathSet.genDir != pathSet.baseDir {
+		perms = append(perms, &fst.ExtraPermConfig{Ensure: true, Path: pathSet.genDir, Read: true, Write: true, Execute: true})
+	}
+	return perms
 }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"git.gensokyo.uk/security/fortify/fst"
+)
+
+/*
+	A portable bundle does not depend on nix and is identified by its format field.
+	Its root filesystem is either a directory tree or a tar archive image within the
+	bundle, named by the rootfs field. The program described by path and args is
+	started from this root filesystem, and its /etc becomes the container /etc.
+*/
+
+const (
+	// formatRootfs identifies a portable bundle carrying a root filesystem.
+	formatRootfs = "rootfs"
+	// rootfsDefault is the default path of the root filesystem within a portable bundle.
+	rootfsDefault = "rootfs"
+	// rootfsShell is the shell used within a portable root filesystem.
+	rootfsShell = "/bin/sh"
+)
+
+// isPortable returns whether app is installed from a portable bundle.
+func (app *appInfo) isPortable() bool { return app.Format == formatRootfs }
+
+// checkPortable validates portable bundle metadata, app must be portable.
+func (app *appInfo) checkPortable() error {
+	if app.Rootfs == "" {
+		app.Rootfs = rootfsDefault
+	}
+	if p := path.Clean(app.Rootfs); path.IsAbs(p) || p == "." || p == ".." || strings.HasPrefix(p, "../") {
+		return fmt.Errorf("root filesystem %q is not within the bundle", app.Rootfs)
+	}
+	if !path.IsAbs(app.Path) {
+		return fmt.Errorf("program path %q is not absolute", app.Path)
+	}
+	return nil
+}
+
+// installRootfs replaces the root filesystem of an installed portable app with the one in workDir.
+// The directory tree is moved into place, so workDir must be on the same filesystem as the app.
+func installRootfs(workDir string, bundle *appInfo, pathSet *appPathSet) error {
+	src := path.Join(workDir, bundle.Rootfs)
+	s, err := os.Lstat(src)
+	if err != nil {
+		log.Printf("cannot access root filesystem: %v", err)
+		return err
+	}
+
+	if err = os.MkdirAll(pathSet.cacheDir, 0700); err != nil {
+		log.Printf("cannot create cache directory: %v", err)
+		return err
+	}
+	// make cache directory world-readable for autoetc
+	if err = os.Chmod(pathSet.cacheDir, 0755); err != nil {
+		log.Printf("cannot change mode of cache directory: %v", err)
+		return err
+	}
+
+	if err = removeAll(pathSet.rootfsPath); err != nil {
+		log.Printf("cannot remove root filesystem: %v", err)
+		return err
+	}
+
+	switch {
+	case s.IsDir():
+		if err = os.Rename(src, pathSet.rootfsPath); err != nil {
+			log.Printf("cannot move root filesystem: %v", err)
+			return err
+		}
+
+	case s.Mode().IsRegular():
+		var f *os.File
+		if f, err = os.Open(src); err != nil {
+			log.Printf("cannot open root filesystem image: %v", err)
+			return err
+		}
+		defer func() { _ = f.Close() }()
+
+		if err = os.Mkdir(pathSet.rootfsPath, 0755); err != nil {
+			log.Printf("cannot create root filesystem directory: %v", err)
+			return err
+		}
+		if r, err := decompress(f); err != nil {
+			log.Printf("cannot decompress root filesystem image: %v", err)
+			return err
+		} else if err = extract(r, pathSet.rootfsPath); err != nil {
+			log.Printf("cannot extract root filesystem image: %v", err)
+			return err
+		}
+
+	default:
+		log.Printf("root filesystem %q is not a directory or regular file", bundle.Rootfs)
+		return syscall.EBADMSG
+	}
+	return nil
+}
+
+// applyRootfs populates container with the root filesystem of a portable app.
+func (app *appInfo) applyRootfs(container *fst.ContainerConfig, pathSet *appPathSet) {
+	d, err := os.ReadDir(pathSet.rootfsPath)
+	if err != nil {
+		log.Fatalf("cannot read root filesystem: %v", err)
+	}
+
+	var hasEtc bool
+	container.Filesystem = make([]*fst.FilesystemConfig, 0, len(d)+1)
+	for _, ent := range d {
+		name := "/" + ent.Name()
+		switch name {
+		case "/etc":
+			hasEtc = ent.IsDir()
+			continue
+		case "/proc", "/dev", "/tmp", "/run", "/sys":
+			// set up by the container
+			continue
+		}
+
+		p := path.Join(pathSet.rootfsPath, ent.Name())
+		if ent.Type()&fs.ModeSymlink != 0 {
+			// binding a symlink follows it on the host
+			if target, err := os.Readlink(p); err != nil {
+				log.Fatalf("cannot read link %q: %v", p, err)
+			} else {
+				container.Link = append(container.Link, [2]string{target, name})
+			}
+			continue
+		}
+		container.Filesystem = append(container.Filesystem, &fst.FilesystemConfig{Src: p, Dst: name, Must: true})
+	}
+	container.Filesystem = append(container.Filesystem, &fst.FilesystemConfig{Src: "/etc/resolv.conf"})
+
+	// an empty /etc is used if the root filesystem does not provide one
+	if hasEtc {
+		container.Etc = path.Join(pathSet.rootfsPath, "etc")
+		container.AutoEtc = true
+	}
+}
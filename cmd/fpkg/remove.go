@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"git.gensokyo.uk/security/fortify/fst"
+)
+
+// removePaths removes names within the base directory of app, which must be ordered so children precede their parents.
+// Paths owned by the privileged user are removed directly, while paths owned by the target user are removed
+// first from within a container set up from the generation described by pathSet.
+func removePaths(ctx context.Context, app *appInfo, pathSet *appPathSet, names []string, beforeFail func()) error {
+	var own, target []string
+	for _, name := range names {
+		if s, err := os.Lstat(name); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			log.Printf("cannot access %q: %v", name, err)
+			return err
+		} else if st, ok := s.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+			target = append(target, name)
+		} else {
+			own = append(own, name)
+		}
+	}
+
+	if len(target) > 0 {
+		// paths relative to the base directory, which is the working directory of the container
+		rel := make([]string, len(target))
+		perms := make([]*fst.ExtraPermConfig, 0, len(target))
+		for i, name := range target {
+			if rel[i] = strings.TrimPrefix(name, pathSet.baseDir+"/"); rel[i] == name {
+				log.Printf("path %q is not within %q", name, pathSet.baseDir)
+				return syscall.EINVAL
+			}
+			// removing an entry requires write access to its parent
+			if parent := path.Dir(name); parent != pathSet.baseDir && parent != pathSet.genDir {
+				perms = append(perms, &fst.ExtraPermConfig{Path: parent, Read: true, Write: true, Execute: true})
+			}
+		}
+
+		withBaseDir(ctx, "remove", []string{
+			"chmod -R +w " + strings.Join(rel, " "),
+			"rm -rf " + strings.Join(rel, " "),
+		}, func(config *fst.Config) *fst.Config {
+			config.ExtraPerms = append(config.ExtraPerms, perms...)
+			return config
+		}, app, pathSet, beforeFail)
+	}
+
+	for _, name := range own {
+		if err := removeAll(name); err != nil {
+			log.Printf("cannot remove %q: %v", name, err)
+			return err
+		}
+	}
+	return nil
+}
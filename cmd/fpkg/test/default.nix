@@ -1,18 +1,25 @@
 {
   nixosTest,
   callPackage,
+  runCommand,
 
   system,
   self,
 }:
 let
   buildPackage = self.buildPackage.${system};
+  foot = callPackage ./foot.nix { inherit buildPackage; };
 in
 nixosTest {
   name = "fpkg";
   nodes.machine = {
     environment.etc = {
-      "foot.pkg".source = callPackage ./foot.nix { inherit buildPackage; };
+      "foot.pkg".source = foot;
+
+      # trust the package by its digest
+      "fpkg/trusted_keys".source = runCommand "fpkg-trusted-keys" { } ''
+        echo "sha256 $(sha256sum ${foot} | cut -d' ' -f1) foot.pkg" > $out
+      '';
     };
 
     imports = [
@@ -83,7 +83,7 @@ machine.wait_for_file("/tmp/sway-ipc.sock")
 machine.succeed("install -dm 0700 -o alice -g users /var/lib/fortify/1000")
 
 # Install fpkg app:
-swaymsg("exec fpkg -v install /etc/foot.pkg && touch /tmp/fpkg-install-done")
+swaymsg("exec fpkg -v install --accept /etc/foot.pkg && touch /tmp/fpkg-install-done")
 machine.wait_for_file("/tmp/fpkg-install-done")
 
 # Start app (foot) with Wayland enablement:
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"slices"
+	"strings"
+)
+
+/*
+	Packages are verified against a set of trusted keys and digests read from trustedKeysPath.
+	Each non-empty line not starting with '#' holds either an ed25519 public key or a SHA-256 digest:
+
+		ed25519 <base64 encoded public key>
+		sha256 <hex encoded digest> [name]
+
+	A package is trusted if its SHA-256 digest is listed, or if the detached signature next to it
+	(the package path suffixed with ".sig") is made by any of the listed keys. The signature file
+	holds the application identifier and version of the package followed by the base64 encoded
+	ed25519 signature of the message returned by signedMessage:
+
+		<id> <version> <base64 encoded signature>
+
+	The identifier and version are checked against the bundle metadata after extraction, so a
+	signature cannot be replayed for a different application, and installs older than the installed
+	version are refused regardless of how the package is trusted.
+*/
+
+const (
+	trustKeyEd25519 = "ed25519"
+	trustDigest     = "sha256"
+
+	// sigSuffix is appended to the package path to obtain the path to its detached signature.
+	sigSuffix = ".sig"
+)
+
+var (
+	// errUntrusted is returned by verify if a package is not trusted.
+	errUntrusted = errors.New("package is not trusted")
+)
+
+// signedPackage holds the claims of a detached signature, checked against the bundle metadata after extraction.
+type signedPackage struct {
+	ID      string
+	Version string
+}
+
+// signedMessage returns the message signed for the package of app id at version with SHA-256 digest.
+func signedMessage(id, version string, digest [sha256.Size]byte) []byte {
+	return fmt.Appendf(nil, "fpkg package\x00%s\x00%s\x00%x", id, version, digest)
+}
+
+// trustError describes an invalid line in the set of trusted keys.
+type trustError struct {
+	Line int
+	Err  error
+}
+
+func (e *trustError) Error() string { return fmt.Sprintf("line %d: %v", e.Line, e.Err) }
+func (e *trustError) Unwrap() error { return e.Err }
+
+// trustedSet holds keys and digests of trusted packages.
+type trustedSet struct {
+	keys    []ed25519.PublicKey
+	digests [][sha256.Size]byte
+}
+
+// loadTrusted parses the set of trusted keys at name. A nonexistent file results in an empty set.
+func loadTrusted(name string) (*trustedSet, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return new(trustedSet), nil
+		}
+		return nil, err
+	}
+	t, err := parseTrusted(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return t, err
+}
+
+func parseTrusted(r io.Reader) (*trustedSet, error) {
+	t := new(trustedSet)
+	s := bufio.NewScanner(r)
+	for i := 1; s.Scan(); i++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			return nil, &trustError{i, errors.New("missing value")}
+		}
+		switch fields[0] {
+		case trustKeyEd25519:
+			if key, err := base64.StdEncoding.DecodeString(fields[1]); err != nil {
+				return nil, &trustError{i, err}
+			} else if len(key) != ed25519.PublicKeySize {
+				return nil, &trustError{i, fmt.Errorf("invalid ed25519 public key length %d", len(key))}
+			} else {
+				t.keys = append(t.keys, key)
+			}
+
+		case trustDigest:
+			var digest [sha256.Size]byte
+			if len(fields[1]) != hex.EncodedLen(sha256.Size) {
+				return nil, &trustError{i, fmt.Errorf("invalid sha256 digest length %d", len(fields[1]))}
+			} else if _, err := hex.Decode(digest[:], []byte(fields[1])); err != nil {
+				return nil, &trustError{i, err}
+			}
+			t.digests = append(t.digests, digest)
+
+		default:
+			return nil, &trustError{i, fmt.Errorf("unknown entry type %q", fields[0])}
+		}
+	}
+	return t, s.Err()
+}
+
+// verify reads the package f located at name and checks it against the trusted set.
+// If the package is trusted by its signature, the claims of the signature are returned and must be checked
+// against the bundle metadata. The offset of f is reset on success so it can be extracted without opening the package again.
+func (t *trustedSet) verify(f io.ReadSeeker, name string) (*signedPackage, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	var digest [sha256.Size]byte
+	h.Sum(digest[:0])
+
+	var claims *signedPackage
+	if !slices.Contains(t.digests, digest) {
+		data, err := os.ReadFile(name + sigSuffix)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, fmt.Errorf("%w: digest %x is not listed and signature is absent", errUntrusted, digest)
+			}
+			return nil, err
+		}
+
+		fields := strings.Fields(string(data))
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("cannot parse signature: expected 3 fields, got %d", len(fields))
+		}
+		claims = &signedPackage{ID: fields[0], Version: fields[1]}
+		if sig, err := base64.StdEncoding.DecodeString(fields[2]); err != nil {
+			return nil, fmt.Errorf("cannot decode signature: %w", err)
+		} else if message := signedMessage(claims.ID, claims.Version, digest); !slices.ContainsFunc(t.keys, func(key ed25519.PublicKey) bool {
+			return ed25519.Verify(key, message, sig)
+		}) {
+			return nil, fmt.Errorf("%w: signature is not made by a trusted key", errUntrusted)
+		}
+	}
+
+	_, err := f.Seek(0, io.SeekStart)
+	return claims, err
+}
+
+// check returns an error wrapping [errUntrusted] if the claims of a signature do not match bundle.
+// A nil receiver corresponds to a package trusted by its digest and is always valid.
+func (s *signedPackage) check(bundle *appInfo) error {
+	if s == nil {
+		return nil
+	}
+	if s.ID != bundle.ID || s.Version != bundle.Version {
+		return fmt.Errorf("%w: signature is made for %q version %q", errUntrusted, s.ID, s.Version)
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTrusted(t *testing.T) {
+	key := bytes.Repeat([]byte{0xfd}, ed25519.PublicKeySize)
+	digest := sha256.Sum256([]byte("fpkg"))
+
+	testCases := []struct {
+		name    string
+		data    string
+		want    *trustedSet
+		wantErr error
+	}{
+		{"empty", "", new(trustedSet), nil},
+		{"valid", "# trusted packages\n\n" +
+			"ed25519 " + base64.StdEncoding.EncodeToString(key) + "\n" +
+			"  sha256 " + hex.EncodeToString(digest[:]) + "  foot.pkg\n",
+			&trustedSet{keys: []ed25519.PublicKey{key}, digests: [][sha256.Size]byte{digest}}, nil},
+		{"missing value", "ed25519\n", nil, &trustError{1, errors.New("missing value")}},
+		{"short key", "\ned25519 AAAA\n", nil, &trustError{2, errors.New("invalid ed25519 public key length 3")}},
+		{"short digest", "sha256 " + hex.EncodeToString(digest[:16]), nil,
+			&trustError{1, errors.New("invalid sha256 digest length 32")}},
+		{"long digest", "sha256 " + hex.EncodeToString(digest[:]) + "00", nil,
+			&trustError{1, errors.New("invalid sha256 digest length 66")}},
+		{"unknown", "rsa AAAA", nil, &trustError{1, errors.New(`unknown entry type "rsa"`)}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseTrusted(strings.NewReader(tc.data))
+			if !reflect.DeepEqual(err, tc.wantErr) {
+				t.Fatalf("parseTrusted: error = %v, want %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseTrusted: %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: error = %v", err)
+	}
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("GenerateKey: error = %v", err)
+	}
+
+	data := []byte("fortify package")
+	digest := sha256.Sum256(data)
+	sign := func(id, version string, digest [sha256.Size]byte) string {
+		return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, signedMessage(id, version, digest)))
+	}
+	sig := "org.example 1.0 " + sign("org.example", "1.0", digest) + "\n"
+	claims := &signedPackage{"org.example", "1.0"}
+
+	testCases := []struct {
+		name    string
+		trusted *trustedSet
+		sig     string
+		want    *signedPackage
+		wantErr bool
+	}{
+		{"untrusted", new(trustedSet), "", nil, true},
+		{"digest", &trustedSet{digests: [][sha256.Size]byte{digest}}, "", nil, false},
+		{"digest ignores signature", &trustedSet{digests: [][sha256.Size]byte{digest}}, "\x00", nil, false},
+		{"signature", &trustedSet{keys: []ed25519.PublicKey{otherPub, pub}}, sig, claims, false},
+		{"signature untrusted key", &trustedSet{keys: []ed25519.PublicKey{otherPub}}, sig, nil, true},
+		{"signature malformed", &trustedSet{keys: []ed25519.PublicKey{pub}}, "org.example 1.0 \x00", nil, true},
+		{"signature fields", &trustedSet{keys: []ed25519.PublicKey{pub}}, sign("org.example", "1.0", digest), nil, true},
+		{"signature digest only", &trustedSet{keys: []ed25519.PublicKey{pub}},
+			"org.example 1.0 " + base64.StdEncoding.EncodeToString(ed25519.Sign(priv, digest[:])), nil, true},
+		{"signature other data", &trustedSet{keys: []ed25519.PublicKey{pub}},
+			"org.example 1.0 " + sign("org.example", "1.0", sha256.Sum256([]byte("other"))), nil, true},
+		{"signature replayed version", &trustedSet{keys: []ed25519.PublicKey{pub}},
+			"org.example 2.0 " + sign("org.example", "1.0", digest), nil, true},
+		{"signature replayed id", &trustedSet{keys: []ed25519.PublicKey{pub}},
+			"org.example.other 1.0 " + sign("org.example", "1.0", digest), nil, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			name := path.Join(t.TempDir(), "app.pkg")
+			if tc.sig != "" {
+				if err := os.WriteFile(name+sigSuffix, []byte(tc.sig), 0600); err != nil {
+					t.Fatalf("WriteFile: error = %v", err)
+				}
+			}
+
+			r := bytes.NewReader(data)
+			if got, err := tc.trusted.verify(r, name); (err != nil) != tc.wantErr {
+				t.Fatalf("verify: error = %v, wantErr %v", err, tc.wantErr)
+			} else if err != nil {
+				return
+			} else if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("verify: %#v, want %#v", got, tc.want)
+			}
+			if r.Len() != len(data) {
+				t.Errorf("verify: offset not reset")
+			}
+		})
+	}
+
+	t.Run("untrusted error", func(t *testing.T) {
+		if _, err := new(trustedSet).verify(bytes.NewReader(data), path.Join(t.TempDir(), "app.pkg")); !errors.Is(err, errUntrusted) {
+			t.Errorf("verify: error = %v", err)
+		}
+	})
+}
+
+func TestSignedPackageCheck(t *testing.T) {
+	bundle := &appInfo{ID: "org.example", Version: "1.0"}
+	testCases := []struct {
+		name    string
+		claims  *signedPackage
+		wantErr bool
+	}{
+		{"digest", nil, false},
+		{"match", &signedPackage{"org.example", "1.0"}, false},
+		{"id", &signedPackage{"org.example.other", "1.0"}, true},
+		{"version", &signedPackage{"org.example", "0.9"}, true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.claims.check(bundle); (err != nil) != tc.wantErr {
+				t.Errorf("check: error = %v, wantErr %v", err, tc.wantErr)
+			} else if err != nil && !errors.Is(err, errUntrusted) {
+				t.Errorf("check: error = %v", err)
+			}
+		})
+	}
+}
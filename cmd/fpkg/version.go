@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// compareVersions compares version strings a and b the same way as builtins.compareVersions of nix,
+// returning -1 if a is older than b, 0 if they are equal and 1 if a is newer than b.
+func compareVersions(a, b string) int {
+	for a != "" || b != "" {
+		var c1, c2 string
+		c1, a = nextVersionComponent(a)
+		c2, b = nextVersionComponent(b)
+		if versionComponentLess(c1, c2) {
+			return -1
+		} else if versionComponentLess(c2, c1) {
+			return 1
+		}
+	}
+	return 0
+}
+
+// nextVersionComponent returns the component at the start of s after skipping separators, and the remainder of s.
+// A component is either a sequence of digits or a sequence of characters that are neither digits nor separators.
+func nextVersionComponent(s string) (string, string) {
+	s = strings.TrimLeft(s, ".-")
+	if s == "" {
+		return "", ""
+	}
+	digit := isDigit(s[0])
+	i := 1
+	for i < len(s) && s[i] != '.' && s[i] != '-' && isDigit(s[i]) == digit {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func versionComponentLess(c1, c2 string) bool {
+	n1, err1 := strconv.Atoi(c1)
+	n2, err2 := strconv.Atoi(c2)
+	switch {
+	case err1 == nil && err2 == nil:
+		return n1 < n2
+	case c1 == "" && err2 == nil:
+		return true
+	case c1 == "pre" && c2 != "pre":
+		return true
+	case c2 == "pre":
+		return false
+	// 2.3a is older than 2.3.1
+	case err2 == nil:
+		return true
+	case err1 == nil:
+		return false
+	default:
+		return c1 < c2
+	}
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	testCases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.3", -1},
+		{"2.1", "2.3", -1},
+		{"2.3", "2.3", 0},
+		{"2.5", "2.3", 1},
+		{"3.1", "2.3", 1},
+		{"2.3.1", "2.3", 1},
+		{"2.3.1", "2.3a", 1},
+		{"2.3pre1", "2.3", -1},
+		{"2.3pre3", "2.3pre12", -1},
+		{"2.3a", "2.3c", -1},
+		{"2.3pre1", "2.3c", -1},
+		{"2.3pre1", "2.3q", -1},
+		{"1.10", "1.9", 1},
+		{"1.0-unstable-2025-01-02", "1.0-unstable-2024-12-31", 1},
+		{"", "0", -1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.a+" "+tc.b, func(t *testing.T) {
+			if got := compareVersions(tc.a, tc.b); got != tc.want {
+				t.Errorf("compareVersions: %d, want %d", got, tc.want)
+			}
+			if got := compareVersions(tc.b, tc.a); got != -tc.want {
+				t.Errorf("compareVersions: reverse %d, want %d", got, -tc.want)
+			}
+		})
+	}
+}
@@ -31,14 +31,11 @@ func withNixDaemon(
 			" && pkill nix-daemon",
 		},
 
-		Username: "fortify",
-		Shell:    shellPath,
-		Data:     pathSet.homeDir,
-		Dir:      path.Join("/data/data", app.ID),
-		ExtraPerms: []*fst.ExtraPermConfig{
-			{Path: dataHome, Execute: true},
-			{Ensure: true, Path: pathSet.baseDir, Read: true, Write: true, Execute: true},
-		},
+		Username:   "fortify",
+		Shell:      shellPath,
+		Data:       pathSet.homeDir,
+		Dir:        path.Join("/data/data", app.ID),
+		ExtraPerms: pathSet.extraPerms(),
 
 		Identity: app.Identity,
 
@@ -76,11 +73,8 @@ func withCacheDir(
 		Shell:    shellPath,
 		Data:     pathSet.cacheDir, // this also ensures cacheDir via shim
 		Dir:      path.Join("/data/data", app.ID, "cache"),
-		ExtraPerms: []*fst.ExtraPermConfig{
-			{Path: dataHome, Execute: true},
-			{Ensure: true, Path: pathSet.baseDir, Read: true, Write: true, Execute: true},
-			{Path: workDir, Execute: true},
-		},
+		ExtraPerms: append(pathSet.extraPerms(),
+			&fst.ExtraPermConfig{Path: workDir, Execute: true}),
 
 		Identity: app.Identity,
 
@@ -103,6 +97,49 @@ func withCacheDir(
 	}, dropShell, beforeFail)
 }
 
+// withBaseDir runs command as the target user in the base directory of app,
+// within the environment of the generation described by pathSet.
+func withBaseDir(
+	ctx context.Context,
+	action string, command []string, updateConfig func(config *fst.Config) *fst.Config,
+	app *appInfo, pathSet *appPathSet, beforeFail func(),
+) {
+	config := &fst.Config{
+		ID: app.ID,
+
+		Username:   "fortify",
+		Data:       pathSet.baseDir,
+		Dir:        path.Join("/data/data", app.ID),
+		ExtraPerms: pathSet.extraPerms(),
+
+		Identity: app.Identity,
+
+		Container: &fst.ContainerConfig{
+			Hostname: formatHostname(app.Name) + "-" + action,
+		},
+	}
+	if app.isPortable() {
+		config.Shell = rootfsShell
+		app.applyRootfs(config.Container, pathSet)
+	} else {
+		config.Shell = shellPath
+		config.Container.Filesystem = []*fst.FilesystemConfig{
+			{Src: path.Join(pathSet.nixPath, "store"), Dst: "/nix/store", Must: true},
+		}
+		config.Container.Link = [][2]string{
+			{app.CurrentSystem, "/run/current-system"},
+			{"/run/current-system/sw/bin", "/bin"},
+			{"/run/current-system/sw/bin", "/usr/bin"},
+		}
+		config.Container.Etc = path.Join(pathSet.cacheDir, "etc")
+		config.Container.AutoEtc = true
+	}
+	config.Path = config.Shell
+	config.Args = []string{config.Shell, "-c", strings.Join(command, " && ")}
+
+	mustRunApp(ctx, updateConfig(config), beforeFail)
+}
+
 func mustRunAppDropShell(ctx context.Context, config *fst.Config, dropShell bool, beforeFail func()) {
 	if dropShell {
 		config.Args = []string{shellPath, "-l"}
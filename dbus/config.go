@@ -26,6 +26,8 @@ type Config struct {
 
 	Log    bool `json:"log,omitempty"`
 	Filter bool `json:"filter"`
+	// Learn permit all traffic and record a configuration permitting it (--learn), native proxy only
+	Learn bool `json:"learn,omitempty"`
 }
 
 func (c *Config) interfaces(yield func(string) bool) {
@@ -57,7 +59,8 @@ func (c *Config) interfaces(yield func(string) bool) {
 	}
 }
 
-func (c *Config) checkInterfaces(segment string) error {
+// CheckInterfaces returns a [BadInterfaceError] if any interface string of c is rejected by xdg-dbus-proxy.
+func (c *Config) CheckInterfaces(segment string) error {
 	for iface := range c.interfaces {
 		/*
 			xdg-dbus-proxy fails without output when this condition is not met:
@@ -91,6 +94,9 @@ func (c *Config) Args(bus ProxyPair) (args []string) {
 	if c.Filter {
 		argc++
 	}
+	if c.Learn {
+		argc++
+	}
 
 	args = make([]string, 0, argc)
 	args = append(args, bus[0], bus[1])
@@ -115,6 +121,9 @@ func (c *Config) Args(bus ProxyPair) (args []string) {
 	if c.Log {
 		args = append(args, "--log")
 	}
+	if c.Learn {
+		args = append(args, "--learn")
+	}
 
 	return
 }
@@ -0,0 +1,327 @@
+package dbus
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+const (
+	// maxLineLength is the maximum length of a line of the authentication protocol.
+	maxLineLength = 1 << 14
+	// maxAuthLines is the maximum number of lines exchanged before authentication completes.
+	maxAuthLines = 1 << 6
+	// maxUnixFds is the maximum number of file descriptors received along with a single read.
+	maxUnixFds = 253
+)
+
+var (
+	ErrAuth        = errors.New("authentication failed")
+	ErrLineLength  = errors.New("authentication line exceeds maximum length")
+	ErrMissingFds  = errors.New("message references file descriptors not received")
+	ErrUnsupported = errors.New("unsupported bus address")
+)
+
+// msgConn is a connection carrying D-Bus messages and the file descriptors attached to them.
+type msgConn struct {
+	conn *net.UnixConn
+
+	// bytes received but not yet consumed
+	buf []byte
+	// file descriptors received but not yet claimed by a message
+	fds []int
+	oob []byte
+
+	// serialises writes
+	wmu sync.Mutex
+}
+
+func newMsgConn(conn *net.UnixConn) *msgConn {
+	return &msgConn{conn: conn, oob: make([]byte, syscall.CmsgSpace(maxUnixFds*4))}
+}
+
+// fill reads once from the connection.
+func (c *msgConn) fill() error {
+	var p [4096]byte
+	n, oobn, flags, _, err := c.conn.ReadMsgUnix(p[:], c.oob)
+	if oobn > 0 {
+		if fds, err0 := parseRights(c.oob[:oobn]); err0 != nil {
+			return err0
+		} else {
+			c.fds = append(c.fds, fds...)
+		}
+	}
+	if flags&syscall.MSG_CTRUNC != 0 {
+		return syscall.EMSGSIZE
+	}
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return io.EOF
+	}
+	c.buf = append(c.buf, p[:n]...)
+	return nil
+}
+
+func parseRights(oob []byte) ([]int, error) {
+	msgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	var fds []int
+	for i := range msgs {
+		if v, err := syscall.ParseUnixRights(&msgs[i]); err == nil {
+			fds = append(fds, v...)
+		}
+	}
+	return fds, nil
+}
+
+// consume removes and returns the first n bytes of the buffer.
+func (c *msgConn) consume(n int) []byte {
+	v := bytes.Clone(c.buf[:n])
+	c.buf = append(c.buf[:0], c.buf[n:]...)
+	return v
+}
+
+// readByte returns the next byte received.
+func (c *msgConn) readByte() (byte, error) {
+	for len(c.buf) < 1 {
+		if err := c.fill(); err != nil {
+			return 0, err
+		}
+	}
+	return c.consume(1)[0], nil
+}
+
+// readLine returns the next line of the authentication protocol without its terminating CRLF.
+func (c *msgConn) readLine() (string, error) {
+	for {
+		if i := bytes.Index(c.buf, []byte("\r\n")); i != -1 {
+			line := c.consume(i + 2)
+			return string(line[:i]), nil
+		}
+		if len(c.buf) > maxLineLength {
+			return "", ErrLineLength
+		}
+		if err := c.fill(); err != nil {
+			return "", err
+		}
+	}
+}
+
+func (c *msgConn) writeLine(line string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	_, err := c.conn.Write([]byte(line + "\r\n"))
+	return err
+}
+
+// readMessage returns the next message received along with its file descriptors.
+func (c *msgConn) readMessage() (*message, error) {
+	for len(c.buf) < headerPrefixSize {
+		if err := c.fill(); err != nil {
+			return nil, err
+		}
+	}
+	n, err := messageLen(c.buf)
+	if err != nil {
+		return nil, err
+	}
+	for len(c.buf) < n {
+		if err = c.fill(); err != nil {
+			return nil, err
+		}
+	}
+
+	var m *message
+	if m, err = parseMessage(c.consume(n)); err != nil {
+		return nil, err
+	}
+	if int(m.unixFds) > len(c.fds) {
+		return nil, ErrMissingFds
+	}
+	m.fds = c.fds[:m.unixFds:m.unixFds]
+	c.fds = c.fds[m.unixFds:]
+	return m, nil
+}
+
+// writeMessage writes m and its file descriptors to the connection.
+func (c *msgConn) writeMessage(m *message) error {
+	b := m.wire()
+	var oob []byte
+	if len(m.fds) > 0 {
+		oob = syscall.UnixRights(m.fds...)
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	for len(b) > 0 {
+		n, _, err := c.conn.WriteMsgUnix(b, oob, nil)
+		if err != nil {
+			return err
+		}
+		b, oob = b[n:], nil
+	}
+	return nil
+}
+
+// Close closes the connection and file descriptors not claimed by a message.
+func (c *msgConn) Close() error {
+	for _, fd := range c.fds {
+		_ = syscall.Close(fd)
+	}
+	c.fds = nil
+	return c.conn.Close()
+}
+
+// dialBus connects to the first reachable unix socket described by addr.
+func dialBus(addr []AddrEntry) (*net.UnixConn, error) {
+	var errs []error
+	for _, ent := range addr {
+		if ent.Method != "unix" {
+			continue
+		}
+		for _, pair := range ent.Values {
+			var name string
+			switch pair[0] {
+			case "path":
+				name = pair[1]
+			case "abstract":
+				name = "@" + pair[1]
+			default:
+				continue
+			}
+
+			if conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: name, Net: "unix"}); err != nil {
+				errs = append(errs, err)
+			} else {
+				return conn, nil
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return nil, ErrUnsupported
+	}
+	return nil, errors.Join(errs...)
+}
+
+/*
+	The proxy authenticates to the upstream bus on its own behalf with the EXTERNAL mechanism,
+	without an authorisation identity so the bus derives it from the credentials of the socket.
+	Downstream clients connect to a socket only reachable by the sandbox the proxy serves, so any
+	client completing the EXTERNAL mechanism is accepted regardless of the identity it claims.
+*/
+
+// authUpstream authenticates the connection to the bus and returns the server GUID,
+// and whether passing file descriptors was agreed on.
+func (c *msgConn) authUpstream() (guid string, unixFds bool, err error) {
+	c.wmu.Lock()
+	_, err = c.conn.Write([]byte("\x00AUTH EXTERNAL\r\n"))
+	c.wmu.Unlock()
+	if err != nil {
+		return
+	}
+
+	for i := 0; ; i++ {
+		if i > maxAuthLines {
+			return "", false, ErrAuth
+		}
+
+		var line string
+		if line, err = c.readLine(); err != nil {
+			return
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+		switch cmd {
+		case "DATA":
+			if err = c.writeLine("DATA"); err != nil {
+				return
+			}
+		case "OK":
+			guid = arg
+			if err = c.writeLine("NEGOTIATE_UNIX_FD"); err != nil {
+				return
+			}
+		case "AGREE_UNIX_FD", "ERROR":
+			if guid == "" {
+				return "", false, ErrAuth
+			}
+			unixFds = cmd == "AGREE_UNIX_FD"
+			err = c.writeLine("BEGIN")
+			return
+		default:
+			return "", false, ErrAuth
+		}
+	}
+}
+
+// authDownstream completes authentication of a client using guid,
+// file descriptor passing is agreed on if unixFds is true.
+func (c *msgConn) authDownstream(guid string, unixFds bool) error {
+	if b, err := c.readByte(); err != nil {
+		return err
+	} else if b != 0 {
+		return ErrAuth
+	}
+
+	const rejected = "REJECTED EXTERNAL"
+	authenticated, waitData := false, false
+	for i := 0; ; i++ {
+		if i > maxAuthLines {
+			return ErrAuth
+		}
+
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		cmd, arg, _ := strings.Cut(line, " ")
+
+		var reply string
+		switch {
+		case cmd == "AUTH" && !authenticated:
+			mech, data, ok := strings.Cut(arg, " ")
+			switch {
+			case mech != "EXTERNAL":
+				reply = rejected
+			case !ok:
+				reply, waitData = "DATA", true
+			case validHex(data):
+				reply, authenticated = "OK "+guid, true
+			default:
+				reply = rejected
+			}
+		case cmd == "DATA" && waitData:
+			waitData = false
+			if validHex(arg) {
+				reply, authenticated = "OK "+guid, true
+			} else {
+				reply = rejected
+			}
+		case (cmd == "CANCEL" || cmd == "ERROR") && !authenticated:
+			reply, waitData = rejected, false
+		case cmd == "NEGOTIATE_UNIX_FD" && authenticated:
+			if unixFds {
+				reply = "AGREE_UNIX_FD"
+			} else {
+				reply = "ERROR"
+			}
+		case cmd == "BEGIN" && authenticated:
+			return nil
+		default:
+			reply = "ERROR"
+		}
+		if err = c.writeLine(reply); err != nil {
+			return err
+		}
+	}
+}
+
+func validHex(s string) bool { _, err := hex.DecodeString(s); return err == nil }
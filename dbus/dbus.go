@@ -1,4 +1,4 @@
-// Package dbus wraps xdg-dbus-proxy and implements configuration and sandboxing of the underlying helper process.
+// Package dbus implements a filtering D-Bus proxy, and configuration and sandboxing of its helper process.
 package dbus
 
 import (
@@ -141,12 +141,12 @@ func testProxyFinaliseStartWaitCloseString(t *testing.T, useSandbox bool) {
 					container.Args = append([]string{os.Args[0], "-test.run=TestHelperStub", "--"}, container.Args[1:]...)
 				} else {
 					cmd := v.(*exec.Cmd)
-					if cmd.Args[0] != dbus.ProxyName {
-						panic(fmt.Sprintf("unexpected argv0 %q", os.Args[0]))
+					if cmd.Args[0] != sandbox.MustExecutable() || cmd.Args[1] != "dbus" {
+						panic(fmt.Sprintf("unexpected argv %q", cmd.Args))
 					}
 					cmd.Err = nil
 					cmd.Path = os.Args[0]
-					cmd.Args = append([]string{os.Args[0], "-test.run=TestHelperStub", "--"}, cmd.Args[1:]...)
+					cmd.Args = append([]string{os.Args[0], "-test.run=TestHelperStub", "--"}, cmd.Args[2:]...)
 				}
 			}
 			p.FilterF = func(v []byte) []byte { return bytes.SplitN(v, []byte("TestHelperInit\n"), 2)[1] }
@@ -0,0 +1,293 @@
+package dbus
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+/*
+	Filtering follows the semantics of xdg-dbus-proxy: every rule applies to a bus name, or to a
+	name and all names below it if it ends with ".*". The highest policy of all rules matching a
+	name determines whether it is visible to the client, whether the client may talk to it and
+	whether the client may own it. Call and broadcast rules grant the talk policy restricted to
+	method calls and broadcast signals matching the rule.
+
+	A unique name is subject to the rules of all well-known names it owns.
+*/
+
+const (
+	// busName is the well-known name of the message bus itself.
+	busName = "org.freedesktop.DBus"
+	// busPath is the object path of the message bus.
+	busPath = "/org/freedesktop/DBus"
+)
+
+// policy is the level of access granted to a bus name.
+type policy byte
+
+const (
+	policyNone policy = iota
+	policySee
+	policyTalk
+	policyOwn
+)
+
+// ruleType is the set of message types a rule applies to.
+type ruleType byte
+
+const (
+	ruleCall ruleType = 1 << iota
+	ruleBroadcast
+
+	ruleAll = ruleCall | ruleBroadcast
+)
+
+var (
+	ErrBadRule = errors.New("malformed filtering rule")
+)
+
+// rule is a filtering rule applying to a bus name.
+type rule struct {
+	name string
+	// whether the rule applies to names below name
+	subtree bool
+	policy  policy
+	types   ruleType
+
+	// object path, or the root of a subtree if pathSubtree is true; empty for any path
+	path        string
+	pathSubtree bool
+	// interface and member, empty for any
+	iface, member string
+}
+
+// newRule returns a rule for name with an optional method and path restriction.
+// The restriction is formatted as [METHOD][@PATH] where METHOD is "*", an interface
+// followed by ".*", or a fully qualified member, and PATH optionally ends with "/*".
+func newRule(name string, p policy, types ruleType, restriction string) (*rule, error) {
+	r := &rule{policy: p, types: types}
+	if r.name, r.subtree = strings.CutSuffix(name, ".*"); r.name == "" {
+		return nil, ErrBadRule
+	}
+
+	if restriction == "" {
+		return r, nil
+	}
+	method, path, hasPath := strings.Cut(restriction, "@")
+	if hasPath {
+		if !strings.HasPrefix(path, "/") {
+			return nil, ErrBadRule
+		}
+		r.path, r.pathSubtree = strings.CutSuffix(path, "/*")
+		if r.path == "" {
+			r.path = "/"
+		}
+	}
+	if method != "" && method != "*" {
+		if iface, ok := strings.CutSuffix(method, ".*"); ok {
+			r.iface = iface
+		} else if i := strings.LastIndexByte(method, '.'); i > 0 {
+			r.iface, r.member = method[:i], method[i+1:]
+		} else {
+			return nil, ErrBadRule
+		}
+	}
+	return r, nil
+}
+
+// matchName returns whether r applies to the well-known name.
+func (r *rule) matchName(name string) bool {
+	return name == r.name || (r.subtree && strings.HasPrefix(name, r.name+"."))
+}
+
+// match returns whether r permits a message of type t with the given path, interface and member.
+func (r *rule) match(t ruleType, path, iface, member string) bool {
+	if r.policy < policyTalk || r.types&t == 0 {
+		return false
+	}
+	if r.path != "" && path != r.path &&
+		!(r.pathSubtree && (r.path == "/" || strings.HasPrefix(path, r.path+"/"))) {
+		return false
+	}
+	return (r.iface == "" || r.iface == iface) && (r.member == "" || r.member == member)
+}
+
+// rules is the set of filtering rules of a proxied bus.
+type rules []*rule
+
+// policy returns the highest policy granted to the well-known name.
+func (s rules) policy(name string) policy {
+	p := policyNone
+	for _, r := range s {
+		if r.policy > p && r.matchName(name) {
+			p = r.policy
+		}
+	}
+	return p
+}
+
+// match returns whether any rule applying to any of names permits a message.
+func (s rules) match(names []string, t ruleType, path, iface, member string) bool {
+	for _, r := range s {
+		if !r.match(t, path, iface, member) {
+			continue
+		}
+		for _, name := range names {
+			if r.matchName(name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isUnique returns whether name is a unique connection name.
+func isUnique(name string) bool { return strings.HasPrefix(name, ":") }
+
+// matchRule is a match rule registered by a client via AddMatch.
+// It is only evaluated against signals emitted by the message bus itself.
+type matchRule struct {
+	typ                         byte
+	sender, iface, member, path string
+	pathNamespace, dest         string
+	arg0Namespace               string
+	// the rule sets eavesdrop regardless of its value
+	eavesdrop bool
+
+	args, argPaths map[int]string
+}
+
+// parseMatchRule parses a match rule according to
+// https://dbus.freedesktop.org/doc/dbus-specification.html#message-bus-routing-match-rules
+func parseMatchRule(s string) (*matchRule, error) {
+	r := new(matchRule)
+	for s != "" {
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, ErrBadRule
+		}
+		key = strings.TrimSpace(key)
+
+		// values are quoted with apostrophes, and an apostrophe is escaped with a backslash outside quotes
+		value := new(strings.Builder)
+		quoted := false
+		i := 0
+	value:
+		for ; i < len(rest); i++ {
+			switch c := rest[i]; {
+			case c == '\'':
+				quoted = !quoted
+			case quoted:
+				value.WriteByte(c)
+			case c == '\\' && i+1 < len(rest) && rest[i+1] == '\'':
+				value.WriteByte('\'')
+				i++
+			case c == ',':
+				break value
+			default:
+				value.WriteByte(c)
+			}
+		}
+		if quoted {
+			return nil, ErrBadRule
+		}
+		s = rest[min(i+1, len(rest)):]
+
+		v := value.String()
+		switch key {
+		case "type":
+			switch v {
+			case "method_call":
+				r.typ = msgMethodCall
+			case "method_return":
+				r.typ = msgMethodReturn
+			case "error":
+				r.typ = msgError
+			case "signal":
+				r.typ = msgSignal
+			default:
+				return nil, ErrBadRule
+			}
+		case "sender":
+			r.sender = v
+		case "interface":
+			r.iface = v
+		case "member":
+			r.member = v
+		case "path":
+			r.path = v
+		case "path_namespace":
+			r.pathNamespace = v
+		case "destination":
+			r.dest = v
+		case "arg0namespace":
+			r.arg0Namespace = v
+		case "eavesdrop":
+			r.eavesdrop = true
+		default:
+			n, isPath := strings.CutSuffix(strings.TrimPrefix(key, "arg"), "path")
+			i, err := strconv.Atoi(n)
+			if !strings.HasPrefix(key, "arg") || err != nil || i < 0 || i > 63 || strconv.Itoa(i) != n {
+				return nil, ErrBadRule
+			}
+			if isPath {
+				if r.argPaths == nil {
+					r.argPaths = make(map[int]string)
+				}
+				r.argPaths[i] = v
+			} else {
+				if r.args == nil {
+					r.args = make(map[int]string)
+				}
+				r.args[i] = v
+			}
+		}
+	}
+	return r, nil
+}
+
+// match returns whether r matches the message m with string arguments args.
+func (r *matchRule) match(m *message, args []string) bool {
+	if (r.typ != 0 && r.typ != m.typ) ||
+		(r.sender != "" && r.sender != m.sender) ||
+		(r.iface != "" && r.iface != m.iface) ||
+		(r.member != "" && r.member != m.member) ||
+		(r.path != "" && r.path != m.path) ||
+		(r.dest != "" && r.dest != m.dest) {
+		return false
+	}
+	if r.pathNamespace != "" && m.path != r.pathNamespace &&
+		r.pathNamespace != "/" && !strings.HasPrefix(m.path, r.pathNamespace+"/") {
+		return false
+	}
+
+	arg := func(i int) (string, bool) {
+		if i < len(args) {
+			return args[i], true
+		}
+		return "", false
+	}
+	if r.arg0Namespace != "" {
+		if v, ok := arg(0); !ok || (v != r.arg0Namespace && !strings.HasPrefix(v, r.arg0Namespace+".")) {
+			return false
+		}
+	}
+	for i, want := range r.args {
+		if v, ok := arg(i); !ok || v != want {
+			return false
+		}
+	}
+	for i, want := range r.argPaths {
+		v, ok := arg(i)
+		if !ok {
+			return false
+		}
+		if v != want &&
+			!(strings.HasSuffix(v, "/") && strings.HasPrefix(want, v)) &&
+			!(strings.HasSuffix(want, "/") && strings.HasPrefix(v, want)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,195 @@
+package dbus
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestNewRule(t *testing.T) {
+	testCases := []struct {
+		name, restriction string
+		want              *rule
+		wantErr           error
+	}{
+		{"org.freedesktop.Notifications", "",
+			&rule{name: "org.freedesktop.Notifications"}, nil},
+		{"org.mpris.MediaPlayer2.*", "",
+			&rule{name: "org.mpris.MediaPlayer2", subtree: true}, nil},
+		{"org.freedesktop.portal.*", "*",
+			&rule{name: "org.freedesktop.portal", subtree: true}, nil},
+		{"org.freedesktop.portal.Desktop", "org.freedesktop.portal.Settings.Read@/org/freedesktop/portal/desktop",
+			&rule{name: "org.freedesktop.portal.Desktop", iface: "org.freedesktop.portal.Settings", member: "Read",
+				path: "/org/freedesktop/portal/desktop"}, nil},
+		{"org.freedesktop.portal.Desktop", "org.freedesktop.portal.*@/org/freedesktop/portal/*",
+			&rule{name: "org.freedesktop.portal.Desktop", iface: "org.freedesktop.portal",
+				path: "/org/freedesktop/portal", pathSubtree: true}, nil},
+		{"org.freedesktop.portal.Desktop", "@/*",
+			&rule{name: "org.freedesktop.portal.Desktop", path: "/", pathSubtree: true}, nil},
+
+		{"", "", nil, ErrBadRule},
+		{".*", "", nil, ErrBadRule},
+		{"org.freedesktop.portal.Desktop", "Read", nil, ErrBadRule},
+		{"org.freedesktop.portal.Desktop", "*@org/freedesktop", nil, ErrBadRule},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name+"="+tc.restriction, func(t *testing.T) {
+			got, err := newRule(tc.name, policyTalk, ruleCall, tc.restriction)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("newRule: error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if tc.want != nil {
+				tc.want.policy, tc.want.types = policyTalk, ruleCall
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("newRule: %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestRules(t *testing.T) {
+	mustRule := func(name string, p policy, types ruleType, restriction string) *rule {
+		if r, err := newRule(name, p, types, restriction); err != nil {
+			panic(err.Error())
+		} else {
+			return r
+		}
+	}
+	s := rules{
+		mustRule("org.freedesktop.Notifications", policyTalk, ruleAll, ""),
+		mustRule("org.mpris.MediaPlayer2.*", policyOwn, ruleAll, ""),
+		mustRule("org.freedesktop.portal.*", policySee, ruleAll, ""),
+		mustRule("org.freedesktop.portal.Desktop", policyTalk, ruleCall, "org.freedesktop.portal.Settings.*@/org/freedesktop/portal/desktop"),
+		mustRule("org.freedesktop.portal.Desktop", policyTalk, ruleBroadcast, "org.freedesktop.portal.Settings.SettingChanged@/org/freedesktop/portal/*"),
+	}
+
+	t.Run("policy", func(t *testing.T) {
+		testCases := []struct {
+			name string
+			want policy
+		}{
+			{"org.freedesktop.Notifications", policyTalk},
+			{"org.freedesktop.Notifications.Extra", policyNone},
+			{"org.mpris.MediaPlayer2", policyOwn},
+			{"org.mpris.MediaPlayer2.chromium.instance1", policyOwn},
+			{"org.mpris.MediaPlayer2chromium", policyNone},
+			{"org.freedesktop.portal.Documents", policySee},
+			{"org.freedesktop.portal.Desktop", policyTalk},
+			{"org.freedesktop.systemd1", policyNone},
+			{"", policyNone},
+		}
+		for _, tc := range testCases {
+			if got := s.policy(tc.name); got != tc.want {
+				t.Errorf("policy(%q): %d, want %d", tc.name, got, tc.want)
+			}
+		}
+	})
+
+	t.Run("match", func(t *testing.T) {
+		testCases := []struct {
+			names               []string
+			t                   ruleType
+			path, iface, member string
+			want                bool
+		}{
+			{[]string{"org.freedesktop.Notifications"}, ruleCall,
+				"/org/freedesktop/Notifications", "org.freedesktop.Notifications", "Notify", true},
+			{[]string{"org.freedesktop.Notifications"}, ruleBroadcast,
+				"/org/freedesktop/Notifications", "org.freedesktop.Notifications", "ActionInvoked", true},
+			{[]string{"org.freedesktop.portal.Documents"}, ruleCall,
+				"/org/freedesktop/portal/documents", "org.freedesktop.portal.Documents", "Add", false},
+			{[]string{"org.freedesktop.portal.Desktop"}, ruleCall,
+				"/org/freedesktop/portal/desktop", "org.freedesktop.portal.Settings", "Read", true},
+			{[]string{"org.freedesktop.portal.Desktop"}, ruleCall,
+				"/org/freedesktop/portal/desktop/extra", "org.freedesktop.portal.Settings", "Read", false},
+			{[]string{"org.freedesktop.portal.Desktop"}, ruleCall,
+				"/org/freedesktop/portal/desktop", "org.freedesktop.portal.FileChooser", "OpenFile", false},
+			{[]string{"org.freedesktop.portal.Desktop"}, ruleBroadcast,
+				"/org/freedesktop/portal/desktop", "org.freedesktop.portal.Settings", "SettingChanged", true},
+			{[]string{"org.freedesktop.portal.Desktop"}, ruleBroadcast,
+				"/org/freedesktop/portal", "org.freedesktop.portal.Settings", "SettingChanged", true},
+			{[]string{"org.freedesktop.portal.Desktop"}, ruleBroadcast,
+				"/org/freedesktop/portalx", "org.freedesktop.portal.Settings", "SettingChanged", false},
+			{[]string{"org.freedesktop.portal.Desktop"}, ruleBroadcast,
+				"/org/freedesktop/portal/desktop", "org.freedesktop.portal.Settings", "Read", false},
+			{[]string{"org.freedesktop.systemd1", "org.mpris.MediaPlayer2.mpv"}, ruleCall,
+				"/org/mpris/MediaPlayer2", "org.mpris.MediaPlayer2.Player", "Play", true},
+			{nil, ruleCall, "/", "org.freedesktop.DBus.Peer", "Ping", false},
+		}
+		for _, tc := range testCases {
+			if got := s.match(tc.names, tc.t, tc.path, tc.iface, tc.member); got != tc.want {
+				t.Errorf("match(%q, %d, %q, %q, %q): %v, want %v",
+					tc.names, tc.t, tc.path, tc.iface, tc.member, got, tc.want)
+			}
+		}
+	})
+}
+
+func TestMatchRule(t *testing.T) {
+	testCases := []struct {
+		rule    string
+		want    *matchRule
+		wantErr error
+
+		m     *message
+		args  []string
+		match bool
+	}{
+		{"type='signal',sender='org.freedesktop.DBus',interface='org.freedesktop.DBus',member='NameOwnerChanged'",
+			&matchRule{typ: msgSignal, sender: busName, iface: busName, member: "NameOwnerChanged"}, nil,
+			&message{typ: msgSignal, sender: busName, iface: busName, member: "NameOwnerChanged", path: busPath},
+			[]string{"org.example.App", "", ":1.1"}, true},
+		{"type='signal',member='NameOwnerChanged',arg0='org.example.App'",
+			&matchRule{typ: msgSignal, member: "NameOwnerChanged", args: map[int]string{0: "org.example.App"}}, nil,
+			&message{typ: msgSignal, sender: busName, iface: busName, member: "NameOwnerChanged", path: busPath},
+			[]string{"org.example.Other", "", ":1.1"}, false},
+		{"type='signal',arg0namespace='org.mpris.MediaPlayer2'",
+			&matchRule{typ: msgSignal, arg0Namespace: "org.mpris.MediaPlayer2"}, nil,
+			&message{typ: msgSignal, sender: busName, iface: busName, member: "NameOwnerChanged", path: busPath},
+			[]string{"org.mpris.MediaPlayer2.mpv", "", ":1.1"}, true},
+		{"path_namespace='/org/freedesktop',eavesdrop='true'",
+			&matchRule{pathNamespace: "/org/freedesktop", eavesdrop: true}, nil,
+			&message{typ: msgSignal, path: busPath},
+			nil, true},
+		{"path_namespace='/org/freedesktop'",
+			&matchRule{pathNamespace: "/org/freedesktop"}, nil,
+			&message{typ: msgSignal, path: "/org/freedesktopx"},
+			nil, false},
+		{"arg1path='/aa/bb/',arg2=''",
+			&matchRule{argPaths: map[int]string{1: "/aa/bb/"}, args: map[int]string{2: ""}}, nil,
+			&message{typ: msgSignal},
+			[]string{"", "/aa/bb/cc", ""}, true},
+		{"member='It'\\''s'",
+			&matchRule{member: "It's"}, nil,
+			&message{typ: msgSignal, member: "It's"},
+			nil, true},
+		{"type='method_call'",
+			&matchRule{typ: msgMethodCall}, nil,
+			&message{typ: msgSignal},
+			nil, false},
+
+		{"type='invalid'", nil, ErrBadRule, nil, nil, false},
+		{"member='unterminated", nil, ErrBadRule, nil, nil, false},
+		{"member", nil, ErrBadRule, nil, nil, false},
+		{"arg64='value'", nil, ErrBadRule, nil, nil, false},
+		{"arg01='value'", nil, ErrBadRule, nil, nil, false},
+		{"unknown='value'", nil, ErrBadRule, nil, nil, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.rule, func(t *testing.T) {
+			got, err := parseMatchRule(tc.rule)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("parseMatchRule: error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("parseMatchRule: %#v, want %#v", got, tc.want)
+			}
+			if got != nil {
+				if match := got.match(tc.m, tc.args); match != tc.match {
+					t.Errorf("match: %v, want %v", match, tc.match)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,214 @@
+package dbus
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+/*
+	In learn mode the proxy grants every name the own policy, so the client is subject to the same
+	restrictions on message bus methods as a filtered client but may otherwise talk to anything.
+	Names the client looked up, talked to, owned, called and received broadcasts from are recorded
+	and summarised into the smallest configuration that would have permitted the same traffic.
+*/
+
+// learnSuffix is appended to the downstream socket path to form the path the learned configuration is written to.
+const learnSuffix = ".learn"
+
+// learnedMember is the object path, interface and member a message was addressed to or emitted from.
+type learnedMember struct{ path, iface, member string }
+
+// learner records traffic relayed by a proxy in learn mode, and is safe for concurrent use.
+type learner struct {
+	mu sync.Mutex
+
+	see, talk, own  map[string]struct{}
+	call, broadcast map[string]map[learnedMember]struct{}
+}
+
+func newLearner() *learner {
+	return &learner{
+		see:       make(map[string]struct{}),
+		talk:      make(map[string]struct{}),
+		own:       make(map[string]struct{}),
+		call:      make(map[string]map[learnedMember]struct{}),
+		broadcast: make(map[string]map[learnedMember]struct{}),
+	}
+}
+
+// ignore returns whether name cannot or need not appear in a configuration.
+func ignore(name string) bool { return name == "" || name == busName || isUnique(name) }
+
+// add records name in set.
+func (l *learner) add(set map[string]struct{}, name string) {
+	if ignore(name) {
+		return
+	}
+	l.mu.Lock()
+	set[name] = struct{}{}
+	l.mu.Unlock()
+}
+
+// addMember records a message of name in set.
+func (l *learner) addMember(set map[string]map[learnedMember]struct{}, names []string, m *message) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, name := range names {
+		if ignore(name) {
+			continue
+		}
+		if set[name] == nil {
+			set[name] = make(map[learnedMember]struct{})
+		}
+		set[name][learnedMember{m.path, m.iface, m.member}] = struct{}{}
+	}
+}
+
+// known returns whether anything was recorded for name.
+func (l *learner) known(name string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, set := range []map[string]struct{}{l.see, l.talk, l.own} {
+		if _, ok := set[name]; ok {
+			return true
+		}
+	}
+	return l.call[name] != nil || l.broadcast[name] != nil
+}
+
+// config returns the smallest configuration permitting the recorded traffic.
+func (l *learner) config() *Config {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := &Config{Call: make(map[string]string), Broadcast: make(map[string]string), Filter: true}
+	c.Own = slices.Sorted(maps.Keys(l.own))
+	for _, name := range slices.Sorted(maps.Keys(l.talk)) {
+		if _, ok := l.own[name]; !ok {
+			c.Talk = append(c.Talk, name)
+		}
+	}
+	covered := func(name string) bool {
+		_, owned := l.own[name]
+		_, talked := l.talk[name]
+		return owned || talked
+	}
+	for name, members := range l.call {
+		if !covered(name) {
+			c.Call[name] = summarise(members)
+		}
+	}
+	for name, members := range l.broadcast {
+		if !covered(name) {
+			c.Broadcast[name] = summarise(members)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(l.see)) {
+		_, called := c.Call[name]
+		_, received := c.Broadcast[name]
+		if !covered(name) && !called && !received {
+			c.See = append(c.See, name)
+		}
+	}
+	return c
+}
+
+// summarise returns the most specific rule matching all members.
+func summarise(members map[learnedMember]struct{}) string {
+	var iface, name, prefix string
+	sameIface, sameMember := true, true
+	first := true
+	for m := range members {
+		if first {
+			iface, name, prefix = m.iface, m.member, m.path
+			first = false
+			continue
+		}
+		sameIface = sameIface && m.iface == iface
+		sameMember = sameMember && m.member == name
+		prefix = commonPath(prefix, m.path)
+	}
+
+	var method string
+	switch {
+	case !sameIface || iface == "":
+		method = "*"
+	case !sameMember:
+		method = iface + ".*"
+	default:
+		method = iface + "." + name
+	}
+
+	switch {
+	case allPath(members, prefix):
+		return method + "@" + prefix
+	case prefix == "/":
+		return method
+	default:
+		return method + "@" + prefix + "/*"
+	}
+}
+
+// allPath returns whether every member has the object path p.
+func allPath(members map[learnedMember]struct{}, p string) bool {
+	for m := range members {
+		if m.path != p {
+			return false
+		}
+	}
+	return true
+}
+
+// commonPath returns the longest object path a and b are equal to or below.
+func commonPath(a, b string) string {
+	for a != b && !strings.HasPrefix(b, a+"/") {
+		if i := strings.LastIndexByte(a, '/'); i <= 0 {
+			return "/"
+		} else {
+			a = a[:i]
+		}
+	}
+	return a
+}
+
+// writeLearned writes the configuration learned by p next to its downstream socket.
+func (p *proxyArgs) writeLearned() error {
+	if data, err := json.Marshal(p.learned.config()); err != nil {
+		return err
+	} else {
+		return os.WriteFile(p.downstream+learnSuffix, data, 0600)
+	}
+}
+
+// readLearned reads and removes the configuration learned by the proxy of bus.
+// A nil configuration is returned if the proxy was not in learn mode.
+func readLearned(bus ProxyPair) (*Config, error) {
+	name := bus[1] + learnSuffix
+	if c, err := NewConfigFromFile(name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	} else {
+		return c, os.Remove(name)
+	}
+}
+
+// Learned returns configurations learned by the proxy, and must only be called after [Proxy.Wait] returns.
+// A nil configuration is returned for a bus not proxied in learn mode.
+func (p *Proxy) Learned() (session, system *Config, err error) {
+	var errs [2]error
+	if p.final.SessionUpstream != nil {
+		session, errs[0] = readLearned(p.final.Session)
+	}
+	if p.final.SystemUpstream != nil {
+		system, errs[1] = readLearned(p.final.System)
+	}
+	return session, system, errors.Join(errs[:]...)
+}
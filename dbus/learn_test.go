@@ -0,0 +1,104 @@
+package dbus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSummarise(t *testing.T) {
+	testCases := []struct {
+		name    string
+		members []learnedMember
+		want    string
+	}{
+		{"single", []learnedMember{
+			{"/org/freedesktop/portal/desktop", "org.freedesktop.portal.Settings", "Read"},
+		}, "org.freedesktop.portal.Settings.Read@/org/freedesktop/portal/desktop"},
+		{"members", []learnedMember{
+			{"/org/freedesktop/portal/desktop", "org.freedesktop.portal.Settings", "Read"},
+			{"/org/freedesktop/portal/desktop", "org.freedesktop.portal.Settings", "ReadAll"},
+		}, "org.freedesktop.portal.Settings.*@/org/freedesktop/portal/desktop"},
+		{"paths", []learnedMember{
+			{"/org/freedesktop/portal/desktop", "org.freedesktop.portal.Settings", "Read"},
+			{"/org/freedesktop/portal/documents", "org.freedesktop.portal.Settings", "Read"},
+		}, "org.freedesktop.portal.Settings.Read@/org/freedesktop/portal/*"},
+		{"parent path", []learnedMember{
+			{"/org/freedesktop/portal", "org.freedesktop.portal.Settings", "Read"},
+			{"/org/freedesktop/portal/desktop", "org.freedesktop.portal.Settings", "Read"},
+		}, "org.freedesktop.portal.Settings.Read@/org/freedesktop/portal/*"},
+		{"interfaces", []learnedMember{
+			{"/org/mpris/MediaPlayer2", "org.mpris.MediaPlayer2.Player", "Play"},
+			{"/org/mpris/MediaPlayer2", "org.freedesktop.DBus.Properties", "Get"},
+		}, "*@/org/mpris/MediaPlayer2"},
+		{"no interface", []learnedMember{
+			{"/org/example", "", "Ping"},
+		}, "*@/org/example"},
+		{"disjoint", []learnedMember{
+			{"/org/example", "org.example.Iface", "Ping"},
+			{"/com/example", "org.example.Iface", "Ping"},
+		}, "org.example.Iface.Ping"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			members := make(map[learnedMember]struct{}, len(tc.members))
+			for _, m := range tc.members {
+				members[m] = struct{}{}
+			}
+			if got := summarise(members); got != tc.want {
+				t.Errorf("summarise: %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCommonPath(t *testing.T) {
+	testCases := []struct{ a, b, want string }{
+		{"/org/example", "/org/example", "/org/example"},
+		{"/org/example", "/org/example/sub", "/org/example"},
+		{"/org/example/sub", "/org/example", "/org/example"},
+		{"/org/example", "/org/examplex", "/org"},
+		{"/org/example", "/com/example", "/"},
+		{"/", "/org/example", "/"},
+	}
+	for _, tc := range testCases {
+		if got := commonPath(tc.a, tc.b); got != tc.want {
+			t.Errorf("commonPath(%q, %q): %q, want %q", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestLearnerConfig(t *testing.T) {
+	l := newLearner()
+	for _, name := range []string{"org.freedesktop.portal.Documents", "org.freedesktop.portal.Desktop", "org.mpris.MediaPlayer2.mpv", busName, ":1.1", ""} {
+		l.add(l.see, name)
+	}
+	l.add(l.talk, "org.freedesktop.Notifications")
+	l.add(l.talk, "org.mpris.MediaPlayer2.mpv")
+	l.add(l.own, "org.mpris.MediaPlayer2.mpv")
+	l.addMember(l.call, []string{"org.freedesktop.portal.Desktop", ":1.2"},
+		&message{path: "/org/freedesktop/portal/desktop", iface: "org.freedesktop.portal.Settings", member: "Read"})
+	l.addMember(l.call, []string{"org.freedesktop.Notifications"},
+		&message{path: "/org/freedesktop/Notifications", iface: "org.freedesktop.Notifications", member: "Notify"})
+	l.addMember(l.broadcast, []string{"org.freedesktop.portal.Desktop"},
+		&message{path: "/org/freedesktop/portal/desktop", iface: "org.freedesktop.portal.Settings", member: "SettingChanged"})
+
+	if !l.known("org.freedesktop.portal.Desktop") || l.known("org.freedesktop.systemd1") || l.known(":1.2") {
+		t.Errorf("known: unexpected result")
+	}
+
+	want := &Config{
+		See:  []string{"org.freedesktop.portal.Documents"},
+		Talk: []string{"org.freedesktop.Notifications"},
+		Own:  []string{"org.mpris.MediaPlayer2.mpv"},
+		Call: map[string]string{
+			"org.freedesktop.portal.Desktop": "org.freedesktop.portal.Settings.Read@/org/freedesktop/portal/desktop",
+		},
+		Broadcast: map[string]string{
+			"org.freedesktop.portal.Desktop": "org.freedesktop.portal.Settings.SettingChanged@/org/freedesktop/portal/desktop",
+		},
+		Filter: true,
+	}
+	if got := l.config(); !reflect.DeepEqual(got, want) {
+		t.Errorf("config: %#v, want %#v", got, want)
+	}
+}
@@ -0,0 +1,625 @@
+package dbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+/*
+	Messages are parsed according to
+	https://dbus.freedesktop.org/doc/dbus-specification.html#message-protocol
+
+	Only the header is interpreted when relaying messages; forwarded messages are written out
+	exactly as they were received. Bodies are decoded for the few bus driver methods and signals
+	the proxy has to inspect.
+*/
+
+const (
+	msgMethodCall byte = 1 + iota
+	msgMethodReturn
+	msgError
+	msgSignal
+)
+
+const (
+	flagNoReplyExpected byte = 1 << iota
+	flagNoAutoStart
+)
+
+const (
+	fieldPath byte = 1 + iota
+	fieldInterface
+	fieldMember
+	fieldErrorName
+	fieldReplySerial
+	fieldDestination
+	fieldSender
+	fieldSignature
+	fieldUnixFds
+)
+
+const (
+	// maxMessageSize is the maximum length of a message including its header.
+	maxMessageSize = 1 << 27
+	// maxArraySize is the maximum length of an array in bytes.
+	maxArraySize = 1 << 26
+	// maxDepth is the maximum nesting depth of container types.
+	maxDepth = 64
+
+	// headerPrefixSize is the length of the fixed part of the message header.
+	headerPrefixSize = 16
+)
+
+var (
+	ErrBadMessage   = errors.New("malformed message")
+	ErrMessageSize  = errors.New("message exceeds maximum length")
+	ErrBadSignature = errors.New("malformed type signature")
+)
+
+// message is a D-Bus message with its header fields decoded.
+type message struct {
+	order binary.ByteOrder
+
+	typ, flags byte
+	serial     uint32
+
+	path, iface, member, errName string
+	dest, sender, sig            string
+	replySerial, unixFds         uint32
+
+	body []byte
+	// file descriptors attached to the message
+	fds []int
+	// wire representation, nil for messages created by the proxy
+	raw []byte
+}
+
+func (m *message) String() string {
+	buf := new(strings.Builder)
+	switch m.typ {
+	case msgMethodCall:
+		buf.WriteString("call")
+	case msgMethodReturn:
+		buf.WriteString("return")
+	case msgError:
+		buf.WriteString("error")
+	case msgSignal:
+		buf.WriteString("signal")
+	default:
+		_, _ = fmt.Fprintf(buf, "type %d", m.typ)
+	}
+	_, _ = fmt.Fprintf(buf, " serial=%d", m.serial)
+	if m.replySerial != 0 {
+		_, _ = fmt.Fprintf(buf, " reply_serial=%d", m.replySerial)
+	}
+	if m.sender != "" {
+		buf.WriteString(" sender=" + m.sender)
+	}
+	if m.dest != "" {
+		buf.WriteString(" destination=" + m.dest)
+	}
+	if m.path != "" {
+		buf.WriteString(" path=" + m.path)
+	}
+	if m.iface != "" {
+		buf.WriteString(" interface=" + m.iface)
+	}
+	if m.member != "" {
+		buf.WriteString(" member=" + m.member)
+	}
+	if m.errName != "" {
+		buf.WriteString(" error_name=" + m.errName)
+	}
+	return buf.String()
+}
+
+// close closes file descriptors attached to m.
+func (m *message) close() {
+	for _, fd := range m.fds {
+		_ = syscall.Close(fd)
+	}
+	m.fds = nil
+}
+
+// wire returns the wire representation of m.
+func (m *message) wire() []byte {
+	if m.raw == nil {
+		m.raw = m.marshal()
+	}
+	return m.raw
+}
+
+func byteOrder(b byte) (binary.ByteOrder, bool) {
+	switch b {
+	case 'l':
+		return binary.LittleEndian, true
+	case 'B':
+		return binary.BigEndian, true
+	default:
+		return nil, false
+	}
+}
+
+func align(n, a int) int { return (n + a - 1) &^ (a - 1) }
+
+// messageLen returns the length of the message beginning with the fixed header in b.
+func messageLen(b []byte) (int, error) {
+	if len(b) < headerPrefixSize {
+		return 0, ErrBadMessage
+	}
+	order, ok := byteOrder(b[0])
+	if !ok || b[3] != 1 {
+		return 0, ErrBadMessage
+	}
+	fieldsLen, bodyLen := order.Uint32(b[12:]), order.Uint32(b[4:])
+	if fieldsLen > maxArraySize || bodyLen > maxMessageSize {
+		return 0, ErrMessageSize
+	}
+	n := align(headerPrefixSize+int(fieldsLen), 8) + int(bodyLen)
+	if n > maxMessageSize {
+		return 0, ErrMessageSize
+	}
+	return n, nil
+}
+
+// parseMessage parses the complete message in b, b is retained as its wire representation.
+func parseMessage(b []byte) (*message, error) {
+	n, err := messageLen(b)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(b) {
+		return nil, ErrBadMessage
+	}
+
+	m := &message{raw: b, typ: b[1], flags: b[2]}
+	m.order, _ = byteOrder(b[0])
+	m.serial = m.order.Uint32(b[8:])
+	if m.serial == 0 || m.typ < msgMethodCall || m.typ > msgSignal {
+		return nil, ErrBadMessage
+	}
+
+	fieldsEnd := headerPrefixSize + int(m.order.Uint32(b[12:]))
+	d := &decoder{order: m.order, b: b[:fieldsEnd], pos: headerPrefixSize}
+	for d.pos < fieldsEnd {
+		if err = d.align(8); err != nil {
+			return nil, err
+		}
+		var code byte
+		if code, err = d.byte(); err != nil {
+			return nil, err
+		}
+		var sig string
+		if sig, err = d.signature(); err != nil {
+			return nil, err
+		}
+
+		var want byte
+		var s *string
+		var u *uint32
+		switch code {
+		case fieldPath:
+			want, s = 'o', &m.path
+		case fieldInterface:
+			want, s = 's', &m.iface
+		case fieldMember:
+			want, s = 's', &m.member
+		case fieldErrorName:
+			want, s = 's', &m.errName
+		case fieldReplySerial:
+			want, u = 'u', &m.replySerial
+		case fieldDestination:
+			want, s = 's', &m.dest
+		case fieldSender:
+			want, s = 's', &m.sender
+		case fieldSignature:
+			want, s = 'g', &m.sig
+		case fieldUnixFds:
+			want, u = 'u', &m.unixFds
+		default:
+			// unknown header fields must be ignored
+			var rest string
+			if rest, err = d.skip(sig, 0); err != nil {
+				return nil, err
+			} else if rest != "" {
+				return nil, ErrBadSignature
+			}
+			continue
+		}
+
+		if len(sig) != 1 || sig[0] != want {
+			return nil, ErrBadMessage
+		}
+		switch want {
+		case 'u':
+			*u, err = d.uint32()
+		case 'g':
+			*s, err = d.signature()
+		default:
+			*s, err = d.string()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch m.typ {
+	case msgMethodCall:
+		if m.path == "" || m.member == "" {
+			return nil, ErrBadMessage
+		}
+	case msgSignal:
+		if m.path == "" || m.iface == "" || m.member == "" {
+			return nil, ErrBadMessage
+		}
+	case msgError:
+		if m.errName == "" {
+			return nil, ErrBadMessage
+		}
+		fallthrough
+	case msgMethodReturn:
+		if m.replySerial == 0 {
+			return nil, ErrBadMessage
+		}
+	}
+
+	m.body = b[align(fieldsEnd, 8):]
+	return m, nil
+}
+
+// marshal returns the wire representation of the header fields and body of m.
+func (m *message) marshal() []byte {
+	if m.order == nil {
+		m.order = binary.LittleEndian
+	}
+	e := &encoder{order: m.order}
+	if m.order == binary.BigEndian {
+		e.b = append(e.b, 'B')
+	} else {
+		e.b = append(e.b, 'l')
+	}
+	e.b = append(e.b, m.typ, m.flags, 1)
+	e.uint32(uint32(len(m.body)))
+	e.uint32(m.serial)
+
+	e.uint32(0)
+	start := len(e.b)
+	field := func(code, sig byte, v any) {
+		e.align(8)
+		e.b = append(e.b, code)
+		e.signature(string(sig))
+		switch v := v.(type) {
+		case uint32:
+			e.uint32(v)
+		case string:
+			if sig == 'g' {
+				e.signature(v)
+			} else {
+				e.string(v)
+			}
+		}
+	}
+	for _, f := range []struct {
+		code, sig byte
+		v         string
+	}{
+		{fieldPath, 'o', m.path},
+		{fieldInterface, 's', m.iface},
+		{fieldMember, 's', m.member},
+		{fieldErrorName, 's', m.errName},
+		{fieldDestination, 's', m.dest},
+		{fieldSender, 's', m.sender},
+		{fieldSignature, 'g', m.sig},
+	} {
+		if f.v != "" {
+			field(f.code, f.sig, f.v)
+		}
+	}
+	if m.replySerial != 0 {
+		field(fieldReplySerial, 'u', m.replySerial)
+	}
+	if m.unixFds != 0 {
+		field(fieldUnixFds, 'u', m.unixFds)
+	}
+	m.order.PutUint32(e.b[12:], uint32(len(e.b)-start))
+
+	e.align(8)
+	return append(e.b, m.body...)
+}
+
+// strings decodes leading string-like arguments of the message body.
+func (m *message) strings() ([]string, error) {
+	d := &decoder{order: m.order, b: m.body}
+	var v []string
+	for _, c := range m.sig {
+		var s string
+		var err error
+		switch c {
+		case 's', 'o':
+			s, err = d.string()
+		case 'g':
+			s, err = d.signature()
+		default:
+			return v, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		v = append(v, s)
+	}
+	return v, nil
+}
+
+// stringArray decodes a message body holding a single array of strings.
+func (m *message) stringArray() ([]string, error) {
+	if m.sig != "as" {
+		return nil, ErrBadSignature
+	}
+	d := &decoder{order: m.order, b: m.body}
+	n, err := d.uint32()
+	if err != nil {
+		return nil, err
+	}
+	if n > maxArraySize || d.pos+int(n) > len(d.b) {
+		return nil, ErrBadMessage
+	}
+	end := d.pos + int(n)
+
+	var v []string
+	for d.pos < end {
+		var s string
+		if s, err = d.string(); err != nil {
+			return nil, err
+		}
+		v = append(v, s)
+	}
+	if d.pos != end {
+		return nil, ErrBadMessage
+	}
+	return v, nil
+}
+
+// decoder decodes values aligned relative to the start of b.
+type decoder struct {
+	order binary.ByteOrder
+	b     []byte
+	pos   int
+}
+
+func (d *decoder) align(n int) error {
+	p := align(d.pos, n)
+	if p > len(d.b) {
+		return ErrBadMessage
+	}
+	for _, c := range d.b[d.pos:p] {
+		if c != 0 {
+			return ErrBadMessage
+		}
+	}
+	d.pos = p
+	return nil
+}
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || d.pos+n > len(d.b) {
+		return nil, ErrBadMessage
+	}
+	v := d.b[d.pos : d.pos+n]
+	d.pos += n
+	return v, nil
+}
+
+func (d *decoder) byte() (byte, error) {
+	v, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return v[0], nil
+}
+
+func (d *decoder) uint32() (uint32, error) {
+	if err := d.align(4); err != nil {
+		return 0, err
+	}
+	v, err := d.next(4)
+	if err != nil {
+		return 0, err
+	}
+	return d.order.Uint32(v), nil
+}
+
+func (d *decoder) terminated(n int) (string, error) {
+	v, err := d.next(n + 1)
+	if err != nil {
+		return "", err
+	}
+	if v[n] != 0 || strings.IndexByte(string(v[:n]), 0) != -1 {
+		return "", ErrBadMessage
+	}
+	return string(v[:n]), nil
+}
+
+func (d *decoder) string() (string, error) {
+	n, err := d.uint32()
+	if err != nil {
+		return "", err
+	}
+	if n > maxMessageSize {
+		return "", ErrBadMessage
+	}
+	return d.terminated(int(n))
+}
+
+func (d *decoder) signature() (string, error) {
+	n, err := d.byte()
+	if err != nil {
+		return "", err
+	}
+	return d.terminated(int(n))
+}
+
+// skip skips a value of the first complete type in sig and returns the rest of sig.
+func (d *decoder) skip(sig string, depth int) (string, error) {
+	if sig == "" || depth > maxDepth {
+		return "", ErrBadSignature
+	}
+
+	var err error
+	switch sig[0] {
+	case 'y':
+		_, err = d.next(1)
+	case 'n', 'q':
+		if err = d.align(2); err == nil {
+			_, err = d.next(2)
+		}
+	case 'b', 'i', 'u', 'h':
+		_, err = d.uint32()
+	case 'x', 't', 'd':
+		if err = d.align(8); err == nil {
+			_, err = d.next(8)
+		}
+	case 's', 'o':
+		_, err = d.string()
+	case 'g':
+		_, err = d.signature()
+
+	case 'v':
+		var s, rest string
+		if s, err = d.signature(); err != nil {
+			return "", err
+		}
+		if rest, err = d.skip(s, depth+1); err != nil {
+			return "", err
+		} else if rest != "" {
+			return "", ErrBadSignature
+		}
+
+	case 'a':
+		var elem, rest string
+		if elem, rest, err = splitType(sig[1:], depth+1); err != nil {
+			return "", err
+		}
+		var n uint32
+		if n, err = d.uint32(); err != nil {
+			return "", err
+		}
+		if n > maxArraySize {
+			return "", ErrBadMessage
+		}
+		if err = d.align(alignOf(elem[0])); err != nil {
+			return "", err
+		}
+		if _, err = d.next(int(n)); err != nil {
+			return "", err
+		}
+		return rest, nil
+
+	case '(', '{':
+		var t, rest string
+		if t, rest, err = splitType(sig, depth); err != nil {
+			return "", err
+		}
+		if err = d.align(8); err != nil {
+			return "", err
+		}
+		for inner := t[1 : len(t)-1]; inner != ""; {
+			if inner, err = d.skip(inner, depth+1); err != nil {
+				return "", err
+			}
+		}
+		return rest, nil
+
+	default:
+		return "", ErrBadSignature
+	}
+	return sig[1:], err
+}
+
+// splitType returns the first complete type in sig and the rest of sig.
+func splitType(sig string, depth int) (string, string, error) {
+	if sig == "" || depth > maxDepth {
+		return "", "", ErrBadSignature
+	}
+	switch sig[0] {
+	case 'y', 'b', 'n', 'q', 'i', 'u', 'x', 't', 'd', 'h', 's', 'o', 'g', 'v':
+		return sig[:1], sig[1:], nil
+	case 'a':
+		elem, rest, err := splitType(sig[1:], depth+1)
+		return "a" + elem, rest, err
+	case '(', '{':
+		end := byte(')')
+		if sig[0] == '{' {
+			end = '}'
+		}
+		for i, n := 1, 0; i < len(sig); n++ {
+			if sig[i] == end {
+				if n == 0 || (end == '}' && n != 2) {
+					return "", "", ErrBadSignature
+				}
+				return sig[:i+1], sig[i+1:], nil
+			}
+			t, _, err := splitType(sig[i:], depth+1)
+			if err != nil {
+				return "", "", err
+			}
+			i += len(t)
+		}
+		return "", "", ErrBadSignature
+	default:
+		return "", "", ErrBadSignature
+	}
+}
+
+// alignOf returns the alignment of the type beginning with c.
+func alignOf(c byte) int {
+	switch c {
+	case 'n', 'q':
+		return 2
+	case 'b', 'i', 'u', 'h', 's', 'o', 'a':
+		return 4
+	case 'x', 't', 'd', '(', '{':
+		return 8
+	default:
+		return 1
+	}
+}
+
+// encoder encodes values aligned relative to the start of b.
+type encoder struct {
+	order binary.ByteOrder
+	b     []byte
+}
+
+func (e *encoder) align(n int) {
+	for len(e.b)%n != 0 {
+		e.b = append(e.b, 0)
+	}
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.align(4)
+	var b [4]byte
+	e.order.PutUint32(b[:], v)
+	e.b = append(e.b, b[:]...)
+}
+
+func (e *encoder) string(v string) {
+	e.uint32(uint32(len(v)))
+	e.b = append(append(e.b, v...), 0)
+}
+
+func (e *encoder) signature(v string) {
+	e.b = append(append(append(e.b, byte(len(v))), v...), 0)
+}
+
+func (e *encoder) stringArray(v []string) {
+	e.uint32(0)
+	start := len(e.b)
+	for _, s := range v {
+		e.string(s)
+	}
+	e.order.PutUint32(e.b[start-4:], uint32(len(e.b)-start))
+}
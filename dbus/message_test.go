@@ -0,0 +1,185 @@
+package dbus
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMessage(t *testing.T) {
+	stringBody := func(order binary.ByteOrder, v ...string) []byte {
+		e := &encoder{order: order}
+		for _, s := range v {
+			e.string(s)
+		}
+		return e.b
+	}
+
+	testCases := []struct {
+		name string
+		m    *message
+		want string
+	}{
+		{"hello", &message{typ: msgMethodCall, serial: 1,
+			dest: busName, path: busPath, iface: busName, member: "Hello"},
+			"call serial=1 destination=org.freedesktop.DBus path=/org/freedesktop/DBus interface=org.freedesktop.DBus member=Hello"},
+		{"reply", &message{typ: msgMethodReturn, flags: flagNoReplyExpected, serial: 1, replySerial: 1,
+			sender: busName, dest: ":1.1", sig: "s", body: stringBody(binary.LittleEndian, ":1.1")},
+			"return serial=1 reply_serial=1 sender=org.freedesktop.DBus destination=:1.1"},
+		{"error big endian", &message{order: binary.BigEndian, typ: msgError, serial: 0xfeed, replySerial: 3,
+			errName: errAccessDenied, sig: "s", body: stringBody(binary.BigEndian, "denied")},
+			"error serial=65261 reply_serial=3 error_name=org.freedesktop.DBus.Error.AccessDenied"},
+		{"signal", &message{typ: msgSignal, serial: 7, sender: ":1.2",
+			path: "/org/example", iface: "org.example.Iface", member: "Changed", unixFds: 1, sig: "hs",
+			body: append([]byte{0, 0, 0, 0}, stringBody(binary.LittleEndian, "value")...)},
+			"signal serial=7 sender=:1.2 path=/org/example interface=org.example.Iface member=Changed"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.m.String(); got != tc.want {
+				t.Errorf("String: %q, want %q", got, tc.want)
+			}
+
+			b := tc.m.wire()
+			if n, err := messageLen(b); err != nil {
+				t.Fatalf("messageLen: error = %v", err)
+			} else if n != len(b) {
+				t.Errorf("messageLen: %d, want %d", n, len(b))
+			}
+
+			got, err := parseMessage(b)
+			if err != nil {
+				t.Fatalf("parseMessage: error = %v", err)
+			}
+			want := *tc.m
+			if want.order == nil {
+				want.order = binary.LittleEndian
+			}
+			if want.body == nil {
+				want.body = []byte{}
+			}
+			if !reflect.DeepEqual(got, &want) {
+				t.Errorf("parseMessage:\n%#v, want\n%#v", got, &want)
+			}
+		})
+	}
+}
+
+func TestMessageStrings(t *testing.T) {
+	e := &encoder{order: binary.LittleEndian}
+	e.string("org.example.App")
+	e.string("")
+	e.string(":1.1")
+	m := &message{order: binary.LittleEndian, sig: "sss", body: e.b}
+	if got, err := m.strings(); err != nil {
+		t.Errorf("strings: error = %v", err)
+	} else if want := []string{"org.example.App", "", ":1.1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("strings: %q, want %q", got, want)
+	}
+
+	e = &encoder{order: binary.BigEndian}
+	e.string("arg0")
+	e.uint32(1)
+	m = &message{order: binary.BigEndian, sig: "su", body: e.b}
+	if got, err := m.strings(); err != nil {
+		t.Errorf("strings: error = %v", err)
+	} else if want := []string{"arg0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("strings: %q, want %q", got, want)
+	}
+
+	m = &message{order: binary.LittleEndian, sig: "s", body: []byte{0xff, 0, 0, 0, 'a'}}
+	if _, err := m.strings(); !errors.Is(err, ErrBadMessage) {
+		t.Errorf("strings: error = %v, want %v", err, ErrBadMessage)
+	}
+
+	for _, names := range [][]string{nil, {busName}, {":1.1", "org.example.App", "org.example.App.Service"}} {
+		e = &encoder{order: binary.LittleEndian}
+		e.stringArray(names)
+		m = &message{order: binary.LittleEndian, sig: "as", body: e.b}
+		if got, err := m.stringArray(); err != nil {
+			t.Errorf("stringArray: error = %v", err)
+		} else if !reflect.DeepEqual(got, names) {
+			t.Errorf("stringArray: %q, want %q", got, names)
+		}
+	}
+
+	m = &message{order: binary.LittleEndian, sig: "s", body: e.b}
+	if _, err := m.stringArray(); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("stringArray: error = %v, want %v", err, ErrBadSignature)
+	}
+}
+
+func TestParseMessageInvalid(t *testing.T) {
+	valid := (&message{typ: msgMethodCall, serial: 1, path: "/", member: "Ping"}).marshal()
+	patch := func(f func(b []byte)) []byte {
+		b := append([]byte(nil), valid...)
+		f(b)
+		return b
+	}
+
+	testCases := []struct {
+		name    string
+		b       []byte
+		wantErr error
+	}{
+		{"short", valid[:headerPrefixSize-1], ErrBadMessage},
+		{"truncated", valid[:len(valid)-1], ErrBadMessage},
+		{"byte order", patch(func(b []byte) { b[0] = 'x' }), ErrBadMessage},
+		{"protocol version", patch(func(b []byte) { b[3] = 2 }), ErrBadMessage},
+		{"type", patch(func(b []byte) { b[1] = 5 }), ErrBadMessage},
+		{"serial", patch(func(b []byte) { binary.LittleEndian.PutUint32(b[8:], 0) }), ErrBadMessage},
+		{"body length", patch(func(b []byte) { binary.LittleEndian.PutUint32(b[4:], maxMessageSize+1) }), ErrMessageSize},
+		{"field signature", patch(func(b []byte) { b[headerPrefixSize+2] = 's' }), ErrBadMessage},
+		{"missing member", (&message{typ: msgMethodCall, serial: 1, path: "/"}).marshal(), ErrBadMessage},
+		{"missing reply serial", (&message{typ: msgMethodReturn, serial: 1}).marshal(), ErrBadMessage},
+		{"missing error name", (&message{typ: msgError, serial: 1, replySerial: 1}).marshal(), ErrBadMessage},
+		{"missing interface", (&message{typ: msgSignal, serial: 1, path: "/", member: "Changed"}).marshal(), ErrBadMessage},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if _, err := parseMessage(tc.b); !errors.Is(err, tc.wantErr) {
+				t.Errorf("parseMessage: error = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestDecoderSkip(t *testing.T) {
+	e := &encoder{order: binary.LittleEndian}
+	// y
+	e.b = append(e.b, 0xfe)
+	// a{sv}
+	e.uint32(0)
+	e.align(8)
+	start := len(e.b)
+	e.string("key")
+	e.signature("u")
+	e.uint32(0xcafe)
+	binary.LittleEndian.PutUint32(e.b[4:], uint32(len(e.b)-start))
+	// (ts)
+	e.align(8)
+	e.b = append(e.b, make([]byte, 8)...)
+	e.string("value")
+	// g
+	e.signature("a(ii)")
+
+	d := &decoder{order: binary.LittleEndian, b: e.b}
+	sig := "ya{sv}(ts)g"
+	for sig != "" {
+		var err error
+		if sig, err = d.skip(sig, 0); err != nil {
+			t.Fatalf("skip: error = %v", err)
+		}
+	}
+	if d.pos != len(e.b) {
+		t.Errorf("skip: pos = %d, want %d", d.pos, len(e.b))
+	}
+
+	for _, sig = range []string{"", "z", "a", "(", "()", "{s}", "(s", "{sss}"} {
+		d = &decoder{order: binary.LittleEndian, b: make([]byte, 64)}
+		if _, err := d.skip(sig, 0); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("skip %q: error = %v, want %v", sig, err, ErrBadSignature)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package dbus
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+
+	"git.gensokyo.uk/security/fortify/helper"
+)
+
+var (
+	ErrProxyArgs = errors.New("malformed proxy arguments")
+)
+
+// proxyArgs holds the configuration of a proxied bus.
+type proxyArgs struct {
+	// path of the socket to listen on
+	downstream string
+	*busProxy
+}
+
+// parseProxyArgs parses arguments in the format understood by xdg-dbus-proxy, as emitted by [Config.Args].
+func parseProxyArgs(args []string) ([]*proxyArgs, error) {
+	var v []*proxyArgs
+	var cur *proxyArgs
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") {
+			if i+1 >= len(args) {
+				return nil, ErrProxyArgs
+			}
+			upstream, err := Parse([]byte(arg))
+			if err != nil {
+				return nil, err
+			}
+			cur = &proxyArgs{downstream: args[i+1], busProxy: &busProxy{upstream: upstream}}
+			v = append(v, cur)
+			i++
+			continue
+		}
+		if cur == nil {
+			return nil, ErrProxyArgs
+		}
+
+		opt, value, _ := strings.Cut(arg, "=")
+		var r *rule
+		var err error
+		switch opt {
+		case "--filter":
+			cur.filter = true
+			continue
+		case "--log":
+			cur.log = true
+			continue
+		case "--learn":
+			cur.filter, cur.learned = true, newLearner()
+			continue
+		case "--see":
+			r, err = newRule(value, policySee, ruleAll, "")
+		case "--talk":
+			r, err = newRule(value, policyTalk, ruleAll, "")
+		case "--own":
+			r, err = newRule(value, policyOwn, ruleAll, "")
+		case "--call", "--broadcast":
+			name, restriction, ok := strings.Cut(value, "=")
+			if !ok {
+				return nil, ErrBadRule
+			}
+			t := ruleCall
+			if opt == "--broadcast" {
+				t = ruleBroadcast
+			}
+			r, err = newRule(name, policyTalk, t, restriction)
+		default:
+			return nil, ErrProxyArgs
+		}
+		if err != nil {
+			return nil, err
+		}
+		cur.rules = append(cur.rules, r)
+	}
+	if len(v) == 0 {
+		return nil, ErrProxyArgs
+	}
+	return v, nil
+}
+
+// HelperMain is the main function of the native D-Bus proxy helper process.
+// Arguments are read from argsFd, and it exits when statFd is closed by the parent.
+func HelperMain(argsFd, statFd int, prepare func(prefix string)) {
+	prepare("dbus")
+
+	if argsFd < 0 || statFd < 0 {
+		log.Fatal("invalid helper file descriptors")
+	}
+
+	var args []string
+	if f := os.NewFile(uintptr(argsFd), "args"); f == nil {
+		log.Fatal("invalid args descriptor")
+	} else if data, err := io.ReadAll(f); err != nil {
+		log.Fatalf("cannot read proxy arguments: %v", err)
+	} else {
+		if err = f.Close(); err != nil {
+			log.Printf("cannot close args pipe: %v", err)
+			// not fatal
+		}
+		args = strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00")
+	}
+
+	buses, err := parseProxyArgs(args)
+	if err != nil {
+		log.Fatalf("cannot parse proxy arguments: %v", err)
+	}
+	listeners := make([]*net.UnixListener, len(buses))
+	for i, bus := range buses {
+		if listeners[i], err = net.ListenUnix("unix", &net.UnixAddr{Name: bus.downstream, Net: "unix"}); err != nil {
+			log.Fatalf("cannot listen on %q: %v", bus.downstream, err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stat := os.NewFile(uintptr(statFd), "stat")
+	if _, err = stat.Write([]byte{'x'}); err != nil {
+		log.Fatalf("cannot write to status pipe: %v", err)
+	}
+	go func() {
+		// parent closes the read end of the status pipe to request exit
+		if err := helper.WaitClose(statFd); err != nil {
+			log.Fatalf("cannot poll status pipe: %v", err)
+		}
+		stop()
+	}()
+
+	var wg sync.WaitGroup
+	for i, bus := range buses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := bus.serve(ctx, listeners[i]); err != nil {
+				log.Printf("cannot serve %q: %v", bus.downstream, err)
+				stop()
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, bus := range buses {
+		if bus.learned != nil {
+			if err = bus.writeLearned(); err != nil {
+				log.Printf("cannot write configuration learned on %q: %v", bus.downstream, err)
+			}
+		}
+	}
+}
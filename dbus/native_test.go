@@ -0,0 +1,76 @@
+package dbus
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseProxyArgs(t *testing.T) {
+	mustRule := func(name string, p policy, types ruleType, restriction string) *rule {
+		if r, err := newRule(name, p, types, restriction); err != nil {
+			panic(err.Error())
+		} else {
+			return r
+		}
+	}
+
+	testCases := []struct {
+		name    string
+		args    []string
+		want    []*proxyArgs
+		wantErr error
+	}{
+		{"session and system", []string{
+			"unix:path=/run/user/1971/bus", "/tmp/fortify.1971/bus",
+			"--filter", "--log",
+			"--see=org.freedesktop.portal.*",
+			"--talk=org.freedesktop.Notifications",
+			"--own=org.mpris.MediaPlayer2.*",
+			"--call=org.freedesktop.portal.*=*",
+			"--broadcast=org.freedesktop.portal.*=@/org/freedesktop/portal/*",
+			"unix:path=/run/dbus/system_bus_socket", "/tmp/fortify.1971/system_bus_socket",
+		}, []*proxyArgs{
+			{"/tmp/fortify.1971/bus", &busProxy{
+				upstream: []AddrEntry{{Method: "unix", Values: [][2]string{{"path", "/run/user/1971/bus"}}}},
+				filter:   true, log: true,
+				rules: rules{
+					mustRule("org.freedesktop.portal.*", policySee, ruleAll, ""),
+					mustRule("org.freedesktop.Notifications", policyTalk, ruleAll, ""),
+					mustRule("org.mpris.MediaPlayer2.*", policyOwn, ruleAll, ""),
+					mustRule("org.freedesktop.portal.*", policyTalk, ruleCall, "*"),
+					mustRule("org.freedesktop.portal.*", policyTalk, ruleBroadcast, "@/org/freedesktop/portal/*"),
+				},
+			}},
+			{"/tmp/fortify.1971/system_bus_socket", &busProxy{
+				upstream: []AddrEntry{{Method: "unix", Values: [][2]string{{"path", "/run/dbus/system_bus_socket"}}}},
+			}},
+		}, nil},
+
+		{"empty", nil, nil, ErrProxyArgs},
+		{"missing downstream", []string{"unix:path=/run/user/1971/bus"}, nil, ErrProxyArgs},
+		{"option before bus", []string{"--filter", "unix:path=/run/user/1971/bus", "/tmp/bus"}, nil, ErrProxyArgs},
+		{"unknown option", []string{"unix:path=/run/user/1971/bus", "/tmp/bus", "--sloppy-names"}, nil, ErrProxyArgs},
+		{"call without rule", []string{"unix:path=/run/user/1971/bus", "/tmp/bus", "--call=org.freedesktop.portal.*"}, nil, ErrBadRule},
+		{"bad name", []string{"unix:path=/run/user/1971/bus", "/tmp/bus", "--talk="}, nil, ErrBadRule},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseProxyArgs(tc.args)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("parseProxyArgs: error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("parseProxyArgs: %d buses, want %d", len(got), len(tc.want))
+			}
+			for i := range got {
+				if got[i].downstream != tc.want[i].downstream ||
+					!reflect.DeepEqual(got[i].upstream, tc.want[i].upstream) ||
+					!reflect.DeepEqual(got[i].rules, tc.want[i].rules) ||
+					got[i].filter != tc.want[i].filter || got[i].log != tc.want[i].log {
+					t.Errorf("parseProxyArgs: %#v, want %#v", got[i].busProxy, tc.want[i].busProxy)
+				}
+			}
+		})
+	}
+}
@@ -34,8 +34,14 @@ func (p *Proxy) Start() error {
 
 	ctx, cancel := context.WithCancelCause(p.ctx)
 
+	toolPath, argF := p.name, xdgArgF
+	if toolPath == "" {
+		// the native proxy runs as a hidden command of the current executable
+		toolPath, argF = sandbox.MustExecutable(), nativeArgF
+	}
+
 	if !p.useSandbox {
-		p.helper = helper.NewDirect(ctx, p.name, p.final, true, argF, func(cmd *exec.Cmd) {
+		p.helper = helper.NewDirect(ctx, toolPath, p.final, true, argF, func(cmd *exec.Cmd) {
 			if p.CmdF != nil {
 				p.CmdF(cmd)
 			}
@@ -46,9 +52,9 @@ func (p *Proxy) Start() error {
 			cmd.Env = make([]string, 0)
 		}, nil)
 	} else {
-		toolPath := p.name
-		if filepath.Base(p.name) == p.name {
-			if s, err := exec.LookPath(p.name); err != nil {
+		if filepath.Base(toolPath) == toolPath {
+			if s, err := exec.LookPath(toolPath); err != nil {
+				cancel(err)
 				return err
 			} else {
 				toolPath = s
@@ -57,6 +63,7 @@ func (p *Proxy) Start() error {
 
 		var libPaths []string
 		if entries, err := ldd.ExecFilter(ctx, p.CommandContext, p.FilterF, toolPath); err != nil {
+			cancel(err)
 			return err
 		} else {
 			libPaths = ldd.Path(entries)
@@ -117,7 +124,7 @@ func (p *Proxy) Start() error {
 					container.Bind(name, name, sandbox.BindWritable)
 				}
 
-				// xdg-dbus-proxy bin path
+				// proxy bin path
 				binPath := path.Dir(toolPath)
 				container.Bind(binPath, binPath, 0)
 			}, nil)
@@ -135,7 +142,7 @@ func (p *Proxy) Start() error {
 
 var proxyClosed = errors.New("proxy closed")
 
-// Wait blocks until xdg-dbus-proxy exits and releases resources.
+// Wait blocks until the proxy process exits and releases resources.
 func (p *Proxy) Wait() error {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
@@ -168,7 +175,7 @@ func (p *Proxy) Wait() error {
 	return errors.Join(errs...)
 }
 
-// Close cancels the context passed to the helper instance attached to xdg-dbus-proxy.
+// Close cancels the context passed to the helper instance attached to the proxy process.
 func (p *Proxy) Close() {
 	p.pmu.Lock()
 	defer p.pmu.Unlock()
@@ -179,7 +186,11 @@ func (p *Proxy) Close() {
 	p.cancel(proxyClosed)
 }
 
-func argF(argsFd, statFd int) []string {
+func nativeArgF(argsFd, statFd int) []string {
+	return append([]string{"dbus"}, xdgArgF(argsFd, statFd)...)
+}
+
+func xdgArgF(argsFd, statFd int) []string {
 	if statFd == -1 {
 		return []string{"--args=" + strconv.Itoa(argsFd)}
 	} else {
@@ -11,9 +11,10 @@ import (
 	"git.gensokyo.uk/security/fortify/helper"
 )
 
-// ProxyName is the file name or path to the proxy program.
+// ProxyName is the file name or path to a proxy program compatible with xdg-dbus-proxy.
+// The native proxy implemented by [HelperMain] in the current executable is used if ProxyName is empty.
 // Overriding ProxyName will only affect Proxy instance created after the change.
-var ProxyName = "xdg-dbus-proxy"
+var ProxyName = ""
 
 type BadInterfaceError struct {
 	Interface string
@@ -24,7 +25,7 @@ func (e *BadInterfaceError) Error() string {
 	return fmt.Sprintf("bad interface string %q in %s bus configuration", e.Interface, e.Segment)
 }
 
-// Proxy holds the state of a xdg-dbus-proxy process, and should never be copied.
+// Proxy holds the state of a D-Bus proxy process, and should never be copied.
 type Proxy struct {
 	helper helper.Helper
 	ctx    context.Context
@@ -76,13 +77,13 @@ func Finalise(sessionBus, systemBus ProxyPair, session, system *Config) (final *
 
 	var args []string
 	if session != nil {
-		if err = session.checkInterfaces("session"); err != nil {
+		if err = session.CheckInterfaces("session"); err != nil {
 			return
 		}
 		args = append(args, session.Args(sessionBus)...)
 	}
 	if system != nil {
-		if err = system.checkInterfaces("system"); err != nil {
+		if err = system.CheckInterfaces("system"); err != nil {
 			return
 		}
 		args = append(args, system.Args(systemBus)...)
@@ -0,0 +1,774 @@
+package dbus
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	errAccessDenied   = "org.freedesktop.DBus.Error.AccessDenied"
+	errServiceUnknown = "org.freedesktop.DBus.Error.ServiceUnknown"
+	errNameHasNoOwner = "org.freedesktop.DBus.Error.NameHasNoOwner"
+	errMatchInvalid   = "org.freedesktop.DBus.Error.MatchRuleInvalid"
+
+	// authTimeout is the time allowed for a client to complete authentication.
+	authTimeout = 30 * time.Second
+)
+
+var (
+	ErrHello = errors.New("first message is not a Hello call")
+)
+
+// busProxy relays connections accepted on a downstream socket to an upstream bus.
+type busProxy struct {
+	upstream []AddrEntry
+	rules    rules
+	// whether messages are filtered according to rules
+	filter bool
+	// whether every message is logged
+	log bool
+	// records traffic permitted regardless of rules, nil outside learn mode
+	learned *learner
+
+	// last client id
+	seq atomic.Uint32
+}
+
+// serve accepts connections on l until ctx is done, and waits for all relays to terminate.
+func (p *busProxy) serve(ctx context.Context, l *net.UnixListener) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	stop := context.AfterFunc(ctx, func() { _ = l.Close() })
+	defer stop()
+
+	for {
+		conn, err := l.AcceptUnix()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() { defer wg.Done(); p.relay(ctx, conn) }()
+	}
+}
+
+// relay connects conn to the upstream bus and relays messages until either side disconnects.
+func (p *busProxy) relay(ctx context.Context, conn *net.UnixConn) {
+	c := &client{
+		busProxy: p,
+		id:       p.seq.Add(1),
+		down:     newMsgConn(conn),
+
+		ready:    make(chan struct{}),
+		owners:   make(map[string]string),
+		calls:    make(map[uint32]call),
+		replies:  make(map[replyKey]struct{}),
+		internal: make(map[uint32]func(m *message)),
+	}
+	defer func() { _ = c.down.Close() }()
+
+	if upConn, err := dialBus(p.upstream); err != nil {
+		log.Printf("C%d: cannot connect to bus: %v", c.id, err)
+		return
+	} else {
+		c.up = newMsgConn(upConn)
+		defer func() { _ = c.up.Close() }()
+	}
+
+	stop := context.AfterFunc(ctx, c.closeConns)
+	defer stop()
+
+	guid, unixFds, err := c.up.authUpstream()
+	if err != nil {
+		log.Printf("C%d: cannot authenticate to bus: %v", c.id, err)
+		return
+	}
+	_ = conn.SetDeadline(time.Now().Add(authTimeout))
+	if err = c.down.authDownstream(guid, unixFds); err != nil {
+		c.logErr("cannot authenticate client", err)
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.logErr("bus connection", c.fromBus())
+		c.closeConns()
+	}()
+	c.logErr("client connection", c.fromClient())
+	c.closeConns()
+	<-done
+}
+
+// callKind describes the handling of the reply to a method call from a client.
+type callKind byte
+
+const (
+	callForward callKind = iota
+	callHello
+	callListNames
+	callAddMatch
+	callRemoveMatch
+)
+
+// call is an outstanding method call from a client.
+type call struct {
+	kind callKind
+	// match rule of AddMatch and RemoveMatch calls
+	rule string
+}
+
+// replyKey identifies an outstanding method call to a client.
+type replyKey struct {
+	sender string
+	serial uint32
+}
+
+// clientMatch is a match rule registered by a client.
+type clientMatch struct {
+	rule   string
+	parsed *matchRule
+}
+
+// client holds the state of a relayed connection.
+type client struct {
+	*busProxy
+	id       uint32
+	down, up *msgConn
+
+	// closed once the reply to Hello is processed and the initial state of names is requested
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	mu sync.Mutex
+	// unique name of the client
+	unique string
+	// owners of well-known names any rule applies to
+	owners map[string]string
+	// outstanding method calls from the client
+	calls map[uint32]call
+	// outstanding method calls to the client
+	replies map[replyKey]struct{}
+	// outstanding method calls from the proxy
+	internal map[uint32]func(m *message)
+	// match rules registered by the client
+	matches []clientMatch
+	// last serial of a message sent to the bus by the proxy, allocated downwards
+	// as clients allocate serials upwards from 1
+	upSerial uint32
+	// last serial of a message sent to the client by the proxy
+	downSerial uint32
+}
+
+func (c *client) setReady() { c.readyOnce.Do(func() { close(c.ready) }) }
+
+func (c *client) closeConns() {
+	_ = c.down.conn.Close()
+	_ = c.up.conn.Close()
+}
+
+func (c *client) logErr(what string, err error) {
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		log.Printf("C%d: %s: %v", c.id, what, err)
+	}
+}
+
+func (c *client) logMsg(dir string, m *message, note string) {
+	if c.log {
+		if note != "" {
+			note = " (" + note + ")"
+		}
+		log.Printf("C%d %s %s%s", c.id, dir, m, note)
+	}
+}
+
+// forward writes m to conn and releases its file descriptors.
+func forward(conn *msgConn, m *message) error {
+	err := conn.writeMessage(m)
+	m.close()
+	return err
+}
+
+// fromClient relays messages from the client to the bus.
+func (c *client) fromClient() error {
+	hello := false
+	for {
+		m, err := c.down.readMessage()
+		if err != nil {
+			return err
+		}
+
+		if !c.filter {
+			c.logMsg("->", m, "")
+			if err = forward(c.up, m); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !hello {
+			if m.typ != msgMethodCall || m.dest != busName || m.member != "Hello" {
+				m.close()
+				return ErrHello
+			}
+			hello = true
+			if err = c.forwardCall(m, call{kind: callHello}); err != nil {
+				return err
+			}
+			<-c.ready
+			continue
+		}
+
+		if err = c.filterClient(m); err != nil {
+			return err
+		}
+	}
+}
+
+// fromBus relays messages from the bus to the client.
+func (c *client) fromBus() error {
+	defer c.setReady()
+	for {
+		m, err := c.up.readMessage()
+		if err != nil {
+			return err
+		}
+
+		if !c.filter {
+			c.logMsg("<-", m, "")
+			if err = forward(c.down, m); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err = c.filterBus(m); err != nil {
+			return err
+		}
+	}
+}
+
+// names returns well-known names rules are evaluated against for name. The caller must hold mu.
+func (c *client) names(name string) []string {
+	if !isUnique(name) {
+		return []string{name}
+	}
+	var names []string
+	for wk, owner := range c.owners {
+		if owner == name {
+			names = append(names, wk)
+		}
+	}
+	return names
+}
+
+// policy returns the policy of name. The caller must hold mu.
+func (c *client) policy(name string) policy {
+	if name == busName {
+		return policyTalk
+	}
+	if c.self(name) || c.learned != nil {
+		return policyOwn
+	}
+	p := policyNone
+	for _, wk := range c.names(name) {
+		p = max(p, c.rules.policy(wk))
+	}
+	return p
+}
+
+// self returns whether name is the unique name of the client. The caller must hold mu.
+func (c *client) self(name string) bool { return name != "" && name == c.unique }
+
+// addressed returns whether name is the unique name of the client or a name owned by it. The caller must hold mu.
+func (c *client) addressed(name string) bool {
+	return c.self(name) || (c.unique != "" && c.owners[name] == c.unique)
+}
+
+// allow returns whether a message of type t addressed to or sent by name is permitted. The caller must hold mu.
+func (c *client) allow(name string, t ruleType, m *message) bool {
+	return c.self(name) || c.learned != nil || c.rules.match(c.names(name), t, m.path, m.iface, m.member)
+}
+
+// tracked returns whether the owner of the well-known name is tracked.
+func (c *client) tracked(name string) bool {
+	return c.learned != nil || c.rules.policy(name) != policyNone
+}
+
+// learnName records name, or well-known names owned by it, with policy p in learn mode.
+func (c *client) learnName(p policy, name string) {
+	if c.learned == nil {
+		return
+	}
+	c.mu.Lock()
+	names := c.learnNames(name)
+	c.mu.Unlock()
+
+	set := c.learned.see
+	switch p {
+	case policyTalk:
+		set = c.learned.talk
+	case policyOwn:
+		set = c.learned.own
+	}
+	for _, wk := range names {
+		c.learned.add(set, wk)
+	}
+}
+
+// learnMember records a method call to, or a broadcast from, name in learn mode.
+func (c *client) learnMember(t ruleType, name string, m *message) {
+	if c.learned == nil {
+		return
+	}
+	c.mu.Lock()
+	names := c.learnNames(name)
+	c.mu.Unlock()
+
+	set := c.learned.call
+	if t == ruleBroadcast {
+		set = c.learned.broadcast
+	}
+	c.learned.addMember(set, names, m)
+}
+
+// learnNames returns well-known names traffic with name is recorded against. The caller must hold mu.
+// Traffic with a unique name owning multiple names is only recorded against names already recorded, if any.
+func (c *client) learnNames(name string) []string {
+	names := c.names(name)
+	if len(names) > 1 {
+		if known := slices.DeleteFunc(slices.Clone(names), func(wk string) bool { return !c.learned.known(wk) }); len(known) > 0 {
+			return known
+		}
+	}
+	return names
+}
+
+// setOwner records owner of name, or its release if owner is empty. The caller must hold mu.
+func (c *client) setOwner(name, owner string) {
+	if isUnique(name) {
+		if owner == "" {
+			// connection closed, names are released before this is emitted
+			for wk, o := range c.owners {
+				if o == name {
+					delete(c.owners, wk)
+				}
+			}
+		}
+		return
+	}
+	if !c.tracked(name) {
+		return
+	}
+	if owner == "" {
+		delete(c.owners, name)
+	} else {
+		c.owners[name] = owner
+	}
+}
+
+// forwardCall forwards a method call from the client and records it for its reply.
+func (c *client) forwardCall(m *message, v call) error {
+	if m.flags&flagNoReplyExpected == 0 {
+		c.mu.Lock()
+		c.calls[m.serial] = v
+		c.mu.Unlock()
+	}
+	c.logMsg("->", m, "")
+	return forward(c.up, m)
+}
+
+// deny replies to a method call from the client with an error.
+func (c *client) deny(m *message, name, text string) error {
+	m.close()
+	if name == errServiceUnknown {
+		c.logMsg("->", m, "hidden")
+	} else {
+		c.logMsg("->", m, "denied")
+	}
+	if m.typ != msgMethodCall || m.flags&flagNoReplyExpected != 0 {
+		return nil
+	}
+
+	e := &encoder{order: m.order}
+	e.string(text)
+	return c.reply(m, &message{typ: msgError, errName: name, sig: "s", body: e.b})
+}
+
+// reply sends r from the message bus as a reply to the method call m from the client.
+func (c *client) reply(m, r *message) error {
+	c.mu.Lock()
+	c.downSerial++
+	r.serial = c.downSerial
+	r.dest = c.unique
+	c.mu.Unlock()
+	r.order, r.flags, r.sender, r.replySerial = m.order, flagNoReplyExpected, busName, m.serial
+	c.logMsg("<-", r, "generated")
+	return c.down.writeMessage(r)
+}
+
+// filterClient relays or rejects a message from the client.
+func (c *client) filterClient(m *message) error {
+	switch m.typ {
+	case msgMethodCall:
+		if m.dest == busName {
+			return c.busCall(m)
+		}
+		if m.dest == "" {
+			return c.deny(m, errAccessDenied, "Peer-to-peer method calls are not permitted")
+		}
+
+		c.mu.Lock()
+		p := c.policy(m.dest)
+		ok := p >= policyTalk && c.allow(m.dest, ruleCall, m)
+		c.mu.Unlock()
+		switch {
+		case p < policySee:
+			return c.deny(m, errServiceUnknown, "The name "+m.dest+" was not provided by any .service files")
+		case !ok:
+			return c.deny(m, errAccessDenied, "Calling "+m.iface+"."+m.member+" on "+m.dest+" is not permitted")
+		}
+		c.learnMember(ruleCall, m.dest, m)
+		return c.forwardCall(m, call{})
+
+	case msgMethodReturn, msgError:
+		k := replyKey{m.dest, m.replySerial}
+		c.mu.Lock()
+		_, ok := c.replies[k]
+		delete(c.replies, k)
+		c.mu.Unlock()
+		if !ok {
+			m.close()
+			c.logMsg("->", m, "unexpected reply")
+			return nil
+		}
+		c.logMsg("->", m, "")
+		return forward(c.up, m)
+
+	case msgSignal:
+		if m.dest != "" {
+			c.mu.Lock()
+			ok := c.policy(m.dest) >= policyTalk
+			c.mu.Unlock()
+			if !ok {
+				m.close()
+				c.logMsg("->", m, "denied")
+				return nil
+			}
+			c.learnName(policyTalk, m.dest)
+		}
+		c.logMsg("->", m, "")
+		return forward(c.up, m)
+
+	default:
+		// unreachable
+		m.close()
+		return ErrBadMessage
+	}
+}
+
+// busCall relays or rejects a method call from the client to the message bus.
+func (c *client) busCall(m *message) error {
+	switch m.iface {
+	case "", busName:
+	case "org.freedesktop.DBus.Peer", "org.freedesktop.DBus.Introspectable":
+		return c.forwardCall(m, call{})
+	default:
+		return c.deny(m, errAccessDenied, "Calling "+m.iface+"."+m.member+" on the message bus is not permitted")
+	}
+
+	var arg string
+	if args, err := m.strings(); err != nil {
+		m.close()
+		return err
+	} else if len(args) > 0 {
+		arg = args[0]
+	}
+
+	switch m.member {
+	case "Hello", "GetId":
+		return c.forwardCall(m, call{})
+
+	case "AddMatch":
+		// a rule the proxy cannot parse might be interpreted differently by the bus
+		r, err := parseMatchRule(arg)
+		if err != nil {
+			return c.deny(m, errMatchInvalid, "Match rule "+arg+" is not supported")
+		}
+		if r.eavesdrop {
+			return c.deny(m, errAccessDenied, "Eavesdropping is not permitted")
+		}
+		// watching the owner of a name reveals whether it exists
+		if r.member == "NameOwnerChanged" && r.args[0] != "" {
+			c.learnName(policySee, r.args[0])
+		}
+		return c.forwardCall(m, call{kind: callAddMatch, rule: arg})
+	case "RemoveMatch":
+		return c.forwardCall(m, call{kind: callRemoveMatch, rule: arg})
+
+	case "ListNames", "ListActivatableNames":
+		return c.forwardCall(m, call{kind: callListNames})
+
+	case "NameHasOwner", "GetNameOwner", "ListQueuedOwners",
+		"GetConnectionUnixUser", "GetConnectionUnixProcessID", "GetConnectionCredentials",
+		"GetAdtAuditSessionData", "GetConnectionSELinuxSecurityContext":
+		c.mu.Lock()
+		p := c.policy(arg)
+		c.mu.Unlock()
+		if p >= policySee {
+			c.learnName(policySee, arg)
+			return c.forwardCall(m, call{})
+		}
+		if m.member == "NameHasOwner" {
+			m.close()
+			c.logMsg("->", m, "hidden")
+			if m.flags&flagNoReplyExpected != 0 {
+				return nil
+			}
+			e := &encoder{order: m.order}
+			e.uint32(0)
+			return c.reply(m, &message{typ: msgMethodReturn, sig: "b", body: e.b})
+		}
+		return c.deny(m, errNameHasNoOwner, "Could not get owner of name '"+arg+"': no such name")
+
+	case "StartServiceByName":
+		c.mu.Lock()
+		p := c.policy(arg)
+		c.mu.Unlock()
+		switch {
+		case p < policySee:
+			return c.deny(m, errServiceUnknown, "The name "+arg+" was not provided by any .service files")
+		case p < policyTalk:
+			return c.deny(m, errAccessDenied, "Starting "+arg+" is not permitted")
+		}
+		c.learnName(policyTalk, arg)
+		return c.forwardCall(m, call{})
+
+	case "RequestName", "ReleaseName":
+		c.mu.Lock()
+		p := c.policy(arg)
+		c.mu.Unlock()
+		if p < policyOwn || isUnique(arg) {
+			return c.deny(m, errAccessDenied, "Owning "+arg+" is not permitted")
+		}
+		if m.member == "RequestName" {
+			c.learnName(policyOwn, arg)
+		}
+		return c.forwardCall(m, call{})
+
+	default:
+		return c.deny(m, errAccessDenied, "Calling "+m.member+" on the message bus is not permitted")
+	}
+}
+
+// filterBus relays or drops a message from the bus.
+func (c *client) filterBus(m *message) error {
+	switch m.typ {
+	case msgMethodReturn, msgError:
+		c.mu.Lock()
+		if h, ok := c.internal[m.replySerial]; ok {
+			delete(c.internal, m.replySerial)
+			c.mu.Unlock()
+			h(m)
+			m.close()
+			return nil
+		}
+		v, ok := c.calls[m.replySerial]
+		delete(c.calls, m.replySerial)
+		c.mu.Unlock()
+		if !ok {
+			m.close()
+			c.logMsg("<-", m, "unexpected reply")
+			return nil
+		}
+		if m.typ == msgMethodReturn {
+			if err := c.handleReply(&m, v); err != nil {
+				m.close()
+				return err
+			}
+		}
+		hello := v.kind == callHello && m.typ == msgMethodReturn
+		c.logMsg("<-", m, "")
+		if err := forward(c.down, m); err != nil {
+			return err
+		}
+		if hello {
+			return c.requestNames()
+		}
+		c.setReady()
+		return nil
+
+	case msgMethodCall:
+		c.mu.Lock()
+		ok := c.addressed(m.dest)
+		if ok && m.flags&flagNoReplyExpected == 0 {
+			c.replies[replyKey{m.sender, m.serial}] = struct{}{}
+		}
+		c.mu.Unlock()
+		if !ok {
+			m.close()
+			c.logMsg("<-", m, "dropped")
+			return nil
+		}
+		c.logMsg("<-", m, "")
+		return forward(c.down, m)
+
+	case msgSignal:
+		if m.sender == busName {
+			return c.busSignal(m)
+		}
+		c.mu.Lock()
+		ok := c.allow(m.sender, ruleBroadcast, m)
+		c.mu.Unlock()
+		if !ok {
+			m.close()
+			c.logMsg("<-", m, "dropped")
+			return nil
+		}
+		c.learnMember(ruleBroadcast, m.sender, m)
+		c.logMsg("<-", m, "")
+		return forward(c.down, m)
+
+	default:
+		// unreachable
+		m.close()
+		return ErrBadMessage
+	}
+}
+
+// handleReply updates state according to the reply *mp to a method call from the client,
+// and replaces it if its body is filtered.
+func (c *client) handleReply(mp **message, v call) error {
+	m := *mp
+	switch v.kind {
+	case callHello:
+		args, err := m.strings()
+		if err != nil || len(args) != 1 {
+			return ErrBadMessage
+		}
+		c.mu.Lock()
+		c.unique = args[0]
+		c.mu.Unlock()
+
+	case callListNames:
+		names, err := m.stringArray()
+		if err != nil {
+			return err
+		}
+		c.mu.Lock()
+		names = slices.DeleteFunc(names, func(name string) bool { return c.policy(name) < policySee })
+		c.mu.Unlock()
+
+		e := &encoder{order: m.order}
+		e.stringArray(names)
+		*mp = &message{order: m.order, typ: m.typ, flags: m.flags, serial: m.serial,
+			replySerial: m.replySerial, dest: m.dest, sender: m.sender, sig: m.sig, body: e.b}
+
+	case callAddMatch:
+		if r, err := parseMatchRule(v.rule); err == nil {
+			c.mu.Lock()
+			c.matches = append(c.matches, clientMatch{v.rule, r})
+			c.mu.Unlock()
+		}
+
+	case callRemoveMatch:
+		c.mu.Lock()
+		if i := slices.IndexFunc(c.matches, func(cm clientMatch) bool { return cm.rule == v.rule }); i != -1 {
+			c.matches = slices.Delete(c.matches, i, i+1)
+		}
+		c.mu.Unlock()
+	}
+	return nil
+}
+
+// busSignal relays or drops a signal emitted by the message bus.
+func (c *client) busSignal(m *message) error {
+	if m.iface == busName && m.member == "NameOwnerChanged" {
+		args, err := m.strings()
+		if err != nil || len(args) != 3 {
+			m.close()
+			return ErrBadMessage
+		}
+
+		c.mu.Lock()
+		visible := c.policy(args[0]) >= policySee
+		// delivered because of the match rule registered by the proxy
+		subscribed := slices.ContainsFunc(c.matches, func(cm clientMatch) bool { return cm.parsed.match(m, args) })
+		c.setOwner(args[0], args[2])
+		c.mu.Unlock()
+
+		if !visible || !subscribed {
+			m.close()
+			c.logMsg("<-", m, "dropped")
+			return nil
+		}
+	}
+
+	c.logMsg("<-", m, "")
+	return forward(c.down, m)
+}
+
+// callBus sends a method call to the message bus on behalf of the proxy, h is called with its reply.
+func (c *client) callBus(member, sig string, body []byte, h func(m *message)) error {
+	c.mu.Lock()
+	c.upSerial--
+	serial := c.upSerial
+	c.internal[serial] = h
+	c.mu.Unlock()
+
+	return c.up.writeMessage(&message{typ: msgMethodCall, serial: serial,
+		dest: busName, path: busPath, iface: busName, member: member, sig: sig, body: body})
+}
+
+// requestNames subscribes to changes of name ownership and requests owners of names any rule applies to.
+// The client is made ready once all requests are sent, as the bus processes messages in order.
+func (c *client) requestNames() error {
+	e := &encoder{order: binary.LittleEndian}
+	e.string("type='signal',sender='" + busName + "',interface='" + busName + "',member='NameOwnerChanged'")
+	if err := c.callBus("AddMatch", "s", e.b, func(*message) {}); err != nil {
+		c.setReady()
+		return err
+	}
+
+	return c.callBus("ListNames", "", nil, func(m *message) {
+		defer c.setReady()
+		if m.typ != msgMethodReturn {
+			return
+		}
+		names, err := m.stringArray()
+		if err != nil {
+			return
+		}
+		for _, name := range names {
+			if isUnique(name) || !c.tracked(name) {
+				continue
+			}
+			e = &encoder{order: binary.LittleEndian}
+			e.string(name)
+			if err = c.callBus("GetNameOwner", "s", e.b, func(m *message) {
+				if m.typ != msgMethodReturn {
+					return
+				}
+				if args, err := m.strings(); err == nil && len(args) == 1 {
+					c.mu.Lock()
+					c.setOwner(name, args[0])
+					c.mu.Unlock()
+				}
+			}); err != nil {
+				return
+			}
+		}
+	})
+}
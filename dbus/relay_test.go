@@ -0,0 +1,725 @@
+package dbus
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRelay(t *testing.T) {
+	t.Run("passthrough", func(t *testing.T) {
+		bus, proxy, _ := newTestProxy(t)
+
+		hidden := bus.peer(t, "org.example.Hidden")
+		c := dialTestConn(t, proxy)
+		if reply := c.call(t, "org.example.Hidden", "/", "org.example.Iface", "Method", "", nil); reply.typ != msgMethodReturn {
+			t.Errorf("call: %s, want return", reply)
+		}
+		if got := c.callStrings(t, "ListNames", ""); !slices.Contains(got, "org.example.Hidden") {
+			t.Errorf("ListNames: %q, want %q", got, "org.example.Hidden")
+		}
+		testFds(t, c, "org.example.Hidden")
+
+		c.call(t, busName, busPath, busName, "AddMatch", "s", stringBody("type='signal'"))
+		hidden.emit(t, "/", "org.example.Iface", "Changed")
+		c.expectSignal(t, hidden.unique, "/", "Changed")
+	})
+
+	t.Run("filtered", func(t *testing.T) {
+		bus, proxy, _ := newTestProxy(t,
+			"--filter",
+			"--talk=org.example.Service",
+			"--see=org.example.Seen.*",
+			"--own=org.example.App.*",
+			"--call=org.example.Restricted=org.example.Iface.Allowed@/allowed",
+			"--broadcast=org.example.Restricted=org.example.Iface.Signal@/allowed/*",
+		)
+
+		service := bus.peer(t, "org.example.Service")
+		restricted := bus.peer(t, "org.example.Restricted")
+		seen := bus.peer(t, "org.example.Seen")
+		hidden := bus.peer(t, "org.example.Hidden")
+		c := dialTestConn(t, proxy)
+
+		t.Run("call", func(t *testing.T) {
+			testCases := []struct {
+				dest, path, member string
+				want               string
+			}{
+				{"org.example.Service", "/", "Method", ""},
+				{service.unique, "/", "Method", ""},
+				{"org.example.Seen", "/", "Method", errAccessDenied},
+				{"org.example.Hidden", "/", "Method", errServiceUnknown},
+				{hidden.unique, "/", "Method", errServiceUnknown},
+				{"org.example.Restricted", "/allowed", "Allowed", ""},
+				{"org.example.Restricted", "/allowed/child", "Allowed", errAccessDenied},
+				{"org.example.Restricted", "/allowed", "Denied", errAccessDenied},
+				{"", "/", "Method", errAccessDenied},
+			}
+			for _, tc := range testCases {
+				reply := c.call(t, tc.dest, tc.path, "org.example.Iface", tc.member, "", nil)
+				if reply.errName != tc.want {
+					t.Errorf("call %s %s %s: %s, want %q", tc.dest, tc.path, tc.member, reply, tc.want)
+				} else if tc.want == "" {
+					if args, _ := reply.strings(); !reflect.DeepEqual(args, []string{tc.member}) {
+						t.Errorf("call %s %s %s: %q, want %q", tc.dest, tc.path, tc.member, args, tc.member)
+					}
+				}
+			}
+			testFds(t, c, "org.example.Service")
+		})
+
+		t.Run("bus", func(t *testing.T) {
+			testCases := []struct {
+				member, name string
+				want         string
+				wantArgs     []string
+			}{
+				{"GetNameOwner", "org.example.Seen", "", []string{seen.unique}},
+				{"GetNameOwner", "org.example.Hidden", errNameHasNoOwner, nil},
+				{"GetNameOwner", hidden.unique, errNameHasNoOwner, nil},
+				{"StartServiceByName", "org.example.Seen", errAccessDenied, nil},
+				{"StartServiceByName", "org.example.Hidden", errServiceUnknown, nil},
+				{"RequestName", "org.example.Service", errAccessDenied, nil},
+				{"RequestName", ":1.1", errAccessDenied, nil},
+				{"ReleaseName", "org.example.Seen", errAccessDenied, nil},
+				{"UpdateActivationEnvironment", "", errAccessDenied, nil},
+				{"BecomeMonitor", "", errAccessDenied, nil},
+			}
+			for _, tc := range testCases {
+				reply := c.call(t, busName, busPath, busName, tc.member, "s", stringBody(tc.name))
+				if reply.errName != tc.want {
+					t.Errorf("%s(%q): %s, want %q", tc.member, tc.name, reply, tc.want)
+				} else if args, _ := reply.strings(); tc.wantArgs != nil && !reflect.DeepEqual(args, tc.wantArgs) {
+					t.Errorf("%s(%q): %q, want %q", tc.member, tc.name, args, tc.wantArgs)
+				}
+			}
+
+			for name, want := range map[string]uint32{
+				"org.example.Service": 1,
+				"org.example.Seen":    1,
+				"org.example.Hidden":  0,
+				hidden.unique:         0,
+			} {
+				reply := c.call(t, busName, busPath, busName, "NameHasOwner", "s", stringBody(name))
+				d := &decoder{order: reply.order, b: reply.body}
+				if got, err := d.uint32(); err != nil || reply.sig != "b" || got != want {
+					t.Errorf("NameHasOwner(%q): %s %v, want %v", name, reply, got, want)
+				}
+			}
+
+			e := &encoder{order: binary.LittleEndian}
+			e.string("org.example.App.Instance")
+			e.uint32(0)
+			if reply := c.call(t, busName, busPath, busName, "RequestName", "su", e.b); reply.typ != msgMethodReturn {
+				t.Errorf("RequestName: %s", reply)
+			}
+
+			want := []string{busName, c.unique, service.unique, restricted.unique, seen.unique,
+				"org.example.Service", "org.example.Restricted", "org.example.Seen", "org.example.App.Instance"}
+			got := c.callStrings(t, "ListNames", "")
+			slices.Sort(want)
+			slices.Sort(got)
+			if !slices.Equal(got, want) {
+				t.Errorf("ListNames: %q, want %q", got, want)
+			}
+		})
+
+		t.Run("incoming call", func(t *testing.T) {
+			for _, dest := range []string{c.unique, "org.example.App.Instance"} {
+				reply := service.call(t, dest, "/", "org.example.Iface", "Callback", "", nil)
+				if args, _ := reply.strings(); reply.typ != msgMethodReturn || !reflect.DeepEqual(args, []string{"Callback"}) {
+					t.Errorf("call %s: %s %q", dest, reply, args)
+				}
+			}
+		})
+
+		t.Run("eavesdrop", func(t *testing.T) {
+			testCases := []struct {
+				rule string
+				want string
+			}{
+				{"type='method_call',eavesdrop='true'", errAccessDenied},
+				{"eavesdrop='false'", errAccessDenied},
+				{"type='invalid'", errMatchInvalid},
+			}
+			for _, tc := range testCases {
+				if reply := c.call(t, busName, busPath, busName, "AddMatch", "s", stringBody(tc.rule)); reply.errName != tc.want {
+					t.Errorf("AddMatch(%q): %s, want %q", tc.rule, reply, tc.want)
+				}
+			}
+
+			// calls between other peers delivered by the bus are dropped
+			bus.eavesdrop.Store(true)
+			defer bus.eavesdrop.Store(false)
+			received := c.received.Load()
+			if reply := service.call(t, "org.example.Seen", "/", "org.example.Iface", "Private", "", nil); reply.typ != msgMethodReturn {
+				t.Errorf("call: %s, want return", reply)
+			}
+			service.call(t, c.unique, "/", "org.example.Iface", "Callback", "", nil)
+			if got := c.received.Load() - received; got != 1 {
+				t.Errorf("received %d method calls, want 1", got)
+			}
+		})
+
+		t.Run("signal", func(t *testing.T) {
+			c.call(t, busName, busPath, busName, "AddMatch", "s", stringBody("type='signal'"))
+
+			hidden.emit(t, "/", "org.example.Iface", "Changed")
+			seen.emit(t, "/", "org.example.Iface", "Changed")
+			service.emit(t, "/", "org.example.Iface", "Changed")
+			c.expectSignal(t, service.unique, "/", "Changed")
+
+			restricted.emit(t, "/allowed/child", "org.example.Iface", "Other")
+			restricted.emit(t, "/denied", "org.example.Iface", "Signal")
+			restricted.emit(t, "/allowed/child", "org.example.Iface", "Signal")
+			c.expectSignal(t, restricted.unique, "/allowed/child", "Signal")
+
+			hidden.requestName(t, "org.example.Hidden.Other")
+			seen.requestName(t, "org.example.Seen.Other")
+			m := c.expectSignal(t, busName, busPath, "NameOwnerChanged")
+			if args, _ := m.strings(); !reflect.DeepEqual(args, []string{"org.example.Seen.Other", "", seen.unique}) {
+				t.Errorf("NameOwnerChanged: %q", args)
+			}
+
+			// a new owner is subject to the rules of the name
+			hidden.requestName(t, "org.example.App.Hidden")
+			c.expectSignal(t, busName, busPath, "NameOwnerChanged")
+			hidden.emit(t, "/", "org.example.Iface", "Changed")
+			c.expectSignal(t, hidden.unique, "/", "Changed")
+		})
+	})
+
+	t.Run("learn", func(t *testing.T) {
+		bus, proxy, p := newTestProxy(t, "--learn")
+
+		bus.peer(t, "org.example.Service")
+		seen := bus.peer(t, "org.example.Seen")
+		signals := bus.peer(t, "org.example.Signals")
+		c := dialTestConn(t, proxy)
+
+		for _, path := range []string{"/org/example/a", "/org/example/b"} {
+			if reply := c.call(t, "org.example.Service", path, "org.example.Iface", "Method", "", nil); reply.typ != msgMethodReturn {
+				t.Errorf("call: %s, want return", reply)
+			}
+		}
+		reply := c.call(t, busName, busPath, busName, "GetNameOwner", "s", stringBody("org.example.Seen"))
+		if args, _ := reply.strings(); !reflect.DeepEqual(args, []string{seen.unique}) {
+			t.Errorf("GetNameOwner: %s %q, want %q", reply, args, seen.unique)
+		}
+		c.requestName(t, "org.example.App")
+
+		c.call(t, busName, busPath, busName, "AddMatch", "s", stringBody("type='signal'"))
+		signals.emit(t, "/org/example/signals", "org.example.Iface", "Changed")
+		c.expectSignal(t, signals.unique, "/org/example/signals", "Changed")
+
+		want := &Config{
+			See: []string{"org.example.Seen"},
+			Own: []string{"org.example.App"},
+			Call: map[string]string{
+				"org.example.Service": "org.example.Iface.Method@/org/example/*",
+			},
+			Broadcast: map[string]string{
+				"org.example.Signals": "org.example.Iface.Changed@/org/example/signals",
+			},
+			Filter: true,
+		}
+		if got := p.learned.config(); !reflect.DeepEqual(got, want) {
+			t.Errorf("config: %#v, want %#v", got, want)
+		}
+	})
+
+	t.Run("hello", func(t *testing.T) {
+		_, proxy, _ := newTestProxy(t, "--filter")
+
+		conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: proxy, Net: "unix"})
+		if err != nil {
+			t.Fatalf("DialUnix: error = %v", err)
+		}
+		c := newMsgConn(conn)
+		t.Cleanup(func() { _ = c.Close() })
+		if _, _, err = c.authUpstream(); err != nil {
+			t.Fatalf("authUpstream: error = %v", err)
+		}
+		if err = c.writeMessage(&message{typ: msgMethodCall, serial: 1,
+			dest: busName, path: busPath, iface: busName, member: "ListNames"}); err != nil {
+			t.Fatalf("writeMessage: error = %v", err)
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		if m, err := c.readMessage(); err == nil {
+			t.Errorf("readMessage: %s, want connection closed", m)
+		}
+	})
+}
+
+// testFds calls dest with a file descriptor attached, and checks the descriptor attached to its reply.
+func testFds(t *testing.T, c *testConn, dest string) {
+	var p [2]int
+	if err := syscall.Pipe2(p[:], syscall.O_CLOEXEC); err != nil {
+		t.Fatalf("Pipe2: error = %v", err)
+	}
+	r := os.NewFile(uintptr(p[0]), "r")
+	defer func() { _ = r.Close() }()
+
+	e := &encoder{order: binary.LittleEndian}
+	e.uint32(0)
+	reply := c.send(t, &message{typ: msgMethodCall, dest: dest, path: "/", iface: "org.example.Iface", member: "Fd",
+		sig: "h", unixFds: 1, body: e.b, fds: []int{p[1]}})
+	if len(reply.fds) != 1 {
+		t.Fatalf("call: %s, want a file descriptor", reply)
+	}
+	w := os.NewFile(uintptr(reply.fds[0]), "w")
+	_, _ = w.Write([]byte{'x'})
+	_ = w.Close()
+
+	var b [2]byte
+	if n, err := r.Read(b[:]); err != nil || n != 1 || b[0] != 'x' {
+		t.Errorf("Read: %q, error = %v", b[:n], err)
+	}
+}
+
+func stringBody(v ...string) []byte {
+	e := &encoder{order: binary.LittleEndian}
+	for _, s := range v {
+		e.string(s)
+	}
+	return e.b
+}
+
+// newTestProxy starts a fake bus and a proxy of it filtering according to args.
+func newTestProxy(t *testing.T, args ...string) (bus *fakeBus, proxy string, p *proxyArgs) {
+	dir := t.TempDir()
+	bus = newFakeBus(t, path.Join(dir, "bus"))
+	proxy = path.Join(dir, "proxy")
+
+	v, err := parseProxyArgs(append([]string{"unix:path=" + bus.name, proxy}, args...))
+	if err != nil {
+		t.Fatalf("parseProxyArgs: error = %v", err)
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: proxy, Net: "unix"})
+	if err != nil {
+		t.Fatalf("ListenUnix: error = %v", err)
+	}
+
+	p = v[0]
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := p.serve(ctx, l); err != nil {
+			t.Errorf("serve: error = %v", err)
+		}
+	}()
+	t.Cleanup(func() { cancel(); <-done })
+	return
+}
+
+// testConn is a bus connection replying to method calls with their member name,
+// or with file descriptors attached to the call.
+type testConn struct {
+	c      *msgConn
+	unique string
+	serial atomic.Uint32
+
+	mu      sync.Mutex
+	pending map[uint32]chan *message
+	signals chan *message
+	// number of method calls received
+	received atomic.Uint32
+}
+
+func dialTestConn(t *testing.T, name string) *testConn {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: name, Net: "unix"})
+	if err != nil {
+		t.Fatalf("DialUnix: error = %v", err)
+	}
+	c := &testConn{c: newMsgConn(conn), pending: make(map[uint32]chan *message), signals: make(chan *message, 64)}
+	t.Cleanup(func() {
+		_ = conn.Close()
+		// wait for serve to return before releasing file descriptors
+		for range c.signals {
+		}
+		_ = c.c.Close()
+	})
+	if _, unixFds, err := c.c.authUpstream(); err != nil {
+		t.Fatalf("authUpstream: error = %v", err)
+	} else if !unixFds {
+		t.Fatal("authUpstream: file descriptor passing not agreed on")
+	}
+	go c.serve()
+
+	if args, err := c.call(t, busName, busPath, busName, "Hello", "", nil).strings(); err != nil || len(args) != 1 {
+		t.Fatalf("Hello: %q, error = %v", args, err)
+	} else {
+		c.unique = args[0]
+	}
+	return c
+}
+
+func (c *testConn) serve() {
+	defer close(c.signals)
+	for {
+		m, err := c.c.readMessage()
+		if err != nil {
+			return
+		}
+		switch m.typ {
+		case msgMethodReturn, msgError:
+			c.mu.Lock()
+			ch, ok := c.pending[m.replySerial]
+			delete(c.pending, m.replySerial)
+			c.mu.Unlock()
+			if ok {
+				ch <- m
+			} else {
+				m.close()
+			}
+		case msgSignal:
+			c.signals <- m
+		case msgMethodCall:
+			c.received.Add(1)
+			if m.flags&flagNoReplyExpected != 0 {
+				m.close()
+				continue
+			}
+			r := &message{typ: msgMethodReturn, serial: c.serial.Add(1), replySerial: m.serial, dest: m.sender}
+			if len(m.fds) > 0 {
+				r.sig, r.body, r.unixFds, r.fds = m.sig, m.body, m.unixFds, m.fds
+			} else {
+				r.sig, r.body = "s", stringBody(m.member)
+			}
+			_ = c.c.writeMessage(r)
+			m.close()
+		}
+	}
+}
+
+// send sends a method call and returns its reply.
+func (c *testConn) send(t *testing.T, m *message) *message {
+	m.serial = c.serial.Add(1)
+	ch := make(chan *message, 1)
+	c.mu.Lock()
+	c.pending[m.serial] = ch
+	c.mu.Unlock()
+	if err := c.c.writeMessage(m); err != nil {
+		t.Fatalf("writeMessage: error = %v", err)
+	}
+
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s: timed out waiting for reply", m)
+		return nil
+	}
+}
+
+func (c *testConn) call(t *testing.T, dest, path, iface, member, sig string, body []byte) *message {
+	return c.send(t, &message{typ: msgMethodCall, dest: dest, path: path, iface: iface, member: member, sig: sig, body: body})
+}
+
+func (c *testConn) callStrings(t *testing.T, member, sig string, args ...string) []string {
+	reply := c.call(t, busName, busPath, busName, member, sig, stringBody(args...))
+	v, err := reply.stringArray()
+	if err != nil {
+		t.Fatalf("%s: %s, error = %v", member, reply, err)
+	}
+	return v
+}
+
+func (c *testConn) requestName(t *testing.T, name string) {
+	e := &encoder{order: binary.LittleEndian}
+	e.string(name)
+	e.uint32(0)
+	if reply := c.call(t, busName, busPath, busName, "RequestName", "su", e.b); reply.typ != msgMethodReturn {
+		t.Fatalf("RequestName: %s", reply)
+	}
+}
+
+// emit emits a signal and waits for the bus to process it.
+func (c *testConn) emit(t *testing.T, path, iface, member string) {
+	if err := c.c.writeMessage(&message{typ: msgSignal, serial: c.serial.Add(1),
+		path: path, iface: iface, member: member}); err != nil {
+		t.Fatalf("writeMessage: error = %v", err)
+	}
+	c.call(t, busName, busPath, "org.freedesktop.DBus.Peer", "Ping", "", nil)
+}
+
+// expectSignal returns the next signal, skipping signals unrelated to the test.
+func (c *testConn) expectSignal(t *testing.T, sender, path, member string) *message {
+	for {
+		select {
+		case m, ok := <-c.signals:
+			if !ok {
+				t.Fatal("connection closed")
+			}
+			if m.member == "NameAcquired" || m.member == "NameLost" ||
+				(m.member == "NameOwnerChanged" && member != "NameOwnerChanged") {
+				continue
+			}
+			if m.sender != sender || m.path != path || m.member != member {
+				t.Fatalf("expectSignal: %s, want %s %s from %s", m, path, member, sender)
+			}
+			return m
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expectSignal: timed out waiting for %s %s from %s", path, member, sender)
+			return nil
+		}
+	}
+}
+
+// fakeBus is a minimal message bus. Messages are processed one at a time in the order received,
+// and signals are broadcast to every other connection regardless of match rules.
+type fakeBus struct {
+	name string
+	l    *net.UnixListener
+	// method calls are also delivered to every other connection, as if all of them eavesdrop
+	eavesdrop atomic.Bool
+
+	events chan fakeEvent
+	done   chan struct{}
+	serial uint32
+	seq    int
+	conns  []*fakeConn
+	owners map[string]*fakeConn
+}
+
+type fakeConn struct {
+	c      *msgConn
+	unique string
+}
+
+// fakeEvent is a message received on a connection, or its disconnection if m is nil.
+type fakeEvent struct {
+	fc *fakeConn
+	m  *message
+}
+
+func newFakeBus(t *testing.T, name string) *fakeBus {
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: name, Net: "unix"})
+	if err != nil {
+		t.Fatalf("ListenUnix: error = %v", err)
+	}
+	b := &fakeBus{name: name, l: l, events: make(chan fakeEvent), done: make(chan struct{}),
+		owners: make(map[string]*fakeConn)}
+	t.Cleanup(func() { _ = l.Close(); close(b.done) })
+	go b.accept()
+	go func() {
+		for {
+			select {
+			case ev := <-b.events:
+				b.handle(ev)
+			case <-b.done:
+				for _, fc := range b.conns {
+					_ = fc.c.Close()
+				}
+				return
+			}
+		}
+	}()
+	return b
+}
+
+// peer returns a connection to the bus owning name.
+func (b *fakeBus) peer(t *testing.T, name string) *testConn {
+	c := dialTestConn(t, b.name)
+	c.requestName(t, name)
+	return c
+}
+
+func (b *fakeBus) accept() {
+	for {
+		conn, err := b.l.AcceptUnix()
+		if err != nil {
+			return
+		}
+		go func() {
+			fc := &fakeConn{c: newMsgConn(conn)}
+			if err = fc.c.authDownstream("0123456789abcdef0123456789abcdef", true); err != nil {
+				_ = fc.c.Close()
+				return
+			}
+			for {
+				m, err := fc.c.readMessage()
+				select {
+				case b.events <- fakeEvent{fc, m}:
+				case <-b.done:
+					return
+				}
+				if err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+func (b *fakeBus) send(fc *fakeConn, m *message) {
+	b.serial++
+	m.serial, m.sender = b.serial, busName
+	if m.typ != msgSignal || m.dest != "" {
+		m.dest = fc.unique
+	}
+	_ = fc.c.writeMessage(m)
+}
+
+func (b *fakeBus) reply(fc *fakeConn, m *message, sig string, body []byte) {
+	b.send(fc, &message{typ: msgMethodReturn, flags: flagNoReplyExpected, replySerial: m.serial, sig: sig, body: body})
+}
+
+func (b *fakeBus) error(fc *fakeConn, m *message, name string) {
+	b.send(fc, &message{typ: msgError, flags: flagNoReplyExpected, replySerial: m.serial, errName: name})
+}
+
+func (b *fakeBus) broadcast(m *message, except *fakeConn) {
+	for _, fc := range b.conns {
+		if fc != except && fc.unique != "" {
+			v := *m
+			v.raw = nil
+			if v.sender == "" {
+				b.send(fc, &v)
+			} else {
+				_ = fc.c.writeMessage(&v)
+			}
+		}
+	}
+}
+
+func (b *fakeBus) nameOwnerChanged(name, oldOwner, newOwner string) {
+	b.broadcast(&message{typ: msgSignal, path: busPath, iface: busName, member: "NameOwnerChanged",
+		sig: "sss", body: stringBody(name, oldOwner, newOwner)}, nil)
+}
+
+func (b *fakeBus) handle(ev fakeEvent) {
+	fc, m := ev.fc, ev.m
+	if m == nil {
+		if i := slices.Index(b.conns, fc); i != -1 {
+			b.conns = slices.Delete(b.conns, i, i+1)
+		}
+		for name, owner := range b.owners {
+			if owner == fc {
+				delete(b.owners, name)
+				b.nameOwnerChanged(name, fc.unique, "")
+			}
+		}
+		if fc.unique != "" {
+			b.nameOwnerChanged(fc.unique, fc.unique, "")
+		}
+		_ = fc.c.Close()
+		return
+	}
+	defer m.close()
+
+	if fc.unique == "" {
+		if m.member != "Hello" {
+			_ = fc.c.Close()
+			return
+		}
+		b.seq++
+		fc.unique = ":1." + strconv.Itoa(b.seq)
+		b.conns = append(b.conns, fc)
+		b.reply(fc, m, "s", stringBody(fc.unique))
+		b.send(fc, &message{typ: msgSignal, dest: fc.unique, path: busPath, iface: busName,
+			member: "NameAcquired", sig: "s", body: stringBody(fc.unique)})
+		b.nameOwnerChanged(fc.unique, "", fc.unique)
+		return
+	}
+
+	v := *m
+	v.sender, v.raw = fc.unique, nil
+	if m.dest != busName {
+		switch {
+		case m.dest == "" && m.typ == msgSignal:
+			b.broadcast(&v, fc)
+		case b.owner(m.dest) != nil:
+			owner := b.owner(m.dest)
+			_ = owner.c.writeMessage(&v)
+			if m.typ == msgMethodCall && b.eavesdrop.Load() {
+				for _, other := range b.conns {
+					if other != owner && other != fc {
+						e := v
+						_ = other.c.writeMessage(&e)
+					}
+				}
+			}
+		case m.typ == msgMethodCall:
+			b.error(fc, m, errServiceUnknown)
+		}
+		return
+	}
+	if m.typ != msgMethodCall {
+		return
+	}
+
+	args, _ := m.strings()
+	arg := ""
+	if len(args) > 0 {
+		arg = args[0]
+	}
+	switch m.member {
+	case "RequestName":
+		if b.owners[arg] != nil {
+			b.reply(fc, m, "u", []byte{3, 0, 0, 0})
+			return
+		}
+		b.owners[arg] = fc
+		b.reply(fc, m, "u", []byte{1, 0, 0, 0})
+		b.nameOwnerChanged(arg, "", fc.unique)
+	case "ReleaseName":
+		if b.owners[arg] != fc {
+			b.reply(fc, m, "u", []byte{3, 0, 0, 0})
+			return
+		}
+		delete(b.owners, arg)
+		b.reply(fc, m, "u", []byte{1, 0, 0, 0})
+		b.nameOwnerChanged(arg, fc.unique, "")
+	case "GetNameOwner":
+		if owner := b.owner(arg); owner != nil {
+			b.reply(fc, m, "s", stringBody(owner.unique))
+		} else {
+			b.error(fc, m, errNameHasNoOwner)
+		}
+	case "NameHasOwner":
+		if b.owner(arg) != nil {
+			b.reply(fc, m, "b", []byte{1, 0, 0, 0})
+		} else {
+			b.reply(fc, m, "b", []byte{0, 0, 0, 0})
+		}
+	case "ListNames":
+		names := []string{busName}
+		for _, c := range b.conns {
+			names = append(names, c.unique)
+		}
+		for name := range b.owners {
+			names = append(names, name)
+		}
+		e := &encoder{order: binary.LittleEndian}
+		e.stringArray(names)
+		b.reply(fc, m, "as", e.b)
+	default:
+		b.reply(fc, m, "", nil)
+	}
+}
+
+func (b *fakeBus) owner(name string) *fakeConn {
+	if !isUnique(name) {
+		return b.owners[name]
+	}
+	for _, fc := range b.conns {
+		if fc.unique == name {
+			return fc
+		}
+	}
+	return nil
+}
@@ -123,12 +123,6 @@
 
               # appPackages
               glibc
-              xdg-dbus-proxy
-
-              # fpkg
-              zstd
-              gnutar
-              coreutils
               ;
           };
           fsu = pkgs.callPackage ./cmd/fsu/package.nix { inherit (self.packages.${system}) fortify; };
@@ -9,7 +9,15 @@ import (
 const Tmp = "/.fortify"
 
 // Config is used to seal an app implementation.
+// Paths and container environment values may reference variables expanded during seal, see [Config.Expand].
 type Config struct {
+	// paths to base configurations this configuration is merged on top of, see [Config.ResolveExtends];
+	// relative paths are resolved against the directory containing this configuration
+	Extends []string `json:"extends,omitempty"`
+	// fields of this configuration replacing those of base configurations instead of being combined with them,
+	// named by their JSON key and prefixed by "container." for fields of the container configuration
+	Replace []string `json:"replace,omitempty"`
+
 	// reverse-DNS style arbitrary identifier string from config;
 	// passed to wayland security-context-v1 as application ID
 	// and used as part of defaults in dbus session proxy
@@ -29,6 +37,9 @@ type Config struct {
 	// system D-Bus proxy configuration;
 	// nil disables system bus proxy
 	SystemBus *dbus.Config `json:"system_bus,omitempty"`
+	// absolute path to write a D-Bus proxy configuration permitting all observed traffic to on exit;
+	// message bus proxies permit all traffic while this is set
+	DBusLearn string `json:"dbus_learn,omitempty"`
 	// direct access to wayland socket; when this gets set no attempt is made to attach security-context-v1
 	// and the bare socket is mounted to the sandbox
 	DirectWayland bool `json:"direct_wayland,omitempty"`
@@ -12,6 +12,10 @@ type (
 
 		// extra seccomp flags
 		Seccomp seccomp.FilterOpts `json:"seccomp"`
+		// extra seccomp rules, allow rules exempt their syscall from rules of seccomp flags
+		SeccompRules []seccomp.Rule `json:"seccomp_rules,omitempty"`
+		// syscalls passed to and handled by the supervisor in the shim, calls matching no rule are decided by the rest of the filter
+		SeccompSupervise []seccomp.NotifyRule `json:"seccomp_supervise,omitempty"`
 		// allow ptrace and friends
 		Devel bool `json:"devel,omitempty"`
 		// allow userns creation in container
@@ -22,6 +26,11 @@ type (
 		Tty bool `json:"tty,omitempty"`
 		// allow multiarch
 		Multiarch bool `json:"multiarch,omitempty"`
+		// restrict filesystem access to container mount points using landlock,
+		// read-only paths nested under writable ones remain writable
+		Landlock bool `json:"landlock,omitempty"`
+		// permit and record syscalls denied by the syscall filter instead of failing them
+		SeccompAudit bool `json:"seccomp_audit,omitempty"`
 
 		// initial process environment variables
 		Env map[string]string `json:"env"`
@@ -30,6 +39,8 @@ type (
 
 		// pass through all devices
 		Device bool `json:"device,omitempty"`
+		// discover and expose GPU device nodes, their sysfs entries and driver libraries
+		GPU bool `json:"gpu,omitempty"`
 		// container host filesystem bind mounts
 		Filesystem []*FilesystemConfig `json:"filesystem"`
 		// create symlinks inside container filesystem
@@ -41,6 +52,38 @@ type (
 		AutoEtc bool `json:"auto_etc"`
 		// cover these paths or create them if they do not already exist
 		Cover []string `json:"cover"`
+
+		// cgroup v2 resource limits, no cgroup is created if nil
+		Cgroup *CgroupConfig `json:"cgroup,omitempty"`
+		// user-mode networking in a private net namespace, mutually exclusive with net
+		Usernet *UsernetConfig `json:"usernet,omitempty"`
+	}
+
+	// UsernetConfig describes outbound connectivity provided to the container by a user-mode networking helper.
+	UsernetConfig struct {
+		// relay connections to the gateway address 10.0.2.2 to host loopback
+		HostLoopback bool `json:"host_loopback,omitempty"`
+		// only permit outbound connections to these destinations, as host:port, address, address:port, CIDR or CIDR:port;
+		// host names are matched against lookups via the DNS forwarder, all destinations are permitted if nil
+		Allow []string `json:"allow,omitempty"`
+		// host address or absolute unix socket pathname of a proxy reachable at 10.0.2.2:3128, all other traffic is rejected
+		Proxy string `json:"proxy,omitempty"`
+	}
+
+	// CgroupConfig describes resource limits enforced on the container via a per-instance cgroup.
+	CgroupConfig struct {
+		// delegated cgroup v2 subtree to create the instance cgroup under, relative to the cgroup v2 mount point;
+		// must not hold processes itself and is required for resource limits
+		Parent string `json:"parent,omitempty"`
+
+		// value of memory.max in bytes, no limit if zero
+		Memory int64 `json:"memory,omitempty"`
+		// value of pids.max, no limit if zero
+		Pids int `json:"pids,omitempty"`
+		// value of cpu.weight between 1 and 10000, unchanged if zero
+		CPUWeight int `json:"cpu_weight,omitempty"`
+		// value of io.weight between 1 and 10000, unchanged if zero
+		IOWeight int `json:"io_weight,omitempty"`
 	}
 
 	// FilesystemConfig is an abstract representation of a bind mount.
@@ -55,5 +98,10 @@ type (
 		Device bool `json:"dev,omitempty"`
 		// fail if the bind mount cannot be established for any reason
 		Must bool `json:"require,omitempty"`
+
+		// mount an overlay with src as its lower directory instead of a bind mount, src must exist
+		Overlay bool `json:"overlay,omitempty"`
+		// persist overlay writes to this path relative to the app data directory, discard writes if empty
+		Upper string `json:"upper,omitempty"`
 	}
 )
@@ -0,0 +1,218 @@
+package fst
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Variables available for substitution via [Config.Expand].
+const (
+	// VarHome is the home directory of the privileged user.
+	VarHome = "home"
+	// VarUid is the target uid resolved by fsu.
+	VarUid = "uid"
+	// VarAid is the numerical application id, see [Config.Identity].
+	VarAid = "aid"
+	// VarID is the reverse-DNS style application identifier, see [Config.ID].
+	VarID = "id"
+	// VarInstance is the instance identifier of the app being sealed.
+	VarInstance = "instance"
+	// VarRuntime is the XDG_RUNTIME_DIR of the privileged user.
+	VarRuntime = "runtime"
+	// VarShare is the process share directory (usually `/tmp/fortify.%d`).
+	VarShare = "share"
+)
+
+var (
+	// ErrUnknownVar is returned by [Config.Expand] for a reference to a variable not present in vars.
+	ErrUnknownVar = errors.New("unknown variable")
+	// ErrBadVar is returned by [Config.Expand] for an unterminated variable reference.
+	ErrBadVar = errors.New("unterminated variable reference")
+)
+
+// VarError is returned by [Config.Expand] if a value cannot be expanded.
+type VarError struct {
+	// JSON path of the offending field
+	Field string
+	// name of the variable, or the offending value for [ErrBadVar]
+	Name string
+	Err  error
+}
+
+func (e *VarError) Error() string { return fmt.Sprintf("%s: %v %q", e.Field, e.Err, e.Name) }
+func (e *VarError) Unwrap() error { return e.Err }
+
+// Expand substitutes references to variables in paths and container environment values of config.
+// A reference has the form ${name}, where name is a key of vars, and $$ expands to a literal $.
+// References to any other name are rejected with [ErrUnknownVar].
+//
+// Environment values commonly hold shell syntax meant for the program, so they are only expanded if they reference
+// a variable in vars, and references to other names as well as unterminated references are kept as is.
+// A value without such a reference, including one containing $$ or ${...} of other names, is left unchanged.
+//
+// Expanded fields are Path, DBusLearn, Shell, Data, Dir, ExtraPerms paths and the Filesystem, Link, Etc, Cover and
+// Env values of Container. Slices, maps and structs reachable from config are replaced with expanded copies,
+// so values shared with other configurations are not modified.
+func (config *Config) Expand(vars map[string]string) error {
+	e := expander(vars)
+
+	if err := e.expand("path", &config.Path); err != nil {
+		return err
+	}
+	if err := e.expand("dbus_learn", &config.DBusLearn); err != nil {
+		return err
+	}
+	if err := e.expand("shell", &config.Shell); err != nil {
+		return err
+	}
+	if err := e.expand("data", &config.Data); err != nil {
+		return err
+	}
+	if err := e.expand("dir", &config.Dir); err != nil {
+		return err
+	}
+	if config.ExtraPerms != nil {
+		config.ExtraPerms = slices.Clone(config.ExtraPerms)
+		for i, p := range config.ExtraPerms {
+			if p == nil {
+				continue
+			}
+			v := *p
+			if err := e.expand(fmt.Sprintf("extra_perms[%d].path", i), &v.Path); err != nil {
+				return err
+			}
+			config.ExtraPerms[i] = &v
+		}
+	}
+
+	if config.Container == nil {
+		return nil
+	}
+	s := *config.Container
+	config.Container = &s
+
+	if s.Env != nil {
+		s.Env = maps.Clone(s.Env)
+		for k, val := range s.Env {
+			if err := e.expandEnv("container.env."+k, &val); err != nil {
+				return err
+			}
+			s.Env[k] = val
+		}
+	}
+	if s.Filesystem != nil {
+		s.Filesystem = slices.Clone(s.Filesystem)
+		for i, b := range s.Filesystem {
+			if b == nil {
+				continue
+			}
+			v := *b
+			field := fmt.Sprintf("container.filesystem[%d]", i)
+			if err := e.expand(field+".src", &v.Src); err != nil {
+				return err
+			}
+			if err := e.expand(field+".dst", &v.Dst); err != nil {
+				return err
+			}
+			if err := e.expand(field+".upper", &v.Upper); err != nil {
+				return err
+			}
+			s.Filesystem[i] = &v
+		}
+	}
+	if s.Link != nil {
+		s.Link = slices.Clone(s.Link)
+		for i := range s.Link {
+			for j := range s.Link[i] {
+				if err := e.expand(fmt.Sprintf("container.symlink[%d]", i), &s.Link[i][j]); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	if err := e.expand("container.etc", &s.Etc); err != nil {
+		return err
+	}
+	if s.Cover != nil {
+		s.Cover = slices.Clone(s.Cover)
+		for i := range s.Cover {
+			if err := e.expand(fmt.Sprintf("container.cover[%d]", i), &s.Cover[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type expander map[string]string
+
+// expand substitutes variable references in the string pointed to by v.
+func (e expander) expand(field string, v *string) error { return e.subst(field, v, false) }
+
+// expandEnv substitutes variable references in the environment value pointed to by v
+// if it references any variable of e, references to other names are kept.
+func (e expander) expandEnv(field string, v *string) error {
+	for name := range e {
+		if strings.Contains(*v, "${"+name+"}") {
+			return e.subst(field, v, true)
+		}
+	}
+	return nil
+}
+
+// subst substitutes variable references in the string pointed to by v.
+// If keep is true, unknown and unterminated references are written out unchanged instead of being rejected.
+func (e expander) subst(field string, v *string, keep bool) error {
+	s := *v
+	if !strings.Contains(s, "$") {
+		return nil
+	}
+
+	var buf strings.Builder
+	buf.Grow(len(s))
+	for {
+		i := strings.IndexByte(s, '$')
+		if i == -1 || i == len(s)-1 {
+			buf.WriteString(s)
+			break
+		}
+		buf.WriteString(s[:i])
+		s = s[i+1:]
+
+		switch s[0] {
+		case '$':
+			buf.WriteByte('$')
+			s = s[1:]
+
+		case '{':
+			end := strings.IndexByte(s, '}')
+			if end == -1 {
+				if keep {
+					buf.WriteByte('$')
+					buf.WriteString(s)
+					*v = buf.String()
+					return nil
+				}
+				return &VarError{field, *v, ErrBadVar}
+			}
+			name := s[1:end]
+			if val, ok := e[name]; !ok {
+				if !keep {
+					return &VarError{field, name, ErrUnknownVar}
+				}
+				buf.WriteString("${" + name + "}")
+			} else {
+				buf.WriteString(val)
+			}
+			s = s[end+1:]
+
+		default:
+			buf.WriteByte('$')
+		}
+	}
+	*v = buf.String()
+	return nil
+}
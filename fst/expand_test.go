@@ -0,0 +1,118 @@
+package fst_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/fst"
+)
+
+func TestExpand(t *testing.T) {
+	vars := map[string]string{
+		fst.VarHome:     "/home/ophestra",
+		fst.VarUid:      "1000001",
+		fst.VarAid:      "1",
+		fst.VarID:       "org.chromium.Chromium",
+		fst.VarInstance: "8e2c76b066dabe574cf073bdb46eb5c1",
+		fst.VarRuntime:  "/run/user/1971",
+		fst.VarShare:    "/tmp/fortify.1971",
+	}
+
+	testCases := []struct {
+		name    string
+		config  *fst.Config
+		want    *fst.Config
+		wantErr error
+	}{
+		{"none", &fst.Config{Data: "/var/lib/fortify/u0/a1"}, &fst.Config{Data: "/var/lib/fortify/u0/a1"}, nil},
+
+		{"paths", &fst.Config{
+			Path:       "${home}/.nix-profile/bin/chromium",
+			Args:       []string{"${id}"},
+			Shell:      "${home}/.nix-profile/bin/zsh",
+			Data:       "${home}/.local/share/fortify/${aid}",
+			Dir:        "/data/data/${id}",
+			ExtraPerms: []*fst.ExtraPermConfig{nil, {Path: "${share}/${instance}", Read: true}},
+		}, &fst.Config{
+			Path:       "/home/ophestra/.nix-profile/bin/chromium",
+			Args:       []string{"${id}"},
+			Shell:      "/home/ophestra/.nix-profile/bin/zsh",
+			Data:       "/home/ophestra/.local/share/fortify/1",
+			Dir:        "/data/data/org.chromium.Chromium",
+			ExtraPerms: []*fst.ExtraPermConfig{nil, {Path: "/tmp/fortify.1971/8e2c76b066dabe574cf073bdb46eb5c1", Read: true}},
+		}, nil},
+
+		{"container", &fst.Config{Container: &fst.ContainerConfig{
+			Env: map[string]string{"PRICE": "$$5 or $$${uid}$", "CACHE": "/run/user/${uid}/cache"},
+			Filesystem: []*fst.FilesystemConfig{
+				{Src: "${runtime}/pipewire-0", Dst: "/run/user/${uid}/pipewire-0"},
+				{Src: "${home}/.config/${id}", Overlay: true, Upper: "overlay/${id}"},
+			},
+			Link:  [][2]string{{"${home}", "/home/${aid}"}},
+			Etc:   "${share}/etc",
+			Cover: []string{"${home}/.ssh"},
+		}}, &fst.Config{Container: &fst.ContainerConfig{
+			Env: map[string]string{"PRICE": "$5 or $1000001$", "CACHE": "/run/user/1000001/cache"},
+			Filesystem: []*fst.FilesystemConfig{
+				{Src: "/run/user/1971/pipewire-0", Dst: "/run/user/1000001/pipewire-0"},
+				{Src: "/home/ophestra/.config/org.chromium.Chromium", Overlay: true, Upper: "overlay/org.chromium.Chromium"},
+			},
+			Link:  [][2]string{{"/home/ophestra", "/home/1"}},
+			Etc:   "/tmp/fortify.1971/etc",
+			Cover: []string{"/home/ophestra/.ssh"},
+		}}, nil},
+
+		{"env literal", &fst.Config{Container: &fst.ContainerConfig{Env: map[string]string{
+			"FOO":    "${FOO}",
+			"PRICE":  "$$5",
+			"PS1":    "${debian_chroot:+($debian_chroot)}\\u@\\h:\\w\\$ ",
+			"PATH":   "${home}/bin:${PATH}",
+			"BROKEN": "${uid}:${PATH",
+		}}}, &fst.Config{Container: &fst.ContainerConfig{Env: map[string]string{
+			"FOO":    "${FOO}",
+			"PRICE":  "$$5",
+			"PS1":    "${debian_chroot:+($debian_chroot)}\\u@\\h:\\w\\$ ",
+			"PATH":   "/home/ophestra/bin:${PATH}",
+			"BROKEN": "1000001:${PATH",
+		}}}, nil},
+
+		{"unknown", &fst.Config{Data: "${HOME}/data"}, nil, &fst.VarError{Field: "data", Name: "HOME", Err: fst.ErrUnknownVar}},
+		{"unterminated", &fst.Config{Container: &fst.ContainerConfig{Cover: []string{"/", "${home"}}}, nil,
+			&fst.VarError{Field: "container.cover[1]", Name: "${home", Err: fst.ErrBadVar}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.Expand(vars)
+			if tc.wantErr != nil {
+				if !reflect.DeepEqual(err, tc.wantErr) {
+					t.Errorf("Expand: error = %v, want %v", err, tc.wantErr)
+				}
+				if !errors.Is(err, errors.Unwrap(tc.wantErr)) {
+					t.Errorf("Expand: error = %v does not wrap %v", err, errors.Unwrap(tc.wantErr))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expand: error = %v", err)
+			}
+			if !reflect.DeepEqual(tc.config, tc.want) {
+				t.Errorf("Expand:\ngot  %#v\nwant %#v", tc.config, tc.want)
+			}
+		})
+	}
+
+	t.Run("unmodified", func(t *testing.T) {
+		b := &fst.FilesystemConfig{Src: "${home}"}
+		env := map[string]string{"HOME": "${home}"}
+		config := &fst.Config{Container: &fst.ContainerConfig{Env: env, Filesystem: []*fst.FilesystemConfig{b}}}
+		container := config.Container
+		if err := config.Expand(vars); err != nil {
+			t.Fatalf("Expand: error = %v", err)
+		}
+		if b.Src != "${home}" || env["HOME"] != "${home}" || container.Filesystem[0] != b {
+			t.Errorf("Expand: shared values modified")
+		}
+	})
+}
@@ -0,0 +1,248 @@
+package fst
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"slices"
+)
+
+var (
+	// ErrExtendsCycle is returned by [Config.ResolveExtends] if a base configuration extends itself.
+	ErrExtendsCycle = errors.New("configuration extends itself")
+	// ErrReplaceField is returned by [Config.ResolveExtends] if Replace names an unknown field.
+	ErrReplaceField = errors.New("cannot replace field")
+)
+
+// ExtendsError is returned by [Config.ResolveExtends] if a base configuration cannot be loaded.
+type ExtendsError struct {
+	// path to the base configuration
+	Name string
+	Err  error
+}
+
+func (e *ExtendsError) Error() string { return fmt.Sprintf("base configuration %q: %v", e.Name, e.Err) }
+func (e *ExtendsError) Unwrap() error { return e.Err }
+
+// ResolveExtends loads every base configuration named by Extends and merges config on top of them via [Merge].
+// Base configurations are merged in order and may extend other configurations. Relative paths are resolved
+// against the directory of name, which is the path config is loaded from, or the working directory if name is empty.
+// Fields named by Replace of a configuration replace those of its base configurations, see [Merge].
+// Extends and Replace are cleared on success.
+func (config *Config) ResolveExtends(name string) error {
+	if err := config.checkReplace(); err != nil {
+		return err
+	}
+	var seen []string
+	if name != "" {
+		seen = []string{path.Clean(name)}
+	}
+	if err := config.resolveExtends(path.Dir(name), seen); err != nil {
+		return err
+	}
+	config.Replace = nil
+	return nil
+}
+
+func (config *Config) resolveExtends(dir string, seen []string) error {
+	if len(config.Extends) == 0 {
+		return nil
+	}
+
+	base := new(Config)
+	for _, name := range config.Extends {
+		if !path.IsAbs(name) {
+			name = path.Join(dir, name)
+		}
+		name = path.Clean(name)
+		if slices.Contains(seen, name) {
+			return &ExtendsError{name, ErrExtendsCycle}
+		}
+
+		v := new(Config)
+		if f, err := os.Open(name); err != nil {
+			return &ExtendsError{name, err}
+		} else if err = json.NewDecoder(f).Decode(v); err != nil {
+			_ = f.Close()
+			return &ExtendsError{name, err}
+		} else if err = f.Close(); err != nil {
+			return &ExtendsError{name, err}
+		} else if err = v.checkReplace(); err != nil {
+			return &ExtendsError{name, err}
+		}
+		if err := v.resolveExtends(path.Dir(name), append(seen, name)); err != nil {
+			return err
+		}
+		base = Merge(base, v)
+	}
+
+	*config = *Merge(base, config)
+	config.Extends = nil
+	return nil
+}
+
+// Merge returns a new [Config] with values of config applied on top of base. Neither argument is modified.
+//
+// Strings and numbers of config replace those of base unless they are zero. Booleans, enablements and seccomp
+// flags are combined. Args, dbus proxy configurations and pointers to other structs are replaced if set in config,
+// while Container is merged field by field. All other slices are appended to those of base, and maps are merged
+// with entries of config taking precedence. Fields named in Replace of config are replaced unconditionally,
+// so a value of base can be overridden with the zero value.
+func Merge(base, config *Config) *Config {
+	v := *base
+	v.Extends = config.Extends
+	v.Replace = config.Replace
+	r := replaceSet(config.Replace)
+
+	replace(r("id"), &v.ID, config.ID)
+	replace(r("path"), &v.Path, config.Path)
+	if r("args") || len(config.Args) > 0 {
+		v.Args = slices.Clone(config.Args)
+	} else {
+		v.Args = slices.Clone(base.Args)
+	}
+	union(r("enablements"), &v.Enablements, config.Enablements)
+
+	replacePtr(r("session_bus"), &v.SessionBus, config.SessionBus)
+	replacePtr(r("system_bus"), &v.SystemBus, config.SystemBus)
+	replace(r("dbus_learn"), &v.DBusLearn, config.DBusLearn)
+	either(r("direct_wayland"), &v.DirectWayland, config.DirectWayland)
+
+	replace(r("username"), &v.Username, config.Username)
+	replace(r("shell"), &v.Shell, config.Shell)
+	replace(r("data"), &v.Data, config.Data)
+	replace(r("dir"), &v.Dir, config.Dir)
+	v.ExtraPerms = concat(r("extra_perms"), base.ExtraPerms, config.ExtraPerms)
+
+	replace(r("identity"), &v.Identity, config.Identity)
+	v.Groups = concat(r("groups"), base.Groups, config.Groups)
+
+	switch {
+	case r("container") && config.Container == nil:
+		v.Container = nil
+	case r("container"):
+		v.Container = mergeContainer(new(ContainerConfig), config.Container, r)
+	case base.Container == nil && config.Container == nil:
+	case base.Container == nil:
+		v.Container = mergeContainer(new(ContainerConfig), config.Container, r)
+	case config.Container == nil:
+		v.Container = mergeContainer(base.Container, new(ContainerConfig), r)
+	default:
+		v.Container = mergeContainer(base.Container, config.Container, r)
+	}
+	return &v
+}
+
+func mergeContainer(base, config *ContainerConfig, r func(name string) bool) *ContainerConfig {
+	v := *base
+	c := func(name string) bool { return r("container." + name) }
+
+	replace(c("hostname"), &v.Hostname, config.Hostname)
+	union(c("seccomp"), &v.Seccomp, config.Seccomp)
+	v.SeccompRules = concat(c("seccomp_rules"), base.SeccompRules, config.SeccompRules)
+	v.SeccompSupervise = concat(c("seccomp_supervise"), base.SeccompSupervise, config.SeccompSupervise)
+	either(c("devel"), &v.Devel, config.Devel)
+	either(c("userns"), &v.Userns, config.Userns)
+	either(c("net"), &v.Net, config.Net)
+	either(c("tty"), &v.Tty, config.Tty)
+	either(c("multiarch"), &v.Multiarch, config.Multiarch)
+	either(c("landlock"), &v.Landlock, config.Landlock)
+	either(c("seccomp_audit"), &v.SeccompAudit, config.SeccompAudit)
+
+	if c("env") {
+		v.Env = maps.Clone(config.Env)
+	} else if base.Env != nil || config.Env != nil {
+		v.Env = make(map[string]string, len(base.Env)+len(config.Env))
+		maps.Copy(v.Env, base.Env)
+		maps.Copy(v.Env, config.Env)
+	}
+	either(c("map_real_uid"), &v.MapRealUID, config.MapRealUID)
+
+	either(c("device"), &v.Device, config.Device)
+	either(c("gpu"), &v.GPU, config.GPU)
+	v.Filesystem = concat(c("filesystem"), base.Filesystem, config.Filesystem)
+	v.Link = concat(c("symlink"), base.Link, config.Link)
+
+	replace(c("etc"), &v.Etc, config.Etc)
+	either(c("auto_etc"), &v.AutoEtc, config.AutoEtc)
+	v.Cover = concat(c("cover"), base.Cover, config.Cover)
+
+	replacePtr(c("cgroup"), &v.Cgroup, config.Cgroup)
+	replacePtr(c("usernet"), &v.Usernet, config.Usernet)
+	return &v
+}
+
+// replaceFields are names of fields accepted in [Config.Replace].
+var replaceFields = []string{
+	"id", "path", "args", "enablements", "session_bus", "system_bus", "dbus_learn", "direct_wayland",
+	"username", "shell", "data", "dir", "extra_perms", "identity", "groups", "container",
+
+	"container.hostname", "container.seccomp", "container.seccomp_rules", "container.seccomp_supervise",
+	"container.devel", "container.userns", "container.net", "container.tty", "container.multiarch",
+	"container.landlock", "container.seccomp_audit", "container.env", "container.map_real_uid",
+	"container.device", "container.gpu", "container.filesystem", "container.symlink",
+	"container.etc", "container.auto_etc", "container.cover", "container.cgroup", "container.usernet",
+}
+
+// checkReplace returns an error wrapping [ErrReplaceField] if Replace names a field not accepted by [Merge].
+func (config *Config) checkReplace() error {
+	for _, name := range config.Replace {
+		if !slices.Contains(replaceFields, name) {
+			return fmt.Errorf("%w %q", ErrReplaceField, name)
+		}
+	}
+	return nil
+}
+
+// replaceSet returns a function reporting whether the field name is in names.
+func replaceSet(names []string) func(name string) bool {
+	return func(name string) bool { return slices.Contains(names, name) }
+}
+
+// replace sets v to val if r is true or val is not the zero value.
+func replace[T comparable](r bool, v *T, val T) {
+	var zero T
+	if r || val != zero {
+		*v = val
+	}
+}
+
+// replacePtr sets v to val if r is true or val is not nil.
+func replacePtr[T any](r bool, v **T, val *T) {
+	if r || val != nil {
+		*v = val
+	}
+}
+
+// either sets v to val if r is true, and to whether either of v or val is true otherwise.
+func either(r bool, v *bool, val bool) {
+	if r {
+		*v = val
+	} else {
+		*v = *v || val
+	}
+}
+
+// union sets v to val if r is true, and to the union of flags v and val otherwise.
+func union[T ~uint8 | ~uint32](r bool, v *T, val T) {
+	if r {
+		*v = val
+	} else {
+		*v |= val
+	}
+}
+
+// concat returns a new slice holding elements of a followed by elements of b, or a if both are empty.
+// If r is true, a copy of b is returned instead.
+func concat[S ~[]E, E any](r bool, a, b S) S {
+	if r {
+		return slices.Clone(b)
+	}
+	if len(a) == 0 && len(b) == 0 {
+		return a
+	}
+	return slices.Concat(a, b)
+}
@@ -0,0 +1,263 @@
+package fst_test
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/dbus"
+	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+	"git.gensokyo.uk/security/fortify/system"
+)
+
+func TestMerge(t *testing.T) {
+	testCases := []struct {
+		name   string
+		base   *fst.Config
+		config *fst.Config
+		want   *fst.Config
+	}{
+		{"zero", new(fst.Config), new(fst.Config), new(fst.Config)},
+
+		{"replace", &fst.Config{
+			ID:       "org.chromium.Chromium",
+			Path:     "/run/current-system/sw/bin/chromium",
+			Args:     []string{"chromium", "--ignore-gpu-blocklist"},
+			Username: "chronos",
+			Identity: 9,
+		}, &fst.Config{
+			Path:     "/run/current-system/sw/bin/chromium-dev",
+			Args:     []string{"chromium-dev"},
+			Identity: 10,
+		}, &fst.Config{
+			ID:       "org.chromium.Chromium",
+			Path:     "/run/current-system/sw/bin/chromium-dev",
+			Args:     []string{"chromium-dev"},
+			Username: "chronos",
+			Identity: 10,
+		}},
+
+		{"combine", &fst.Config{
+			Enablements: system.EWayland,
+			SessionBus:  &dbus.Config{Talk: []string{"org.freedesktop.Notifications"}},
+			Groups:      []string{"video"},
+			ExtraPerms:  []*fst.ExtraPermConfig{{Path: "/var/lib/fortify", Execute: true}},
+		}, &fst.Config{
+			Enablements:   system.EPulse,
+			DirectWayland: true,
+			SystemBus:     &dbus.Config{Talk: []string{"org.bluez"}},
+			Groups:        []string{"dialout"},
+			ExtraPerms:    []*fst.ExtraPermConfig{{Path: "/var/lib/fortify/u0", Read: true}},
+		}, &fst.Config{
+			Enablements:   system.EWayland | system.EPulse,
+			DirectWayland: true,
+			SessionBus:    &dbus.Config{Talk: []string{"org.freedesktop.Notifications"}},
+			SystemBus:     &dbus.Config{Talk: []string{"org.bluez"}},
+			Groups:        []string{"video", "dialout"},
+			ExtraPerms: []*fst.ExtraPermConfig{
+				{Path: "/var/lib/fortify", Execute: true},
+				{Path: "/var/lib/fortify/u0", Read: true},
+			},
+		}},
+
+		{"container base only", &fst.Config{
+			Container: &fst.ContainerConfig{Hostname: "localhost", Env: map[string]string{"LANG": "C"}},
+		}, new(fst.Config), &fst.Config{
+			Container: &fst.ContainerConfig{Hostname: "localhost", Env: map[string]string{"LANG": "C"}},
+		}},
+
+		{"container", &fst.Config{
+			Container: &fst.ContainerConfig{
+				Hostname:   "localhost",
+				Seccomp:    seccomp.FilterMultiarch,
+				Devel:      true,
+				Env:        map[string]string{"LANG": "C", "TERM": "xterm"},
+				Filesystem: []*fst.FilesystemConfig{{Src: "/nix/store", Must: true}},
+				Link:       [][2]string{{"/run/current-system/sw/bin", "/bin"}},
+				Cover:      []string{"/var/run/nscd"},
+				Cgroup:     &fst.CgroupConfig{Pids: 64},
+				Usernet:    new(fst.UsernetConfig),
+			},
+		}, &fst.Config{
+			Container: &fst.ContainerConfig{
+				Seccomp:    seccomp.FilterBluetooth,
+				Net:        true,
+				GPU:        true,
+				Env:        map[string]string{"TERM": "foot"},
+				Filesystem: []*fst.FilesystemConfig{{Src: "/dev/dri", Device: true}},
+				Cgroup:     &fst.CgroupConfig{Memory: 1 << 30},
+			},
+		}, &fst.Config{
+			Container: &fst.ContainerConfig{
+				Hostname: "localhost",
+				Seccomp:  seccomp.FilterMultiarch | seccomp.FilterBluetooth,
+				Devel:    true,
+				Net:      true,
+				GPU:      true,
+				Env:      map[string]string{"LANG": "C", "TERM": "foot"},
+				Filesystem: []*fst.FilesystemConfig{
+					{Src: "/nix/store", Must: true},
+					{Src: "/dev/dri", Device: true},
+				},
+				Link:    [][2]string{{"/run/current-system/sw/bin", "/bin"}},
+				Cover:   []string{"/var/run/nscd"},
+				Cgroup:  &fst.CgroupConfig{Memory: 1 << 30},
+				Usernet: new(fst.UsernetConfig),
+			},
+		}},
+
+		{"replace fields", &fst.Config{
+			Args:          []string{"chromium"},
+			Enablements:   system.EWayland | system.EPulse,
+			DirectWayland: true,
+			SessionBus:    &dbus.Config{Talk: []string{"org.freedesktop.Notifications"}},
+			Groups:        []string{"video"},
+			Container: &fst.ContainerConfig{
+				Hostname:   "localhost",
+				Devel:      true,
+				Net:        true,
+				Env:        map[string]string{"LANG": "C"},
+				Filesystem: []*fst.FilesystemConfig{{Src: "/nix/store", Must: true}},
+				Usernet:    new(fst.UsernetConfig),
+			},
+		}, &fst.Config{
+			Replace: []string{
+				"args", "enablements", "direct_wayland", "session_bus", "groups",
+				"container.hostname", "container.devel", "container.env", "container.filesystem", "container.usernet",
+			},
+			Enablements: system.EWayland,
+			Groups:      []string{"dialout"},
+			Container:   &fst.ContainerConfig{Userns: true},
+		}, &fst.Config{
+			Replace: []string{
+				"args", "enablements", "direct_wayland", "session_bus", "groups",
+				"container.hostname", "container.devel", "container.env", "container.filesystem", "container.usernet",
+			},
+			Enablements: system.EWayland,
+			Groups:      []string{"dialout"},
+			Container:   &fst.ContainerConfig{Userns: true, Net: true},
+		}},
+
+		{"replace container", &fst.Config{
+			Container: &fst.ContainerConfig{Devel: true},
+		}, &fst.Config{
+			Replace: []string{"container"},
+		}, &fst.Config{
+			Replace: []string{"container"},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := fst.Merge(tc.base, tc.config); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Merge:\ngot  %#v\nwant %#v", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("unmodified", func(t *testing.T) {
+		base := &fst.Config{Groups: make([]string, 1, 2), Container: &fst.ContainerConfig{Env: map[string]string{"LANG": "C"}}}
+		config := &fst.Config{Groups: []string{"video"}, Container: &fst.ContainerConfig{Env: map[string]string{"LANG": "en_US.UTF-8"}}}
+		fst.Merge(base, config)
+		if len(base.Groups) != 1 || base.Groups[:2][1] != "" {
+			t.Errorf("Merge: base groups modified: %q", base.Groups[:2])
+		}
+		if base.Container.Env["LANG"] != "C" {
+			t.Errorf("Merge: base env modified: %v", base.Container.Env)
+		}
+	})
+}
+
+func TestResolveExtends(t *testing.T) {
+	d := t.TempDir()
+	for name, data := range map[string]string{
+		"base.json":        `{"enablements": 1, "username": "chronos", "groups": ["video"]}`,
+		"common/gpu.json":  `{"extends": ["../base.json"], "container": {"filesystem": [{"src": "/dev/dri", "dev": true}]}}`,
+		"common/self.json": `{"extends": ["self.json"]}`,
+		"loop/a.json":      `{"extends": ["b.json"]}`,
+		"loop/b.json":      `{"extends": ["a.json"]}`,
+		"invalid.json":     `{"extends": "base.json"}`,
+		"unknown.json":     `{"replace": ["nonexistent"]}`,
+	} {
+		if err := os.MkdirAll(path.Dir(path.Join(d, name)), 0700); err != nil {
+			t.Fatalf("MkdirAll: error = %v", err)
+		}
+		if err := os.WriteFile(path.Join(d, name), []byte(data), 0600); err != nil {
+			t.Fatalf("WriteFile: error = %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name    string
+		path    string
+		config  *fst.Config
+		want    *fst.Config
+		wantErr error
+	}{
+		{"none", path.Join(d, "app.json"), &fst.Config{ID: "org.chromium.Chromium"}, &fst.Config{ID: "org.chromium.Chromium"}, nil},
+
+		{"nested", path.Join(d, "app.json"), &fst.Config{
+			Extends:  []string{"common/gpu.json"},
+			Username: "fortify",
+			Groups:   []string{"dialout"},
+		}, &fst.Config{
+			Enablements: system.EWayland,
+			Username:    "fortify",
+			Groups:      []string{"video", "dialout"},
+			Container: &fst.ContainerConfig{
+				Filesystem: []*fst.FilesystemConfig{{Src: "/dev/dri", Device: true}},
+			},
+		}, nil},
+
+		{"absolute", "", &fst.Config{Extends: []string{path.Join(d, "base.json")}}, &fst.Config{
+			Enablements: system.EWayland,
+			Username:    "chronos",
+			Groups:      []string{"video"},
+		}, nil},
+
+		{"override", path.Join(d, "app.json"), &fst.Config{
+			Extends:     []string{"common/gpu.json"},
+			Replace:     []string{"enablements", "groups", "container.filesystem"},
+			Enablements: system.EPulse,
+		}, &fst.Config{
+			Enablements: system.EPulse,
+			Username:    "chronos",
+			Container:   new(fst.ContainerConfig),
+		}, nil},
+
+		{"self", path.Join(d, "app.json"), &fst.Config{Extends: []string{"common/self.json"}}, nil, fst.ErrExtendsCycle},
+		{"loop", path.Join(d, "loop/a.json"), &fst.Config{Extends: []string{"b.json"}}, nil, fst.ErrExtendsCycle},
+		{"missing", path.Join(d, "app.json"), &fst.Config{Extends: []string{"nonexistent.json"}}, nil, fs.ErrNotExist},
+		{"replace unknown", path.Join(d, "app.json"), &fst.Config{Replace: []string{"container.path"}}, nil, fst.ErrReplaceField},
+		{"replace unknown base", path.Join(d, "app.json"), &fst.Config{Extends: []string{"unknown.json"}}, nil, fst.ErrReplaceField},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.config.ResolveExtends(tc.path)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("ResolveExtends: error = %v, want %v", err, tc.wantErr)
+			}
+			if tc.wantErr != nil {
+				return
+			}
+			if !reflect.DeepEqual(tc.config, tc.want) {
+				t.Errorf("ResolveExtends:\ngot  %#v\nwant %#v", tc.config, tc.want)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		config := &fst.Config{Extends: []string{"invalid.json"}}
+		var e *fst.ExtendsError
+		if err := config.ResolveExtends(path.Join(d, "app.json")); !errors.As(err, &e) {
+			t.Fatalf("ResolveExtends: error = %v", err)
+		} else if e.Name != path.Join(d, "invalid.json") {
+			t.Errorf("ResolveExtends: Name = %q", e.Name)
+		}
+	})
+}
@@ -0,0 +1,310 @@
+// Package flatpak converts flatpak application metadata to fortify app configuration.
+package flatpak
+
+import (
+	"errors"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"git.gensokyo.uk/security/fortify/dbus"
+	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/system"
+)
+
+// Key file groups of flatpak metadata.
+const (
+	GroupApplication = "Application"
+	GroupContext     = "Context"
+	GroupSessionBus  = "Session Bus Policy"
+	GroupSystemBus   = "System Bus Policy"
+	GroupEnvironment = "Environment"
+)
+
+// applicationPathBase is the directory flatpak resolves relative application commands against.
+const applicationPathBase = "/app/bin"
+
+// ErrNotApplication is returned by [Convert] for metadata not describing an application, such as that of a runtime.
+var ErrNotApplication = errors.New("metadata does not describe an application")
+
+// Unsupported describes a flatpak permission with no equivalent in [fst.Config].
+type Unsupported struct {
+	Group string `json:"group"`
+	Key   string `json:"key,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+func (u *Unsupported) String() string {
+	s := "[" + u.Group + "]"
+	if u.Key != "" {
+		s += " " + u.Key
+		if u.Value != "" {
+			s += "=" + u.Value
+		}
+	}
+	return s
+}
+
+// xdgDirs maps flatpak filesystem tokens to paths relative to the home directory.
+var xdgDirs = map[string]string{
+	"xdg-desktop":      "Desktop",
+	"xdg-documents":    "Documents",
+	"xdg-download":     "Downloads",
+	"xdg-music":        "Music",
+	"xdg-pictures":     "Pictures",
+	"xdg-public-share": "Public",
+	"xdg-templates":    "Templates",
+	"xdg-videos":       "Videos",
+	"xdg-config":       ".config",
+	"xdg-cache":        ".cache",
+	"xdg-data":         ".local/share",
+}
+
+// deviceNodes maps flatpak devices to device nodes exposed to the container.
+var deviceNodes = map[string]string{
+	"kvm":   "/dev/kvm",
+	"input": "/dev/input",
+	"usb":   "/dev/bus/usb",
+}
+
+// Convert returns an app configuration granting the permissions requested by flatpak metadata f,
+// and the permissions it was unable to grant.
+//
+// The session bus is always proxied with built-in defaults in addition to the session bus policy, matching flatpak.
+// Home directory paths and XDG user directories are expressed relative to [fst.VarHome] and xdg-run to
+// [fst.VarRuntime]. The flatpak runtime and application files are not made available, the program path
+// assumes application files are mounted on /app. Negated permissions are omitted since nothing is granted by default.
+// Deployment specific fields such as Identity and Data are left for the caller to populate.
+func Convert(f KeyFile) (*fst.Config, []*Unsupported, error) {
+	id := f.String(GroupApplication, "name")
+	if id == "" {
+		return nil, nil, ErrNotApplication
+	}
+
+	config := &fst.Config{
+		ID:          id,
+		Enablements: system.EDBus,
+		SessionBus:  dbus.NewConfig(id, true, false),
+		Container: &fst.ContainerConfig{
+			Env:     make(map[string]string),
+			AutoEtc: true,
+		},
+	}
+	c := &converter{f: f, config: config}
+
+	if command := f.String(GroupApplication, "command"); command != "" {
+		config.Args = []string{command}
+		if path.IsAbs(command) {
+			config.Path = command
+		} else {
+			config.Path = path.Join(applicationPathBase, command)
+		}
+	}
+	if runtime := f.String(GroupApplication, "runtime"); runtime != "" {
+		c.unsupported(GroupApplication, "runtime", runtime)
+	}
+
+	for _, group := range slices.Sorted(maps.Keys(f)) {
+		switch group {
+		case GroupApplication:
+		case GroupContext:
+			c.context()
+		case GroupSessionBus:
+			c.policy(group, config.SessionBus)
+		case GroupSystemBus:
+			if config.SystemBus == nil {
+				config.SystemBus = dbus.NewConfig("", false, false)
+			}
+			c.policy(group, config.SystemBus)
+		case GroupEnvironment:
+			for key := range f[group] {
+				config.Container.Env[key] = f.String(group, key)
+			}
+		default:
+			c.unsupported(group, "", "")
+		}
+	}
+
+	// unfiltered buses are granted by sockets in the context group
+	for _, s := range f.List(GroupContext, "sockets") {
+		switch s {
+		case "session-bus":
+			config.SessionBus = &dbus.Config{Filter: false}
+		case "system-bus":
+			config.SystemBus = &dbus.Config{Filter: false}
+		}
+	}
+
+	return config, c.u, nil
+}
+
+type converter struct {
+	f      KeyFile
+	config *fst.Config
+	u      []*Unsupported
+}
+
+func (c *converter) unsupported(group, key, value string) {
+	c.u = append(c.u, &Unsupported{group, key, value})
+}
+
+// list calls f for every element of key in the context group not negated.
+func (c *converter) list(key string, f func(v string) bool) {
+	for _, v := range c.f.List(GroupContext, key) {
+		if strings.HasPrefix(v, "!") {
+			continue
+		}
+		if !f(v) {
+			c.unsupported(GroupContext, key, v)
+		}
+	}
+}
+
+func (c *converter) context() {
+	container := c.config.Container
+
+	for _, key := range slices.Sorted(maps.Keys(c.f[GroupContext])) {
+		switch key {
+		case "shared":
+			c.list(key, func(v string) bool {
+				if v == "network" {
+					container.Net = true
+					return true
+				}
+				return false
+			})
+
+		case "sockets":
+			sockets := c.f.List(GroupContext, key)
+			c.list(key, func(v string) bool {
+				switch v {
+				case "wayland":
+					c.config.Enablements |= system.EWayland
+				case "x11":
+					c.config.Enablements |= system.EX11
+				case "fallback-x11":
+					// only used by flatpak in the absence of a wayland session
+					if !slices.Contains(sockets, "wayland") {
+						c.config.Enablements |= system.EX11
+					}
+				case "pulseaudio":
+					c.config.Enablements |= system.EPulse
+				case "session-bus", "system-bus":
+					// handled after bus policies
+				default:
+					return false
+				}
+				return true
+			})
+
+		case "devices":
+			c.list(key, func(v string) bool {
+				switch v {
+				case "dri":
+					container.GPU = true
+				case "all":
+					container.Device = true
+				default:
+					if p, ok := deviceNodes[v]; ok {
+						container.Filesystem = append(container.Filesystem, &fst.FilesystemConfig{Src: p, Device: true})
+						return true
+					}
+					return false
+				}
+				return true
+			})
+
+		case "features":
+			c.list(key, func(v string) bool {
+				switch v {
+				case "devel":
+					container.Devel = true
+				case "multiarch":
+					container.Multiarch = true
+				default:
+					return false
+				}
+				return true
+			})
+
+		case "filesystems":
+			c.list(key, func(v string) bool {
+				if fs := filesystem(v); fs != nil {
+					container.Filesystem = append(container.Filesystem, fs)
+					return true
+				}
+				return false
+			})
+
+		case "persistent":
+			// the home directory of the container is always persistent
+
+		default:
+			c.unsupported(GroupContext, key, c.f.String(GroupContext, key))
+		}
+	}
+}
+
+// policy appends names of a bus policy group not already present to the corresponding lists of bus.
+func (c *converter) policy(group string, bus *dbus.Config) {
+	add := func(s *[]string, name string) {
+		if !slices.Contains(*s, name) {
+			*s = append(*s, name)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.f[group])) {
+		switch p := c.f.String(group, name); p {
+		case "none":
+		case "see":
+			add(&bus.See, name)
+		case "talk":
+			add(&bus.Talk, name)
+		case "own":
+			add(&bus.Own, name)
+		default:
+			c.unsupported(group, name, p)
+		}
+	}
+}
+
+// filesystem returns the filesystem entry corresponding to a flatpak filesystem token,
+// or nil if it has no equivalent.
+func filesystem(v string) *fst.FilesystemConfig {
+	var write bool
+	if i := strings.LastIndexByte(v, ':'); i != -1 {
+		switch v[i+1:] {
+		case "ro":
+		case "rw", "create":
+			write = true
+		default:
+			return nil
+		}
+		v = v[:i]
+	}
+
+	name, sub, _ := strings.Cut(v, "/")
+	var base string
+	switch {
+	case name == "" && sub != "":
+		return &fst.FilesystemConfig{Src: path.Clean(escape(v)), Write: write}
+	case name == "home" || name == "~":
+		base = "${" + fst.VarHome + "}"
+	case name == "xdg-run":
+		base = "${" + fst.VarRuntime + "}"
+	default:
+		if p, ok := xdgDirs[name]; ok {
+			base = path.Join("${"+fst.VarHome+"}", p)
+		} else {
+			return nil
+		}
+	}
+	if name == "xdg-run" && sub == "" {
+		// flatpak requires a subdirectory of the runtime directory
+		return nil
+	}
+	return &fst.FilesystemConfig{Src: path.Join(base, escape(sub)), Write: write}
+}
+
+// escape escapes s for use as a value subject to [fst.Config.Expand].
+func escape(s string) string { return strings.ReplaceAll(s, "$", "$$") }
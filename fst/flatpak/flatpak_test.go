@@ -0,0 +1,182 @@
+package flatpak_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/dbus"
+	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/fst/flatpak"
+	"git.gensokyo.uk/security/fortify/system"
+)
+
+func TestConvert(t *testing.T) {
+	testCases := []struct {
+		name     string
+		metadata string
+		want     *fst.Config
+		wantU    []*flatpak.Unsupported
+		wantErr  error
+	}{
+		{"full", `
+[Application]
+name=org.example.App
+runtime=org.freedesktop.Platform/x86_64/24.08
+sdk=org.freedesktop.Sdk/x86_64/24.08
+command=example
+
+[Context]
+shared=network;ipc;
+sockets=wayland;fallback-x11;pulseaudio;ssh-auth;!x11;
+devices=dri;kvm;shm;
+features=devel;bluetooth;
+filesystems=xdg-download;~/.example:create;/opt/$lib:ro;xdg-run/pipewire-0;host;!home;/srv:bad
+persistent=.example
+unset-environment=LD_PRELOAD;
+
+[Session Bus Policy]
+org.freedesktop.Notifications=talk
+org.mpris.MediaPlayer2.example=own
+org.freedesktop.portal.*=see
+org.freedesktop.Flatpak=none
+org.example.Broken=admin
+
+[System Bus Policy]
+org.freedesktop.UPower=talk
+
+[Environment]
+EXAMPLE_MODE=sandboxed\sfast
+
+[Extension org.example.App.Plugin]
+directory=plugins
+`, &fst.Config{
+			ID:          "org.example.App",
+			Path:        "/app/bin/example",
+			Args:        []string{"example"},
+			Enablements: system.EWayland | system.EDBus | system.EPulse,
+			SessionBus: &dbus.Config{
+				See:       []string{"org.freedesktop.portal.*"},
+				Talk:      []string{"org.freedesktop.DBus", "org.freedesktop.Notifications"},
+				Own:       []string{"org.example.App.*", "org.mpris.MediaPlayer2.example"},
+				Call:      map[string]string{"org.freedesktop.portal.*": "*"},
+				Broadcast: map[string]string{"org.freedesktop.portal.*": "@/org/freedesktop/portal/*"},
+				Filter:    true,
+			},
+			SystemBus: &dbus.Config{
+				Talk:      []string{"org.freedesktop.UPower"},
+				Call:      map[string]string{},
+				Broadcast: map[string]string{},
+				Filter:    true,
+			},
+			Container: &fst.ContainerConfig{
+				Net:   true,
+				Devel: true,
+				GPU:   true,
+				Env:   map[string]string{"EXAMPLE_MODE": "sandboxed fast"},
+				Filesystem: []*fst.FilesystemConfig{
+					{Src: "/dev/kvm", Device: true},
+					{Src: "${home}/Downloads"},
+					{Src: "${home}/.example", Write: true},
+					{Src: "/opt/$$lib"},
+					{Src: "${runtime}/pipewire-0"},
+				},
+				AutoEtc: true,
+			},
+		}, []*flatpak.Unsupported{
+			{"Application", "runtime", "org.freedesktop.Platform/x86_64/24.08"},
+			{"Context", "devices", "shm"},
+			{"Context", "features", "bluetooth"},
+			{"Context", "filesystems", "host"},
+			{"Context", "filesystems", "/srv:bad"},
+			{"Context", "shared", "ipc"},
+			{"Context", "sockets", "ssh-auth"},
+			{"Context", "unset-environment", "LD_PRELOAD;"},
+			{"Extension org.example.App.Plugin", "", ""},
+			{"Session Bus Policy", "org.example.Broken", "admin"},
+		}, nil},
+
+		{"unfiltered", `
+[Application]
+name=org.example.Unfiltered
+command=/usr/bin/unfiltered
+
+[Context]
+sockets=x11;session-bus;system-bus;
+devices=all;
+features=multiarch;
+
+[Session Bus Policy]
+org.freedesktop.Notifications=talk
+`, &fst.Config{
+			ID:          "org.example.Unfiltered",
+			Path:        "/usr/bin/unfiltered",
+			Args:        []string{"/usr/bin/unfiltered"},
+			Enablements: system.EX11 | system.EDBus,
+			SessionBus:  &dbus.Config{},
+			SystemBus:   &dbus.Config{},
+			Container: &fst.ContainerConfig{
+				Multiarch: true,
+				Device:    true,
+				Env:       map[string]string{},
+				AutoEtc:   true,
+			},
+		}, nil, nil},
+
+		{"fallback x11", `
+[Application]
+name=org.example.X11
+
+[Context]
+sockets=fallback-x11;
+`, &fst.Config{
+			ID:          "org.example.X11",
+			Enablements: system.EX11 | system.EDBus,
+			SessionBus:  dbus.NewConfig("org.example.X11", true, false),
+			Container: &fst.ContainerConfig{
+				Env:     map[string]string{},
+				AutoEtc: true,
+			},
+		}, nil, nil},
+
+		{"runtime", `
+[Runtime]
+name=org.freedesktop.Platform
+`, nil, nil, flatpak.ErrNotApplication},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f, err := flatpak.ParseKeyFile(strings.NewReader(tc.metadata))
+			if err != nil {
+				t.Fatalf("ParseKeyFile: error = %v", err)
+			}
+			got, u, err := flatpak.Convert(f)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Convert: error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Convert: %#v, want %#v", got, tc.want)
+			}
+			if !reflect.DeepEqual(u, tc.wantU) {
+				t.Errorf("Convert: unsupported %s, want %s", u, tc.wantU)
+			}
+		})
+	}
+}
+
+func TestUnsupportedString(t *testing.T) {
+	testCases := []struct {
+		u    *flatpak.Unsupported
+		want string
+	}{
+		{&flatpak.Unsupported{Group: "Extension org.example.App.Plugin"}, "[Extension org.example.App.Plugin]"},
+		{&flatpak.Unsupported{Group: "Context", Key: "shared", Value: "ipc"}, "[Context] shared=ipc"},
+		{&flatpak.Unsupported{Group: "Context", Key: "unset-environment"}, "[Context] unset-environment"},
+	}
+	for _, tc := range testCases {
+		if got := tc.u.String(); got != tc.want {
+			t.Errorf("String: %q, want %q", got, tc.want)
+		}
+	}
+}
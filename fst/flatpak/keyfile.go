@@ -0,0 +1,118 @@
+package flatpak
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrKeyFile is returned by [ParseKeyFile] for malformed input.
+var ErrKeyFile = errors.New("malformed key file")
+
+// KeyFile holds values of a GLib key file such as flatpak metadata, indexed by group and key.
+// Localised keys are not retained.
+type KeyFile map[string]map[string]string
+
+// ParseKeyFile parses a GLib key file from r.
+func ParseKeyFile(r io.Reader) (KeyFile, error) {
+	f := make(KeyFile)
+	var group map[string]string
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if line[len(line)-1] != ']' || len(line) < 3 {
+				return nil, fmt.Errorf("%w: line %d: bad group header %q", ErrKeyFile, n, line)
+			}
+			name := line[1 : len(line)-1]
+			if _, ok := f[name]; ok {
+				return nil, fmt.Errorf("%w: line %d: duplicate group %q", ErrKeyFile, n, name)
+			}
+			group = make(map[string]string)
+			f[name] = group
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%w: line %d: expected key=value", ErrKeyFile, n)
+		}
+		if group == nil {
+			return nil, fmt.Errorf("%w: line %d: key outside of a group", ErrKeyFile, n)
+		}
+		key = strings.TrimSpace(key)
+		if key == "" {
+			return nil, fmt.Errorf("%w: line %d: empty key", ErrKeyFile, n)
+		}
+		if strings.ContainsRune(key, '[') {
+			// localised value
+			continue
+		}
+		group[key] = strings.TrimSpace(value)
+	}
+	return f, s.Err()
+}
+
+// String returns the value of key in group with escape sequences resolved.
+func (f KeyFile) String(group, key string) string { return unescape(f[group][key]) }
+
+// List returns the elements of the semicolon separated list held by key in group.
+// Empty elements are omitted.
+func (f KeyFile) List(group, key string) (v []string) {
+	raw := f[group][key]
+	start := 0
+	for i := 0; i < len(raw); i++ {
+		switch raw[i] {
+		case '\\':
+			i++
+		case ';':
+			if e := unescape(raw[start:i]); e != "" {
+				v = append(v, e)
+			}
+			start = i + 1
+		}
+	}
+	if start < len(raw) {
+		if e := unescape(raw[start:]); e != "" {
+			v = append(v, e)
+		}
+	}
+	return
+}
+
+// unescape resolves the escape sequences of a key file value.
+func unescape(s string) string {
+	if !strings.ContainsRune(s, '\\') {
+		return s
+	}
+
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 's':
+			b.WriteByte(' ')
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
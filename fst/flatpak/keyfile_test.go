@@ -0,0 +1,69 @@
+package flatpak_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/fst/flatpak"
+)
+
+func TestParseKeyFile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		data    string
+		want    flatpak.KeyFile
+		wantErr error
+	}{
+		{"metadata", `
+# comment
+[Application]
+name=org.example.App
+name[de]=Beispiel
+command = example
+
+[Context]
+sockets=wayland;pulseaudio;
+`, flatpak.KeyFile{
+			"Application": {"name": "org.example.App", "command": "example"},
+			"Context":     {"sockets": "wayland;pulseaudio;"},
+		}, nil},
+		{"empty group", "[Environment]\n", flatpak.KeyFile{"Environment": {}}, nil},
+
+		{"key outside group", "name=org.example.App\n", nil, flatpak.ErrKeyFile},
+		{"bad header", "[Application\n", nil, flatpak.ErrKeyFile},
+		{"empty header", "[]\n", nil, flatpak.ErrKeyFile},
+		{"duplicate group", "[Context]\n[Context]\n", nil, flatpak.ErrKeyFile},
+		{"missing value", "[Context]\nsockets\n", nil, flatpak.ErrKeyFile},
+		{"empty key", "[Context]\n=wayland\n", nil, flatpak.ErrKeyFile},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := flatpak.ParseKeyFile(strings.NewReader(tc.data))
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("ParseKeyFile: error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParseKeyFile: %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestKeyFileValues(t *testing.T) {
+	f := flatpak.KeyFile{"Context": {
+		"filesystems": `~/My\sFiles:ro;/opt/a\;b;;xdg-download`,
+		"value":       `line\none\ttab\\`,
+	}}
+
+	if got, want := f.List("Context", "filesystems"), []string{"~/My Files:ro", "/opt/a;b", "xdg-download"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List: %q, want %q", got, want)
+	}
+	if got := f.List("Context", "sockets"); got != nil {
+		t.Errorf("List: %q, want nil", got)
+	}
+	if got, want := f.String("Context", "value"), "line\none\ttab\\"; got != want {
+		t.Errorf("String: %q, want %q", got, want)
+	}
+}
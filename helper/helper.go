@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"syscall"
 	"time"
 
 	"git.gensokyo.uk/security/fortify/helper/proc"
@@ -20,6 +21,26 @@ const (
 	FortifyStatus = "FORTIFY_STATUS"
 )
 
+// WaitClose blocks until the read end of the status pipe at fd is closed by the parent.
+// It is called by the helper process.
+func WaitClose(fd int) error {
+	epoll, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = syscall.Close(epoll) }()
+
+	if err = syscall.EpollCtl(epoll, syscall.EPOLL_CTL_ADD, fd, &syscall.EpollEvent{}); err != nil {
+		return err
+	}
+	events := make([]syscall.EpollEvent, 1)
+	for {
+		if _, err = syscall.EpollWait(epoll, events, -1); err != syscall.EINTR {
+			return err
+		}
+	}
+}
+
 type Helper interface {
 	// Start starts the helper process.
 	Start() error
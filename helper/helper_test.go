@@ -135,3 +135,32 @@ func testHelper(t *testing.T, createHelper func(ctx context.Context, setOutput f
 func trimStdout(stdout fmt.Stringer) string {
 	return strings.TrimPrefix(stdout.String(), "=== RUN   TestHelperInit\n")
 }
+
+func TestWaitClose(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: error = %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	done := make(chan error, 1)
+	go func() { done <- helper.WaitClose(int(w.Fd())) }()
+
+	select {
+	case err = <-done:
+		t.Fatalf("WaitClose: returned early, error = %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err = r.Close(); err != nil {
+		t.Fatalf("Close: error = %v", err)
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("WaitClose: error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("WaitClose: did not return after the read end is closed")
+	}
+}
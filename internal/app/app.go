@@ -22,6 +22,38 @@ type App interface {
 type SealedApp interface {
 	// Run commits sealed system setup and starts the app process.
 	Run(rs *RunState) error
+	// Plan describes the setup performed by Run without committing any of it.
+	Plan() *Plan
+}
+
+// Plan describes the host and container setup of a [SealedApp].
+type Plan struct {
+	// instance identifier
+	ID string `json:"instance"`
+	// target uid the container runs as
+	Uid int `json:"uid"`
+	// absolute path of the initial process in the container
+	Path string `json:"path"`
+	// initial process argv
+	Args []string `json:"args"`
+	// initial process environment
+	Env []string `json:"env"`
+
+	// host system operations in the order they are committed
+	System []*PlanOp `json:"system"`
+	// container setup operations in the order they are applied by init
+	Container []string `json:"container"`
+}
+
+// PlanOp describes a host system operation held by a [Plan].
+type PlanOp struct {
+	// kind of the operation
+	Op string `json:"op"`
+	// when the operation is reverted
+	Type string `json:"type"`
+	// host path the operation acts on
+	Path  string `json:"path"`
+	Value string `json:"value"`
 }
 
 // RunState stores the outcome of a call to [SealedApp.Run].
@@ -0,0 +1,56 @@
+package app
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+// AuditRecord describes a syscall denied by the syscall filter of an instance in audit mode.
+type AuditRecord struct {
+	// time of the first occurrence
+	Time time.Time `json:"time"`
+	// pid of the calling process in the pid namespace of the supervisor
+	Pid uint32 `json:"pid"`
+	// audit architecture name
+	Arch string `json:"arch"`
+	// resolved syscall name
+	Syscall string `json:"syscall"`
+	// syscall number
+	Nr int32 `json:"nr"`
+	// raw syscall arguments
+	Args [6]uint64 `json:"args"`
+}
+
+// AuditPath returns the path to the audit report of instance id.
+func AuditPath(runDirPath string, id *ID) string {
+	return path.Join(runDirPath, "audit", id.String())
+}
+
+// LoadAudit reads an audit report written as JSON lines from r.
+func LoadAudit(r io.Reader) ([]*AuditRecord, error) {
+	records := make([]*AuditRecord, 0)
+	d := json.NewDecoder(bufio.NewReader(r))
+	for {
+		record := new(AuditRecord)
+		if err := d.Decode(record); err != nil {
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+// OpenAudit opens the audit report of instance id for appending, creating it if it does not exist.
+func OpenAudit(runDirPath string, id *ID) (*os.File, error) {
+	if err := os.MkdirAll(path.Join(runDirPath, "audit"), 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(AuditPath(runDirPath, id), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+}
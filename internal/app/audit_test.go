@@ -0,0 +1,42 @@
+package app_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "git.gensokyo.uk/security/fortify/internal/app"
+)
+
+func TestLoadAudit(t *testing.T) {
+	testCases := []struct {
+		name    string
+		data    string
+		want    []*AuditRecord
+		wantErr bool
+	}{
+		{"empty", "", []*AuditRecord{}, false},
+		{"records", `{"time":"1970-01-01T00:00:00.000000009Z","pid":2,"arch":"x86_64","syscall":"syslog","nr":103,"args":[10,0,0,0,0,0]}
+{"time":"1970-01-01T01:02:32Z","pid":3,"arch":"x86","syscall":"ptrace","nr":26,"args":[16,254,0,0,0,0]}
+`, []*AuditRecord{
+			{Time: time.Unix(0, 9).UTC(), Pid: 2, Arch: "x86_64", Syscall: "syslog", Nr: 103, Args: [6]uint64{10}},
+			{Time: time.Unix(3752, 0).UTC(), Pid: 3, Arch: "x86", Syscall: "ptrace", Nr: 26, Args: [6]uint64{16, 254}},
+		}, false},
+		{"truncated", `{"time":"1970-01-01T00:00:00.000000009Z","pid":2,"arch":"x86_64","syscall":"syslog","nr":103,"args":[10,0,0,0,0,0]}
+{"time":"1970-01-01T01:02:32Z","pid":3,`, []*AuditRecord{
+			{Time: time.Unix(0, 9).UTC(), Pid: 2, Arch: "x86_64", Syscall: "syslog", Nr: 103, Args: [6]uint64{10}},
+		}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := LoadAudit(strings.NewReader(tc.data))
+			if (err != nil) != tc.wantErr {
+				t.Errorf("LoadAudit: error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("LoadAudit: %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
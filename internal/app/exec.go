@@ -0,0 +1,35 @@
+package app
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path"
+	"syscall"
+)
+
+// ExecPath returns the path to the exec socket of instance id.
+// Each connection to the socket starts a process in the container of the instance via [sandbox.Exec].
+func ExecPath(runDirPath string, id *ID) string {
+	return path.Join(runDirPath, "exec", id.String())
+}
+
+// ListenExec creates the exec socket of instance id. The socket is removed when the listener is closed.
+func ListenExec(runDirPath string, id *ID) (*net.UnixListener, error) {
+	if err := os.MkdirAll(path.Join(runDirPath, "exec"), 0700); err != nil {
+		return nil, err
+	}
+	return net.ListenUnix("unix", &net.UnixAddr{Name: ExecPath(runDirPath, id), Net: "unix"})
+}
+
+// DialExec connects to the exec socket of instance id.
+func DialExec(runDirPath string, id *ID) (*net.UnixConn, error) {
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: ExecPath(runDirPath, id), Net: "unix"})
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ECONNREFUSED) {
+			return nil, ErrNotRunning
+		}
+		return nil, err
+	}
+	return conn, nil
+}
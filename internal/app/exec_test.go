@@ -0,0 +1,42 @@
+package app_test
+
+import (
+	"errors"
+	"testing"
+
+	. "git.gensokyo.uk/security/fortify/internal/app"
+)
+
+func TestDialExec(t *testing.T) {
+	runDirPath := t.TempDir()
+	id := new(ID)
+	if err := NewAppID(id); err != nil {
+		t.Fatalf("NewAppID: error = %v", err)
+	}
+
+	if _, err := DialExec(runDirPath, id); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("DialExec: error = %v, want %v", err, ErrNotRunning)
+	}
+
+	l, err := ListenExec(runDirPath, id)
+	if err != nil {
+		t.Fatalf("ListenExec: error = %v", err)
+	}
+	conn, err := DialExec(runDirPath, id)
+	if err != nil {
+		t.Fatalf("DialExec: error = %v", err)
+	}
+	if accepted, err := l.AcceptUnix(); err != nil {
+		t.Errorf("AcceptUnix: error = %v", err)
+	} else {
+		_ = accepted.Close()
+	}
+	_ = conn.Close()
+
+	if err = l.Close(); err != nil {
+		t.Fatalf("Close: error = %v", err)
+	}
+	if _, err = DialExec(runDirPath, id); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("DialExec: error = %v, want %v", err, ErrNotRunning)
+	}
+}
@@ -0,0 +1,63 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+
+	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/internal/fmsg"
+)
+
+const cgroupMount = "/sys/fs/cgroup"
+
+var ErrCgroup = errors.New("invalid cgroup configuration")
+
+// CgroupPath returns the host path of the cgroup of instance id.
+//
+// The parent cgroup must be delegated explicitly: the cgroup of the current process already holds processes
+// and cannot have controllers enabled for its children under the no internal processes rule of cgroup v2.
+func CgroupPath(c *fst.CgroupConfig, id string) (string, error) {
+	if c.Parent == "" {
+		return "", fmsg.WrapError(ErrCgroup,
+			"resource limits require a delegated parent cgroup")
+	}
+	if !path.IsAbs(c.Parent) || path.Clean(c.Parent) != c.Parent || c.Parent == "/" {
+		return "", fmsg.WrapError(ErrCgroup,
+			fmt.Sprintf("invalid parent cgroup %q", c.Parent))
+	}
+	return path.Join(cgroupMount, c.Parent, "fortify."+id), nil
+}
+
+// CgroupFiles returns interface files and their values for limits described by c.
+func CgroupFiles(c *fst.CgroupConfig) ([][2]string, error) {
+	files := make([][2]string, 0, 4)
+
+	if c.Memory < 0 {
+		return nil, fmsg.WrapError(ErrCgroup,
+			fmt.Sprintf("memory limit %d out of range", c.Memory))
+	} else if c.Memory > 0 {
+		files = append(files, [2]string{"memory.max", strconv.FormatInt(c.Memory, 10)})
+	}
+	if c.Pids < 0 {
+		return nil, fmsg.WrapError(ErrCgroup,
+			fmt.Sprintf("pids limit %d out of range", c.Pids))
+	} else if c.Pids > 0 {
+		files = append(files, [2]string{"pids.max", strconv.Itoa(c.Pids)})
+	}
+	if c.CPUWeight < 0 || c.CPUWeight > 10000 {
+		return nil, fmsg.WrapError(ErrCgroup,
+			fmt.Sprintf("cpu weight %d out of range", c.CPUWeight))
+	} else if c.CPUWeight > 0 {
+		files = append(files, [2]string{"cpu.weight", strconv.Itoa(c.CPUWeight)})
+	}
+	if c.IOWeight < 0 || c.IOWeight > 10000 {
+		return nil, fmsg.WrapError(ErrCgroup,
+			fmt.Sprintf("io weight %d out of range", c.IOWeight))
+	} else if c.IOWeight > 0 {
+		files = append(files, [2]string{"io.weight", "default " + strconv.Itoa(c.IOWeight)})
+	}
+
+	return files, nil
+}
@@ -0,0 +1,75 @@
+package common
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/fst"
+)
+
+func TestCgroupPath(t *testing.T) {
+	const id = "ec07546a772a07cde87389afc84ffd13"
+	testCases := []struct {
+		name    string
+		parent  string
+		want    string
+		wantErr bool
+	}{
+		{"delegated", "/user.slice/user-1000.slice/user@1000.service/fortify.slice",
+			"/sys/fs/cgroup/user.slice/user-1000.slice/user@1000.service/fortify.slice/fortify." + id, false},
+		{"unset", "", "", true},
+		{"root", "/", "", true},
+		{"relative", "fortify.slice", "", true},
+		{"unclean", "/fortify.slice/../system.slice", "", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := CgroupPath(&fst.CgroupConfig{Parent: tc.parent}, id)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("CgroupPath: error = %v, wantErr %v", err, tc.wantErr)
+			} else if err != nil && !errors.Is(err, ErrCgroup) {
+				t.Fatalf("CgroupPath: error = %v, want %v", err, ErrCgroup)
+			}
+			if got != tc.want {
+				t.Errorf("CgroupPath: %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCgroupFiles(t *testing.T) {
+	testCases := []struct {
+		name    string
+		c       *fst.CgroupConfig
+		want    [][2]string
+		wantErr bool
+	}{
+		{"none", new(fst.CgroupConfig), [][2]string{}, false},
+		{"all", &fst.CgroupConfig{Memory: 1 << 30, Pids: 512, CPUWeight: 50, IOWeight: 200}, [][2]string{
+			{"memory.max", "1073741824"},
+			{"pids.max", "512"},
+			{"cpu.weight", "50"},
+			{"io.weight", "default 200"},
+		}, false},
+		{"pids", &fst.CgroupConfig{Pids: 64}, [][2]string{{"pids.max", "64"}}, false},
+
+		{"memory range", &fst.CgroupConfig{Memory: -1}, nil, true},
+		{"pids range", &fst.CgroupConfig{Pids: -1}, nil, true},
+		{"cpu weight range", &fst.CgroupConfig{CPUWeight: 10001}, nil, true},
+		{"io weight range", &fst.CgroupConfig{IOWeight: -1}, nil, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := CgroupFiles(tc.c)
+			if tc.wantErr != (err != nil) {
+				t.Fatalf("CgroupFiles: error = %v, wantErr %v", err, tc.wantErr)
+			} else if err != nil && !errors.Is(err, ErrCgroup) {
+				t.Fatalf("CgroupFiles: error = %v, want %v", err, ErrCgroup)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("CgroupFiles: %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+
+	"git.gensokyo.uk/security/fortify/dbus"
+	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/internal/fmsg"
+	"git.gensokyo.uk/security/fortify/internal/sys"
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+	"git.gensokyo.uk/security/fortify/system"
+)
+
+// Severity is the severity of a [Diagnostic].
+type Severity string
+
+const (
+	// SeverityError is reported for problems causing seal to fail.
+	SeverityError Severity = "error"
+	// SeverityWarning is reported for settings weakening the sandbox or having no effect.
+	SeverityWarning Severity = "warning"
+)
+
+// Diagnostic describes a problem found in an [fst.Config] by [CheckConfig].
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	// JSON path of the offending field
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s", d.Severity, d.Field, d.Message)
+}
+
+// CheckConfig statically validates config and returns diagnostics in field order.
+// Unlike seal, CheckConfig does not stop at the first error and does not modify config.
+// Variables only known during seal are left unexpanded and paths referencing them are not accessed.
+func CheckConfig(config *fst.Config, os sys.State) []*Diagnostic {
+	c := &checker{os: os}
+	if config == nil {
+		c.errorf("", "no configuration")
+		return c.d
+	}
+
+	{
+		v := *config
+		if err := v.Expand(checkVars(config, os)); err != nil {
+			var e *fst.VarError
+			if errors.As(err, &e) {
+				c.errorf(e.Field, "%v %q", e.Err, e.Name)
+			} else {
+				c.errorf("", "%v", err)
+			}
+			return c.d
+		}
+		config = &v
+	}
+
+	if config.Path != "" && !path.IsAbs(config.Path) {
+		c.errorf("path", "program path %q is not absolute", config.Path)
+	} else if config.Path == "" && config.Container != nil {
+		c.errorf("path", "program path must be set if container is configured")
+	}
+
+	if config.DirectWayland {
+		if config.Enablements&system.EWayland == 0 {
+			c.warnf("direct_wayland", "has no effect without wayland enablement")
+		} else {
+			c.warnf("direct_wayland", "wayland socket is exposed without security-context-v1")
+		}
+	}
+	c.bus("session_bus", "session", config.SessionBus, config.Enablements)
+	c.bus("system_bus", "system", config.SystemBus, config.Enablements)
+	if config.DBusLearn != "" {
+		if !path.IsAbs(config.DBusLearn) {
+			c.errorf("dbus_learn", "path %q is not absolute", config.DBusLearn)
+		}
+		if config.Enablements&system.EDBus == 0 {
+			c.warnf("dbus_learn", "has no effect without dbus enablement")
+		} else {
+			c.warnf("dbus_learn", "message bus proxies permit all traffic")
+		}
+	}
+
+	if config.Shell != "" && !path.IsAbs(config.Shell) {
+		c.warnf("shell", "shell path %q is not absolute, falling back to host shell", config.Shell)
+	}
+	if config.Data == "" || !path.IsAbs(config.Data) {
+		c.errorf("data", "home directory %q is not absolute", config.Data)
+	}
+	if config.Dir != "" && !path.IsAbs(config.Dir) {
+		c.errorf("dir", "working directory %q is not absolute", config.Dir)
+	}
+	for i, p := range config.ExtraPerms {
+		if p == nil {
+			continue
+		}
+		if !path.IsAbs(p.Path) {
+			c.errorf(fmt.Sprintf("extra_perms[%d].path", i), "path %q is not absolute", p.Path)
+		}
+	}
+
+	// allowed aid range 0 to 9999, this is checked again in fsu
+	if config.Identity < 0 || config.Identity > 9999 {
+		c.errorf("identity", "identity %d out of range", config.Identity)
+	}
+	for i, name := range config.Groups {
+		if _, err := os.LookupGroup(name); err != nil {
+			c.errorf(fmt.Sprintf("groups[%d]", i), "unknown group %q", name)
+		}
+	}
+
+	if config.Container == nil {
+		c.warnf("container", "not configured, permissive defaults expose the host filesystem and network")
+	} else {
+		c.container(config.Container)
+	}
+	return c.d
+}
+
+// checkVars returns variables for [fst.Config.Expand] resolvable without sealing config.
+func checkVars(config *fst.Config, os sys.State) map[string]string {
+	sc := os.Paths()
+	vars := map[string]string{
+		fst.VarUid:      "${" + fst.VarUid + "}",
+		fst.VarAid:      strconv.Itoa(config.Identity),
+		fst.VarID:       config.ID,
+		fst.VarInstance: "${" + fst.VarInstance + "}",
+		fst.VarRuntime:  sc.RuntimePath,
+		fst.VarShare:    sc.SharePath,
+	}
+	if h, ok := os.LookupEnv("HOME"); ok && path.IsAbs(h) {
+		vars[fst.VarHome] = h
+	}
+	return vars
+}
+
+type checker struct {
+	os sys.State
+	d  []*Diagnostic
+}
+
+func (c *checker) errorf(field, format string, a ...any) {
+	c.d = append(c.d, &Diagnostic{SeverityError, field, fmt.Sprintf(format, a...)})
+}
+
+// errorErr reports err by its user-facing message if available.
+func (c *checker) errorErr(field string, err error) {
+	var e *fmsg.BaseError
+	if fmsg.AsBaseError(err, &e) {
+		c.errorf(field, "%s", strings.TrimSpace(e.Message()))
+	} else {
+		c.errorf(field, "%v", err)
+	}
+}
+
+func (c *checker) warnf(field, format string, a ...any) {
+	c.d = append(c.d, &Diagnostic{SeverityWarning, field, fmt.Sprintf(format, a...)})
+}
+
+func (c *checker) bus(field, segment string, config *dbus.Config, enablements system.Enablement) {
+	if config == nil {
+		return
+	}
+	if enablements&system.EDBus == 0 {
+		c.warnf(field, "has no effect without dbus enablement")
+	}
+	if err := config.CheckInterfaces(segment); err != nil {
+		c.errorf(field, "%v", err)
+	}
+}
+
+func (c *checker) container(s *fst.ContainerConfig) {
+	if s.Devel {
+		c.warnf("container.devel", "ptrace and related syscalls are allowed")
+	}
+	if s.Userns {
+		c.warnf("container.userns", "user namespace creation is allowed")
+	}
+	if s.Net {
+		c.warnf("container.net", "host net namespace is shared")
+		if s.Usernet != nil {
+			c.errorf("container.usernet", "user-mode networking requires a private net namespace")
+		}
+	}
+	if s.Device {
+		c.warnf("container.device", "all devices are passed through")
+	}
+
+	if s.SeccompAudit {
+		c.warnf("container.seccomp_audit", "syscalls denied by the filter are permitted")
+	}
+	if err := seccomp.ValidateRules(s.SeccompRules); err != nil {
+		c.errorf("container.seccomp_rules", "%v", err)
+	}
+	for i, r := range s.SeccompRules {
+		if r.Action == seccomp.ActionAllow {
+			c.warnf(fmt.Sprintf("container.seccomp_rules[%d]", i), "syscall %q is exempt from the preset filter", r.Syscall)
+		}
+	}
+	if err := seccomp.ValidateNotifyRules(s.SeccompSupervise); err != nil {
+		c.errorf("container.seccomp_supervise", "%v", err)
+	}
+	for i, r := range s.SeccompSupervise {
+		if r.Response == seccomp.ResponseContinue {
+			c.warnf(fmt.Sprintf("container.seccomp_supervise[%d]", i), "matching calls to %q bypass the filter", r.Syscall)
+		}
+	}
+
+	home, _ := c.os.LookupEnv("HOME")
+	for i, b := range s.Filesystem {
+		if b != nil {
+			c.filesystem(fmt.Sprintf("container.filesystem[%d]", i), b, home)
+		}
+	}
+	if s.Landlock {
+		c.landlock(s)
+	}
+	for i, l := range s.Link {
+		if !path.IsAbs(l[1]) {
+			c.errorf(fmt.Sprintf("container.symlink[%d]", i), "link name %q is not absolute", l[1])
+		}
+		if strings.HasPrefix(l[0], "*") && !path.IsAbs(l[0][1:]) {
+			c.errorf(fmt.Sprintf("container.symlink[%d]", i), "path %q is not absolute", l[0][1:])
+		}
+	}
+	if s.Etc != "" && !path.IsAbs(s.Etc) {
+		c.errorf("container.etc", "path %q is not absolute", s.Etc)
+	}
+	for i, p := range s.Cover {
+		if !path.IsAbs(p) {
+			c.errorf(fmt.Sprintf("container.cover[%d]", i), "path %q is not absolute", p)
+		}
+	}
+
+	if s.Usernet != nil {
+		if s.Usernet.HostLoopback {
+			c.warnf("container.usernet.host_loopback", "services listening on host loopback are reachable")
+		}
+		if _, err := NewUsernet(s.Usernet); err != nil {
+			c.errorErr("container.usernet", err)
+		}
+	}
+
+	// validated the same way as during seal
+	if s.Cgroup != nil {
+		if _, err := CgroupPath(s.Cgroup, ""); err != nil {
+			c.errorErr("container.cgroup.parent", err)
+		}
+		if _, err := CgroupFiles(s.Cgroup); err != nil {
+			c.errorErr("container.cgroup", err)
+		}
+	}
+}
+
+// landlock warns about read-only filesystem entries made writable by landlock rules of writable mount points
+// mounted before them, as landlock grants access to everything beneath a path.
+func (c *checker) landlock(s *fst.ContainerConfig) {
+	var writable []string
+	if s.Device {
+		writable = append(writable, "/dev")
+	}
+	for i, b := range s.Filesystem {
+		if b == nil || !path.IsAbs(b.Src) {
+			continue
+		}
+		dest := b.Dst
+		if dest == "" {
+			dest = b.Src
+		}
+
+		// overlay mounts are writable through their upper directory
+		if b.Write || b.Device || b.Overlay {
+			writable = append(writable, dest)
+			continue
+		}
+		for _, w := range writable {
+			if ok, _ := deepContainsH(w, dest); ok {
+				c.warnf(fmt.Sprintf("container.filesystem[%d]", i),
+					"read-only path %q is writable under landlock beneath %q", dest, w)
+				break
+			}
+		}
+	}
+}
+
+func (c *checker) filesystem(field string, b *fst.FilesystemConfig, home string) {
+	if !path.IsAbs(b.Src) {
+		c.errorf(field+".src", "src path %q is not absolute", b.Src)
+		return
+	}
+	if b.Dst != "" && !path.IsAbs(b.Dst) {
+		c.errorf(field+".dst", "dst path %q is not absolute", b.Dst)
+	}
+
+	// paths referencing variables unknown until seal cannot be accessed
+	if (b.Must || b.Overlay) && !strings.Contains(b.Src, "${") {
+		if _, err := c.os.Stat(b.Src); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				c.errorf(field+".src", "required path %q does not exist", b.Src)
+			} else {
+				c.errorf(field+".src", "cannot access %q: %v", b.Src, err)
+			}
+		}
+	}
+
+	if b.Overlay {
+		if b.Write || b.Device {
+			c.errorf(field, "overlay cannot be writable or expose devices")
+		}
+		if b.Upper != "" {
+			if _, err := overlayUpper("", b.Upper); err != nil {
+				c.errorf(field+".upper", "%v", err)
+			}
+		}
+		return
+	} else if b.Upper != "" {
+		c.warnf(field+".upper", "has no effect without overlay")
+	}
+
+	if b.Device {
+		c.warnf(field+".dev", "device files in %q are exposed", b.Src)
+	}
+	if b.Write && path.IsAbs(home) {
+		if ok, _ := deepContainsH(b.Src, home); ok {
+			c.warnf(field+".write", "home directory %q is writable", home)
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package common
+
+import (
+	"io/fs"
+	"os/user"
+	"reflect"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/dbus"
+	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/internal/app"
+	"git.gensokyo.uk/security/fortify/internal/sys"
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+	"git.gensokyo.uk/security/fortify/system"
+)
+
+func TestCheckConfig(t *testing.T) {
+	valid := func() *fst.Config {
+		return &fst.Config{
+			ID:          "org.chromium.Chromium",
+			Path:        "/run/current-system/sw/bin/chromium",
+			Enablements: system.EWayland | system.EDBus,
+			Data:        "/var/lib/persist/module/fortify/u0/a9",
+			Identity:    9,
+			Groups:      []string{"video"},
+			Container: &fst.ContainerConfig{
+				Filesystem: []*fst.FilesystemConfig{
+					{Src: "/nix/store", Must: true},
+					{Src: "/run/current-system", Must: true},
+					{Src: "/dev/dri", Device: true},
+				},
+				Link: [][2]string{{"/run/user/65534", "/run/user/150"}},
+			},
+		}
+	}
+
+	testCases := []struct {
+		name   string
+		modify func(config *fst.Config)
+		want   []*Diagnostic
+	}{
+		{"valid", func(*fst.Config) {}, []*Diagnostic{
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+		}},
+		{"nil container", func(config *fst.Config) { config.Container = nil; config.Path = "" }, []*Diagnostic{
+			{SeverityWarning, "container", "not configured, permissive defaults expose the host filesystem and network"},
+		}},
+		{"paths", func(config *fst.Config) {
+			config.Path = "chromium"
+			config.Data = "data"
+			config.Container.Filesystem[0].Src = "nix/store"
+			config.Container.Filesystem[1].Dst = "run"
+			config.Container.Link[0][1] = "run/user/150"
+			config.Container.Cover = []string{"/var/run/nscd", "nscd"}
+		}, []*Diagnostic{
+			{SeverityError, "path", `program path "chromium" is not absolute`},
+			{SeverityError, "data", `home directory "data" is not absolute`},
+			{SeverityError, "container.filesystem[0].src", `src path "nix/store" is not absolute`},
+			{SeverityError, "container.filesystem[1].dst", `dst path "run" is not absolute`},
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+			{SeverityError, "container.symlink[0]", `link name "run/user/150" is not absolute`},
+			{SeverityError, "container.cover[1]", `path "nscd" is not absolute`},
+		}},
+		{"require", func(config *fst.Config) {
+			config.Container.Filesystem = append(config.Container.Filesystem,
+				&fst.FilesystemConfig{Src: "/nonexistent", Must: true},
+				&fst.FilesystemConfig{Src: "/nonexistent/optional"})
+		}, []*Diagnostic{
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+			{SeverityError, "container.filesystem[3].src", `required path "/nonexistent" does not exist`},
+		}},
+		{"identity groups", func(config *fst.Config) {
+			config.Identity = 10000
+			config.Groups = append(config.Groups, "nonexistent")
+		}, []*Diagnostic{
+			{SeverityError, "identity", "identity 10000 out of range"},
+			{SeverityError, "groups[1]", `unknown group "nonexistent"`},
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+		}},
+		{"conflict", func(config *fst.Config) {
+			config.Enablements = 0
+			config.DirectWayland = true
+			config.SessionBus = &dbus.Config{Talk: []string{"org.freedesktop.Notifications", "bad"}}
+			config.Container.Net = true
+			config.Container.Usernet = new(fst.UsernetConfig)
+			config.Container.Filesystem[0].Overlay = true
+			config.Container.Filesystem[0].Write = true
+			config.Container.Filesystem[1].Upper = "upper"
+		}, []*Diagnostic{
+			{SeverityWarning, "direct_wayland", "has no effect without wayland enablement"},
+			{SeverityWarning, "session_bus", "has no effect without dbus enablement"},
+			{SeverityError, "session_bus", `bad interface string "bad" in session bus configuration`},
+			{SeverityWarning, "container.net", "host net namespace is shared"},
+			{SeverityError, "container.usernet", "user-mode networking requires a private net namespace"},
+			{SeverityError, "container.filesystem[0]", "overlay cannot be writable or expose devices"},
+			{SeverityWarning, "container.filesystem[1].upper", "has no effect without overlay"},
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+		}},
+		{"dbus learn", func(config *fst.Config) {
+			config.DBusLearn = "learned.json"
+		}, []*Diagnostic{
+			{SeverityError, "dbus_learn", `path "learned.json" is not absolute`},
+			{SeverityWarning, "dbus_learn", "message bus proxies permit all traffic"},
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+		}},
+		{"risky", func(config *fst.Config) {
+			config.Container.Devel = true
+			config.Container.Userns = true
+			config.Container.Device = true
+			config.Container.Filesystem = append(config.Container.Filesystem,
+				&fst.FilesystemConfig{Src: "/home", Write: true})
+		}, []*Diagnostic{
+			{SeverityWarning, "container.devel", "ptrace and related syscalls are allowed"},
+			{SeverityWarning, "container.userns", "user namespace creation is allowed"},
+			{SeverityWarning, "container.device", "all devices are passed through"},
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+			{SeverityWarning, "container.filesystem[3].write", `home directory "/home/ophestra" is writable`},
+		}},
+		{"seccomp", func(config *fst.Config) {
+			config.Container.SeccompRules = []seccomp.Rule{{Syscall: "nonexistent", Action: seccomp.ActionAllow}}
+			config.Container.Cgroup = &fst.CgroupConfig{Parent: "/user.slice/fortify", CPUWeight: 10001}
+		}, []*Diagnostic{
+			{SeverityError, "container.seccomp_rules", `invalid seccomp rule 0: unknown syscall "nonexistent"`},
+			{SeverityWarning, "container.seccomp_rules[0]", `syscall "nonexistent" is exempt from the preset filter`},
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+			{SeverityError, "container.cgroup", "cpu weight 10001 out of range"},
+		}},
+		{"weakened", func(config *fst.Config) {
+			config.Container.SeccompAudit = true
+			config.Container.SeccompRules = []seccomp.Rule{
+				{Syscall: "ioctl", Action: seccomp.ActionErrno, Errno: 38},
+				{Syscall: "personality", Action: seccomp.ActionAllow},
+			}
+			config.Container.SeccompSupervise = []seccomp.NotifyRule{
+				{Syscall: "mount", Response: seccomp.ResponseErrno},
+				{Syscall: "chdir", Response: seccomp.ResponseContinue},
+			}
+			config.Container.Usernet = &fst.UsernetConfig{HostLoopback: true}
+		}, []*Diagnostic{
+			{SeverityWarning, "container.seccomp_audit", "syscalls denied by the filter are permitted"},
+			{SeverityWarning, "container.seccomp_rules[1]", `syscall "personality" is exempt from the preset filter`},
+			{SeverityWarning, "container.seccomp_supervise[1]", `matching calls to "chdir" bypass the filter`},
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+			{SeverityWarning, "container.usernet.host_loopback", "services listening on host loopback are reachable"},
+		}},
+		{"cgroup", func(config *fst.Config) {
+			config.Container.Cgroup = &fst.CgroupConfig{Memory: 1 << 30}
+		}, []*Diagnostic{
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+			{SeverityError, "container.cgroup.parent", "resource limits require a delegated parent cgroup"},
+		}},
+		{"landlock", func(config *fst.Config) {
+			config.Container.Landlock = true
+			config.Container.Filesystem = append(config.Container.Filesystem,
+				// mounted before the writable parent, covered by it
+				&fst.FilesystemConfig{Src: "/home/ophestra/.config"},
+				&fst.FilesystemConfig{Src: "/home", Write: true},
+				&fst.FilesystemConfig{Src: "/home/ophestra/.ssh"},
+				&fst.FilesystemConfig{Src: "/var/lib/secrets", Dst: "/dev/dri/secrets"})
+		}, []*Diagnostic{
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+			{SeverityWarning, "container.filesystem[4].write", `home directory "/home/ophestra" is writable`},
+			{SeverityWarning, "container.filesystem[5]", `read-only path "/home/ophestra/.ssh" is writable under landlock beneath "/home"`},
+			{SeverityWarning, "container.filesystem[6]", `read-only path "/dev/dri/secrets" is writable under landlock beneath "/dev/dri"`},
+		}},
+		{"seal validators", func(config *fst.Config) {
+			config.Container.Filesystem[0].Overlay = true
+			config.Container.Filesystem[0].Upper = "overlay/../../escape"
+			config.Container.Usernet = &fst.UsernetConfig{Allow: []string{"example.org:http"}}
+			config.Container.Cgroup = &fst.CgroupConfig{Parent: "/user.slice/../system.slice", Pids: -1}
+		}, []*Diagnostic{
+			{SeverityError, "container.filesystem[0].upper", `upper path "overlay/../../escape" is not within the data directory`},
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+			{SeverityError, "container.usernet", `invalid destination rule "example.org:http": bad port "http"`},
+			{SeverityError, "container.cgroup.parent", `invalid parent cgroup "/user.slice/../system.slice"`},
+			{SeverityError, "container.cgroup", "pids limit -1 out of range"},
+		}},
+		{"usernet proxy", func(config *fst.Config) {
+			config.Container.Usernet = &fst.UsernetConfig{Proxy: "192.0.2.1:3128"}
+			config.Container.Cgroup = &fst.CgroupConfig{Parent: "/"}
+		}, []*Diagnostic{
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+			{SeverityError, "container.usernet", `proxy "192.0.2.1:3128" is not a loopback address or unix socket`},
+			{SeverityError, "container.cgroup.parent", `invalid parent cgroup "/"`},
+		}},
+		{"vars", func(config *fst.Config) {
+			config.Data = "${home}/.local/share/fortify/${aid}"
+			config.Container.Filesystem = append(config.Container.Filesystem,
+				&fst.FilesystemConfig{Src: "${runtime}/fortify/${instance}", Must: true})
+		}, []*Diagnostic{
+			{SeverityWarning, "container.filesystem[2].dev", `device files in "/dev/dri" are exposed`},
+		}},
+		{"unknown var", func(config *fst.Config) {
+			config.Container.Cover = []string{"${data}/.cache"}
+		}, []*Diagnostic{
+			{SeverityError, "container.cover[0]", `unknown variable "data"`},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config := valid()
+			tc.modify(config)
+			if got := CheckConfig(config, stubCheck{}); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("CheckConfig:\ngot  %s\nwant %s", got, tc.want)
+			}
+		})
+	}
+}
+
+// stubCheck implements the methods of [sys.State] used by [CheckConfig].
+type stubCheck struct{ sys.State }
+
+func (stubCheck) LookupEnv(key string) (string, bool) {
+	if key == "HOME" {
+		return "/home/ophestra", true
+	}
+	return "", false
+}
+
+func (stubCheck) LookupGroup(name string) (*user.Group, error) {
+	if name == "video" {
+		return &user.Group{Gid: "26", Name: "video"}, nil
+	}
+	return nil, user.UnknownGroupError(name)
+}
+
+func (stubCheck) Paths() app.Paths {
+	return app.Paths{
+		SharePath:   "/tmp/fortify.1971",
+		RuntimePath: "/run/user/1971",
+		RunDirPath:  "/run/user/1971/fortify",
+	}
+}
+
+func (stubCheck) Stat(name string) (fs.FileInfo, error) {
+	switch name {
+	case "/nix/store", "/run/current-system", "/dev/dri":
+		return nil, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
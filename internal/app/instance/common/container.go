@@ -6,6 +6,7 @@ import (
 	"io/fs"
 	"maps"
 	"path"
+	"strings"
 	"syscall"
 
 	"git.gensokyo.uk/security/fortify/dbus"
@@ -13,6 +14,7 @@ import (
 	"git.gensokyo.uk/security/fortify/internal/sys"
 	"git.gensokyo.uk/security/fortify/sandbox"
 	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+	"git.gensokyo.uk/security/fortify/sandbox/usernet"
 )
 
 // in practice there should be less than 30 entries added by the runtime;
@@ -20,8 +22,9 @@ import (
 const preallocateOpsCount = 1 << 5
 
 // NewContainer initialises [sandbox.Params] via [fst.ContainerConfig].
+// Persistent overlay upper directories are placed under data.
 // Note that remaining container setup must be queued by the caller.
-func NewContainer(s *fst.ContainerConfig, os sys.State, uid, gid *int) (*sandbox.Params, map[string]string, error) {
+func NewContainer(s *fst.ContainerConfig, os sys.State, data string, uid, gid *int) (*sandbox.Params, map[string]string, error) {
 	if s == nil {
 		return nil, nil, syscall.EBADE
 	}
@@ -29,6 +32,7 @@ func NewContainer(s *fst.ContainerConfig, os sys.State, uid, gid *int) (*sandbox
 	container := &sandbox.Params{
 		Hostname: s.Hostname,
 		Seccomp:  s.Seccomp,
+		Landlock: s.Landlock,
 	}
 
 	{
@@ -39,6 +43,20 @@ func NewContainer(s *fst.ContainerConfig, os sys.State, uid, gid *int) (*sandbox
 	if s.Multiarch {
 		container.Seccomp |= seccomp.FilterMultiarch
 	}
+	if s.SeccompAudit {
+		container.Seccomp |= seccomp.FilterAudit
+	}
+	if len(s.SeccompRules) > 0 || len(s.SeccompSupervise) > 0 {
+		if err := seccomp.ValidateNotifyRules(s.SeccompSupervise); err != nil {
+			return nil, nil, err
+		}
+		rules := append(s.SeccompRules[:len(s.SeccompRules):len(s.SeccompRules)],
+			seccomp.NotifyFilterRules(s.SeccompSupervise)...)
+		if err := seccomp.ValidateRules(rules); err != nil {
+			return nil, nil, err
+		}
+		container.SeccompRules = rules
+	}
 
 	if s.Devel {
 		container.Flags |= sandbox.FAllowDevel
@@ -49,6 +67,12 @@ func NewContainer(s *fst.ContainerConfig, os sys.State, uid, gid *int) (*sandbox
 	if s.Net {
 		container.Flags |= sandbox.FAllowNet
 	}
+	if s.Usernet != nil {
+		if s.Net {
+			return nil, nil, errors.New("user-mode networking requires a private net namespace")
+		}
+		container.Tun = usernet.NewConfig().Tun()
+	}
 	if s.Tty {
 		container.Flags |= sandbox.FAllowTTY
 	}
@@ -149,6 +173,23 @@ func NewContainer(s *fst.ContainerConfig, os sys.State, uid, gid *int) (*sandbox
 			}
 		}
 
+		if c.Overlay {
+			if c.Write || c.Device {
+				return nil, nil, fmt.Errorf("overlay on %q cannot be writable or expose devices", dest)
+			}
+			if c.Upper == "" {
+				container.Overlay(dest, []string{c.Src}, "", "")
+				continue
+			}
+
+			upper, err := overlayUpper(data, c.Upper)
+			if err != nil {
+				return nil, nil, err
+			}
+			container.Overlay(dest, []string{c.Src}, path.Join(upper, "upper"), path.Join(upper, "work"))
+			continue
+		}
+
 		var flags int
 		if c.Write {
 			flags |= sandbox.BindWritable
@@ -162,6 +203,14 @@ func NewContainer(s *fst.ContainerConfig, os sys.State, uid, gid *int) (*sandbox
 		container.Bind(c.Src, dest, flags)
 	}
 
+	if s.GPU {
+		if g, err := discoverGPU(os); err != nil {
+			return nil, nil, err
+		} else {
+			g.apply(container.Ops, func(name string) bool { return containerCovers(s, name) }, s.Device)
+		}
+	}
+
 	// cover matched paths
 	for i, ok := range hidePathMatch {
 		if ok {
@@ -187,3 +236,12 @@ func evalSymlinks(os sys.State, v *string) error {
 	}
 	return nil
 }
+
+// overlayUpper returns the directory holding persistent overlay writes of upper within data.
+func overlayUpper(data, upper string) (string, error) {
+	v := path.Clean(upper)
+	if path.IsAbs(v) || v == "." || v == ".." || strings.HasPrefix(v, "../") {
+		return "", fmt.Errorf("upper path %q is not within the data directory", upper)
+	}
+	return path.Join(data, v), nil
+}
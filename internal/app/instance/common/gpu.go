@@ -0,0 +1,195 @@
+package common
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+
+	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/internal/sys"
+	"git.gensokyo.uk/security/fortify/sandbox"
+)
+
+const (
+	// gpuDRMClass holds links to sysfs device directories of DRM nodes.
+	gpuDRMClass = "/sys/class/drm"
+	// gpuDRMDev holds DRM device nodes.
+	gpuDRMDev = "/dev/dri"
+	// gpuRenderPrefix is the name prefix of DRM render nodes.
+	gpuRenderPrefix = "renderD"
+	// gpuCardPrefix is the name prefix of DRM primary nodes, required by some drivers for device enumeration.
+	gpuCardPrefix = "card"
+	// gpuNvidiaPrefix is the name prefix of device nodes of the proprietary nvidia driver.
+	gpuNvidiaPrefix = "nvidia"
+	// gpuMaliPrefix is the name prefix of device nodes of the proprietary Arm Mali driver.
+	gpuMaliPrefix = "mali"
+	// gpuUMPLock is the name of the buffer locking device node used alongside the Mali driver.
+	gpuUMPLock = "umplock"
+)
+
+// gpuDriverPaths are driver library and configuration paths made available if present.
+var gpuDriverPaths = []string{
+	// NixOS hardware.graphics
+	"/run/opengl-driver",
+	"/run/opengl-driver-32",
+	// ICD and vendor library manifests
+	"/usr/share/glvnd",
+	"/usr/share/egl",
+	"/usr/share/vulkan",
+	"/etc/OpenCL",
+}
+
+// gpuPaths holds host paths exposing GPUs to a container.
+type gpuPaths struct {
+	// device nodes
+	Devices []string
+	// sysfs directories of GPU devices
+	Sysfs []string
+	// sysfs symbolic links to device directories, as [target, linkname]
+	Links [][2]string
+	// driver libraries and configuration
+	Drivers []string
+}
+
+// discoverGPU discovers DRM nodes, their sysfs entries, device nodes of other GPU drivers and driver paths
+// on the host filesystem as seen through os.
+func discoverGPU(os sys.State) (*gpuPaths, error) {
+	g := new(gpuPaths)
+
+	d, err := os.ReadDir(gpuDRMClass)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, ent := range d {
+		name := ent.Name()
+		if !isDRMNode(name) {
+			continue
+		}
+		dev := path.Join(gpuDRMDev, name)
+		if _, err = os.Stat(dev); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		g.Devices = append(g.Devices, dev)
+
+		class := path.Join(gpuDRMClass, name)
+		sysDir, err := os.EvalSymlinks(class)
+		if err != nil {
+			return nil, err
+		} else if !strings.HasPrefix(sysDir, "/sys/devices/") {
+			// virtual devices have no parent device directory
+			continue
+		}
+		// ${device}/drm/${name}, virtual devices share a parent so only the node is exposed
+		dir := path.Dir(path.Dir(sysDir))
+		if dir == "/sys/devices" || dir == "/sys/devices/virtual" {
+			dir = sysDir
+		}
+		if !slices.Contains(g.Sysfs, dir) {
+			g.Sysfs = append(g.Sysfs, dir)
+		}
+		// both links are two levels below /sys
+		target := "../.." + strings.TrimPrefix(sysDir, "/sys")
+		g.Links = append(g.Links, [2]string{target, class})
+
+		if data, err := readFile(os, path.Join(class, "dev")); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+		} else if number := strings.TrimSpace(string(data)); number != "" && !strings.Contains(number, "/") {
+			g.Links = append(g.Links, [2]string{target, path.Join("/sys/dev/char", number)})
+		}
+	}
+
+	if d, err = os.ReadDir("/dev"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	for _, ent := range d {
+		if name := ent.Name(); strings.HasPrefix(name, gpuNvidiaPrefix) ||
+			strings.HasPrefix(name, gpuMaliPrefix) || name == gpuUMPLock {
+			g.Devices = append(g.Devices, path.Join("/dev", name))
+		}
+	}
+
+	for _, p := range gpuDriverPaths {
+		if _, err = os.Stat(p); err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+		g.Drivers = append(g.Drivers, p)
+	}
+
+	return g, nil
+}
+
+// isDRMNode returns whether name is the name of a DRM primary or render node, as opposed to a connector.
+func isDRMNode(name string) bool {
+	var number string
+	if v, ok := strings.CutPrefix(name, gpuRenderPrefix); ok {
+		number = v
+	} else if v, ok = strings.CutPrefix(name, gpuCardPrefix); ok {
+		number = v
+	}
+	return number != "" && strings.Trim(number, "0123456789") == ""
+}
+
+// readFile reads the file name via os.
+func readFile(os sys.State, name string) ([]byte, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return data, err
+}
+
+// apply queues bind mounts and symbolic links exposing g on container, skipping paths covered by an existing mount point.
+// Device nodes are skipped if the container has access to all devices.
+func (g *gpuPaths) apply(container *sandbox.Ops, covered func(name string) bool, device bool) {
+	if !device {
+		for _, name := range g.Devices {
+			container.Bind(name, name, sandbox.BindDevice|sandbox.BindWritable|sandbox.BindOptional)
+		}
+	}
+	for _, name := range g.Sysfs {
+		if !covered(name) {
+			container.Bind(name, name, sandbox.BindOptional)
+		}
+	}
+	for _, l := range g.Links {
+		if !covered(l[1]) {
+			container.Link(l[0], l[1])
+		}
+	}
+	for _, name := range g.Drivers {
+		if !covered(name) {
+			container.Bind(name, name, sandbox.BindOptional)
+		}
+	}
+}
+
+// containerCovers returns whether name is at or below a mount point or symbolic link configured in s.
+func containerCovers(s *fst.ContainerConfig, name string) bool {
+	under := func(dest string) bool {
+		return name == path.Clean(dest) || strings.HasPrefix(name, path.Clean(dest)+"/")
+	}
+	return slices.ContainsFunc(s.Filesystem, func(c *fst.FilesystemConfig) bool {
+		if c == nil {
+			return false
+		}
+		if c.Dst != "" {
+			return under(c.Dst)
+		}
+		return under(c.Src)
+	}) || slices.ContainsFunc(s.Link, func(l [2]string) bool { return under(l[1]) })
+}
@@ -0,0 +1,258 @@
+package common
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/internal/sys"
+	"git.gensokyo.uk/security/fortify/sandbox"
+)
+
+// fsEntry describes an entry of a fake host filesystem, a directory if both link and data are empty.
+type fsEntry struct {
+	name string
+	link string
+	data string
+}
+
+func mustFakeRoot(t *testing.T, entries []fsEntry) string {
+	root := t.TempDir()
+	for _, e := range entries {
+		name := path.Join(root, e.name)
+		if err := os.MkdirAll(path.Dir(name), 0755); err != nil {
+			t.Fatalf("MkdirAll: error = %v", err)
+		}
+		var err error
+		switch {
+		case e.link != "":
+			err = os.Symlink(e.link, name)
+		case e.data != "":
+			err = os.WriteFile(name, []byte(e.data), 0644)
+		default:
+			err = os.MkdirAll(name, 0755)
+		}
+		if err != nil {
+			t.Fatalf("cannot create %q: %v", e.name, err)
+		}
+	}
+	return root
+}
+
+// stubRoot implements the methods of [sys.State] used by [discoverGPU] on a fake host filesystem at root.
+type stubRoot struct {
+	sys.State
+	root string
+}
+
+func (s stubRoot) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(s.root + name) }
+func (s stubRoot) Stat(name string) (fs.FileInfo, error)      { return os.Stat(s.root + name) }
+func (s stubRoot) Open(name string) (fs.File, error)          { return os.Open(s.root + name) }
+func (s stubRoot) EvalSymlinks(name string) (string, error) {
+	p, err := filepath.EvalSymlinks(s.root + name)
+	if err != nil {
+		return "", err
+	}
+	if p != s.root && !strings.HasPrefix(p, s.root+"/") {
+		return "", &fs.PathError{Op: "evalsymlinks", Path: name, Err: errors.New("path escapes root")}
+	}
+	return strings.TrimPrefix(p, s.root), nil
+}
+
+func TestDiscoverGPU(t *testing.T) {
+	const (
+		intel  = "/sys/devices/pci0000:00/0000:00:02.0"
+		nvidia = "/sys/devices/pci0000:00/0000:00:01.0/0000:01:00.0"
+	)
+
+	testCases := []struct {
+		name    string
+		entries []fsEntry
+		want    *gpuPaths
+	}{
+		{"empty", nil, new(gpuPaths)},
+
+		{"intel", []fsEntry{
+			{name: intel + "/drm/card1/dev", data: "226:1\n"},
+			{name: intel + "/drm/renderD128/dev", data: "226:128\n"},
+			{name: "/sys/class/drm/card1", link: "../../devices/pci0000:00/0000:00:02.0/drm/card1"},
+			{name: "/sys/class/drm/card1-eDP-1", link: "../../devices/pci0000:00/0000:00:02.0/drm/card1/card1-eDP-1"},
+			{name: "/sys/class/drm/renderD128", link: "../../devices/pci0000:00/0000:00:02.0/drm/renderD128"},
+			{name: "/sys/class/drm/version", data: "drm 1.1.0 20060810\n"},
+			{name: "/dev/dri/card1", data: "\x00"},
+			{name: "/dev/dri/renderD128", data: "\x00"},
+			{name: "/dev/null", data: "\x00"},
+			{name: "/run/opengl-driver/lib"},
+		}, &gpuPaths{
+			Devices: []string{"/dev/dri/card1", "/dev/dri/renderD128"},
+			Sysfs:   []string{intel},
+			Links: [][2]string{
+				{"../../devices/pci0000:00/0000:00:02.0/drm/card1", "/sys/class/drm/card1"},
+				{"../../devices/pci0000:00/0000:00:02.0/drm/card1", "/sys/dev/char/226:1"},
+				{"../../devices/pci0000:00/0000:00:02.0/drm/renderD128", "/sys/class/drm/renderD128"},
+				{"../../devices/pci0000:00/0000:00:02.0/drm/renderD128", "/sys/dev/char/226:128"},
+			},
+			Drivers: []string{"/run/opengl-driver"},
+		}},
+
+		{"hybrid", []fsEntry{
+			{name: intel + "/drm/renderD128/dev", data: "226:128\n"},
+			{name: nvidia + "/drm/renderD129/dev", data: "226:129\n"},
+			{name: "/sys/class/drm/renderD128", link: "../../devices/pci0000:00/0000:00:02.0/drm/renderD128"},
+			{name: "/sys/class/drm/renderD129", link: "../../devices/pci0000:00/0000:00:01.0/0000:01:00.0/drm/renderD129"},
+			{name: "/dev/dri/renderD128", data: "\x00"},
+			{name: "/dev/dri/renderD129", data: "\x00"},
+			{name: "/dev/nvidia-uvm", data: "\x00"},
+			{name: "/dev/nvidia0", data: "\x00"},
+			{name: "/dev/nvidiactl", data: "\x00"},
+			{name: "/usr/share/vulkan/icd.d"},
+			{name: "/usr/share/glvnd/egl_vendor.d"},
+		}, &gpuPaths{
+			Devices: []string{
+				"/dev/dri/renderD128", "/dev/dri/renderD129",
+				"/dev/nvidia-uvm", "/dev/nvidia0", "/dev/nvidiactl",
+			},
+			Sysfs: []string{intel, nvidia},
+			Links: [][2]string{
+				{"../../devices/pci0000:00/0000:00:02.0/drm/renderD128", "/sys/class/drm/renderD128"},
+				{"../../devices/pci0000:00/0000:00:02.0/drm/renderD128", "/sys/dev/char/226:128"},
+				{"../../devices/pci0000:00/0000:00:01.0/0000:01:00.0/drm/renderD129", "/sys/class/drm/renderD129"},
+				{"../../devices/pci0000:00/0000:00:01.0/0000:01:00.0/drm/renderD129", "/sys/dev/char/226:129"},
+			},
+			Drivers: []string{"/usr/share/glvnd", "/usr/share/vulkan"},
+		}},
+
+		{"mali", []fsEntry{
+			{name: "/sys/devices/platform/soc/ffe40000.gpu/drm/card0/dev", data: "226:0\n"},
+			{name: "/sys/class/drm/card0", link: "../../devices/platform/soc/ffe40000.gpu/drm/card0"},
+			{name: "/dev/dri/card0", data: "\x00"},
+			{name: "/dev/mali0", data: "\x00"},
+			{name: "/dev/umplock", data: "\x00"},
+			{name: "/dev/umplockd", data: "\x00"},
+		}, &gpuPaths{
+			Devices: []string{"/dev/dri/card0", "/dev/mali0", "/dev/umplock"},
+			Sysfs:   []string{"/sys/devices/platform/soc/ffe40000.gpu"},
+			Links: [][2]string{
+				{"../../devices/platform/soc/ffe40000.gpu/drm/card0", "/sys/class/drm/card0"},
+				{"../../devices/platform/soc/ffe40000.gpu/drm/card0", "/sys/dev/char/226:0"},
+			},
+		}},
+
+		{"virtual", []fsEntry{
+			{name: "/sys/devices/virtual/drm/renderD128/dev", data: "226:128\n"},
+			{name: "/sys/devices/platform/vgem/drm/renderD129/dev", data: "226:129\n"},
+			{name: "/sys/class/drm/renderD128", link: "../../devices/virtual/drm/renderD128"},
+			{name: "/sys/class/drm/renderD129", link: "../../devices/platform/vgem/drm/renderD129"},
+			{name: "/sys/class/drm/renderD130", link: "../../devices/pci0000:00/0000:00:03.0/drm/renderD130"},
+			{name: "/dev/dri/renderD128", data: "\x00"},
+			{name: "/dev/dri/renderD129", data: "\x00"},
+		}, &gpuPaths{
+			Devices: []string{"/dev/dri/renderD128", "/dev/dri/renderD129"},
+			Sysfs:   []string{"/sys/devices/virtual/drm/renderD128", "/sys/devices/platform/vgem"},
+			Links: [][2]string{
+				{"../../devices/virtual/drm/renderD128", "/sys/class/drm/renderD128"},
+				{"../../devices/virtual/drm/renderD128", "/sys/dev/char/226:128"},
+				{"../../devices/platform/vgem/drm/renderD129", "/sys/class/drm/renderD129"},
+				{"../../devices/platform/vgem/drm/renderD129", "/sys/dev/char/226:129"},
+			},
+		}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := discoverGPU(stubRoot{root: mustFakeRoot(t, tc.entries)})
+			if err != nil {
+				t.Fatalf("discoverGPU: error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("discoverGPU:\ngot  %#v\nwant %#v", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("escape", func(t *testing.T) {
+		root := mustFakeRoot(t, []fsEntry{
+			{name: "/sys/class/drm/renderD128", link: "/proc"},
+			{name: "/dev/dri/renderD128", data: "\x00"},
+		})
+		if _, err := discoverGPU(stubRoot{root: root}); err == nil {
+			t.Errorf("discoverGPU: unexpected success")
+		}
+	})
+}
+
+func TestGPUPathsApply(t *testing.T) {
+	g := &gpuPaths{
+		Devices: []string{"/dev/dri/renderD128", "/dev/nvidiactl"},
+		Sysfs:   []string{"/sys/devices/pci0000:00/0000:00:02.0"},
+		Links: [][2]string{
+			{"../../devices/pci0000:00/0000:00:02.0/drm/renderD128", "/sys/class/drm/renderD128"},
+			{"../../devices/pci0000:00/0000:00:02.0/drm/renderD128", "/sys/dev/char/226:128"},
+		},
+		Drivers: []string{"/run/opengl-driver"},
+	}
+	covered := func(name string) bool { return name == "/sys/dev/char/226:128" }
+
+	testCases := []struct {
+		name   string
+		device bool
+		want   *sandbox.Ops
+	}{
+		{"default", false, new(sandbox.Ops).
+			Bind("/dev/dri/renderD128", "/dev/dri/renderD128", sandbox.BindDevice|sandbox.BindWritable|sandbox.BindOptional).
+			Bind("/dev/nvidiactl", "/dev/nvidiactl", sandbox.BindDevice|sandbox.BindWritable|sandbox.BindOptional).
+			Bind("/sys/devices/pci0000:00/0000:00:02.0", "/sys/devices/pci0000:00/0000:00:02.0", sandbox.BindOptional).
+			Link("../../devices/pci0000:00/0000:00:02.0/drm/renderD128", "/sys/class/drm/renderD128").
+			Bind("/run/opengl-driver", "/run/opengl-driver", sandbox.BindOptional)},
+		{"device", true, new(sandbox.Ops).
+			Bind("/sys/devices/pci0000:00/0000:00:02.0", "/sys/devices/pci0000:00/0000:00:02.0", sandbox.BindOptional).
+			Link("../../devices/pci0000:00/0000:00:02.0/drm/renderD128", "/sys/class/drm/renderD128").
+			Bind("/run/opengl-driver", "/run/opengl-driver", sandbox.BindOptional)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := new(sandbox.Ops)
+			g.apply(got, covered, tc.device)
+			if !slices.EqualFunc(*got, *tc.want, func(a, b sandbox.Op) bool { return a.Is(b) }) {
+				t.Errorf("apply: %v, want %v", got.Describe(), tc.want.Describe())
+			}
+		})
+	}
+}
+
+func TestContainerCovers(t *testing.T) {
+	s := &fst.ContainerConfig{
+		Filesystem: []*fst.FilesystemConfig{
+			nil,
+			{Src: "/sys/dev"},
+			{Src: "/nix/store/opengl-driver", Dst: "/run/opengl-driver-32/"},
+		},
+		Link: [][2]string{{"/nix/store/opengl-driver", "/run/opengl-driver"}},
+	}
+
+	testCases := []struct {
+		name string
+		want bool
+	}{
+		{"/sys/dev", true},
+		{"/sys/dev/char/226:128", true},
+		{"/sys/devices/pci0000:00/0000:00:02.0", false},
+		{"/run/opengl-driver", true},
+		{"/run/opengl-driver-32", true},
+		{"/run/opengl-driver-64", false},
+		{"/dev/dri/renderD128", false},
+	}
+	for _, tc := range testCases {
+		if got := containerCovers(s, tc.name); got != tc.want {
+			t.Errorf("containerCovers(%q): %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"path"
+
+	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/internal/fmsg"
+	"git.gensokyo.uk/security/fortify/sandbox/usernet"
+)
+
+var ErrUsernet = errors.New("invalid user-mode networking configuration")
+
+// NewUsernet returns the user-mode networking helper configuration described by c.
+func NewUsernet(c *fst.UsernetConfig) (*usernet.Config, error) {
+	config := usernet.NewConfig()
+	config.HostLoopback = c.HostLoopback
+	config.Verbose = fmsg.Load()
+
+	if c.Proxy != "" {
+		if c.HostLoopback || c.Allow != nil {
+			return nil, fmsg.WrapError(ErrUsernet,
+				"proxy cannot be combined with host loopback or an allowlist")
+		}
+		if path.IsAbs(c.Proxy) {
+			if path.Clean(c.Proxy) != c.Proxy {
+				return nil, fmsg.WrapError(ErrUsernet,
+					fmt.Sprintf("proxy socket path %q is not clean", c.Proxy))
+			}
+		} else if a, err := netip.ParseAddrPort(c.Proxy); err != nil || !a.Addr().IsLoopback() {
+			return nil, fmsg.WrapError(ErrUsernet,
+				fmt.Sprintf("proxy %q is not a loopback address or unix socket", c.Proxy))
+		}
+		config.Proxy = c.Proxy
+	}
+
+	if c.Allow != nil {
+		config.Restrict = true
+		config.Allow = make([]usernet.Rule, len(c.Allow))
+		for i, s := range c.Allow {
+			if r, err := usernet.ParseRule(s); err != nil {
+				return nil, fmsg.WrapError(err, err.Error())
+			} else {
+				config.Allow[i] = r
+			}
+		}
+	}
+
+	return config, nil
+}
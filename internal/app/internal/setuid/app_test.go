@@ -33,6 +33,7 @@ func TestApp(t *testing.T) {
 			var (
 				gotSys       *system.I
 				gotContainer *sandbox.Params
+				gotPlan      *app.Plan
 			)
 			if !t.Run("seal", func(t *testing.T) {
 				if sa, err := a.Seal(tc.config); err != nil {
@@ -40,6 +41,7 @@ func TestApp(t *testing.T) {
 					return
 				} else {
 					gotSys, gotContainer = setuid.AppIParams(a, sa)
+					gotPlan = sa.Plan()
 				}
 			}) {
 				return
@@ -58,6 +60,27 @@ func TestApp(t *testing.T) {
 						mustMarshal(gotContainer), mustMarshal(tc.wantContainer))
 				}
 			})
+
+			t.Run("plan", func(t *testing.T) {
+				if gotPlan.ID != tc.id.String() {
+					t.Errorf("Plan: ID = %s, want %s", gotPlan.ID, tc.id.String())
+				}
+				if gotPlan.Uid != tc.wantSys.UID() {
+					t.Errorf("Plan: Uid = %d, want %d", gotPlan.Uid, tc.wantSys.UID())
+				}
+				wantOps := tc.wantSys.Ops()
+				if len(gotPlan.System) != len(wantOps) {
+					t.Fatalf("Plan: System = %s, want %d ops", mustMarshal(gotPlan.System), len(wantOps))
+				}
+				for i, o := range wantOps {
+					if got := gotPlan.System[i]; got.Op != system.OpName(o) || got.Path != o.Path() {
+						t.Errorf("Plan: System[%d] = %s, want %s on %q", i, mustMarshal(got), system.OpName(o), o.Path())
+					}
+				}
+				if want := tc.wantContainer.Ops.Describe(); !reflect.DeepEqual(gotPlan.Container, want) {
+					t.Errorf("Plan: Container =\n%s\n, want\n%s", mustMarshal(gotPlan.Container), mustMarshal(want))
+				}
+			})
 		})
 	}
 }
@@ -22,3 +22,5 @@ func AppIParams(a App, sa SealedApp) (*system.I, *sandbox.Params) {
 	}
 	return seal.sys, seal.container
 }
+
+var RelaySignals = relaySignals
@@ -0,0 +1,31 @@
+package setuid
+
+import (
+	. "git.gensokyo.uk/security/fortify/internal/app"
+	"git.gensokyo.uk/security/fortify/system"
+)
+
+func (seal *outcome) Plan() *Plan {
+	id := seal.id.unwrap()
+	p := &Plan{
+		ID:   id.String(),
+		Uid:  seal.user.uid.unwrap(),
+		Path: seal.container.Path,
+		Args: seal.container.Args,
+		Env:  seal.container.Env,
+
+		Container: seal.container.Ops.Describe(),
+	}
+
+	ops := seal.sys.Ops()
+	p.System = make([]*PlanOp, len(ops))
+	for i, o := range ops {
+		p.System[i] = &PlanOp{
+			Op:    system.OpName(o),
+			Type:  system.TypeString(o.Type()),
+			Path:  o.Path(),
+			Value: o.String(),
+		}
+	}
+	return p
+}
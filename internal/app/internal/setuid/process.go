@@ -3,10 +3,13 @@ package setuid
 import (
 	"context"
 	"encoding/gob"
+	"encoding/json"
 	"errors"
+	"io"
 	"log"
 	"os"
 	"os/exec"
+	"path"
 	"strconv"
 	"strings"
 	"syscall"
@@ -17,6 +20,7 @@ import (
 	"git.gensokyo.uk/security/fortify/internal/fmsg"
 	"git.gensokyo.uk/security/fortify/internal/state"
 	"git.gensokyo.uk/security/fortify/sandbox"
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
 	"git.gensokyo.uk/security/fortify/system"
 )
 
@@ -71,7 +75,7 @@ func (seal *outcome) Run(rs *RunState) error {
 						}
 					}
 				}
-				ec |= rt ^ (system.EWayland | system.EX11 | system.EDBus | system.EPulse)
+				ec |= rt ^ (system.EWayland | system.EX11 | system.EDBus | system.EPulse | system.EPipeWire)
 				if fmsg.Load() {
 					if ec > 0 {
 						fmsg.Verbose("reverting operations scope", system.TypeString(ec))
@@ -107,6 +111,95 @@ func (seal *outcome) Run(rs *RunState) error {
 		}
 	}
 
+	// audit records are received from the shim over a pipe as the target user has no access to the run directory
+	var (
+		auditDone chan struct{}
+		auditPipe *os.File
+	)
+	auditFd := -1
+	if seal.container.Seccomp&seccomp.FilterAudit != 0 {
+		id := seal.id.unwrap()
+		if f, err := OpenAudit(seal.runDirPath, &id); err != nil {
+			return fmsg.WrapErrorSuffix(err,
+				"cannot open audit report:")
+		} else if r, w, err := os.Pipe(); err != nil {
+			_ = f.Close()
+			return fmsg.WrapErrorSuffix(err,
+				"cannot create audit pipe:")
+		} else {
+			auditFd = 3 + len(cmd.ExtraFiles)
+			cmd.ExtraFiles = append(cmd.ExtraFiles, w)
+			auditPipe = w
+
+			auditDone = make(chan struct{})
+			go func() { receiveAudit(r, f); close(auditDone) }()
+		}
+	}
+
+	// signals are relayed by the shim as the privileged user cannot signal processes of the target user
+	var (
+		signalPipe *os.File
+		signalFd   int
+	)
+	{
+		id := seal.id.unwrap()
+		if f, err := OpenSignal(seal.runDirPath, &id); err != nil {
+			return fmsg.WrapErrorSuffix(err,
+				"cannot create signal relay:")
+		} else {
+			signalFd = 3 + len(cmd.ExtraFiles)
+			cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+			signalPipe = f
+			defer func() {
+				// already removed if the shim stopped reading
+				if removeErr := os.Remove(SignalPath(seal.runDirPath, &id)); removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+					log.Printf("cannot remove signal relay: %v", removeErr)
+				}
+			}()
+		}
+
+		// refuse signals as soon as the shim stops reading instead of when the instance exits
+		if removed, err := RemoveSignalOnClose(seal.runDirPath, &id); err != nil {
+			_ = signalPipe.Close()
+			return fmsg.WrapErrorSuffix(err,
+				"cannot watch signal relay:")
+		} else {
+			go func() {
+				if removeErr := <-removed; removeErr != nil && !errors.Is(removeErr, os.ErrNotExist) {
+					log.Printf("cannot remove signal relay: %v", removeErr)
+				}
+			}()
+		}
+	}
+
+	// the shim passes exec connections to the container as the target user has no access to the run directory
+	var (
+		execSocket *os.File
+		execFd     int
+	)
+	{
+		id := seal.id.unwrap()
+		if l, err := ListenExec(seal.runDirPath, &id); err != nil {
+			return fmsg.WrapErrorSuffix(err,
+				"cannot create exec socket:")
+		} else {
+			// closing the listener removes the socket, the shim accepts on its own copy
+			defer func() {
+				if closeErr := l.Close(); closeErr != nil {
+					log.Printf("cannot remove exec socket: %v", closeErr)
+				}
+			}()
+			if f, err := l.File(); err != nil {
+				return fmsg.WrapErrorSuffix(err,
+					"cannot duplicate exec socket:")
+			} else {
+				execFd = 3 + len(cmd.ExtraFiles)
+				cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+				execSocket = f
+			}
+		}
+	}
+
 	if len(seal.user.supp) > 0 {
 		fmsg.Verbosef("attaching supplementary group ids %s", seal.user.supp)
 		// interpreted by fsu
@@ -115,16 +208,43 @@ func (seal *outcome) Run(rs *RunState) error {
 
 	fmsg.Verbosef("setuid helper at %s", fsuPath)
 	fmsg.Suspend()
-	if err := cmd.Start(); err != nil {
+	err := cmd.Start()
+	// the shim holds its own copy of these files
+	if auditPipe != nil {
+		if closeErr := auditPipe.Close(); closeErr != nil {
+			log.Printf("cannot close audit pipe: %v", closeErr)
+		}
+	}
+	if closeErr := signalPipe.Close(); closeErr != nil {
+		log.Printf("cannot close signal relay: %v", closeErr)
+	}
+	if closeErr := execSocket.Close(); closeErr != nil {
+		log.Printf("cannot close exec socket: %v", closeErr)
+	}
+	if err != nil {
 		return fmsg.WrapErrorSuffix(err,
 			"cannot start setuid wrapper:")
 	}
 	rs.SetStart()
 
+	// the shim is attached before receiving setup params so the container is created within the cgroup;
+	// CLONE_INTO_CGROUP is not used as the target user has no access to the delegated subtree
+	if seal.cgroup != "" {
+		fmsg.Verbosef("attaching process %d to cgroup %q", cmd.Process.Pid, seal.cgroup)
+		if err := os.WriteFile(path.Join(seal.cgroup, "cgroup.procs"),
+			[]byte(strconv.Itoa(cmd.Process.Pid)), 0); err != nil {
+			fmsg.Resume()
+			return fmsg.WrapErrorSuffix(err,
+				"cannot attach shim to cgroup:")
+		}
+	}
+
 	// this prevents blocking forever on an early failure
 	waitErr, setupErr := make(chan error, 1), make(chan error, 1)
 	go func() { waitErr <- cmd.Wait(); cancel() }()
-	go func() { setupErr <- e.Encode(&shimParams{os.Getpid(), seal.container, seal.user.data, fmsg.Load()}) }()
+	go func() {
+		setupErr <- e.Encode(&shimParams{os.Getpid(), seal.container, seal.usernet, seal.supervise, seal.user.data, auditFd, signalFd, execFd, fmsg.Load()})
+	}()
 
 	select {
 	case err := <-setupErr:
@@ -182,6 +302,13 @@ func (seal *outcome) Run(rs *RunState) error {
 	}
 
 	fmsg.Resume()
+	if auditDone != nil {
+		select {
+		case <-auditDone:
+		case <-time.After(shimWaitTimeout):
+			log.Printf("audit pipe not closed by process %d", cmd.Process.Pid)
+		}
+	}
 	if seal.sync != nil {
 		if err := seal.sync.Close(); err != nil {
 			log.Printf("cannot close wayland security context: %v", err)
@@ -193,3 +320,30 @@ func (seal *outcome) Run(rs *RunState) error {
 
 	return earlyStoreErr.equiv("cannot save process state:")
 }
+
+// receiveAudit appends audit records received from the shim over r to the report f until r is closed.
+func receiveAudit(r, f *os.File) {
+	defer func() {
+		if err := r.Close(); err != nil {
+			log.Printf("cannot close audit pipe: %v", err)
+		}
+		if err := f.Close(); err != nil {
+			log.Printf("cannot close audit report: %v", err)
+		}
+	}()
+
+	d, e := gob.NewDecoder(r), json.NewEncoder(f)
+	for {
+		record := new(AuditRecord)
+		if err := d.Decode(record); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("cannot receive audit record: %v", err)
+			}
+			return
+		}
+		fmsg.Verbosef("syscall %s denied by filter", record.Syscall)
+		if err := e.Encode(record); err != nil {
+			log.Printf("cannot write audit record: %v", err)
+		}
+	}
+}
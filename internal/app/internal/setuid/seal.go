@@ -25,6 +25,8 @@ import (
 	"git.gensokyo.uk/security/fortify/internal/fmsg"
 	"git.gensokyo.uk/security/fortify/internal/sys"
 	"git.gensokyo.uk/security/fortify/sandbox"
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+	"git.gensokyo.uk/security/fortify/sandbox/usernet"
 	"git.gensokyo.uk/security/fortify/sandbox/wl"
 	"git.gensokyo.uk/security/fortify/system"
 )
@@ -44,6 +46,8 @@ const (
 	pulseServer = "PULSE_SERVER"
 	pulseCookie = "PULSE_COOKIE"
 
+	pipewireRemote = "PIPEWIRE_REMOTE"
+
 	dbusSessionBusAddress = "DBUS_SESSION_BUS_ADDRESS"
 	dbusSystemBusAddress  = "DBUS_SYSTEM_BUS_ADDRESS"
 )
@@ -59,6 +63,8 @@ var (
 	ErrPulseCookie = errors.New("pulse cookie not present")
 	ErrPulseSocket = errors.New("pulse socket not present")
 	ErrPulseMode   = errors.New("unexpected pulse socket mode")
+
+	ErrPipeWireSocket = errors.New("pipewire socket not present")
 )
 
 var posixUsername = regexp.MustCompilePOSIX("^[a-z_]([A-Za-z0-9_-]{0,31}|[A-Za-z0-9_-]{0,30}\\$)$")
@@ -83,6 +89,12 @@ type outcome struct {
 	container *sandbox.Params
 	env       map[string]string
 	sync      *os.File
+	// host path of instance cgroup, empty if unused
+	cgroup string
+	// user-mode networking helper configuration, nil if unused
+	usernet *usernet.Config
+	// supervisor rules of syscalls passed to the shim
+	supervise []seccomp.NotifyRule
 
 	f atomic.Bool
 }
@@ -152,6 +164,24 @@ type fsuUser struct {
 	username string
 }
 
+// vars returns values of variables available to [fst.Config.Expand].
+// Must be called after target uid is resolved.
+func (seal *outcome) vars(sys sys.State, config *fst.Config) map[string]string {
+	sc := sys.Paths()
+	vars := map[string]string{
+		fst.VarUid:      seal.user.uid.String(),
+		fst.VarAid:      seal.user.aid.String(),
+		fst.VarID:       config.ID,
+		fst.VarInstance: seal.id.String(),
+		fst.VarRuntime:  sc.RuntimePath,
+		fst.VarShare:    sc.SharePath,
+	}
+	if h, ok := sys.LookupEnv(home); ok && path.IsAbs(h) {
+		vars[fst.VarHome] = h
+	}
+	return vars
+}
+
 func (seal *outcome) finalise(ctx context.Context, sys sys.State, config *fst.Config) error {
 	if seal.ctx != nil {
 		panic("finalise called twice")
@@ -174,12 +204,21 @@ func (seal *outcome) finalise(ctx context.Context, sys sys.State, config *fst.Co
 			fmt.Sprintf("identity %d out of range", config.Identity))
 	}
 
-	seal.user = fsuUser{
-		aid:      newInt(config.Identity),
-		data:     config.Data,
-		home:     config.Dir,
-		username: config.Username,
+	seal.user = fsuUser{aid: newInt(config.Identity)}
+	if u, err := sys.Uid(seal.user.aid.unwrap()); err != nil {
+		return err
+	} else {
+		seal.user.uid = newInt(u)
+	}
+
+	// config is clobbered during seal, it is safe to expand in place
+	if err := config.Expand(seal.vars(sys, config)); err != nil {
+		return fmsg.WrapError(err, err.Error())
 	}
+
+	seal.user.data = config.Data
+	seal.user.home = config.Dir
+	seal.user.username = config.Username
 	if seal.user.username == "" {
 		seal.user.username = "chronos"
 	} else if !posixUsername.MatchString(seal.user.username) ||
@@ -194,11 +233,6 @@ func (seal *outcome) finalise(ctx context.Context, sys sys.State, config *fst.Co
 	if seal.user.home == "" {
 		seal.user.home = seal.user.data
 	}
-	if u, err := sys.Uid(seal.user.aid.unwrap()); err != nil {
-		return err
-	} else {
-		seal.user.uid = newInt(u)
-	}
 	seal.user.supp = make([]string, len(config.Groups))
 	for i, name := range config.Groups {
 		if g, err := sys.LookupGroup(name); err != nil {
@@ -281,7 +315,7 @@ func (seal *outcome) finalise(ctx context.Context, sys sys.State, config *fst.Co
 	{
 		var uid, gid int
 		var err error
-		seal.container, seal.env, err = common.NewContainer(config.Container, sys, &uid, &gid)
+		seal.container, seal.env, err = common.NewContainer(config.Container, sys, seal.user.data, &uid, &gid)
 		if err != nil {
 			return fmsg.WrapErrorSuffix(err,
 				"cannot initialise container configuration:")
@@ -295,6 +329,7 @@ func (seal *outcome) finalise(ctx context.Context, sys sys.State, config *fst.Co
 		}
 		seal.container.Path = config.Path
 		seal.container.Args = config.Args
+		seal.supervise = config.Container.SeccompSupervise
 
 		mapuid = newInt(uid)
 		mapgid = newInt(gid)
@@ -315,6 +350,19 @@ func (seal *outcome) finalise(ctx context.Context, sys sys.State, config *fst.Co
 		seal.container.Etc(etcPath, seal.id.String())
 	}
 
+	if config.Container.Usernet != nil {
+		if c, err := common.NewUsernet(config.Container.Usernet); err != nil {
+			return err
+		} else {
+			seal.usernet = c
+		}
+		// host nameservers are unreachable from the private net namespace,
+		// and no nameserver is reachable in proxy-only mode where names are resolved by the proxy
+		if seal.usernet.Proxy == "" {
+			seal.container.Place("/etc/resolv.conf", []byte("nameserver "+seal.usernet.DNS.String()+"\n"))
+		}
+	}
+
 	// inner XDG_RUNTIME_DIR default formatting of `/run/user/%d` as mapped uid
 	innerRuntimeDir := path.Join("/run/user", mapuid.String())
 	seal.container.Tmpfs("/run/user", 1<<12, 0755)
@@ -458,6 +506,37 @@ func (seal *outcome) finalise(ctx context.Context, sys sys.State, config *fst.Co
 		}
 	}
 
+	if config.Enablements&system.EPipeWire != 0 {
+		// PipeWire socket (usually `/run/user/%d/pipewire-0`)
+		var pipewireSocket string
+		if name, ok := sys.LookupEnv(pipewireRemote); !ok || name == "" {
+			pipewireSocket = path.Join(share.sc.RuntimePath, "pipewire-0")
+		} else if !path.IsAbs(name) {
+			pipewireSocket = path.Join(share.sc.RuntimePath, name)
+		} else {
+			pipewireSocket = name
+		}
+
+		if s, err := sys.Stat(pipewireSocket); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				return fmsg.WrapErrorSuffix(err,
+					fmt.Sprintf("cannot access PipeWire socket %q:", pipewireSocket))
+			}
+			return fmsg.WrapError(ErrPipeWireSocket,
+				fmt.Sprintf("PipeWire socket %q not found", pipewireSocket))
+		} else if s.Mode()&fs.ModeSocket == 0 {
+			return fmsg.WrapError(ErrPipeWireSocket,
+				fmt.Sprintf("%q is not a socket", pipewireSocket))
+		}
+
+		// hard link pipewire socket into target-executable share
+		outerPipeWireSocket := path.Join(share.runtime(), "pipewire")
+		innerPipeWireSocket := path.Join(innerRuntimeDir, "pipewire-0")
+		seal.sys.PipeWire(outerPipeWireSocket, pipewireSocket)
+		seal.container.Bind(outerPipeWireSocket, innerPipeWireSocket, 0)
+		seal.env[pipewireRemote] = innerPipeWireSocket
+	}
+
 	if config.Enablements&system.EDBus != 0 {
 		// ensure dbus session bus defaults
 		if config.SessionBus == nil {
@@ -469,14 +548,30 @@ func (seal *outcome) finalise(ctx context.Context, sys sys.State, config *fst.Co
 		sessionPath, systemPath := path.Join(sharePath, "bus"), path.Join(sharePath, "system_bus_socket")
 
 		// configure dbus proxy
-		if f, err := seal.sys.ProxyDBus(
-			config.SessionBus, config.SystemBus,
-			sessionPath, systemPath,
-		); err != nil {
-			return err
+		var (
+			f   func()
+			err error
+		)
+		if config.DBusLearn == "" {
+			f, err = seal.sys.ProxyDBus(
+				config.SessionBus, config.SystemBus,
+				sessionPath, systemPath,
+			)
 		} else {
-			seal.dbusMsg = f
+			if !path.IsAbs(config.DBusLearn) {
+				return fmsg.WrapError(syscall.EINVAL,
+					fmt.Sprintf("learned D-Bus configuration path %q is not absolute", config.DBusLearn))
+			}
+			// proxies permit all traffic and record a configuration permitting it
+			f, err = seal.sys.LearnDBus(
+				config.SessionBus, config.SystemBus,
+				sessionPath, systemPath, config.DBusLearn,
+			)
+		}
+		if err != nil {
+			return err
 		}
+		seal.dbusMsg = f
 
 		// share proxy sockets
 		sessionInner := path.Join(innerRuntimeDir, "bus")
@@ -495,6 +590,16 @@ func (seal *outcome) finalise(ctx context.Context, sys sys.State, config *fst.Co
 		seal.container.Tmpfs(dest, 1<<13, 0755)
 	}
 
+	if config.Container.Cgroup != nil {
+		if files, err := common.CgroupFiles(config.Container.Cgroup); err != nil {
+			return err
+		} else if seal.cgroup, err = common.CgroupPath(config.Container.Cgroup, seal.id.String()); err != nil {
+			return err
+		} else {
+			seal.sys.Cgroup(system.Process, seal.cgroup, files)
+		}
+	}
+
 	// append ExtraPerms last
 	for _, p := range config.ExtraPerms {
 		if p == nil {
@@ -2,18 +2,28 @@ package setuid
 
 import (
 	"context"
+	"encoding/gob"
 	"errors"
+	"io"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path"
+	"path/filepath"
+	"strconv"
 	"syscall"
 	"time"
 
+	"git.gensokyo.uk/security/fortify/helper"
 	"git.gensokyo.uk/security/fortify/internal"
+	. "git.gensokyo.uk/security/fortify/internal/app"
 	"git.gensokyo.uk/security/fortify/internal/fmsg"
+	"git.gensokyo.uk/security/fortify/ldd"
 	"git.gensokyo.uk/security/fortify/sandbox"
 	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+	"git.gensokyo.uk/security/fortify/sandbox/usernet"
 )
 
 /*
@@ -75,8 +85,18 @@ type shimParams struct {
 
 	// finalised container params
 	Container *sandbox.Params
+	// user-mode networking helper config, nil if unused
+	Usernet *usernet.Config
+	// rules of syscalls passed to the supervisor
+	Supervise []seccomp.NotifyRule
 	// path to outer home directory
 	Home string
+	// audit record pipe fd, only valid if the syscall filter is in audit mode
+	Audit int
+	// signal relay fd
+	Signal int
+	// exec socket fd
+	Exec int
 
 	// verbosity pass through
 	Verbose bool
@@ -122,6 +142,13 @@ func ShimMain() {
 		log.Fatal("invalid container params")
 	}
 
+	// inherited from monitor, must not leak into the container
+	syscall.CloseOnExec(params.Signal)
+	syscall.CloseOnExec(params.Exec)
+	if params.Audit >= 0 {
+		syscall.CloseOnExec(params.Audit)
+	}
+
 	// close setup socket
 	if err := closeSetup(); err != nil {
 		log.Printf("cannot close setup pipe: %v", err)
@@ -154,6 +181,7 @@ func ShimMain() {
 	container.Stdin, container.Stdout, container.Stderr = os.Stdin, os.Stdout, os.Stderr
 	container.Cancel = func(cmd *exec.Cmd) error { return cmd.Process.Signal(os.Interrupt) }
 	container.WaitDelay = 2 * time.Second
+	container.Exec = true
 
 	if err := container.Start(); err != nil {
 		fmsg.PrintBaseError(err, "cannot start container:")
@@ -163,11 +191,41 @@ func ShimMain() {
 		fmsg.PrintBaseError(err, "cannot configure container:")
 	}
 
-	if err := seccomp.Load(seccomp.PresetCommon); err != nil {
+	startSignalRelay(container, params.Signal)
+	startExecRelay(container, params.Exec)
+
+	// started before the syscall filter is loaded and outlives the container
+	var net helper.Helper
+	netCtx, netCancel := context.WithCancel(ctx)
+	if params.Usernet != nil {
+		net = startUsernet(netCtx, container, params.Usernet)
+	}
+
+	// receives the listener before the container can exit
+	var supervisorDone <-chan struct{}
+	if seccomp.Notifies(params.Container.Seccomp, params.Container.SeccompRules) {
+		supervisorDone = startSupervisor(container, params.Supervise, params.Audit)
+	}
+
+	if err := seccomp.Load(seccomp.PresetCommon, nil); err != nil {
 		log.Fatalf("cannot load syscall filter: %v", err)
 	}
 
-	if err := container.Wait(); err != nil {
+	err := container.Wait()
+	netCancel()
+	if supervisorDone != nil {
+		select {
+		case <-supervisorDone:
+		case <-time.After(container.WaitDelay):
+			log.Printf("syscall filter still in use after container exit")
+		}
+	}
+	if net != nil {
+		if waitErr := net.Wait(); waitErr != nil && !errors.Is(waitErr, context.Canceled) {
+			log.Printf("user-mode networking helper: %v", waitErr)
+		}
+	}
+	if err != nil {
 		var exitError *exec.ExitError
 		if !errors.As(err, &exitError) {
 			if errors.Is(err, context.Canceled) {
@@ -179,3 +237,215 @@ func ShimMain() {
 		os.Exit(exitError.ExitCode())
 	}
 }
+
+// startSignalRelay delivers each signal number read from fd to init of container.
+func startSignalRelay(container *sandbox.Container, fd int) {
+	go relaySignals(os.NewFile(uintptr(fd), "signal"), container.Signal)
+}
+
+// relaySignals delivers each signal number read from f via signal until a read fails.
+// f is closed on return so the monitor removes the FIFO and further signals are refused.
+func relaySignals(f *os.File, signal func(sig syscall.Signal) error) {
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("cannot close signal relay: %v", err)
+		}
+	}()
+
+	buf := make([]byte, 1)
+	for {
+		if _, err := f.Read(buf); err != nil {
+			log.Printf("cannot read from signal relay: %v", err)
+			return
+		}
+		sig := syscall.Signal(buf[0])
+		fmsg.Verbosef("relaying %s to container", sig)
+		if err := signal(sig); err != nil {
+			log.Printf("cannot relay %s: %v", sig, err)
+		}
+	}
+}
+
+// startExecRelay passes each connection accepted on the exec socket fd to container.
+func startExecRelay(container *sandbox.Container, fd int) {
+	f := os.NewFile(uintptr(fd), "exec")
+	l, err := net.FileListener(f)
+	if closeErr := f.Close(); closeErr != nil {
+		log.Printf("cannot close exec socket: %v", closeErr)
+	}
+	if err != nil {
+		log.Printf("cannot accept exec connections: %v", err)
+		return
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				log.Printf("cannot accept exec connection: %v", err)
+				return
+			}
+			if f, err := conn.(*net.UnixConn).File(); err != nil {
+				log.Printf("cannot duplicate exec connection: %v", err)
+			} else {
+				fmsg.Verbose("passing exec connection to container")
+				if err = container.SendExec(f); err != nil {
+					log.Printf("cannot pass exec connection: %v", err)
+				}
+				_ = f.Close()
+			}
+			_ = conn.Close()
+		}
+	}()
+}
+
+// startUsernet starts the user-mode networking helper serving the TUN device of container.
+func startUsernet(ctx context.Context, container *sandbox.Container, c *usernet.Config) helper.Helper {
+	tun, err := container.ReceiveTun()
+	if err != nil {
+		fmsg.PrintBaseError(err, "cannot receive tun device:")
+		os.Exit(1)
+	}
+
+	toolPath := sandbox.MustExecutable()
+	var libPaths []string
+	if entries, err := ldd.ExecFilter(ctx, nil, nil, toolPath); err != nil {
+		fmsg.PrintBaseError(err, "cannot determine libraries of user-mode networking helper:")
+		os.Exit(1)
+	} else {
+		libPaths = ldd.Path(entries)
+	}
+
+	h := helper.New(ctx, toolPath, c, true, func(argsFd, statFd int) []string {
+		return []string{"usernet", "--args=" + strconv.Itoa(argsFd), "--fd=" + strconv.Itoa(statFd)}
+	}, func(container *sandbox.Container) {
+		// connections are made from the host net namespace
+		container.Flags |= sandbox.FAllowNet
+		container.Hostname = "fortify-usernet"
+		container.Stdout, container.Stderr = os.Stderr, os.Stderr
+
+		// these lib paths are unpredictable, so mount them first so they cannot cover anything
+		for _, name := range libPaths {
+			container.Bind(name, name, 0)
+		}
+
+		// host resolv.conf is read on every query and might be replaced, so its directory is made available
+		if c.Nameserver == "" {
+			if name, err := filepath.EvalSymlinks("/etc/resolv.conf"); err == nil {
+				container.Bind(path.Dir(name), path.Dir(name), 0)
+				if path.Dir(name) != "/etc" {
+					container.Link(name, "/etc/resolv.conf")
+				}
+			}
+		}
+		// proxy socket directory
+		if path.IsAbs(c.Proxy) {
+			container.Bind(path.Dir(c.Proxy), path.Dir(c.Proxy), 0)
+		}
+
+		// helper bin path
+		binPath := path.Dir(toolPath)
+		container.Bind(binPath, binPath, 0)
+	}, []*os.File{tun})
+	if err = h.Start(); err != nil {
+		fmsg.PrintBaseError(err, "cannot start user-mode networking helper:")
+		os.Exit(1)
+	}
+	fmsg.Verbosef("started user-mode networking helper %s", h)
+
+	// helper holds its own copy
+	if err = tun.Close(); err != nil {
+		log.Printf("cannot close tun device: %v", err)
+	}
+	return h
+}
+
+// startSupervisor responds to syscalls passed to the supervisor by the syscall filter of container according to
+// rules, and to syscalls matching no rule according to the rest of the filter. In audit mode, syscalls denied by
+// the filter are permitted and a record of each distinct occurrence is sent to the monitor via auditFd. The returned channel is closed once no process is using the filter.
+func startSupervisor(container *sandbox.Container, rules []seccomp.NotifyRule, auditFd int) <-chan struct{} {
+	var audit *gob.Encoder
+	if container.Seccomp&seccomp.FilterAudit != 0 {
+		f := os.NewFile(uintptr(auditFd), "audit")
+		defer func() {
+			if err := f.Close(); err != nil {
+				log.Printf("cannot close audit pipe: %v", err)
+			}
+		}()
+		audit = gob.NewEncoder(f)
+	}
+
+	l, err := container.ReceiveListener()
+	if err != nil {
+		fmsg.PrintBaseError(err, "cannot receive seccomp listener:")
+		os.Exit(1)
+	}
+	s, err := container.NewSupervisor(l, rules)
+	if err != nil {
+		// unreachable: rules are validated during seal
+		log.Fatalf("cannot create syscall supervisor: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			if closeErr := l.Close(); closeErr != nil {
+				log.Printf("cannot close seccomp listener: %v", closeErr)
+			}
+		}()
+
+		type key struct {
+			arch uint32
+			nr   int32
+			args [6]uint64
+		}
+		seen := make(map[key]struct{})
+		var n seccomp.Notif
+		for {
+			if err := l.Receive(&n); err != nil {
+				if !errors.Is(err, io.EOF) {
+					log.Printf("cannot receive seccomp notification: %v", err)
+				}
+				return
+			}
+			name := seccomp.SyscallName(n.Data.Arch, n.Data.Nr)
+
+			resp, err := s.Match(&n, name)
+			if err != nil {
+				if errors.Is(err, syscall.ENOENT) {
+					continue
+				}
+				log.Printf("cannot supervise %s from process %d: %v", name, n.Pid, err)
+			}
+			if resp != nil {
+				fmsg.Verbosef("supervised %s from process %d", name, n.Pid)
+			} else if resp = s.Fallback(&n); audit != nil && resp.Flags&seccomp.NotifFlagContinue == 0 {
+				// syscalls denied by the filter are permitted in audit mode
+				resp = &seccomp.NotifResp{ID: n.ID, Flags: seccomp.NotifFlagContinue}
+
+				k := key{n.Data.Arch, n.Data.Nr, n.Data.Args}
+				if _, ok := seen[k]; !ok {
+					seen[k] = struct{}{}
+					record := &AuditRecord{
+						Time:    time.Now().UTC(),
+						Pid:     n.Pid,
+						Arch:    seccomp.ArchName(n.Data.Arch),
+						Syscall: name,
+						Nr:      n.Data.Nr,
+						Args:    n.Data.Args,
+					}
+					fmsg.Verbosef("permitted %s on %s from process %d", record.Syscall, record.Arch, record.Pid)
+					if err = audit.Encode(record); err != nil {
+						log.Printf("cannot send audit record: %v", err)
+					}
+				}
+			}
+
+			if err = l.Respond(resp); err != nil && !errors.Is(err, syscall.ENOENT) {
+				log.Printf("cannot respond to seccomp notification: %v", err)
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,64 @@
+package setuid_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	. "git.gensokyo.uk/security/fortify/internal/app"
+	"git.gensokyo.uk/security/fortify/internal/app/internal/setuid"
+)
+
+func TestRelaySignals(t *testing.T) {
+	runDirPath := t.TempDir()
+	id := new(ID)
+	if err := NewAppID(id); err != nil {
+		t.Fatalf("NewAppID: error = %v", err)
+	}
+
+	f, err := OpenSignal(runDirPath, id)
+	if err != nil {
+		t.Fatalf("OpenSignal: error = %v", err)
+	}
+	removed, err := RemoveSignalOnClose(runDirPath, id)
+	if err != nil {
+		t.Fatalf("RemoveSignalOnClose: error = %v", err)
+	}
+
+	signals := make(chan syscall.Signal, 1)
+	relayDone := make(chan struct{})
+	go func() {
+		setuid.RelaySignals(f, func(sig syscall.Signal) error { signals <- sig; return nil })
+		close(relayDone)
+	}()
+
+	if err = SendSignal(runDirPath, id, syscall.SIGTERM); err != nil {
+		t.Fatalf("SendSignal: error = %v", err)
+	}
+	if sig := <-signals; sig != syscall.SIGTERM {
+		t.Fatalf("relaySignals: %v, want %v", sig, syscall.SIGTERM)
+	}
+
+	// fail the next read
+	if err = f.SetReadDeadline(time.Now()); err != nil {
+		t.Fatalf("SetReadDeadline: error = %v", err)
+	}
+	<-relayDone
+	select {
+	case err = <-removed:
+		if err != nil {
+			t.Fatalf("RemoveSignalOnClose: error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal relay not removed")
+	}
+
+	if err = SendSignal(runDirPath, id, syscall.SIGTERM); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("SendSignal: error = %v, want %v", err, ErrNotRunning)
+	}
+	if _, err = os.Stat(SignalPath(runDirPath, id)); !os.IsNotExist(err) {
+		t.Errorf("Stat: error = %v", err)
+	}
+}
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"errors"
+	"os"
+	"path"
+	"syscall"
+
+	"git.gensokyo.uk/security/fortify/helper"
+)
+
+// ErrNotRunning is returned by [SendSignal] and [DialExec] if an instance is not running.
+var ErrNotRunning = errors.New("instance is not running")
+
+// SignalPath returns the path to the signal relay FIFO of instance id.
+// Each byte written to the FIFO is delivered to the container of the instance as a signal number.
+func SignalPath(runDirPath string, id *ID) string {
+	return path.Join(runDirPath, "signal", id.String())
+}
+
+// OpenSignal creates and opens the signal relay FIFO of instance id for reading.
+// The FIFO is opened for writing as well so reads never return EOF.
+func OpenSignal(runDirPath string, id *ID) (*os.File, error) {
+	if err := os.MkdirAll(path.Join(runDirPath, "signal"), 0700); err != nil {
+		return nil, err
+	}
+	name := SignalPath(runDirPath, id)
+	if err := syscall.Mkfifo(name, 0600); err != nil {
+		return nil, &os.PathError{Op: "mkfifo", Path: name, Err: err}
+	}
+	return os.OpenFile(name, os.O_RDWR, 0)
+}
+
+// RemoveSignalOnClose removes the signal relay FIFO of instance id once no process has it open for reading.
+// It must be called while the FIFO is open for reading, the returned channel receives the result of the removal.
+func RemoveSignalOnClose(runDirPath string, id *ID) (<-chan error, error) {
+	name := SignalPath(runDirPath, id)
+	f, err := os.OpenFile(name, os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		// the write end reports an error once the last reader is gone
+		err := helper.WaitClose(int(f.Fd()))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			err = os.Remove(name)
+		}
+		done <- err
+	}()
+	return done, nil
+}
+
+// SendSignal delivers sig to the container of instance id via its signal relay FIFO.
+// If sig is 0, SendSignal only checks whether the instance is receiving signals.
+func SendSignal(runDirPath string, id *ID, sig syscall.Signal) error {
+	if sig < 0 || sig > 0xff {
+		return syscall.EINVAL
+	}
+
+	f, err := os.OpenFile(SignalPath(runDirPath, id), os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		// ENXIO: FIFO has no reader
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, syscall.ENXIO) {
+			return ErrNotRunning
+		}
+		return err
+	}
+	if sig != 0 {
+		_, err = f.Write([]byte{byte(sig)})
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,97 @@
+package app_test
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	. "git.gensokyo.uk/security/fortify/internal/app"
+)
+
+func TestSendSignal(t *testing.T) {
+	runDirPath := t.TempDir()
+	id := new(ID)
+	if err := NewAppID(id); err != nil {
+		t.Fatalf("NewAppID: error = %v", err)
+	}
+
+	if err := SendSignal(runDirPath, id, syscall.SIGTERM); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("SendSignal: error = %v, want %v", err, ErrNotRunning)
+	}
+
+	f, err := OpenSignal(runDirPath, id)
+	if err != nil {
+		t.Fatalf("OpenSignal: error = %v", err)
+	}
+	if err = SendSignal(runDirPath, id, 0); err != nil {
+		t.Errorf("SendSignal: error = %v", err)
+	}
+	if err = SendSignal(runDirPath, id, syscall.SIGTERM); err != nil {
+		t.Errorf("SendSignal: error = %v", err)
+	}
+	if err = SendSignal(runDirPath, id, 1<<8); !errors.Is(err, syscall.EINVAL) {
+		t.Errorf("SendSignal: error = %v, want %v", err, syscall.EINVAL)
+	}
+	buf := make([]byte, 2)
+	if n, err := f.Read(buf); err != nil {
+		t.Errorf("Read: error = %v", err)
+	} else if n != 1 || syscall.Signal(buf[0]) != syscall.SIGTERM {
+		t.Errorf("Read: %v, want %v", buf[:n], []byte{byte(syscall.SIGTERM)})
+	}
+
+	if err = f.Close(); err != nil {
+		t.Fatalf("Close: error = %v", err)
+	}
+	if err = SendSignal(runDirPath, id, syscall.SIGTERM); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("SendSignal: error = %v, want %v", err, ErrNotRunning)
+	}
+	if err = os.Remove(SignalPath(runDirPath, id)); err != nil {
+		t.Errorf("Remove: error = %v", err)
+	}
+}
+
+func TestRemoveSignalOnClose(t *testing.T) {
+	runDirPath := t.TempDir()
+	id := new(ID)
+	if err := NewAppID(id); err != nil {
+		t.Fatalf("NewAppID: error = %v", err)
+	}
+
+	if _, err := RemoveSignalOnClose(runDirPath, id); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("RemoveSignalOnClose: error = %v, want %v", err, os.ErrNotExist)
+	}
+
+	f, err := OpenSignal(runDirPath, id)
+	if err != nil {
+		t.Fatalf("OpenSignal: error = %v", err)
+	}
+	removed, err := RemoveSignalOnClose(runDirPath, id)
+	if err != nil {
+		t.Fatalf("RemoveSignalOnClose: error = %v", err)
+	}
+	select {
+	case err = <-removed:
+		t.Fatalf("RemoveSignalOnClose: removed with reader, error = %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if err = SendSignal(runDirPath, id, 0); err != nil {
+		t.Errorf("SendSignal: error = %v", err)
+	}
+
+	if err = f.Close(); err != nil {
+		t.Fatalf("Close: error = %v", err)
+	}
+	select {
+	case err = <-removed:
+		if err != nil {
+			t.Fatalf("RemoveSignalOnClose: error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal relay not removed")
+	}
+	if err = SendSignal(runDirPath, id, syscall.SIGTERM); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("SendSignal: error = %v, want %v", err, ErrNotRunning)
+	}
+}
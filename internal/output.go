@@ -4,6 +4,7 @@ import (
 	"git.gensokyo.uk/security/fortify/internal/fmsg"
 	"git.gensokyo.uk/security/fortify/sandbox"
 	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+	"git.gensokyo.uk/security/fortify/sandbox/usernet"
 	"git.gensokyo.uk/security/fortify/system"
 )
 
@@ -11,6 +12,7 @@ func InstallFmsg(verbose bool) {
 	fmsg.Store(verbose)
 	sandbox.SetOutput(fmsg.Output{})
 	system.SetOutput(fmsg.Output{})
+	usernet.SetOutput(fmsg.Output{})
 	if verbose {
 		seccomp.SetOutput(fmsg.Verbose)
 	}
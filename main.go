@@ -8,8 +8,11 @@ import (
 	"io"
 	"log"
 	"os"
+	"os/exec"
 	"os/signal"
 	"os/user"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"sync"
 	"syscall"
@@ -21,10 +24,12 @@ import (
 	"git.gensokyo.uk/security/fortify/internal"
 	"git.gensokyo.uk/security/fortify/internal/app"
 	"git.gensokyo.uk/security/fortify/internal/app/instance"
+	"git.gensokyo.uk/security/fortify/internal/app/instance/common"
 	"git.gensokyo.uk/security/fortify/internal/fmsg"
 	"git.gensokyo.uk/security/fortify/internal/state"
 	"git.gensokyo.uk/security/fortify/internal/sys"
 	"git.gensokyo.uk/security/fortify/sandbox"
+	"git.gensokyo.uk/security/fortify/sandbox/usernet"
 	"git.gensokyo.uk/security/fortify/system"
 )
 
@@ -76,7 +81,29 @@ func buildCommand(out io.Writer) command.Command {
 
 	c.Command("shim", command.UsageInternal, func([]string) error { instance.ShimMain(); return errSuccess })
 
-	c.Command("app", "Launch app defined by the specified config file", func(args []string) error {
+	{
+		var argsFd, statFd int
+		c.NewCommand("usernet", command.UsageInternal, func([]string) error {
+			usernet.HelperMain(argsFd, statFd, fmsg.Prepare, internal.InstallFmsg)
+			return errSuccess
+		}).
+			Flag(&argsFd, "args", command.IntFlag(-1), "Helper config file descriptor").
+			Flag(&statFd, "fd", command.IntFlag(-1), "Helper status file descriptor")
+	}
+	{
+		var argsFd, statFd int
+		c.NewCommand("dbus", command.UsageInternal, func([]string) error {
+			dbus.HelperMain(argsFd, statFd, fmsg.Prepare)
+			return errSuccess
+		}).
+			Flag(&argsFd, "args", command.IntFlag(-1), "Helper config file descriptor").
+			Flag(&statFd, "fd", command.IntFlag(-1), "Helper status file descriptor")
+	}
+
+	// shared by app and run
+	var flagDryRun bool
+
+	c.NewCommand("app", "Launch app defined by the specified config file", func(args []string) error {
 		if len(args) < 1 {
 			log.Fatal("app requires at least 1 argument")
 		}
@@ -85,9 +112,9 @@ func buildCommand(out io.Writer) command.Command {
 		config := tryPath(args[0])
 		config.Args = append(config.Args, args[1:]...)
 
-		runApp(config)
+		runApp(config, flagDryRun, flagJSON)
 		panic("unreachable")
-	})
+	}).Flag(&flagDryRun, "dry-run", command.BoolFlag(false), "Print planned system and container setup without running")
 
 	{
 		var (
@@ -95,6 +122,7 @@ func buildCommand(out io.Writer) command.Command {
 			dbusConfigSystem  string
 			mpris             bool
 			dbusVerbose       bool
+			dbusLearn         string
 
 			fid      string
 			aid      int
@@ -102,7 +130,7 @@ func buildCommand(out io.Writer) command.Command {
 			homeDir  string
 			userName string
 
-			wayland, x11, dBus, pulse bool
+			wayland, x11, dBus, pulse, pipewire bool
 		)
 
 		c.NewCommand("run", "Configure and start a permissive default sandbox", func(args []string) error {
@@ -171,6 +199,9 @@ func buildCommand(out io.Writer) command.Command {
 			if pulse {
 				config.Enablements |= system.EPulse
 			}
+			if pipewire {
+				config.Enablements |= system.EPipeWire
+			}
 
 			// parse D-Bus config file from flags if applicable
 			if dBus {
@@ -198,10 +229,18 @@ func buildCommand(out io.Writer) command.Command {
 					config.SessionBus.Log = true
 					config.SystemBus.Log = true
 				}
+
+				if dbusLearn != "" {
+					if p, err := filepath.Abs(dbusLearn); err != nil {
+						log.Fatalf("cannot resolve %q: %v", dbusLearn, err)
+					} else {
+						config.DBusLearn = p
+					}
+				}
 			}
 
 			// invoke app
-			runApp(config)
+			runApp(config, flagDryRun, flagJSON)
 			panic("unreachable")
 		}).
 			Flag(&dbusConfigSession, "dbus-config", command.StringFlag("builtin"),
@@ -212,6 +251,8 @@ func buildCommand(out io.Writer) command.Command {
 				"Allow owning MPRIS D-Bus path, has no effect if custom config is available").
 			Flag(&dbusVerbose, "dbus-log", command.BoolFlag(false),
 				"Force buffered logging in the D-Bus proxy").
+			Flag(&dbusLearn, "dbus-learn", command.StringFlag(""),
+				"Permit all D-Bus traffic and write a proxy config permitting it to this path on exit").
 			Flag(&fid, "id", command.StringFlag(""),
 				"Reverse-DNS style Application identifier, leave empty to inherit instance identifier").
 			Flag(&aid, "a", command.IntFlag(0),
@@ -229,7 +270,11 @@ func buildCommand(out io.Writer) command.Command {
 			Flag(&dBus, "dbus", command.BoolFlag(false),
 				"Enable proxied connection to D-Bus").
 			Flag(&pulse, "pulse", command.BoolFlag(false),
-				"Enable direct connection to PulseAudio")
+				"Enable direct connection to PulseAudio").
+			Flag(&pipewire, "pipewire", command.BoolFlag(false),
+				"Enable connection to PipeWire").
+			Flag(&flagDryRun, "dry-run", command.BoolFlag(false),
+				"Print planned system and container setup without running")
 	}
 
 	var showFlagShort bool
@@ -252,12 +297,122 @@ func buildCommand(out io.Writer) command.Command {
 		return errSuccess
 	}).Flag(&showFlagShort, "short", command.BoolFlag(false), "Omit filesystem information")
 
+	c.Command("check", "Validate an app configuration and report risky settings", func(args []string) error {
+		if len(args) != 1 {
+			log.Fatal("check requires 1 argument")
+		}
+		diagnostics := common.CheckConfig(tryPath(args[0]), std)
+		printCheck(os.Stdout, diagnostics, flagJSON)
+		if slices.ContainsFunc(diagnostics, func(d *common.Diagnostic) bool { return d.Severity == common.SeverityError }) {
+			internal.Exit(1)
+		}
+		return errSuccess
+	})
+
 	var psFlagShort bool
 	c.NewCommand("ps", "List active apps and their state", func(args []string) error {
 		printPs(os.Stdout, time.Now().UTC(), state.NewMulti(std.Paths().RunDirPath), psFlagShort, flagJSON)
 		return errSuccess
 	}).Flag(&psFlagShort, "short", command.BoolFlag(false), "Print instance id")
 
+	c.Command("signal", "Send a signal to the container of an active app", func(args []string) error {
+		if len(args) != 2 {
+			log.Fatal("signal requires 2 arguments")
+		}
+		entry := tryInstance(args[0])
+		if sig, err := parseSignal(args[1]); err != nil {
+			log.Fatal(err)
+		} else if err = app.SendSignal(std.Paths().RunDirPath, &entry.ID, sig); err != nil {
+			log.Fatalf("cannot signal instance %s: %v", entry.ID.String(), err)
+		}
+		return errSuccess
+	})
+
+	var stopTimeout int
+	c.NewCommand("stop", "Terminate an active app", func(args []string) error {
+		if len(args) != 1 {
+			log.Fatal("stop requires 1 argument")
+		}
+		stopInstance(tryInstance(args[0]), time.Duration(stopTimeout)*time.Second)
+		return errSuccess
+	}).Flag(&stopTimeout, "t", command.IntFlag(5), "Seconds to wait for the app to exit before killing it")
+
+	c.Command("exec", "Run a command in the container of an active app", func(args []string) error {
+		if len(args) < 1 {
+			log.Fatal("exec requires at least 1 argument")
+		}
+		entry := tryInstance(args[0])
+		args = args[1:]
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+		internal.Exit(execInstance(entry, args))
+		return errSuccess
+	})
+
+	c.Command("audit", "Show syscalls recorded by apps in seccomp audit mode", func(args []string) error {
+		switch len(args) {
+		case 0: // reports
+			printAuditList(os.Stdout, loadAuditReports(std.Paths().RunDirPath), flagJSON)
+
+		case 1: // instance
+			records := tryAudit(std.Paths().RunDirPath, args[0])
+			printAudit(os.Stdout, records, flagJSON)
+
+		default:
+			log.Fatal("audit requires at most 1 argument")
+		}
+		return errSuccess
+	})
+
+	c.New("import", "Convert the configuration of another sandbox to an app configuration").
+		Command("flatpak", "Convert flatpak application metadata", func(args []string) error {
+			if len(args) != 1 {
+				log.Fatal("import flatpak requires 1 argument")
+			}
+			printJSON(os.Stdout, false, tryFlatpak(args[0]))
+			return errSuccess
+		})
+
+	var bwrapDryRun bool
+	c.NewCommand("bwrap", "Run a command in a container described by bwrap options", func(args []string) error {
+		if len(args) > 0 && args[0] == "--" {
+			args = args[1:]
+		}
+		params, err := sandbox.ParseBwrap(args)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if bwrapDryRun {
+			bwrapArgs, _, err := sandbox.ExportBwrap(params, 3)
+			if err != nil {
+				log.Fatalf("cannot export container: %v", err)
+			}
+			printBwrap(os.Stdout, bwrapArgs, flagJSON)
+			return errSuccess
+		}
+		runContainer(params)
+		return errSuccess
+	}).Flag(&bwrapDryRun, "dry-run", command.BoolFlag(false), "Print the equivalent bwrap command line without running")
+
+	var ociDryRun bool
+	c.NewCommand("oci", "Run an OCI runtime bundle in a container", func(args []string) error {
+		if len(args) != 1 {
+			log.Fatal("oci requires 1 argument")
+		}
+		params := tryOCI(args[0])
+		if ociDryRun {
+			bwrapArgs, _, err := sandbox.ExportBwrap(params, 3)
+			if err != nil {
+				log.Fatalf("cannot export container: %v", err)
+			}
+			printBwrap(os.Stdout, bwrapArgs, flagJSON)
+			return errSuccess
+		}
+		runContainer(params)
+		return errSuccess
+	}).Flag(&ociDryRun, "dry-run", command.BoolFlag(false), "Print the equivalent bwrap command line without running")
+
 	c.Command("version", "Show fortify version", func(args []string) error {
 		fmt.Println(internal.Version())
 		return errSuccess
@@ -281,7 +436,86 @@ func buildCommand(out io.Writer) command.Command {
 	return c
 }
 
-func runApp(config *fst.Config) {
+// execInstance runs args in the container of entry with the standard streams of this process
+// and returns its exit code. The shell of the container is started if args is empty.
+func execInstance(entry *state.State, args []string) int {
+	conn, err := app.DialExec(std.Paths().RunDirPath, &entry.ID)
+	if err != nil {
+		log.Fatalf("cannot connect to instance %s: %v", entry.ID.String(), err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	req := new(sandbox.ExecRequest)
+	if len(args) > 0 {
+		req.Path, req.Args = args[0], args
+	}
+	status, err := sandbox.Exec(conn, req, os.Stdin, os.Stdout, os.Stderr)
+	if err != nil {
+		fmsg.PrintBaseError(err, "cannot run command:")
+		if status < 0 {
+			return 1
+		}
+	}
+	return status
+}
+
+// stopInstance delivers SIGTERM to the container of entry, followed by SIGKILL if it is still running after timeout.
+func stopInstance(entry *state.State, timeout time.Duration) {
+	runDirPath := std.Paths().RunDirPath
+	for _, sig := range []syscall.Signal{syscall.SIGTERM, syscall.SIGKILL} {
+		if err := app.SendSignal(runDirPath, &entry.ID, sig); err != nil {
+			if errors.Is(err, app.ErrNotRunning) {
+				return
+			}
+			log.Fatalf("cannot signal instance %s: %v", entry.ID.String(), err)
+		}
+		fmsg.Verbosef("sent %s to instance %s", sig, entry.ID.String())
+
+		for deadline := time.Now().Add(timeout); time.Now().Before(deadline); {
+			time.Sleep(100 * time.Millisecond)
+			if err := app.SendSignal(runDirPath, &entry.ID, 0); errors.Is(err, app.ErrNotRunning) {
+				return
+			}
+		}
+		log.Printf("instance %s still running %s after %s", entry.ID.String(), timeout, sig)
+	}
+	log.Fatalf("cannot stop instance %s", entry.ID.String())
+}
+
+// runContainer runs a container configured by params with the standard streams of this process
+// and exits with its exit code.
+func runContainer(params *sandbox.Params) {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop() // unreachable
+	container := sandbox.New(ctx, params.Args[0])
+	container.Params = *params
+	container.Stdin, container.Stdout, container.Stderr = os.Stdin, os.Stdout, os.Stderr
+	container.Cancel = func(cmd *exec.Cmd) error { return cmd.Process.Signal(os.Interrupt) }
+	container.WaitDelay = 2 * time.Second
+
+	if err := container.Start(); err != nil {
+		fmsg.PrintBaseError(err, "cannot start container:")
+		internal.Exit(1)
+	}
+	if err := container.Serve(); err != nil {
+		fmsg.PrintBaseError(err, "cannot configure container:")
+	}
+	if err := container.Wait(); err != nil {
+		var exitError *exec.ExitError
+		if !errors.As(err, &exitError) {
+			if errors.Is(err, context.Canceled) {
+				internal.Exit(2)
+			}
+			log.Printf("wait: %v", err)
+			internal.Exit(127)
+		}
+		internal.Exit(exitError.ExitCode())
+	}
+	internal.Exit(0)
+}
+
+// runApp seals and runs config, or prints the plan of the sealed app if dryRun is set.
+func runApp(config *fst.Config, dryRun, flagJSON bool) {
 	ctx, stop := signal.NotifyContext(context.Background(),
 		syscall.SIGINT, syscall.SIGTERM)
 	defer stop() // unreachable
@@ -291,6 +525,9 @@ func runApp(config *fst.Config) {
 	if sa, err := a.Seal(config); err != nil {
 		fmsg.PrintBaseError(err, "cannot seal app:")
 		internal.Exit(1)
+	} else if dryRun {
+		printPlan(os.Stdout, sa.Plan(), flagJSON)
+		internal.Exit(0)
 	} else {
 		internal.Exit(instance.PrintRunStateErr(instance.ISetuid, rs, sa.Run(rs)))
 	}
@@ -23,7 +23,15 @@ Commands:
     app         Launch app defined by the specified config file
     run         Configure and start a permissive default sandbox
     show        Show the contents of an app configuration
+    check       Validate an app configuration and report risky settings
     ps          List active apps and their state
+    signal      Send a signal to the container of an active app
+    stop        Terminate an active app
+    exec        Run a command in the container of an active app
+    audit       Show syscalls recorded by apps in seccomp audit mode
+    import      Convert the configuration of another sandbox to an app configuration
+    bwrap       Run a command in a container described by bwrap options
+    oci         Run an OCI runtime bundle in a container
     version     Show fortify version
     license     Show full license text
     template    Produce a config template
@@ -33,7 +41,7 @@ Commands:
 		},
 		{
 			"run", []string{"run", "-h"}, `
-Usage:	fortify run [-h | --help] [--dbus-config <value>] [--dbus-system <value>] [--mpris] [--dbus-log] [--id <value>] [-a <int>] [-g <value>] [-d <value>] [-u <value>] [--wayland] [-X] [--dbus] [--pulse] COMMAND [OPTIONS]
+Usage:	fortify run [-h | --help] [--dbus-config <value>] [--dbus-system <value>] [--mpris] [--dbus-log] [--dbus-learn <value>] [--id <value>] [-a <int>] [-g <value>] [-d <value>] [-u <value>] [--wayland] [-X] [--dbus] [--pulse] [--pipewire] [--dry-run] COMMAND [OPTIONS]
 
 Flags:
   -X	Enable direct connection to X11
@@ -45,16 +53,22 @@ Flags:
     	Enable proxied connection to D-Bus
   -dbus-config string
     	Path to session bus proxy config file, or "builtin" for defaults (default "builtin")
+  -dbus-learn string
+    	Permit all D-Bus traffic and write a proxy config permitting it to this path on exit
   -dbus-log
     	Force buffered logging in the D-Bus proxy
   -dbus-system string
     	Path to system bus proxy config file, or "nil" to disable (default "nil")
+  -dry-run
+    	Print planned system and container setup without running
   -g value
     	Groups inherited by all container processes
   -id string
     	Reverse-DNS style Application identifier, leave empty to inherit instance identifier
   -mpris
     	Allow owning MPRIS D-Bus path, has no effect if custom config is available
+  -pipewire
+    	Enable connection to PipeWire
   -pulse
     	Enable direct connection to PulseAudio
   -u string
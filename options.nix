@@ -262,6 +262,14 @@ in
                     Whether to share the PulseAudio socket and cookie.
                   '';
                 };
+
+                pipewire = mkOption {
+                  type = bool;
+                  default = false;
+                  description = ''
+                    Whether to share the PipeWire socket.
+                  '';
+                };
               };
 
               share = mkOption {
@@ -3,7 +3,6 @@
   stdenv,
   buildGoModule,
   makeBinaryWrapper,
-  xdg-dbus-proxy,
   pkg-config,
   libffi,
   libseccomp,
@@ -13,11 +12,6 @@
   wayland-scanner,
   xorg,
 
-  # for fpkg
-  zstd,
-  gnutar,
-  coreutils,
-
   # for passthru.buildInputs
   go,
   gcc,
@@ -89,7 +83,6 @@ buildGoModule rec {
     let
       appPackages = [
         glibc
-        xdg-dbus-proxy
       ];
     in
     ''
@@ -102,16 +95,7 @@ buildGoModule rec {
         --inherit-argv0 --prefix PATH : ${lib.makeBinPath appPackages}
 
       makeBinaryWrapper "$out/libexec/fpkg" "$out/bin/fpkg" \
-        --inherit-argv0 --prefix PATH : ${
-          lib.makeBinPath (
-            appPackages
-            ++ [
-              zstd
-              gnutar
-              coreutils
-            ]
-          )
-        }
+        --inherit-argv0 --prefix PATH : ${lib.makeBinPath appPackages}
     '';
 
   passthru.targetPkgs =
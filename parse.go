@@ -1,22 +1,36 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"path"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/fst/flatpak"
+	"git.gensokyo.uk/security/fortify/internal/app"
 	"git.gensokyo.uk/security/fortify/internal/fmsg"
 	"git.gensokyo.uk/security/fortify/internal/state"
+	"git.gensokyo.uk/security/fortify/sandbox"
+	"git.gensokyo.uk/security/fortify/sandbox/oci"
 )
 
 func tryPath(name string) (config *fst.Config) {
-	var r io.Reader
+	var (
+		r io.Reader
+		// relative base configuration paths are resolved against the working directory if not loaded from a file
+		base string
+	)
 	config = new(fst.Config)
 
 	if name != "-" {
@@ -29,6 +43,7 @@ func tryPath(name string) (config *fst.Config) {
 			} else {
 				// finalizer closes f
 				r = f
+				base = name
 			}
 		} else {
 			defer func() {
@@ -45,9 +60,67 @@ func tryPath(name string) (config *fst.Config) {
 		log.Fatalf("cannot load configuration: %v", err)
 	}
 
+	if err := config.ResolveExtends(base); err != nil {
+		log.Fatalf("cannot load configuration: %v", err)
+	}
+
 	return
 }
 
+// tryFlatpak converts flatpak metadata at pathname, or standard input if pathname is "-",
+// reporting permissions without an equivalent.
+func tryFlatpak(pathname string) *fst.Config {
+	var r io.Reader = os.Stdin
+	if pathname != "-" {
+		if f, err := os.Open(pathname); err != nil {
+			log.Fatalf("cannot access metadata file %q: %v", pathname, err)
+		} else {
+			defer func() {
+				if err = f.Close(); err != nil {
+					log.Printf("cannot close metadata file: %v", err)
+				}
+			}()
+			r = f
+		}
+	}
+
+	f, err := flatpak.ParseKeyFile(r)
+	if err != nil {
+		log.Fatalf("cannot parse flatpak metadata: %v", err)
+	}
+	config, unsupported, err := flatpak.Convert(f)
+	if err != nil {
+		log.Fatalf("cannot convert flatpak metadata: %v", err)
+	}
+	for _, u := range unsupported {
+		log.Printf("%s has no equivalent", u)
+	}
+	return config
+}
+
+// tryOCI converts the runtime configuration of the OCI bundle at directory bundle,
+// reporting settings without an equivalent.
+func tryOCI(bundle string) *sandbox.Params {
+	if v, err := filepath.Abs(bundle); err != nil {
+		log.Fatalf("cannot resolve bundle path: %v", err)
+	} else {
+		bundle = v
+	}
+
+	spec, err := oci.Load(bundle)
+	if err != nil {
+		log.Fatalf("cannot load OCI runtime configuration: %v", err)
+	}
+	params, unsupported, err := oci.Convert(spec, bundle)
+	if err != nil {
+		log.Fatalf("cannot convert OCI runtime configuration: %v", err)
+	}
+	for _, u := range unsupported {
+		log.Printf("%s has no equivalent", u)
+	}
+	return params
+}
+
 func tryFd(name string) io.ReadCloser {
 	if v, err := strconv.Atoi(name); err != nil {
 		if !errors.Is(err, strconv.ErrSyntax) {
@@ -108,3 +181,166 @@ func tryShort(name string) (config *fst.Config, entry *state.State) {
 
 	return
 }
+
+// auditReport summarises the audit report of an instance.
+type auditReport struct {
+	// fortify instance id
+	ID string `json:"instance"`
+	// last modification time of the report
+	Time time.Time `json:"time"`
+	// number of distinct records
+	Records int `json:"records"`
+}
+
+func loadAuditReports(runDirPath string) []*auditReport {
+	entries, err := os.ReadDir(path.Join(runDirPath, "audit"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		log.Fatalf("cannot read audit reports: %v", err)
+	}
+
+	reports := make([]*auditReport, 0, len(entries))
+	for _, e := range entries {
+		var id app.ID
+		if err = app.ParseAppID(&id, e.Name()); err != nil {
+			fmsg.Verbosef("skipped invalid audit report %q", e.Name())
+			continue
+		}
+
+		if fi, err := e.Info(); err != nil {
+			log.Printf("cannot stat audit report %s: %v", e.Name(), err)
+		} else if records, err := readAudit(path.Join(runDirPath, "audit", e.Name())); err != nil {
+			log.Printf("cannot load audit report %s: %v", e.Name(), err)
+		} else {
+			reports = append(reports, &auditReport{id.String(), fi.ModTime().UTC(), len(records)})
+		}
+	}
+	slices.SortFunc(reports, func(a, b *auditReport) int { return a.Time.Compare(b.Time) })
+	return reports
+}
+
+func tryAudit(runDirPath, name string) []*app.AuditRecord {
+	if len(name) < 8 || len(name) > 32 {
+		log.Fatalf("invalid instance %q", name)
+	}
+	for _, report := range loadAuditReports(runDirPath) {
+		if strings.HasPrefix(report.ID, name) {
+			if records, err := readAudit(path.Join(runDirPath, "audit", report.ID)); err != nil {
+				log.Fatalf("cannot load audit report: %v", err)
+			} else {
+				return records
+			}
+		}
+	}
+	log.Fatalf("no audit report for instance %q", name)
+	panic("unreachable")
+}
+
+func readAudit(name string) ([]*app.AuditRecord, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	return app.LoadAudit(f)
+}
+
+// tryInstance resolves name to the state entry of a running instance by the same logic as [tryShort].
+func tryInstance(name string) *state.State {
+	if _, entry := tryShort(name); entry == nil {
+		log.Fatalf("no running instance matches %q", name)
+		panic("unreachable")
+	} else if err := verifyInstance(entry); err != nil {
+		log.Fatalf("cannot verify instance %s: %v", entry.ID.String(), err)
+		panic("unreachable")
+	} else {
+		return entry
+	}
+}
+
+// instanceStartSlack is the maximum difference between the recorded start time and the creation time
+// of the process of an instance, the latter is derived from the boot time which has a resolution of one second.
+const instanceStartSlack = 2 * time.Second
+
+// verifyInstance checks that the process recorded in entry was created at the recorded start time,
+// to guard against acting on a stale state entry whose pid has since been reused.
+func verifyInstance(entry *state.State) error {
+	var btime int64
+	if p, err := os.ReadFile("/proc/stat"); err != nil {
+		return err
+	} else {
+		for _, line := range strings.Split(string(p), "\n") {
+			if v, ok := strings.CutPrefix(line, "btime "); ok {
+				if btime, err = strconv.ParseInt(v, 10, 64); err != nil {
+					return err
+				}
+				break
+			}
+		}
+		if btime == 0 {
+			return errors.New("boot time not available")
+		}
+	}
+
+	var starttime int64
+	if p, err := os.ReadFile("/proc/" + strconv.Itoa(entry.PID) + "/stat"); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("process %d no longer exists", entry.PID)
+		}
+		return err
+	} else {
+		// comm is parenthesised and might contain spaces
+		i := bytes.LastIndexByte(p, ')')
+		if i == -1 {
+			return syscall.EBADMSG
+		}
+		// fields following comm start at field 3, starttime is field 22
+		fields := strings.Fields(string(p[i+1:]))
+		if len(fields) < 20 {
+			return syscall.EBADMSG
+		}
+		if starttime, err = strconv.ParseInt(fields[19], 10, 64); err != nil {
+			return err
+		}
+	}
+
+	// USER_HZ is 100 on all supported architectures
+	start := time.Unix(btime, 0).Add(time.Duration(starttime) * (time.Second / 100))
+	if d := start.Sub(entry.Time); d > instanceStartSlack || d < -instanceStartSlack {
+		return fmt.Errorf("process %d started at %s, not by this instance", entry.PID, start.UTC().Format(time.DateTime))
+	}
+	return nil
+}
+
+// signalNames maps names of signals accepted by parseSignal to their values.
+var signalNames = map[string]syscall.Signal{
+	"HUP":   syscall.SIGHUP,
+	"INT":   syscall.SIGINT,
+	"QUIT":  syscall.SIGQUIT,
+	"KILL":  syscall.SIGKILL,
+	"USR1":  syscall.SIGUSR1,
+	"USR2":  syscall.SIGUSR2,
+	"ALRM":  syscall.SIGALRM,
+	"TERM":  syscall.SIGTERM,
+	"CONT":  syscall.SIGCONT,
+	"TSTP":  syscall.SIGTSTP,
+	"WINCH": syscall.SIGWINCH,
+}
+
+// parseSignal parses a signal by number or name, with or without the SIG prefix.
+// Only signals relayed to the initial process of a container, and SIGKILL, are accepted.
+func parseSignal(s string) (syscall.Signal, error) {
+	if v, err := strconv.Atoi(s); err == nil {
+		sig := syscall.Signal(v)
+		if sig != syscall.SIGKILL && !slices.Contains(sandbox.RelaySignals, sig) {
+			return 0, fmt.Errorf("signal %d cannot be relayed", v)
+		}
+		return sig, nil
+	}
+	if sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(s), "SIG")]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", s)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"syscall"
+	"testing"
+)
+
+func Test_parseSignal(t *testing.T) {
+	testCases := []struct {
+		name    string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{"15", syscall.SIGTERM, false},
+		{"TERM", syscall.SIGTERM, false},
+		{"SIGKILL", syscall.SIGKILL, false},
+		{"usr1", syscall.SIGUSR1, false},
+		{"sigwinch", syscall.SIGWINCH, false},
+
+		{"sigtstp", syscall.SIGTSTP, false},
+
+		{"0", 0, true},
+		{"65", 0, true},
+		{"19", 0, true},
+		{"14", syscall.SIGALRM, false},
+		{"STOP", 0, true},
+		{"SEGV", 0, true},
+		{"SIGNONEXISTENT", 0, true},
+		{"", 0, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseSignal(tc.name)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("parseSignal: error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Errorf("parseSignal: %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -14,6 +14,8 @@ import (
 
 	"git.gensokyo.uk/security/fortify/dbus"
 	"git.gensokyo.uk/security/fortify/fst"
+	"git.gensokyo.uk/security/fortify/internal/app"
+	"git.gensokyo.uk/security/fortify/internal/app/instance/common"
 	"git.gensokyo.uk/security/fortify/internal/fmsg"
 	"git.gensokyo.uk/security/fortify/internal/state"
 )
@@ -80,12 +82,15 @@ func printShowInstance(
 	if config.Data != "" {
 		t.Printf(" Data:\t%s\n", config.Data)
 	}
+	if config.DBusLearn != "" {
+		t.Printf(" D-Bus learn:\t%s\n", config.DBusLearn)
+	}
 	if config.Container != nil {
 		container := config.Container
 		if container.Hostname != "" {
 			t.Printf(" Hostname:\t%s\n", container.Hostname)
 		}
-		flags := make([]string, 0, 7)
+		flags := make([]string, 0, 8)
 		writeFlag := func(name string, value bool) {
 			if value {
 				flags = append(flags, name)
@@ -94,8 +99,12 @@ func printShowInstance(
 		writeFlag("userns", container.Userns)
 		writeFlag("devel", container.Devel)
 		writeFlag("net", container.Net)
+		writeFlag("usernet", container.Usernet != nil)
 		writeFlag("device", container.Device)
+		writeFlag("gpu", container.GPU)
 		writeFlag("tty", container.Tty)
+		writeFlag("landlock", container.Landlock)
+		writeFlag("audit", container.SeccompAudit)
 		writeFlag("mapuid", container.MapRealUID)
 		writeFlag("directwl", config.DirectWayland)
 		writeFlag("autoetc", container.AutoEtc)
@@ -134,6 +143,8 @@ func printShowInstance(
 
 				if f.Device {
 					expr.WriteString(" d")
+				} else if f.Overlay {
+					expr.WriteString(" o")
 				} else if f.Write {
 					expr.WriteString(" w")
 				} else {
@@ -273,6 +284,116 @@ func printPs(output io.Writer, now time.Time, s state.Store, short, flagJSON boo
 	}
 }
 
+func printPlan(output io.Writer, plan *app.Plan, flagJSON bool) {
+	if flagJSON {
+		printJSON(output, false, plan)
+		return
+	}
+
+	t := newPrinter(output)
+	defer t.MustFlush()
+
+	t.Printf("Instance:\t%s\n", plan.ID)
+	t.Printf("Uid:\t%d\n", plan.Uid)
+	t.Printf("Path:\t%s\n", plan.Path)
+	t.Printf("Arguments:\t%s\n", strings.Join(plan.Args, " "))
+	t.Printf("Environment:\t%s\n", strings.Join(plan.Env, " "))
+	t.Printf("\n")
+
+	t.Printf("System:\n")
+	t.Printf("\tOp\tType\tValue\n")
+	for _, o := range plan.System {
+		t.Printf("\t%s\t%s\t%s\n", o.Op, o.Type, o.Value)
+	}
+	t.Printf("\n")
+
+	t.Printf("Container:\n")
+	for _, o := range plan.Container {
+		t.Printf("\t%s\n", o)
+	}
+}
+
+func printCheck(output io.Writer, diagnostics []*common.Diagnostic, flagJSON bool) {
+	if flagJSON {
+		if diagnostics == nil {
+			diagnostics = make([]*common.Diagnostic, 0)
+		}
+		printJSON(output, false, diagnostics)
+		return
+	}
+
+	if len(diagnostics) == 0 {
+		mustPrintln(output, "No problems found.")
+		return
+	}
+
+	t := newPrinter(output)
+	defer t.MustFlush()
+
+	t.Println("\tSeverity\tField\tMessage")
+	for _, d := range diagnostics {
+		t.Printf("\t%s\t%s\t%s\n", d.Severity, d.Field, d.Message)
+	}
+}
+
+func printAuditList(output io.Writer, reports []*auditReport, flagJSON bool) {
+	if flagJSON {
+		if reports == nil {
+			reports = make([]*auditReport, 0)
+		}
+		printJSON(output, false, reports)
+		return
+	}
+
+	t := newPrinter(output)
+	defer t.MustFlush()
+
+	t.Println("\tInstance\tRecords\tModified")
+	for _, r := range reports {
+		t.Printf("\t%s\t%d\t%s\n", r.ID[:8], r.Records, r.Time.Format(time.DateTime))
+	}
+}
+
+func printAudit(output io.Writer, records []*app.AuditRecord, flagJSON bool) {
+	if flagJSON {
+		printJSON(output, false, records)
+		return
+	}
+
+	t := newPrinter(output)
+	defer t.MustFlush()
+
+	t.Println("\tTime\tPID\tArch\tSyscall\tArguments")
+	for _, r := range records {
+		args := make([]string, len(r.Args))
+		for i, v := range r.Args {
+			args[i] = "0x" + strconv.FormatUint(v, 16)
+		}
+		t.Printf("\t%s\t%d\t%s\t%s (%d)\t%s\n",
+			r.Time.Format(time.TimeOnly), r.Pid, r.Arch, r.Syscall, r.Nr, strings.Join(args, " "))
+	}
+}
+
+// printBwrap prints a bwrap command line, quoted for the shell unless flagJSON is set.
+func printBwrap(output io.Writer, args []string, flagJSON bool) {
+	if flagJSON {
+		printJSON(output, false, args)
+		return
+	}
+
+	v := make([]string, 0, len(args)+1)
+	v = append(v, "bwrap")
+	for _, arg := range args {
+		if arg == "" || strings.ContainsFunc(arg, func(r rune) bool {
+			return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r))
+		}) {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		v = append(v, arg)
+	}
+	mustPrintln(output, strings.Join(v, " "))
+}
+
 type expandedStateEntry struct {
 	s string
 	*state.State
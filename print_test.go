@@ -8,6 +8,7 @@ import (
 	"git.gensokyo.uk/security/fortify/dbus"
 	"git.gensokyo.uk/security/fortify/fst"
 	"git.gensokyo.uk/security/fortify/internal/app"
+	"git.gensokyo.uk/security/fortify/internal/app/instance/common"
 	"git.gensokyo.uk/security/fortify/internal/state"
 )
 
@@ -632,6 +633,72 @@ func Test_printPs(t *testing.T) {
 	}
 }
 
+func Test_printAudit(t *testing.T) {
+	testRecords := []*app.AuditRecord{
+		{Time: testAppTime, Pid: 2, Arch: "x86_64", Syscall: "syslog", Nr: 103, Args: [6]uint64{10}},
+		{Time: testTime, Pid: 0xbad, Arch: "x86", Syscall: "ptrace", Nr: 26, Args: [6]uint64{0x10, 0xfe}},
+	}
+	testCases := []struct {
+		name    string
+		reports []*auditReport
+		records []*app.AuditRecord
+		json    bool
+		want    string
+	}{
+		{"no reports", nil, nil, false, "    Instance    Records    Modified\n"},
+		{"no reports json", nil, nil, true, "[]\n"},
+		{"reports", []*auditReport{{testID.String(), testTime, 2}}, nil, false, `    Instance    Records    Modified
+    8e2c76b0    2          1970-01-01 01:02:32
+`},
+		{"reports json", []*auditReport{{testID.String(), testTime, 2}}, nil, true, `[
+  {
+    "instance": "8e2c76b066dabe574cf073bdb46eb5c1",
+    "time": "1970-01-01T01:02:32.000000001Z",
+    "records": 2
+  }
+]
+`},
+
+		{"records", nil, testRecords, false, `    Time        PID     Arch      Syscall         Arguments
+    00:00:00    2       x86_64    syslog (103)    0xa 0x0 0x0 0x0 0x0 0x0
+    01:02:32    2989    x86       ptrace (26)     0x10 0xfe 0x0 0x0 0x0 0x0
+`},
+		{"records json", nil, testRecords[:1], true, `[
+  {
+    "time": "1970-01-01T00:00:00.000000009Z",
+    "pid": 2,
+    "arch": "x86_64",
+    "syscall": "syslog",
+    "nr": 103,
+    "args": [
+      10,
+      0,
+      0,
+      0,
+      0,
+      0
+    ]
+  }
+]
+`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := new(strings.Builder)
+			if tc.records != nil {
+				printAudit(output, tc.records, tc.json)
+			} else {
+				printAuditList(output, tc.reports, tc.json)
+			}
+			if got := output.String(); got != tc.want {
+				t.Errorf("printAudit: got\n%s\nwant\n%s",
+					got, tc.want)
+				return
+			}
+		})
+	}
+}
+
 // stubStore implements [state.Store] and returns test samples via [state.Joiner].
 type stubStore state.Entries
 
@@ -639,3 +706,159 @@ func (s stubStore) Join() (state.Entries, error)               { return state.En
 func (s stubStore) Do(int, func(c state.Cursor)) (bool, error) { panic("unreachable") }
 func (s stubStore) List() ([]int, error)                       { panic("unreachable") }
 func (s stubStore) Close() error                               { return nil }
+
+func Test_printCheck(t *testing.T) {
+	testDiagnostics := []*common.Diagnostic{
+		{Severity: common.SeverityError, Field: "data", Message: `home directory "data" is not absolute`},
+		{Severity: common.SeverityWarning, Field: "container.devel", Message: "ptrace and related syscalls are allowed"},
+	}
+	testCases := []struct {
+		name        string
+		diagnostics []*common.Diagnostic
+		json        bool
+		want        string
+	}{
+		{"none", nil, false, "No problems found.\n"},
+		{"none json", nil, true, "[]\n"},
+		{"diagnostics", testDiagnostics, false, `    Severity    Field              Message
+    error       data               home directory "data" is not absolute
+    warning     container.devel    ptrace and related syscalls are allowed
+`},
+		{"diagnostics json", testDiagnostics[:1], true, `[
+  {
+    "severity": "error",
+    "field": "data",
+    "message": "home directory \"data\" is not absolute"
+  }
+]
+`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := new(strings.Builder)
+			printCheck(output, tc.diagnostics, tc.json)
+			if got := output.String(); got != tc.want {
+				t.Errorf("printCheck: got\n%s\nwant\n%s",
+					got, tc.want)
+			}
+		})
+	}
+}
+
+func Test_printPlan(t *testing.T) {
+	testPlan := &app.Plan{
+		ID:   testID.String(),
+		Uid:  1000009,
+		Path: "/run/current-system/sw/bin/zsh",
+		Args: []string{"zsh", "-c", "exec foot"},
+		Env:  []string{"HOME=/data/data/org.chromium.Chromium", "SHELL=/run/current-system/sw/bin/zsh"},
+		System: []*app.PlanOp{
+			{Op: "mkdir", Type: "process", Path: "/tmp/fortify.1971/8e2c76b066dabe574cf073bdb46eb5c1",
+				Value: `mode: -rwx--x--x type: process path: "/tmp/fortify.1971/8e2c76b066dabe574cf073bdb46eb5c1"`},
+			{Op: "xhost", Type: "x11", Path: "fortify-1000009", Value: "SI:localuser:fortify-1000009"},
+		},
+		Container: []string{`mounting proc on "/proc"`, `mounting "/nix/store" flags 0x0`},
+	}
+	testCases := []struct {
+		name string
+		json bool
+		want string
+	}{
+		{"text", false, `Instance:       8e2c76b066dabe574cf073bdb46eb5c1
+Uid:            1000009
+Path:           /run/current-system/sw/bin/zsh
+Arguments:      zsh -c exec foot
+Environment:    HOME=/data/data/org.chromium.Chromium SHELL=/run/current-system/sw/bin/zsh
+
+System:
+    Op       Type       Value
+    mkdir    process    mode: -rwx--x--x type: process path: "/tmp/fortify.1971/8e2c76b066dabe574cf073bdb46eb5c1"
+    xhost    x11        SI:localuser:fortify-1000009
+
+Container:
+    mounting proc on "/proc"
+    mounting "/nix/store" flags 0x0
+`},
+		{"json", true, `{
+  "instance": "8e2c76b066dabe574cf073bdb46eb5c1",
+  "uid": 1000009,
+  "path": "/run/current-system/sw/bin/zsh",
+  "args": [
+    "zsh",
+    "-c",
+    "exec foot"
+  ],
+  "env": [
+    "HOME=/data/data/org.chromium.Chromium",
+    "SHELL=/run/current-system/sw/bin/zsh"
+  ],
+  "system": [
+    {
+      "op": "mkdir",
+      "type": "process",
+      "path": "/tmp/fortify.1971/8e2c76b066dabe574cf073bdb46eb5c1",
+      "value": "mode: -rwx--x--x type: process path: \"/tmp/fortify.1971/8e2c76b066dabe574cf073bdb46eb5c1\""
+    },
+    {
+      "op": "xhost",
+      "type": "x11",
+      "path": "fortify-1000009",
+      "value": "SI:localuser:fortify-1000009"
+    }
+  ],
+  "container": [
+    "mounting proc on \"/proc\"",
+    "mounting \"/nix/store\" flags 0x0"
+  ]
+}
+`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := new(strings.Builder)
+			printPlan(output, testPlan, tc.json)
+			if got := output.String(); got != tc.want {
+				t.Errorf("printPlan: got\n%s\nwant\n%s",
+					got, tc.want)
+			}
+		})
+	}
+}
+
+func Test_printBwrap(t *testing.T) {
+	args := []string{"--ro-bind", "/usr", "/usr", "--setenv", "GREETING", "it's me", "--setenv", "EMPTY", "", "--", "/bin/sh", "-c", "echo $HOME"}
+	testCases := []struct {
+		name string
+		json bool
+		want string
+	}{
+		{"text", false, `bwrap --ro-bind /usr /usr --setenv GREETING 'it'\''s me' --setenv EMPTY '' -- /bin/sh -c 'echo $HOME'
+`},
+		{"json", true, `[
+  "--ro-bind",
+  "/usr",
+  "/usr",
+  "--setenv",
+  "GREETING",
+  "it's me",
+  "--setenv",
+  "EMPTY",
+  "",
+  "--",
+  "/bin/sh",
+  "-c",
+  "echo $HOME"
+]
+`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output := new(strings.Builder)
+			printBwrap(output, args, tc.json)
+			if got := output.String(); got != tc.want {
+				t.Errorf("printBwrap: got\n%s\nwant\n%s",
+					got, tc.want)
+			}
+		})
+	}
+}
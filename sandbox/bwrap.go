@@ -0,0 +1,520 @@
+package sandbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+)
+
+var (
+	// ErrBwrapArgs is returned by [ParseBwrap] for a malformed bwrap command line.
+	ErrBwrapArgs = errors.New("invalid bwrap arguments")
+	// ErrBwrapUnsupported is returned by [ParseBwrap] for a bwrap option without an equivalent,
+	// and by [ExportBwrap] for an op without an equivalent bwrap option.
+	ErrBwrapUnsupported = errors.New("no bwrap equivalent")
+)
+
+// bwrapArity holds the number of arguments taken by each supported bwrap option.
+var bwrapArity = map[string]int{
+	"--unshare-all":            0,
+	"--share-net":              0,
+	"--unshare-user":           0,
+	"--unshare-user-try":       0,
+	"--unshare-ipc":            0,
+	"--unshare-pid":            0,
+	"--unshare-net":            0,
+	"--unshare-uts":            0,
+	"--unshare-cgroup":         0,
+	"--unshare-cgroup-try":     0,
+	"--disable-userns":         0,
+	"--assert-userns-disabled": 0,
+	"--die-with-parent":        0,
+	"--new-session":            0,
+	"--clearenv":               0,
+
+	"--args":           1,
+	"--argv0":          1,
+	"--chdir":          1,
+	"--unsetenv":       1,
+	"--hostname":       1,
+	"--uid":            1,
+	"--gid":            1,
+	"--seccomp":        1,
+	"--add-seccomp-fd": 1,
+	"--perms":          1,
+	"--size":           1,
+	"--tmpfs":          1,
+	"--proc":           1,
+	"--dev":            1,
+	"--mqueue":         1,
+	"--remount-ro":     1,
+	"--dir":            1,
+	"--overlay-src":    1,
+	"--tmp-overlay":    1,
+
+	"--setenv":       2,
+	"--bind":         2,
+	"--bind-try":     2,
+	"--ro-bind":      2,
+	"--ro-bind-try":  2,
+	"--dev-bind":     2,
+	"--dev-bind-try": 2,
+	"--symlink":      2,
+	"--file":         2,
+	"--ro-bind-data": 2,
+
+	"--overlay": 3,
+}
+
+// bwrapBindFlags maps bwrap bind options to [BindMount] flags.
+var bwrapBindFlags = map[string]int{
+	"--ro-bind":      0,
+	"--ro-bind-try":  BindOptional,
+	"--bind":         BindWritable,
+	"--bind-try":     BindWritable | BindOptional,
+	"--dev-bind":     BindWritable | BindDevice,
+	"--dev-bind-try": BindWritable | BindDevice | BindOptional,
+}
+
+// bwrapDefaultPath is the search path used by bwrap when PATH is not set.
+const bwrapDefaultPath = "/usr/local/bin:/usr/bin:/bin"
+
+// ParseBwrap returns container parameters equivalent to the bwrap command line args, excluding the program name.
+// Environment and setup options are translated in order, file descriptor arguments are read during parsing.
+//
+// The container is always hardened: namespaces other than the network namespace are unshared regardless of
+// options, the initial process runs in a new session, and the preset syscall filter applies in addition to
+// programs passed via --seccomp and --add-seccomp-fd. The network namespace is shared unless unshared
+// by --unshare-net or --unshare-all. A command without a slash is looked up in PATH of the container environment
+// via host paths of preceding bind mounts.
+func ParseBwrap(args []string) (*Params, error) {
+	params := &Params{Dir: "/", Env: os.Environ(), Ops: new(Ops)}
+
+	var (
+		argv0    *string
+		shareNet = true
+		perm     *os.FileMode
+		size     int
+		sizeSet  bool
+		lower    []string
+		argsRead bool
+	)
+
+	for len(args) > 0 {
+		opt := args[0]
+		if opt == "--" {
+			args = args[1:]
+			break
+		}
+		if !strings.HasPrefix(opt, "--") {
+			break
+		}
+		n, ok := bwrapArity[opt]
+		if !ok {
+			return nil, fmt.Errorf("%w: option %s", ErrBwrapUnsupported, opt)
+		}
+		if len(args) < 1+n {
+			return nil, fmt.Errorf("%w: %s takes %d arguments", ErrBwrapArgs, opt, n)
+		}
+		v := args[1 : 1+n]
+		args = args[1+n:]
+
+		// --perms applies to the next --dir or --tmpfs, --size to the next --tmpfs
+		if (perm != nil && opt != "--dir" && opt != "--tmpfs" && opt != "--size") ||
+			(sizeSet && opt != "--tmpfs" && opt != "--perms") {
+			return nil, fmt.Errorf("%w: %s follows --perms or --size", ErrBwrapArgs, opt)
+		}
+		takePerm := func() os.FileMode {
+			defer func() { perm = nil }()
+			if perm == nil {
+				return 0755
+			}
+			return *perm
+		}
+
+		switch opt {
+		case "--unshare-all", "--unshare-net":
+			shareNet = false
+		case "--share-net":
+			shareNet = true
+		case "--unshare-user", "--unshare-user-try", "--unshare-ipc", "--unshare-pid", "--unshare-uts",
+			"--unshare-cgroup", "--unshare-cgroup-try", "--disable-userns", "--assert-userns-disabled",
+			"--die-with-parent", "--new-session":
+			// always the case
+		case "--clearenv":
+			params.Env = make([]string, 0)
+
+		case "--args":
+			if argsRead {
+				return nil, fmt.Errorf("%w: --args specified more than once", ErrBwrapArgs)
+			}
+			argsRead = true
+			if data, err := readBwrapFd(v[0]); err != nil {
+				return nil, err
+			} else {
+				extra := strings.Split(strings.TrimSuffix(string(data), "\x00"), "\x00")
+				if len(data) == 0 {
+					extra = nil
+				}
+				args = append(extra, args...)
+			}
+		case "--argv0":
+			argv0 = &v[0]
+		case "--chdir":
+			params.Dir = v[0]
+		case "--unsetenv":
+			params.Env = unsetenv(params.Env, v[0])
+		case "--setenv":
+			params.Env = append(unsetenv(params.Env, v[0]), v[0]+"="+v[1])
+		case "--hostname":
+			params.Hostname = v[0]
+		case "--uid", "--gid":
+			if id, err := strconv.Atoi(v[0]); err != nil || id < 0 {
+				return nil, fmt.Errorf("%w: %s %q", ErrBwrapArgs, opt, v[0])
+			} else if opt == "--uid" {
+				params.Uid = id
+			} else {
+				params.Gid = id
+			}
+		case "--seccomp", "--add-seccomp-fd":
+			if prog, err := readBwrapFd(v[0]); err != nil {
+				return nil, err
+			} else if err = checkSeccompProgram(prog); err != nil {
+				return nil, fmt.Errorf("%w: %s %s: bad syscall filter program", ErrBwrapArgs, opt, v[0])
+			} else {
+				params.SeccompPrograms = append(params.SeccompPrograms, prog)
+			}
+
+		case "--perms":
+			if p, err := strconv.ParseUint(v[0], 8, 32); err != nil || p > 07777 {
+				return nil, fmt.Errorf("%w: --perms %q", ErrBwrapArgs, v[0])
+			} else {
+				m := os.FileMode(p)
+				perm = &m
+			}
+		case "--size":
+			if s, err := strconv.Atoi(v[0]); err != nil || s < 0 {
+				return nil, fmt.Errorf("%w: --size %q", ErrBwrapArgs, v[0])
+			} else {
+				size, sizeSet = s, true
+			}
+		case "--tmpfs":
+			params.Tmpfs(v[0], size, takePerm())
+			size, sizeSet = 0, false
+		case "--dir":
+			params.Mkdir(v[0], takePerm())
+		case "--proc":
+			params.Proc(v[0])
+		case "--dev":
+			params.Dev(v[0])
+		case "--mqueue":
+			params.Mqueue(v[0])
+		case "--symlink":
+			params.Link(v[0], v[1])
+
+		case "--bind", "--bind-try", "--ro-bind", "--ro-bind-try", "--dev-bind", "--dev-bind-try":
+			params.Bind(v[0], v[1], bwrapBindFlags[opt])
+		case "--file", "--ro-bind-data":
+			if data, err := readBwrapFd(v[0]); err != nil {
+				return nil, err
+			} else {
+				params.Place(v[1], data)
+			}
+		case "--remount-ro":
+			// only supported on the preceding bind mount, see ExportBwrap
+			var b *BindMount
+			if len(*params.Ops) > 0 {
+				b, _ = (*params.Ops)[len(*params.Ops)-1].(*BindMount)
+			}
+			if b == nil || b.Target != v[0] {
+				return nil, fmt.Errorf("%w: --remount-ro not following a bind mount of %q", ErrBwrapUnsupported, v[0])
+			}
+			b.Flags &^= BindWritable
+
+		case "--overlay-src":
+			lower = append(lower, v[0])
+		case "--tmp-overlay", "--overlay":
+			if len(lower) == 0 {
+				return nil, fmt.Errorf("%w: %s requires --overlay-src", ErrBwrapArgs, opt)
+			}
+			if opt == "--overlay" {
+				params.Overlay(v[2], lower, v[0], v[1])
+			} else {
+				params.Overlay(v[0], lower, "", "")
+			}
+			lower = nil
+
+		default:
+			// unreachable
+			return nil, fmt.Errorf("%w: option %s", ErrBwrapUnsupported, opt)
+		}
+	}
+	if perm != nil || sizeSet || lower != nil {
+		return nil, fmt.Errorf("%w: trailing modifier options", ErrBwrapArgs)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("%w: no command specified", ErrBwrapArgs)
+	}
+
+	if shareNet {
+		params.Flags |= FAllowNet
+	}
+	params.Args = slices.Clone(args)
+	if argv0 != nil {
+		params.Args[0] = *argv0
+	}
+	if p, err := params.lookBwrapPath(args[0]); err != nil {
+		return nil, err
+	} else {
+		params.Path = p
+	}
+	return params, nil
+}
+
+// lookBwrapPath resolves name to an absolute path in the container.
+func (p *Params) lookBwrapPath(name string) (string, error) {
+	if strings.ContainsRune(name, '/') {
+		if path.IsAbs(name) {
+			return name, nil
+		}
+		return path.Join(p.Dir, name), nil
+	}
+
+	searchPath := bwrapDefaultPath
+	for _, e := range p.Env {
+		if v, ok := strings.CutPrefix(e, "PATH="); ok {
+			searchPath = v
+		}
+	}
+	for _, dir := range strings.Split(searchPath, ":") {
+		if !path.IsAbs(dir) {
+			continue
+		}
+		target := path.Join(dir, name)
+		if host, ok := p.hostPath(target); ok {
+			if fi, err := os.Stat(host); err == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0 {
+				return target, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("%w: %q not found in PATH", ErrBwrapArgs, name)
+}
+
+// hostPath returns the host path of container path name according to the last bind mount covering it,
+// or false if it is not covered by a bind mount or is covered by a later tmpfs.
+func (p *Params) hostPath(name string) (string, bool) {
+	if p.Ops == nil {
+		return "", false
+	}
+	covers := func(target string) (string, bool) {
+		if name == target {
+			return "", true
+		}
+		rel, ok := strings.CutPrefix(name, target)
+		return rel, ok && (target == "/" || rel[0] == '/')
+	}
+	for i := len(*p.Ops) - 1; i >= 0; i-- {
+		switch op := (*p.Ops)[i].(type) {
+		case *BindMount:
+			if rel, ok := covers(op.Target); ok {
+				return path.Join(op.Source, rel), true
+			}
+		case *MountTmpfs:
+			if _, ok := covers(op.Path); ok {
+				return "", false
+			}
+		}
+	}
+	return "", false
+}
+
+// readBwrapFd reads until EOF from the file descriptor represented by s and closes it.
+func readBwrapFd(s string) ([]byte, error) {
+	fd, err := strconv.Atoi(s)
+	if err != nil || fd < 0 {
+		return nil, fmt.Errorf("%w: bad file descriptor %q", ErrBwrapArgs, s)
+	}
+	f := os.NewFile(uintptr(fd), "fd "+s)
+	defer func() { _ = f.Close() }()
+	if data, err := io.ReadAll(f); err != nil {
+		return nil, wrapErrSuffix(err, fmt.Sprintf("cannot read fd %d:", fd))
+	} else {
+		return data, nil
+	}
+}
+
+// unsetenv returns env without entries of key.
+func unsetenv(env []string, key string) []string {
+	return slices.DeleteFunc(env, func(e string) bool { return strings.HasPrefix(e, key+"=") })
+}
+
+// ExportBwrap returns a bwrap command line equivalent to params for debugging, excluding the program name.
+// Data of placed files and syscall filter programs are returned in the order they are referenced,
+// and are expected on consecutive file descriptors starting at fd. The preset syscall filter is exported
+// as the first program.
+//
+// Landlock, TUN devices, resource limits, exec connections and retained privileges have no bwrap equivalent
+// and are not exported.
+func ExportBwrap(params *Params, fd int) (args []string, files [][]byte, err error) {
+	nextFd := func(data []byte) string {
+		files = append(files, data)
+		return strconv.Itoa(fd + len(files) - 1)
+	}
+
+	args = []string{"--unshare-user", "--unshare-ipc", "--unshare-pid", "--unshare-uts", "--unshare-cgroup"}
+	if params.Flags&FAllowNet == 0 {
+		args = append(args, "--unshare-net")
+	}
+	args = append(args, "--die-with-parent")
+	if params.Flags&FAllowTTY == 0 {
+		args = append(args, "--new-session")
+	}
+	if params.Uid > 0 {
+		args = append(args, "--uid", strconv.Itoa(params.Uid))
+	}
+	if params.Gid > 0 {
+		args = append(args, "--gid", strconv.Itoa(params.Gid))
+	}
+	if params.Hostname != "" {
+		args = append(args, "--hostname", params.Hostname)
+	}
+
+	if params.Ops != nil {
+		for _, op := range *params.Ops {
+			var v []string
+			if v, err = exportBwrapOp(params, op, nextFd); err != nil {
+				return nil, nil, err
+			}
+			args = append(args, v...)
+		}
+	}
+
+	args = append(args, "--clearenv")
+	for _, e := range params.Env {
+		key, value, _ := strings.Cut(e, "=")
+		args = append(args, "--setenv", key, value)
+	}
+	if params.Dir != "" {
+		args = append(args, "--chdir", params.Dir)
+	}
+
+	buf := new(bytes.Buffer)
+	e := seccomp.New(params.Flags.seccomp(params.Seccomp), params.SeccompRules)
+	if _, err = io.Copy(buf, e); err != nil {
+		_ = e.Close()
+		return nil, nil, err
+	} else if err = e.Close(); err != nil {
+		return nil, nil, err
+	}
+	args = append(args, "--add-seccomp-fd", nextFd(buf.Bytes()))
+	for _, prog := range params.SeccompPrograms {
+		args = append(args, "--add-seccomp-fd", nextFd(prog))
+	}
+
+	if len(params.Args) > 0 && params.Args[0] != params.Path {
+		args = append(args, "--argv0", params.Args[0])
+	}
+	args = append(args, "--", params.Path)
+	if len(params.Args) > 1 {
+		args = append(args, params.Args[1:]...)
+	}
+	return
+}
+
+// exportBwrapOp returns bwrap options equivalent to op.
+func exportBwrapOp(params *Params, op Op, nextFd func(data []byte) string) ([]string, error) {
+	switch o := op.(type) {
+	case *BindMount:
+		var opt string
+		switch o.Flags &^ BindOptional {
+		case 0:
+			opt = "--ro-bind"
+		case BindWritable:
+			opt = "--bind"
+		case BindDevice, BindWritable | BindDevice:
+			opt = "--dev-bind"
+		default:
+			return nil, fmt.Errorf("%w: bind mount flags %#x", ErrBwrapUnsupported, o.Flags)
+		}
+		if o.Flags&BindOptional != 0 {
+			opt += "-try"
+		}
+		v := []string{opt, o.Source, o.Target}
+		if o.Flags&(BindWritable|BindDevice) == BindDevice {
+			v = append(v, "--remount-ro", o.Target)
+		}
+		return v, nil
+
+	case MountProc:
+		return []string{"--proc", string(o)}, nil
+	case MountDev:
+		return []string{"--dev", string(o)}, nil
+	case MountMqueue:
+		return []string{"--mqueue", string(o)}, nil
+	case *MountTmpfs:
+		v := []string{"--perms", fmt.Sprintf("%04o", o.Perm)}
+		if o.Size > 0 {
+			v = append(v, "--size", strconv.Itoa(o.Size))
+		}
+		return append(v, "--tmpfs", o.Path), nil
+	case *MountOverlay:
+		v := make([]string, 0, len(o.Lower)*2+4)
+		for _, name := range o.Lower {
+			v = append(v, "--overlay-src", name)
+		}
+		if o.Upper == "" {
+			return append(v, "--tmp-overlay", o.Target), nil
+		}
+		return append(v, "--overlay", o.Upper, o.Work, o.Target), nil
+
+	case *Symlink:
+		target := o[0]
+		if name, ok := strings.CutPrefix(target, "*"); ok {
+			if v, err := os.Readlink(name); err != nil {
+				return nil, wrapErrSelf(err)
+			} else {
+				target = v
+			}
+		}
+		return []string{"--symlink", target, o[1]}, nil
+	case *Mkdir:
+		return []string{"--perms", fmt.Sprintf("%04o", o.Perm), "--dir", o.Path}, nil
+	case *Tmpfile:
+		return []string{"--ro-bind-data", nextFd(o.Data), o.Path}, nil
+
+	case *AutoEtc:
+		// emulates apply via the host path of the preceding bind mount
+		host, ok := params.hostPath(o.hostPath())
+		if !ok {
+			return nil, msg.WrapErr(syscall.EBADE,
+				fmt.Sprintf("auto etc %s has no host directory", o.Prefix))
+		}
+		d, err := os.ReadDir(host)
+		if err != nil {
+			return nil, wrapErrSelf(err)
+		}
+		v := make([]string, 0, len(d)*3)
+		for _, ent := range d {
+			switch n := ent.Name(); n {
+			case ".host", "passwd", "group":
+			case "mtab":
+				v = append(v, "--symlink", "/proc/mounts", "/etc/"+n)
+			default:
+				v = append(v, "--symlink", o.hostRel()+"/"+n, "/etc/"+n)
+			}
+		}
+		return v, nil
+
+	default:
+		return nil, fmt.Errorf("%w: %s %s", ErrBwrapUnsupported, op.prefix(), op)
+	}
+}
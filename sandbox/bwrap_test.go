@@ -0,0 +1,241 @@
+package sandbox_test
+
+import (
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"slices"
+	"strconv"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/sandbox"
+)
+
+// pipeFd returns the read end of a pipe holding data as a string.
+func pipeFd(t *testing.T, data []byte) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Pipe: error = %v", err)
+	}
+	go func() { _, _ = w.Write(data); _ = w.Close() }()
+	t.Cleanup(func() { _ = r.Close() })
+	return strconv.Itoa(int(r.Fd()))
+}
+
+func TestParseBwrap(t *testing.T) {
+	bin := t.TempDir()
+	if err := os.WriteFile(path.Join(bin, "prog"), nil, 0755); err != nil {
+		t.Fatalf("WriteFile: error = %v", err)
+	}
+	prog := []byte{0x06, 0, 0, 0, 0, 0, 0xff, 0x7f} // BPF_RET|BPF_K SECCOMP_RET_ALLOW
+
+	testCases := []struct {
+		name    string
+		args    []string
+		want    *sandbox.Params
+		wantErr error
+	}{
+		{"mounts", []string{
+			"--unshare-all", "--die-with-parent", "--new-session", "--clearenv",
+			"--ro-bind", "/usr", "/usr",
+			"--ro-bind-try", "/opt", "/opt",
+			"--bind", "/var/tmp", "/var/tmp",
+			"--dev-bind", "/dev/dri", "/dev/dri",
+			"--dev-bind-try", "/dev/kvm", "/dev/kvm", "--remount-ro", "/dev/kvm",
+			"--proc", "/proc", "--dev", "/dev", "--mqueue", "/dev/mqueue",
+			"--perms", "0700", "--size", "4096", "--tmpfs", "/tmp",
+			"--tmpfs", "/run",
+			"--perms", "0750", "--dir", "/run/app",
+			"--symlink", "usr/lib", "/lib",
+			"--overlay-src", "/etc", "--tmp-overlay", "/etc",
+			"--overlay-src", "/srv/a", "--overlay-src", "/srv/b", "--overlay", "/srv/upper", "/srv/work", "/srv",
+			"--file", pipeFd(t, []byte("fortify")), "/etc/hostname",
+			"--setenv", "HOME", "/home", "--setenv", "TERM", "dumb", "--setenv", "HOME", "/", "--unsetenv", "TERM",
+			"--chdir", "/tmp", "--hostname", "sandbox", "--uid", "1000", "--gid", "100",
+			"--seccomp", pipeFd(t, prog),
+			"/usr/bin/env", "-i",
+		}, &sandbox.Params{
+			Dir:      "/tmp",
+			Env:      []string{"HOME=/"},
+			Path:     "/usr/bin/env",
+			Args:     []string{"/usr/bin/env", "-i"},
+			Uid:      1000,
+			Gid:      100,
+			Hostname: "sandbox",
+			Ops: new(sandbox.Ops).
+				Bind("/usr", "/usr", 0).
+				Bind("/opt", "/opt", sandbox.BindOptional).
+				Bind("/var/tmp", "/var/tmp", sandbox.BindWritable).
+				Bind("/dev/dri", "/dev/dri", sandbox.BindWritable|sandbox.BindDevice).
+				Bind("/dev/kvm", "/dev/kvm", sandbox.BindDevice|sandbox.BindOptional).
+				Proc("/proc").Dev("/dev").Mqueue("/dev/mqueue").
+				Tmpfs("/tmp", 4096, 0700).
+				Tmpfs("/run", 0, 0755).
+				Mkdir("/run/app", 0750).
+				Link("usr/lib", "/lib").
+				Overlay("/etc", []string{"/etc"}, "", "").
+				Overlay("/srv", []string{"/srv/a", "/srv/b"}, "/srv/upper", "/srv/work").
+				Place("/etc/hostname", []byte("fortify")),
+			SeccompPrograms: [][]byte{prog},
+		}, nil},
+
+		{"lookup", []string{
+			"--clearenv", "--share-net",
+			"--ro-bind", bin, "/opt/bin",
+			"--setenv", "PATH", "/nonexistent:relative:/opt/bin",
+			"--argv0", "renamed",
+			"--", "prog", "--flag",
+		}, &sandbox.Params{
+			Dir:   "/",
+			Env:   []string{"PATH=/nonexistent:relative:/opt/bin"},
+			Path:  "/opt/bin/prog",
+			Args:  []string{"renamed", "--flag"},
+			Ops:   new(sandbox.Ops).Bind(bin, "/opt/bin", 0),
+			Flags: sandbox.FAllowNet,
+		}, nil},
+
+		{"args fd", []string{
+			"--clearenv", "--unshare-net",
+			"--args", pipeFd(t, []byte("--chdir\x00/srv\x00--proc\x00/proc\x00")),
+			"./prog",
+		}, &sandbox.Params{
+			Dir:  "/srv",
+			Env:  []string{},
+			Path: "/srv/prog",
+			Args: []string{"./prog"},
+			Ops:  new(sandbox.Ops).Proc("/proc"),
+		}, nil},
+
+		{"no command", []string{"--clearenv"}, nil, sandbox.ErrBwrapArgs},
+		{"missing argument", []string{"--bind", "/"}, nil, sandbox.ErrBwrapArgs},
+		{"not found", []string{"--clearenv", "--ro-bind", bin, "/opt/bin", "--tmpfs", "/opt", "prog"}, nil, sandbox.ErrBwrapArgs},
+		{"dangling perms", []string{"--perms", "0700", "--proc", "/proc", "/bin/sh"}, nil, sandbox.ErrBwrapArgs},
+		{"bad perms", []string{"--perms", "0799", "--tmpfs", "/tmp", "/bin/sh"}, nil, sandbox.ErrBwrapArgs},
+		{"bad size", []string{"--size", "-1", "--tmpfs", "/tmp", "/bin/sh"}, nil, sandbox.ErrBwrapArgs},
+		{"bad uid", []string{"--uid", "root", "/bin/sh"}, nil, sandbox.ErrBwrapArgs},
+		{"bad fd", []string{"--file", "stdin", "/etc/passwd", "/bin/sh"}, nil, sandbox.ErrBwrapArgs},
+		{"bad program", []string{"--seccomp", pipeFd(t, prog[:7]), "/bin/sh"}, nil, sandbox.ErrBwrapArgs},
+		{"overlay without source", []string{"--tmp-overlay", "/etc", "/bin/sh"}, nil, sandbox.ErrBwrapArgs},
+		{"trailing overlay source", []string{"--overlay-src", "/etc", "--", "/bin/sh"}, nil, sandbox.ErrBwrapArgs},
+		{"unknown option", []string{"--cap-add", "ALL", "/bin/sh"}, nil, sandbox.ErrBwrapUnsupported},
+		{"remount", []string{"--proc", "/proc", "--remount-ro", "/proc", "/bin/sh"}, nil, sandbox.ErrBwrapUnsupported},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := sandbox.ParseBwrap(tc.args)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("ParseBwrap: error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("ParseBwrap:\n%#v, want\n%#v", got, tc.want)
+				if got != nil && tc.want != nil {
+					t.Errorf("Ops: %q, want %q", got.Ops.Describe(), tc.want.Ops.Describe())
+				}
+			}
+		})
+	}
+}
+
+func TestExportBwrap(t *testing.T) {
+	etc := t.TempDir()
+	for _, name := range []string{"hosts", "passwd", "mtab"} {
+		if err := os.WriteFile(path.Join(etc, name), nil, 0644); err != nil {
+			t.Fatalf("WriteFile: error = %v", err)
+		}
+	}
+
+	params := &sandbox.Params{
+		Dir:      "/tmp",
+		Env:      []string{"HOME=/", "EMPTY="},
+		Path:     "/usr/bin/env",
+		Args:     []string{"env", "-i"},
+		Uid:      1000,
+		Gid:      100,
+		Hostname: "sandbox",
+		Ops: new(sandbox.Ops).
+			Bind("/usr", "/usr", 0).
+			Bind("/dev/kvm", "/dev/kvm", sandbox.BindDevice|sandbox.BindOptional).
+			Proc("/proc").Dev("/dev").
+			Tmpfs("/tmp", 4096, 0700).
+			Mkdir("/run/app", 0750).
+			Overlay("/srv", []string{"/srv/a"}, "/srv/upper", "/srv/work").
+			Place("/etc/hostname", []byte("fortify")).
+			Etc(etc, "prefix"),
+		SeccompPrograms: [][]byte{{0x06, 0, 0, 0, 0, 0, 0xff, 0x7f}},
+		Flags:           sandbox.FAllowTTY,
+	}
+
+	const fd = 1 << 10
+	args, files, err := sandbox.ExportBwrap(params, fd)
+	if err != nil {
+		t.Fatalf("ExportBwrap: error = %v", err)
+	}
+	want := []string{
+		"--unshare-user", "--unshare-ipc", "--unshare-pid", "--unshare-uts", "--unshare-cgroup", "--unshare-net",
+		"--die-with-parent",
+		"--uid", "1000", "--gid", "100", "--hostname", "sandbox",
+		"--ro-bind", "/usr", "/usr",
+		"--dev-bind-try", "/dev/kvm", "/dev/kvm", "--remount-ro", "/dev/kvm",
+		"--proc", "/proc", "--dev", "/dev",
+		"--perms", "0700", "--size", "4096", "--tmpfs", "/tmp",
+		"--perms", "0750", "--dir", "/run/app",
+		"--overlay-src", "/srv/a", "--overlay", "/srv/upper", "/srv/work", "/srv",
+		"--ro-bind-data", "1024", "/etc/hostname",
+		"--perms", "0755", "--dir", "/etc",
+		"--ro-bind", etc, "/etc/.host/prefix",
+		"--symlink", ".host/prefix/hosts", "/etc/hosts",
+		"--symlink", "/proc/mounts", "/etc/mtab",
+		"--clearenv", "--setenv", "HOME", "/", "--setenv", "EMPTY", "",
+		"--chdir", "/tmp",
+		"--add-seccomp-fd", "1025", "--add-seccomp-fd", "1026",
+		"--argv0", "env", "--", "/usr/bin/env", "-i",
+	}
+	if !slices.Equal(args, want) {
+		t.Errorf("ExportBwrap:\n%q, want\n%q", args, want)
+	}
+	if len(files) != 3 || string(files[0]) != "fortify" || len(files[1]) == 0 || len(files[1])%8 != 0 ||
+		!slices.Equal(files[2], params.SeccompPrograms[0]) {
+		t.Errorf("ExportBwrap: files %q", files)
+	}
+
+	t.Run("parse", func(t *testing.T) {
+		// substitute pipes holding the exported data for file descriptor arguments
+		for i := range args {
+			if n, err := strconv.Atoi(args[i]); err == nil && n >= fd && n < fd+len(files) && i > 0 && args[i-1] != "--uid" {
+				args[i] = pipeFd(t, files[n-fd])
+			}
+		}
+		got, err := sandbox.ParseBwrap(args)
+		if err != nil {
+			t.Fatalf("ParseBwrap: error = %v", err)
+		}
+
+		wantOps := new(sandbox.Ops).
+			Bind("/usr", "/usr", 0).
+			Bind("/dev/kvm", "/dev/kvm", sandbox.BindDevice|sandbox.BindOptional).
+			Proc("/proc").Dev("/dev").
+			Tmpfs("/tmp", 4096, 0700).
+			Mkdir("/run/app", 0750).
+			Overlay("/srv", []string{"/srv/a"}, "/srv/upper", "/srv/work").
+			Place("/etc/hostname", []byte("fortify")).
+			Mkdir("/etc", 0755).
+			Bind(etc, "/etc/.host/prefix", 0).
+			Link(".host/prefix/hosts", "/etc/hosts").
+			Link("/proc/mounts", "/etc/mtab")
+		if !reflect.DeepEqual(got.Ops, wantOps) {
+			t.Errorf("ParseBwrap: %q, want %q", got.Ops.Describe(), wantOps.Describe())
+		}
+		if got.Path != params.Path || !slices.Equal(got.Args, params.Args) || !slices.Equal(got.Env, params.Env) ||
+			got.Dir != params.Dir || got.Hostname != params.Hostname || got.Uid != params.Uid || got.Gid != params.Gid ||
+			got.Flags != 0 || len(got.SeccompPrograms) != 2 {
+			t.Errorf("ParseBwrap: %#v", got)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		if _, _, err := sandbox.ExportBwrap(&sandbox.Params{Ops: new(sandbox.Ops).Etc("/nonexistent", "prefix")}, fd); err == nil {
+			t.Errorf("ExportBwrap: error = %v", err)
+		}
+	})
+}
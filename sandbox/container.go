@@ -10,6 +10,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"slices"
 	"strconv"
 	"syscall"
 	"time"
@@ -62,6 +63,12 @@ type (
 		setup *gob.Encoder
 		// cancels cmd
 		cancel context.CancelFunc
+		// receives tun device from init
+		tun *os.File
+		// receives seccomp listener from init
+		listener *os.File
+		// passes exec connections to init
+		exec *os.File
 
 		Stdin  io.Reader
 		Stdout io.Writer
@@ -92,20 +99,42 @@ type (
 		Gid int
 		// Hostname value in UTS namespace.
 		Hostname string
+		// Resource limits of the initial process.
+		Rlimits []Rlimit
 		// Sequential container setup ops.
 		*Ops
 		// Extra seccomp options.
 		Seccomp seccomp.FilterOpts
+		// User-defined seccomp rules merged with the preset filter.
+		SeccompRules []seccomp.Rule
+		// Classic BPF programs loaded as additional syscall filters after the preset filter.
+		SeccompPrograms [][]byte
 		// Permission bits of newly created parent directories.
 		// The zero value is interpreted as 0755.
 		ParentPerm os.FileMode
 		// Retain CAP_SYS_ADMIN.
 		Privileged bool
+		// Create a TUN device in the private network namespace, nil to disable.
+		Tun *TunConfig
+		// Restrict filesystem access of the initial process to paths set up by Ops using Landlock.
+		// This is best-effort and has no effect on kernels without Landlock ABI version 2.
+		// Access rights are inherited by everything beneath a path, so a read-only bind mount
+		// nested under a writable mount point is writable by the initial process.
+		Landlock bool
+		// Start additional processes in the container on connections passed via [Container.SendExec].
+		Exec bool
 
 		Flags HardeningFlags
 	}
 )
 
+// Rlimit is a resource limit set by init before starting the initial process, see setrlimit(2).
+type Rlimit struct {
+	// Resource number, such as [syscall.RLIMIT_NOFILE].
+	Resource int
+	Cur, Max uint64
+}
+
 func (p *Container) Start() error {
 	if p.cmd != nil {
 		return errors.New("sandbox: already started")
@@ -122,6 +151,8 @@ func (p *Container) Start() error {
 		syscall.CLONE_NEWCGROUP
 	if p.Flags&FAllowNet == 0 {
 		cloneFlags |= syscall.CLONE_NEWNET
+	} else if p.Tun != nil {
+		return errors.New("sandbox: tun device requires a private network namespace")
 	}
 
 	// map to overflow id to work around ownership checks
@@ -161,6 +192,13 @@ func (p *Container) Start() error {
 
 		UseCgroupFD: p.Cgroup != nil,
 	}
+	if slices.ContainsFunc(*p.Ops, func(op Op) bool { _, ok := op.(*MountOverlay); return ok }) {
+		// overlayfs requires CAP_DAC_OVERRIDE to access its work directory
+		p.cmd.SysProcAttr.AmbientCaps = append(p.cmd.SysProcAttr.AmbientCaps, CAP_DAC_OVERRIDE)
+	}
+	if p.Tun != nil {
+		p.cmd.SysProcAttr.AmbientCaps = append(p.cmd.SysProcAttr.AmbientCaps, CAP_NET_ADMIN)
+	}
 	if p.cmd.SysProcAttr.UseCgroupFD {
 		p.cmd.SysProcAttr.CgroupFD = *p.Cgroup
 	}
@@ -175,8 +213,54 @@ func (p *Container) Start() error {
 	}
 	p.cmd.ExtraFiles = append(p.cmd.ExtraFiles, p.ExtraFiles...)
 
+	// rights sockets are placed after user supplied extra files and are closed by init before exec
+	closeRights := func() {
+		if p.tun != nil {
+			_ = p.tun.Close()
+			p.tun = nil
+		}
+		if p.listener != nil {
+			_ = p.listener.Close()
+			p.listener = nil
+		}
+		if p.exec != nil {
+			_ = p.exec.Close()
+			p.exec = nil
+		}
+	}
+	if p.Tun != nil {
+		if parent, child, err := rightsSocket("tun"); err != nil {
+			return err
+		} else {
+			p.tun = parent
+			p.cmd.ExtraFiles = append(p.cmd.ExtraFiles, child)
+			defer func() { _ = child.Close() }()
+		}
+	}
+	if seccomp.Notifies(p.Flags.seccomp(p.Seccomp), p.SeccompRules) {
+		if parent, child, err := rightsSocket("seccomp listener"); err != nil {
+			closeRights()
+			return err
+		} else {
+			p.listener = parent
+			p.cmd.ExtraFiles = append(p.cmd.ExtraFiles, child)
+			defer func() { _ = child.Close() }()
+		}
+	}
+	if p.Exec {
+		if parent, child, err := rightsSocket("exec"); err != nil {
+			closeRights()
+			return err
+		} else {
+			p.exec = parent
+			p.cmd.ExtraFiles = append(p.cmd.ExtraFiles, child)
+			defer func() { _ = child.Close() }()
+		}
+	}
+
 	msg.Verbose("starting container init")
 	if err := p.cmd.Start(); err != nil {
+		closeRights()
 		return msg.WrapErr(err, err.Error())
 	}
 	return nil
@@ -230,6 +314,70 @@ func (p *Container) Serve() error {
 	return err
 }
 
+// ReceiveListener blocks until the listener of a syscall filter passing syscalls to a supervisor is received
+// from the container. This must be called after a successful call to [Container.Serve].
+func (p *Container) ReceiveListener() (*seccomp.Listener, error) {
+	if p.listener == nil {
+		return nil, errors.New("sandbox: syscall filter does not pass syscalls to a supervisor")
+	}
+	f := p.listener
+	p.listener = nil
+
+	if fd, err := receiveRights(f, "seccomp listener"); err != nil {
+		return nil, err
+	} else if l, err := seccomp.NewListener(fd); err != nil {
+		return nil, wrapErrSelf(err)
+	} else {
+		return l, nil
+	}
+}
+
+// NewSupervisor returns a [seccomp.Supervisor] of l received via [Container.ReceiveListener] responding
+// according to rules. Syscalls matching none of rules are decided by the rest of the syscall filter of p.
+func (p *Container) NewSupervisor(l *seccomp.Listener, rules []seccomp.NotifyRule) (*seccomp.Supervisor, error) {
+	return seccomp.NewSupervisor(l, p.Flags.seccomp(p.Seccomp), p.SeccompRules, rules)
+}
+
+// RelaySignals are signals delivered to the initial process by [Container.Signal].
+var RelaySignals = []syscall.Signal{
+	syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2,
+	syscall.SIGALRM, syscall.SIGTERM, syscall.SIGCONT, syscall.SIGTSTP, syscall.SIGWINCH,
+}
+
+const (
+	// sent to init in place of SIGINT and SIGTERM, which terminate the container when sent to init
+	sigRelayInt  = syscall.Signal(34)
+	sigRelayTerm = syscall.Signal(35)
+)
+
+// Signal delivers sig to the initial process of the container. Sig must be one of [RelaySignals],
+// or SIGKILL, which kills init and therefore every process in the container.
+// Signal is safe for concurrent use with [Container.Wait].
+func (p *Container) Signal(sig syscall.Signal) error {
+	if p.cmd == nil || p.cmd.Process == nil {
+		return errors.New("sandbox: container not started")
+	}
+	switch {
+	case sig == syscall.SIGKILL:
+	case sig == syscall.SIGINT:
+		sig = sigRelayInt
+	case sig == syscall.SIGTERM:
+		sig = sigRelayTerm
+	case !slices.Contains(RelaySignals, sig):
+		return fmt.Errorf("sandbox: cannot relay %s", sig)
+	}
+	return p.cmd.Process.Signal(sig)
+}
+
+// SendExec passes conn to the container, which starts a process requested over conn via [Exec].
+// [Params.Exec] must be set. SendExec is safe for concurrent use with [Container.Wait].
+func (p *Container) SendExec(conn *os.File) error {
+	if p.exec == nil {
+		return errors.New("sandbox: container does not accept exec connections")
+	}
+	return sendRights(int(p.exec.Fd()), int(conn.Fd()), "exec connection")
+}
+
 func (p *Container) Wait() error { defer p.cancel(); return p.cmd.Wait() }
 
 func (p *Container) String() string {
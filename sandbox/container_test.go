@@ -4,9 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"errors"
+	"io"
 	"log"
+	"net"
 	"os"
 	"os/exec"
+	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
 	"testing"
@@ -66,83 +71,220 @@ func TestContainer(t *testing.T) {
 				e("/", "/dev/pts", "rw,nosuid,noexec,relatime", "devpts", "devpts", "rw,mode=620,ptmxmode=666"),
 				e("/", "/dev/mqueue", "rw,nosuid,nodev,noexec,relatime", "mqueue", "mqueue", "rw"),
 			}, ""},
+		{"overlay", 0,
+			new(sandbox.Ops).
+				Overlay("/opt", []string{"/etc"}, "", ""),
+			[]*vfs.MountInfoEntry{
+				e("/", "/opt", "rw,nosuid,nodev,relatime", "overlay", "overlay", ignore),
+			}, "test-overlay"},
+		{"signal", 0, new(sandbox.Ops), nil, "test-signal"},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-
-			container := sandbox.New(ctx, "/usr/bin/sandbox.test", "-test.v",
-				"-test.run=TestHelperCheckContainer", "--", "check", tc.host)
-			container.Uid = 1000
-			container.Gid = 100
-			container.Hostname = tc.host
-			container.CommandContext = commandContext
-			container.Flags |= tc.flags
-			container.Stdout, container.Stderr = os.Stdout, os.Stderr
-			container.Ops = tc.ops
-			if container.Args[5] == "" {
-				if name, err := os.Hostname(); err != nil {
-					t.Fatalf("cannot get hostname: %v", err)
-				} else {
-					container.Args[5] = name
+			testContainer(t, tc.flags, tc.ops, tc.mnt, tc.host, containerFeatures{})
+		})
+	}
+
+	featureCases := []struct {
+		name string
+		ops  *sandbox.Ops
+		mnt  []*vfs.MountInfoEntry
+		host string
+		containerFeatures
+	}{
+		{"landlock",
+			new(sandbox.Ops).
+				Tmpfs(fst.Tmp, 0, 0755),
+			[]*vfs.MountInfoEntry{
+				e("/", fst.Tmp, "rw,nosuid,nodev,relatime", "tmpfs", "tmpfs", ignore),
+			}, "test-landlock", containerFeatures{landlock: true}},
+		{"audit", new(sandbox.Ops), nil, "test-audit", containerFeatures{audit: true}},
+		{"supervise", new(sandbox.Ops), nil, "test-supervise", containerFeatures{supervise: []seccomp.NotifyRule{
+			{Syscall: "syslog", Response: seccomp.ResponseReturn, Value: 0xbeef,
+				Args: []seccomp.ArgCmp{{Index: 0, Op: seccomp.CmpEQ, Value: 10}}},
+			{Syscall: "chdir", Response: seccomp.ResponseReturn,
+				Strings: &seccomp.StringArg{Index: 0, Values: []string{"/nonexistent"}}},
+		}}},
+		{"exec", new(sandbox.Ops), nil, "test-exec", containerFeatures{exec: true}},
+		{"rlimit", new(sandbox.Ops), nil, "test-rlimit", containerFeatures{
+			rlimits: []sandbox.Rlimit{{Resource: syscall.RLIMIT_FSIZE, Cur: 1 << 20, Max: 1 << 30}}}},
+	}
+
+	for _, tc := range featureCases {
+		t.Run(tc.name, func(t *testing.T) {
+			testContainer(t, 0, tc.ops, tc.mnt, tc.host, tc.containerFeatures)
+		})
+	}
+}
+
+// containerFeatures are container features enabled by a [TestContainer] case on top of hardening flags and ops.
+type containerFeatures struct {
+	landlock  bool
+	audit     bool
+	supervise []seccomp.NotifyRule
+	exec      bool
+	rlimits   []sandbox.Rlimit
+}
+
+func testContainer(
+	t *testing.T,
+	flags sandbox.HardeningFlags, ops *sandbox.Ops, mountinfo []*vfs.MountInfoEntry, host string,
+	f containerFeatures,
+) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	container := sandbox.New(ctx, "/usr/bin/sandbox.test", "-test.v",
+		"-test.run=TestHelperCheckContainer", "--", "check", host)
+	container.Uid = 1000
+	container.Gid = 100
+	container.Hostname = host
+	container.CommandContext = commandContext
+	container.Flags |= flags
+	container.Stdout, container.Stderr = os.Stdout, os.Stderr
+	container.Ops = ops
+	container.Landlock = f.landlock
+	if f.audit {
+		container.Seccomp |= seccomp.FilterAudit
+	}
+	container.SeccompRules = seccomp.NotifyFilterRules(f.supervise)
+	container.Exec = f.exec
+	container.Rlimits = f.rlimits
+	if container.Args[5] == "" {
+		if name, err := os.Hostname(); err != nil {
+			t.Fatalf("cannot get hostname: %v", err)
+		} else {
+			container.Args[5] = name
+		}
+	}
+
+	container.
+		Tmpfs("/tmp", 0, 0755).
+		Bind(os.Args[0], os.Args[0], 0).
+		Mkdir("/usr/bin", 0755).
+		Link(os.Args[0], "/usr/bin/sandbox.test").
+		Place("/etc/hostname", []byte(container.Args[5]))
+	// in case test has cgo enabled
+	var libPaths []string
+	if entries, err := ldd.ExecFilter(ctx,
+		commandContext,
+		func(v []byte) []byte {
+			return bytes.SplitN(v, []byte("TestHelperInit\n"), 2)[1]
+		}, os.Args[0]); err != nil {
+		log.Fatalf("ldd: %v", err)
+	} else {
+		libPaths = ldd.Path(entries)
+	}
+	for _, name := range libPaths {
+		container.Bind(name, name, 0)
+	}
+	// needs /proc to check mountinfo
+	container.Proc("/proc")
+
+	mnt := make([]*vfs.MountInfoEntry, 0, 3+len(libPaths))
+	mnt = append(mnt, e("/sysroot", "/", "rw,nosuid,nodev,relatime", "tmpfs", "rootfs", ignore))
+	mnt = append(mnt, mountinfo...)
+	mnt = append(mnt,
+		e("/", "/tmp", "rw,nosuid,nodev,relatime", "tmpfs", "tmpfs", ignore),
+		e(ignore, os.Args[0], "ro,nosuid,nodev,relatime", ignore, ignore, ignore),
+		e(ignore, "/etc/hostname", "ro,nosuid,nodev,relatime", "tmpfs", "rootfs", ignore),
+	)
+	for _, name := range libPaths {
+		mnt = append(mnt, e(ignore, name, "ro,nosuid,nodev,relatime", ignore, ignore, ignore))
+	}
+	mnt = append(mnt, e("/", "/proc", "rw,nosuid,nodev,noexec,relatime", "proc", "proc", "rw"))
+	want := new(bytes.Buffer)
+	if err := gob.NewEncoder(want).Encode(mnt); err != nil {
+		t.Fatalf("cannot serialise expected mount points: %v", err)
+	}
+	container.Stdin = want
+
+	if err := container.Start(); err != nil {
+		fmsg.PrintBaseError(err, "start:")
+		t.Fatalf("cannot start container: %v", err)
+	} else if err = container.Serve(); err != nil {
+		fmsg.PrintBaseError(err, "serve:")
+		t.Errorf("cannot serve setup params: %v", err)
+	}
+
+	var audit chan []string
+	if f.audit || f.supervise != nil {
+		l, err := container.ReceiveListener()
+		if err != nil {
+			fmsg.PrintBaseError(err, "listener:")
+			t.Fatalf("cannot receive seccomp listener: %v", err)
+		}
+		s, err := container.NewSupervisor(l, f.supervise)
+		if err != nil {
+			t.Fatalf("NewSupervisor: error = %v", err)
+		}
+		audit = make(chan []string, 1)
+		go func() {
+			defer func() { _ = l.Close() }()
+			var names []string
+			for {
+				var n seccomp.Notif
+				if err := l.Receive(&n); err != nil {
+					if !errors.Is(err, io.EOF) {
+						t.Errorf("Receive: error = %v", err)
+					}
+					break
+				}
+				name := seccomp.SyscallName(n.Data.Arch, n.Data.Nr)
+				resp, err := s.Match(&n, name)
+				if err != nil {
+					t.Errorf("Match: error = %v", err)
+				}
+				if resp == nil {
+					if resp = s.Fallback(&n); f.audit && resp.Flags&seccomp.NotifFlagContinue == 0 {
+						names = append(names, name)
+						resp = &seccomp.NotifResp{ID: n.ID, Flags: seccomp.NotifFlagContinue}
+					}
+				}
+				if err := l.Respond(resp); err != nil {
+					t.Errorf("Respond: error = %v", err)
 				}
 			}
+			audit <- names
+		}()
+	}
 
-			container.
-				Tmpfs("/tmp", 0, 0755).
-				Bind(os.Args[0], os.Args[0], 0).
-				Mkdir("/usr/bin", 0755).
-				Link(os.Args[0], "/usr/bin/sandbox.test").
-				Place("/etc/hostname", []byte(container.Args[5]))
-			// in case test has cgo enabled
-			var libPaths []string
-			if entries, err := ldd.ExecFilter(ctx,
-				commandContext,
-				func(v []byte) []byte {
-					return bytes.SplitN(v, []byte("TestHelperInit\n"), 2)[1]
-				}, os.Args[0]); err != nil {
-				log.Fatalf("ldd: %v", err)
-			} else {
-				libPaths = ldd.Path(entries)
-			}
-			for _, name := range libPaths {
-				container.Bind(name, name, 0)
-			}
-			// needs /proc to check mountinfo
-			container.Proc("/proc")
-
-			mnt := make([]*vfs.MountInfoEntry, 0, 3+len(libPaths))
-			mnt = append(mnt, e("/sysroot", "/", "rw,nosuid,nodev,relatime", "tmpfs", "rootfs", ignore))
-			mnt = append(mnt, tc.mnt...)
-			mnt = append(mnt,
-				e("/", "/tmp", "rw,nosuid,nodev,relatime", "tmpfs", "tmpfs", ignore),
-				e(ignore, os.Args[0], "ro,nosuid,nodev,relatime", ignore, ignore, ignore),
-				e(ignore, "/etc/hostname", "ro,nosuid,nodev,relatime", "tmpfs", "rootfs", ignore),
-			)
-			for _, name := range libPaths {
-				mnt = append(mnt, e(ignore, name, "ro,nosuid,nodev,relatime", ignore, ignore, ignore))
-			}
-			mnt = append(mnt, e("/", "/proc", "rw,nosuid,nodev,noexec,relatime", "proc", "proc", "rw"))
-			want := new(bytes.Buffer)
-			if err := gob.NewEncoder(want).Encode(mnt); err != nil {
-				t.Fatalf("cannot serialise expected mount points: %v", err)
-			}
-			container.Stdin = want
-
-			if err := container.Start(); err != nil {
-				fmsg.PrintBaseError(err, "start:")
-				t.Fatalf("cannot start container: %v", err)
-			} else if err = container.Serve(); err != nil {
-				fmsg.PrintBaseError(err, "serve:")
-				t.Errorf("cannot serve setup params: %v", err)
-			}
-			if err := container.Wait(); err != nil {
-				fmsg.PrintBaseError(err, "wait:")
-				t.Fatalf("wait: %v", err)
+	if f.exec {
+		if fd, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0); err != nil {
+			t.Fatalf("Socketpair: error = %v", err)
+		} else {
+			local, remote := os.NewFile(uintptr(fd[0]), "local"), os.NewFile(uintptr(fd[1]), "remote")
+			if err = container.SendExec(remote); err != nil {
+				t.Fatalf("SendExec: error = %v", err)
 			}
-		})
+			_ = remote.Close()
+			c, err := net.FileConn(local)
+			_ = local.Close()
+			if err != nil {
+				t.Fatalf("FileConn: error = %v", err)
+			}
+			if status, err := sandbox.Exec(c.(*net.UnixConn), &sandbox.ExecRequest{
+				Path: "/usr/bin/sandbox.test",
+				Args: []string{"/usr/bin/sandbox.test", "-test.v", "-test.run=TestHelperExec", "--", "exec"},
+			}, os.Stdin, os.Stdout, os.Stderr); err != nil {
+				fmsg.PrintBaseError(err, "exec:")
+				t.Errorf("Exec: error = %v", err)
+			} else if status != 0 {
+				t.Errorf("Exec: status = %d", status)
+			}
+			_ = c.Close()
+		}
+	}
+
+	if err := container.Wait(); err != nil {
+		fmsg.PrintBaseError(err, "wait:")
+		t.Fatalf("wait: %v", err)
+	}
+	if audit != nil {
+		if names := <-audit; f.audit && !slices.Contains(names, "syslog") {
+			t.Errorf("audit: %q, want syslog", names)
+		}
 	}
 }
 
@@ -179,6 +321,43 @@ func TestHelperInit(t *testing.T) {
 	sandbox.Init(fmsg.Prepare, internal.InstallFmsg)
 }
 
+func TestHelperExec(t *testing.T) {
+	if len(os.Args) != 5 || os.Args[4] != "exec" {
+		return
+	}
+
+	if uid := syscall.Getuid(); uid != 1000 {
+		t.Errorf("Getuid: %d, want 1000", uid)
+	}
+	if ppid := os.Getppid(); ppid != 1 {
+		t.Errorf("Getppid: %d, want 1", ppid)
+	}
+	if err := os.WriteFile("/tmp/exec", nil, 0644); err != nil {
+		t.Fatalf("WriteFile: error = %v", err)
+	}
+
+	// restrictions of init only apply to the thread it starts processes from
+	if data, err := os.ReadFile("/proc/self/status"); err != nil {
+		t.Fatalf("ReadFile: error = %v", err)
+	} else {
+		status := make(map[string]string)
+		for _, line := range strings.Split(string(data), "\n") {
+			if k, v, ok := strings.Cut(line, ":"); ok {
+				status[k] = strings.TrimSpace(v)
+			}
+		}
+		for k, want := range map[string]string{
+			"Seccomp":    "2",
+			"NoNewPrivs": "1",
+			"CapEff":     "0000000000000000",
+		} {
+			if got := status[k]; got != want {
+				t.Errorf("%s: %q, want %q", k, got, want)
+			}
+		}
+	}
+}
+
 func TestHelperCheckContainer(t *testing.T) {
 	if len(os.Args) != 6 || os.Args[4] != "check" {
 		return
@@ -205,6 +384,91 @@ func TestHelperCheckContainer(t *testing.T) {
 			t.Errorf("/etc/hostname: %q, want %q", string(p), os.Args[5])
 		}
 	})
+	if os.Args[5] == "test-landlock" {
+		t.Run("landlock", func(t *testing.T) {
+			if err := os.WriteFile(fst.Tmp+"/landlock", nil, 0644); err != nil {
+				t.Errorf("WriteFile: error = %v", err)
+			}
+			if _, err := os.ReadFile("/etc/hostname"); err != nil {
+				t.Errorf("ReadFile: error = %v", err)
+			}
+			if err := os.Mkdir("/landlock", 0755); !errors.Is(err, syscall.EACCES) {
+				t.Errorf("Mkdir: error = %v, want %v", err, syscall.EACCES)
+			}
+		})
+	}
+	if os.Args[5] == "test-audit" {
+		t.Run("audit", func(t *testing.T) {
+			// allowed by the supervisor
+			if _, _, errno := syscall.Syscall(syscall.SYS_SYSLOG, 10, 0, 0); errno == syscall.ENOSYS {
+				t.Errorf("syslog: error = %v", errno)
+			}
+		})
+	}
+	if os.Args[5] == "test-signal" {
+		t.Run("signal", func(t *testing.T) {
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, syscall.SIGUSR1, syscall.SIGTERM)
+			defer signal.Stop(c)
+
+			// forwarded back by init, the second signal is sent by Container.Signal in place of SIGTERM
+			for _, sig := range [][2]syscall.Signal{{syscall.SIGUSR1, syscall.SIGUSR1}, {35, syscall.SIGTERM}} {
+				if err := syscall.Kill(1, sig[0]); err != nil {
+					t.Fatalf("Kill: error = %v", err)
+				}
+				select {
+				case got := <-c:
+					if got != sig[1] {
+						t.Errorf("received %s, want %s", got, sig[1])
+					}
+				case <-time.After(time.Second):
+					t.Errorf("%s not forwarded", sig[1])
+				}
+			}
+		})
+	}
+	if os.Args[5] == "test-rlimit" {
+		t.Run("rlimit", func(t *testing.T) {
+			var r syscall.Rlimit
+			if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &r); err != nil {
+				t.Fatalf("Getrlimit: error = %v", err)
+			}
+			if r.Cur != 1<<20 || r.Max != 1<<30 {
+				t.Errorf("Getrlimit: %d/%d, want %d/%d", r.Cur, r.Max, 1<<20, 1<<30)
+			}
+		})
+	}
+	if os.Args[5] == "test-exec" {
+		t.Run("exec", func(t *testing.T) {
+			// created by the process started via exec
+			for i := 0; i < 50; i++ {
+				if _, err := os.Stat("/tmp/exec"); err == nil {
+					return
+				}
+				time.Sleep(100 * time.Millisecond)
+			}
+			t.Errorf("/tmp/exec not created")
+		})
+	}
+	if os.Args[5] == "test-supervise" {
+		t.Run("supervise", func(t *testing.T) {
+			if r, _, errno := syscall.Syscall(syscall.SYS_SYSLOG, 10, 0, 0); errno != 0 || r != 0xbeef {
+				t.Errorf("syslog: %#x, error = %v", r, errno)
+			}
+			if _, _, errno := syscall.Syscall(syscall.SYS_SYSLOG, 3, 0, 0); errno != syscall.EPERM {
+				t.Errorf("syslog: error = %v, want %v", errno, syscall.EPERM)
+			}
+			if err := syscall.Chdir("/nonexistent"); err != nil {
+				t.Errorf("Chdir: error = %v", err)
+			}
+			if err := syscall.Chdir("/enoent"); !errors.Is(err, syscall.ENOENT) {
+				t.Errorf("Chdir: error = %v, want %v", err, syscall.ENOENT)
+			}
+			if err := syscall.Chdir("/proc"); err != nil {
+				t.Errorf("Chdir: error = %v", err)
+			}
+		})
+	}
 	t.Run("mount", func(t *testing.T) {
 		var mnt []*vfs.MountInfoEntry
 		if err := gob.NewDecoder(os.Stdin).Decode(&mnt); err != nil {
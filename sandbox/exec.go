@@ -0,0 +1,273 @@
+package sandbox
+
+import (
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ExecRequest describes an additional process started in a running container via [Exec].
+// The process inherits credentials, environment, working directory and restrictions of the initial process.
+type ExecRequest struct {
+	// Absolute path or name looked up in PATH of the container environment.
+	// Defaults to SHELL of the container environment, or /bin/sh.
+	Path string
+	// Process argv, defaults to Path.
+	Args []string
+}
+
+// execResult is sent by init once the process of an [ExecRequest] exits.
+type execResult struct {
+	// exit code, 128 + signal number if the process is terminated by a signal
+	Status int
+	// non-empty if the process could not be started
+	Err string
+}
+
+// Exec requests the container served on conn via [Container.SendExec] to start a process described by req with standard streams
+// stdin, stdout and stderr, then blocks until the process exits and returns its exit code.
+// The process is killed if conn is closed before it exits.
+func Exec(conn *net.UnixConn, req *ExecRequest, stdin, stdout, stderr *os.File) (int, error) {
+	if _, _, err := conn.WriteMsgUnix([]byte{0},
+		syscall.UnixRights(int(stdin.Fd()), int(stdout.Fd()), int(stderr.Fd())), nil); err != nil {
+		return -1, wrapErrSuffix(err,
+			"cannot send standard streams:")
+	}
+	if err := gob.NewEncoder(conn).Encode(req); err != nil {
+		return -1, wrapErrSuffix(err,
+			"cannot send exec request:")
+	}
+
+	var res execResult
+	if err := gob.NewDecoder(conn).Decode(&res); err != nil {
+		if errors.Is(err, io.EOF) {
+			return -1, msg.WrapErr(syscall.EPIPE,
+				"container exited before the process")
+		}
+		return -1, wrapErrSuffix(err,
+			"cannot receive exec result:")
+	}
+	if res.Err != "" {
+		return res.Status, msg.WrapErr(syscall.ENOEXEC, res.Err)
+	}
+	return res.Status, nil
+}
+
+// execServer starts processes requested over connections received by init.
+type execServer struct {
+	env []string
+	dir string
+
+	// processes to start on the thread holding the restrictions of init, see start
+	starts chan *execStart
+
+	// exit status channels by pid, pids are registered before they can be reaped
+	waiters   map[int]chan syscall.WaitStatus
+	waitersMu sync.Mutex
+}
+
+// execStart is a process start requested by a connection handler.
+type execStart struct {
+	path  string
+	args  []string
+	files []*os.File
+	// receives the exit status of the process once it is reaped
+	w chan syscall.WaitStatus
+
+	p   *os.Process
+	err error
+	// closed once the process is started or err is set
+	done chan struct{}
+}
+
+func newExecServer(env []string, dir string) *execServer {
+	return &execServer{env: env, dir: dir,
+		starts: make(chan *execStart), waiters: make(map[int]chan syscall.WaitStatus)}
+}
+
+// start starts the process requested by r and registers its exit status channel.
+// No_new_privs, the capability bounding set, landlock and the syscall filter are only in effect on the thread init
+// locked to itself, and a new process inherits them from the thread it is forked from, so start must be called from there.
+func (s *execServer) start(r *execStart) {
+	s.waitersMu.Lock()
+	r.p, r.err = os.StartProcess(r.path, r.args, &os.ProcAttr{Dir: s.dir, Env: s.env, Files: r.files})
+	if r.err == nil {
+		s.waiters[r.p.Pid] = r.w
+	}
+	s.waitersMu.Unlock()
+	close(r.done)
+}
+
+// serve accepts connections over socket until it is closed.
+func (s *execServer) serve(socket *os.File) {
+	for {
+		fd, err := readRights(socket, "exec connection")
+		if err != nil {
+			msg.Verbosef("exec socket closed: %v", err)
+			return
+		}
+
+		f := os.NewFile(uintptr(fd), "exec connection")
+		c, err := net.FileConn(f)
+		_ = f.Close()
+		if err != nil {
+			msg.Verbosef("invalid exec connection: %v", err)
+			continue
+		}
+		if conn, ok := c.(*net.UnixConn); !ok {
+			_ = c.Close()
+			msg.Verbosef("invalid exec connection of type %T", c)
+		} else {
+			go s.handle(conn)
+		}
+	}
+}
+
+// exited delivers the exit status of pid reaped by init, and returns false if pid is not started by s.
+func (s *execServer) exited(pid int, wstatus syscall.WaitStatus) bool {
+	s.waitersMu.Lock()
+	defer s.waitersMu.Unlock()
+
+	if w, ok := s.waiters[pid]; ok {
+		delete(s.waiters, pid)
+		w <- wstatus
+		return true
+	}
+	return false
+}
+
+func (s *execServer) handle(conn *net.UnixConn) {
+	defer func() { _ = conn.Close() }()
+	e := gob.NewEncoder(conn)
+	fail := func(err error) {
+		msg.Verbosef("cannot start process: %v", err)
+		if encodeErr := e.Encode(&execResult{Status: 127, Err: err.Error()}); encodeErr != nil {
+			msg.Verbosef("cannot send exec result: %v", encodeErr)
+		}
+	}
+
+	var files []*os.File
+	{
+		buf, oob := make([]byte, 1), make([]byte, syscall.CmsgSpace(3*4))
+		if n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob); err != nil {
+			fail(err)
+			return
+		} else if n != 1 {
+			fail(syscall.EPIPE)
+			return
+		} else if msgs, err := syscall.ParseSocketControlMessage(oob[:oobn]); err != nil {
+			fail(err)
+			return
+		} else if len(msgs) != 1 {
+			fail(syscall.EBADMSG)
+			return
+		} else if fds, err := syscall.ParseUnixRights(&msgs[0]); err != nil {
+			fail(err)
+			return
+		} else {
+			for i, fd := range fds {
+				syscall.CloseOnExec(fd)
+				files = append(files, os.NewFile(uintptr(fd), "stdio "+string(rune('0'+i))))
+			}
+			defer func() {
+				for _, f := range files {
+					_ = f.Close()
+				}
+			}()
+			if len(fds) != 3 {
+				fail(syscall.EBADMSG)
+				return
+			}
+		}
+	}
+
+	var req ExecRequest
+	if err := gob.NewDecoder(conn).Decode(&req); err != nil {
+		fail(err)
+		return
+	}
+	if req.Path == "" {
+		req.Path = "/bin/sh"
+		if v, ok := lookupEnv(s.env, "SHELL"); ok && path.IsAbs(v) {
+			req.Path = v
+		}
+	}
+	if len(req.Args) == 0 {
+		req.Args = []string{req.Path}
+	}
+	if !path.IsAbs(req.Path) {
+		if v, err := lookPath(s.env, req.Path); err != nil {
+			fail(err)
+			return
+		} else {
+			req.Path = v
+		}
+	}
+
+	w := make(chan syscall.WaitStatus, 1)
+	r := &execStart{path: req.Path, args: req.Args, files: files, w: w, done: make(chan struct{})}
+	s.starts <- r
+	<-r.done
+	p, err := r.p, r.err
+	if err != nil {
+		fail(err)
+		return
+	}
+	msg.Verbosef("started process %d of %q", p.Pid, req.Args)
+
+	// the process is killed once the requesting side goes away
+	go func() {
+		if _, err := conn.Read(make([]byte, 1)); err != nil {
+			select {
+			case <-w:
+			default:
+				_ = p.Kill()
+			}
+		}
+	}()
+
+	wstatus := <-w
+	// read by the above goroutine
+	w <- wstatus
+	res := &execResult{Status: wstatus.ExitStatus()}
+	if wstatus.Signaled() {
+		res.Status = 128 + int(wstatus.Signal())
+	}
+	if err = e.Encode(res); err != nil {
+		msg.Verbosef("cannot send exec result: %v", err)
+	}
+}
+
+// lookupEnv returns the value of key in env.
+func lookupEnv(env []string, key string) (string, bool) {
+	for i := len(env) - 1; i >= 0; i-- {
+		if v, ok := strings.CutPrefix(env[i], key+"="); ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// lookPath searches for an executable named name in PATH of env.
+func lookPath(env []string, name string) (string, error) {
+	if strings.Contains(name, "/") {
+		return "", &os.PathError{Op: "exec", Path: name, Err: syscall.EINVAL}
+	}
+	v, _ := lookupEnv(env, "PATH")
+	for _, dir := range strings.Split(v, ":") {
+		if !path.IsAbs(dir) {
+			continue
+		}
+		p := path.Join(dir, name)
+		if fi, err := os.Stat(p); err == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0 {
+			return p, nil
+		}
+	}
+	return "", &os.PathError{Op: "exec", Path: name, Err: syscall.ENOENT}
+}
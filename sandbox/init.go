@@ -104,6 +104,35 @@ func Init(prepare func(prefix string), setVerbose func(verbose bool)) {
 		}
 	}
 
+	// rights sockets are placed after all extra files
+	rightsFd := offsetSetup + params.Count
+	if params.Tun != nil {
+		fd := rightsFd
+		rightsFd++
+		if err := params.Tun.setupTun(fd); err != nil {
+			msg.PrintBaseErr(err, "cannot set up tun device:")
+			msg.BeforeExit()
+			os.Exit(1)
+		}
+		// not close-on-exec, must not leak into the initial process
+		if err := syscall.Close(fd); err != nil {
+			log.Fatalf("cannot close tun socket: %v", err)
+		}
+	}
+
+	listenerFd := -1
+	if seccomp.Notifies(params.Flags.seccomp(params.Seccomp), params.SeccompRules) {
+		listenerFd = rightsFd
+		rightsFd++
+	}
+	var execFile *os.File
+	if params.Exec {
+		// kept open by init to receive exec connections
+		syscall.CloseOnExec(rightsFd)
+		execFile = os.NewFile(uintptr(rightsFd), "exec")
+		rightsFd++
+	}
+
 	// cache sysctl before pivot_root
 	LastCap()
 
@@ -206,7 +235,13 @@ func Init(prepare func(prefix string), setVerbose func(verbose bool)) {
 		}
 	}
 
-	if _, _, errno := syscall.Syscall(PR_SET_NO_NEW_PRIVS, 1, 0, 0); errno != 0 {
+	for _, r := range params.Rlimits {
+		if err := syscall.Setrlimit(r.Resource, &syscall.Rlimit{Cur: r.Cur, Max: r.Max}); err != nil {
+			log.Fatalf("cannot set resource limit %d: %v", r.Resource, err)
+		}
+	}
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_PRCTL, PR_SET_NO_NEW_PRIVS, 1, 0); errno != 0 {
 		log.Fatalf("prctl(PR_SET_NO_NEW_PRIVS): %v", errno)
 	}
 
@@ -237,8 +272,39 @@ func Init(prepare func(prefix string), setVerbose func(verbose bool)) {
 		log.Fatalf("cannot capset: %v", err)
 	}
 
-	if err := seccomp.Load(params.Flags.seccomp(params.Seccomp)); err != nil {
-		log.Fatalf("cannot load syscall filter: %v", err)
+	if params.Landlock {
+		if err := params.Ops.landlock(); err != nil {
+			msg.PrintBaseErr(err, "cannot apply landlock ruleset:")
+			msg.BeforeExit()
+			os.Exit(1)
+		}
+	}
+
+	if opts := params.Flags.seccomp(params.Seccomp); listenerFd == -1 {
+		if err := seccomp.Load(opts, params.SeccompRules); err != nil {
+			log.Fatalf("cannot load syscall filter: %v", err)
+		}
+	} else {
+		// syscalls passed to the supervisor block until the listener is received
+		if fd, err := seccomp.LoadNotify(opts, params.SeccompRules); err != nil {
+			log.Fatalf("cannot load syscall filter: %v", err)
+		} else if err = sendRights(listenerFd, fd, "seccomp listener"); err != nil {
+			msg.PrintBaseErr(err, "cannot set up syscall filter:")
+			msg.BeforeExit()
+			os.Exit(1)
+		} else if err = syscall.Close(fd); err != nil {
+			log.Fatalf("cannot close seccomp listener: %v", err)
+		}
+		// not close-on-exec, must not leak into the initial process
+		if err := syscall.Close(listenerFd); err != nil {
+			log.Fatalf("cannot close seccomp listener socket: %v", err)
+		}
+	}
+
+	for i, prog := range params.SeccompPrograms {
+		if err := loadSeccompProgram(prog); err != nil {
+			log.Fatalf("cannot load syscall filter program %d: %v", i, err)
+		}
 	}
 
 	extraFiles := make([]*os.File, params.Count)
@@ -265,6 +331,17 @@ func Init(prepare func(prefix string), setVerbose func(verbose bool)) {
 		// not fatal
 	}
 
+	// additional processes are started by init to inherit its credentials and restrictions
+	var (
+		execs      *execServer
+		execStarts chan *execStart
+	)
+	if execFile != nil {
+		execs = newExecServer(params.Env, params.Dir)
+		execStarts = execs.starts
+		go execs.serve(execFile)
+	}
+
 	type winfo struct {
 		wpid    int
 		wstatus syscall.WaitStatus
@@ -301,9 +378,14 @@ func Init(prepare func(prefix string), setVerbose func(verbose bool)) {
 		close(done)
 	}()
 
-	// handle signals to dump withheld messages
+	// SIGINT and SIGTERM terminate the container, other handled signals are forwarded to the initial process
 	sig := make(chan os.Signal, 2)
-	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sig, sigRelayInt, sigRelayTerm)
+	for _, s := range RelaySignals {
+		signal.Notify(sig, s)
+	}
+	// the pid of a reaped initial process might be reused
+	var exited bool
 
 	// closed after residualProcessTimeout has elapsed after initial process death
 	timeout := make(chan struct{})
@@ -312,16 +394,39 @@ func Init(prepare func(prefix string), setVerbose func(verbose bool)) {
 	for {
 		select {
 		case s := <-sig:
+			if s != syscall.SIGINT && s != syscall.SIGTERM {
+				switch s {
+				case sigRelayInt:
+					s = syscall.SIGINT
+				case sigRelayTerm:
+					s = syscall.SIGTERM
+				}
+				if !exited {
+					msg.Verbosef("forwarding %s to initial process", s.String())
+					if err := cmd.Process.Signal(s); err != nil {
+						msg.Verbosef("cannot forward %s: %v", s.String(), err)
+					}
+				}
+				continue
+			}
+
 			if msg.Resume() {
 				msg.Verbosef("terminating on %s after process start", s.String())
 			} else {
 				msg.Verbosef("terminating on %s", s.String())
 			}
 			os.Exit(0)
+		case r := <-execStarts:
+			// forked from this thread as restrictions are not in effect on other threads
+			execs.start(r)
 		case w := <-info:
+			if execs != nil && execs.exited(w.wpid, w.wstatus) {
+				continue
+			}
 			if w.wpid == cmd.Process.Pid {
 				// initial process exited, output is most likely available again
 				msg.Resume()
+				exited = true
 
 				switch {
 				case w.wstatus.Exited():
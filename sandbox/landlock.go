@@ -0,0 +1,205 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// landlockAccess describes filesystem access to a path in the container granted by an [Op].
+type landlockAccess int
+
+const (
+	// landlockNone grants no access, the op does not make a path accessible.
+	landlockNone landlockAccess = iota
+	// landlockRead grants read and execute access.
+	landlockRead
+	// landlockWrite grants read, write and execute access.
+	landlockWrite
+	// landlockDevice grants landlockWrite and ioctl access to device files.
+	landlockDevice
+)
+
+// syscall numbers are shared by all architectures
+const (
+	SYS_LANDLOCK_CREATE_RULESET = 444
+	SYS_LANDLOCK_ADD_RULE       = 445
+	SYS_LANDLOCK_RESTRICT_SELF  = 446
+
+	LANDLOCK_CREATE_RULESET_VERSION = 1 << 0
+	LANDLOCK_RULE_PATH_BENEATH      = 1
+)
+
+// linux/landlock.h
+const (
+	LANDLOCK_ACCESS_FS_EXECUTE = 1 << iota
+	LANDLOCK_ACCESS_FS_WRITE_FILE
+	LANDLOCK_ACCESS_FS_READ_FILE
+	LANDLOCK_ACCESS_FS_READ_DIR
+	LANDLOCK_ACCESS_FS_REMOVE_DIR
+	LANDLOCK_ACCESS_FS_REMOVE_FILE
+	LANDLOCK_ACCESS_FS_MAKE_CHAR
+	LANDLOCK_ACCESS_FS_MAKE_DIR
+	LANDLOCK_ACCESS_FS_MAKE_REG
+	LANDLOCK_ACCESS_FS_MAKE_SOCK
+	LANDLOCK_ACCESS_FS_MAKE_FIFO
+	LANDLOCK_ACCESS_FS_MAKE_BLOCK
+	LANDLOCK_ACCESS_FS_MAKE_SYM
+	LANDLOCK_ACCESS_FS_REFER
+	LANDLOCK_ACCESS_FS_TRUNCATE
+	LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+const (
+	// landlockMinABI is the lowest Landlock ABI version the ruleset is applied on.
+	// Prior to ABI version 2, files cannot be renamed or linked across directories.
+	landlockMinABI = 2
+
+	landlockAccessRead = LANDLOCK_ACCESS_FS_EXECUTE |
+		LANDLOCK_ACCESS_FS_READ_FILE |
+		LANDLOCK_ACCESS_FS_READ_DIR
+	// device nodes cannot be created in a user namespace regardless
+	landlockAccessWrite = (LANDLOCK_ACCESS_FS_IOCTL_DEV - 1) &^
+		(LANDLOCK_ACCESS_FS_MAKE_CHAR | LANDLOCK_ACCESS_FS_MAKE_BLOCK)
+	landlockAccessDevice = landlockAccessWrite | LANDLOCK_ACCESS_FS_IOCTL_DEV
+	// access rights applicable to files that are not directories
+	landlockAccessFile = LANDLOCK_ACCESS_FS_EXECUTE |
+		LANDLOCK_ACCESS_FS_WRITE_FILE |
+		LANDLOCK_ACCESS_FS_READ_FILE |
+		LANDLOCK_ACCESS_FS_TRUNCATE |
+		LANDLOCK_ACCESS_FS_IOCTL_DEV
+)
+
+// landlockHandled returns filesystem access rights known to Landlock ABI version abi.
+func landlockHandled(abi int) uint64 {
+	switch {
+	case abi >= 5:
+		return LANDLOCK_ACCESS_FS_IOCTL_DEV<<1 - 1
+	case abi >= 3:
+		return LANDLOCK_ACCESS_FS_TRUNCATE<<1 - 1
+	case abi >= 2:
+		return LANDLOCK_ACCESS_FS_REFER<<1 - 1
+	default:
+		return LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1
+	}
+}
+
+func (a landlockAccess) rights() uint64 {
+	switch a {
+	case landlockRead:
+		return landlockAccessRead
+	case landlockWrite:
+		return landlockAccessWrite
+	case landlockDevice:
+		return landlockAccessDevice
+	default:
+		return 0
+	}
+}
+
+type (
+	landlockRulesetAttr struct {
+		handledAccessFS uint64
+	}
+
+	// struct landlock_path_beneath_attr is packed, allowedAccess and parentFd are at the same offsets
+	landlockPathBeneathAttr struct {
+		allowedAccess uint64
+		parentFd      int32
+	}
+)
+
+// landlockRule is a path in the container and its access rights.
+type landlockRule struct {
+	path   string
+	access landlockAccess
+}
+
+// landlockRules returns rules derived from ops, preceded by directory listing access to the container root.
+// Landlock rights of a rule apply to the entire hierarchy beneath its path and cannot be revoked by
+// a rule for a nested path, so the access of a path is the union of rules for it and its parents.
+func (f *Ops) landlockRules() []landlockRule {
+	rules := make([]landlockRule, 0, 1+len(*f))
+	rules = append(rules, landlockRule{"/", landlockNone})
+	for _, op := range *f {
+		if name, access := op.landlock(); access != landlockNone {
+			rules = append(rules, landlockRule{name, access})
+		}
+	}
+	return rules
+}
+
+// landlock restricts filesystem access of the calling thread and its future children to paths made
+// accessible by f. This is a no-op if Landlock is unavailable or its ABI version is below landlockMinABI.
+// Other threads are not restricted, so this must be called on the locked thread that starts the initial process.
+func (f *Ops) landlock() error {
+	var abi int
+	if v, _, errno := syscall.Syscall(SYS_LANDLOCK_CREATE_RULESET, 0, 0, LANDLOCK_CREATE_RULESET_VERSION); errno != 0 {
+		if errors.Is(errno, syscall.ENOSYS) || errors.Is(errno, syscall.EOPNOTSUPP) {
+			msg.Verbose("landlock is not available, skipping ruleset")
+			return nil
+		}
+		return wrapErrSuffix(errno,
+			"cannot determine landlock ABI version:")
+	} else if abi = int(v); abi < landlockMinABI {
+		msg.Verbosef("landlock ABI version %d is not supported, skipping ruleset", abi)
+		return nil
+	}
+	handled := landlockHandled(abi)
+
+	var rulesetFd int
+	if v, _, errno := syscall.Syscall(SYS_LANDLOCK_CREATE_RULESET,
+		uintptr(unsafe.Pointer(&landlockRulesetAttr{handled})),
+		unsafe.Sizeof(landlockRulesetAttr{}), 0); errno != 0 {
+		return wrapErrSuffix(errno,
+			"cannot create landlock ruleset:")
+	} else {
+		rulesetFd = int(v)
+	}
+	defer func() { _ = syscall.Close(rulesetFd) }()
+
+	for _, rule := range f.landlockRules() {
+		allowed := rule.access.rights() & handled
+		if rule.access == landlockNone {
+			allowed = LANDLOCK_ACCESS_FS_READ_DIR
+		}
+
+		var fd int
+		if err := IgnoringEINTR(func() (err error) {
+			fd, err = syscall.Open(rule.path, O_PATH|syscall.O_CLOEXEC, 0)
+			return
+		}); err != nil {
+			if errors.Is(err, syscall.ENOENT) {
+				// optional bind mount source did not exist
+				continue
+			}
+			return wrapErrSuffix(err,
+				fmt.Sprintf("cannot open %q:", rule.path))
+		}
+
+		var st syscall.Stat_t
+		if err := syscall.Fstat(fd, &st); err != nil {
+			_ = syscall.Close(fd)
+			return wrapErrSelf(err)
+		}
+		if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+			allowed &= landlockAccessFile
+		}
+
+		msg.Verbosef("landlock access %#x beneath %q", allowed, rule.path)
+		_, _, errno := syscall.Syscall6(SYS_LANDLOCK_ADD_RULE, uintptr(rulesetFd), LANDLOCK_RULE_PATH_BENEATH,
+			uintptr(unsafe.Pointer(&landlockPathBeneathAttr{allowed, int32(fd)})), 0, 0, 0)
+		_ = syscall.Close(fd)
+		if errno != 0 {
+			return wrapErrSuffix(errno,
+				fmt.Sprintf("cannot add landlock rule for %q:", rule.path))
+		}
+	}
+
+	if _, _, errno := syscall.Syscall(SYS_LANDLOCK_RESTRICT_SELF, uintptr(rulesetFd), 0, 0); errno != 0 {
+		return wrapErrSuffix(errno,
+			"cannot enforce landlock ruleset:")
+	}
+	return nil
+}
@@ -0,0 +1,560 @@
+// Package oci converts OCI runtime bundles to container parameters.
+package oci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"git.gensokyo.uk/security/fortify/sandbox"
+)
+
+// ConfigName is the name of the runtime configuration file in a bundle directory.
+const ConfigName = "config.json"
+
+// defaultPath is searched for the process of a configuration not setting PATH.
+const defaultPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// ErrSpec is returned for a runtime configuration that cannot be converted.
+var ErrSpec = errors.New("invalid OCI runtime configuration")
+
+// Unsupported describes a setting of the runtime configuration with no equivalent in [sandbox.Params].
+type Unsupported struct {
+	Field string `json:"field"`
+	Value string `json:"value,omitempty"`
+}
+
+func (u *Unsupported) String() string {
+	if u.Value == "" {
+		return u.Field
+	}
+	return u.Field + ": " + u.Value
+}
+
+// Load reads the runtime configuration of the bundle at directory bundle.
+func Load(bundle string) (*Spec, error) {
+	data, err := os.ReadFile(path.Join(bundle, ConfigName))
+	if err != nil {
+		return nil, err
+	}
+	spec := new(Spec)
+	if err = json.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSpec, err)
+	}
+	return spec, nil
+}
+
+// rlimits maps resource limit types to resource numbers.
+var rlimits = map[string]int{
+	"RLIMIT_CPU":        syscall.RLIMIT_CPU,
+	"RLIMIT_FSIZE":      syscall.RLIMIT_FSIZE,
+	"RLIMIT_DATA":       syscall.RLIMIT_DATA,
+	"RLIMIT_STACK":      syscall.RLIMIT_STACK,
+	"RLIMIT_CORE":       syscall.RLIMIT_CORE,
+	"RLIMIT_RSS":        5,
+	"RLIMIT_NPROC":      6,
+	"RLIMIT_NOFILE":     syscall.RLIMIT_NOFILE,
+	"RLIMIT_MEMLOCK":    8,
+	"RLIMIT_AS":         syscall.RLIMIT_AS,
+	"RLIMIT_LOCKS":      10,
+	"RLIMIT_SIGPENDING": 11,
+	"RLIMIT_MSGQUEUE":   12,
+	"RLIMIT_NICE":       13,
+	"RLIMIT_RTPRIO":     14,
+	"RLIMIT_RTTIME":     15,
+}
+
+// Convert returns container parameters equivalent to spec of the bundle at absolute path bundle,
+// and the settings it was unable to apply.
+//
+// The root filesystem is bind mounted on / with mounts, device nodes, masked and read-only paths applied on top
+// of it in order. Device nodes are bind mounted from the same path on the host if a node of the same type and number
+// exists there. Namespaces other than the network namespace are always created, and the network namespace is shared
+// with the host unless it is requested.
+// The process user is mapped to the invoking user and capabilities are never granted, so a root process
+// runs as the overflow user. The seccomp profile is loaded in addition to the preset filter.
+func Convert(spec *Spec, bundle string) (*sandbox.Params, []*Unsupported, error) {
+	if major, _, _ := strings.Cut(spec.Version, "."); major != "1" {
+		return nil, nil, fmt.Errorf("%w: unsupported version %q", ErrSpec, spec.Version)
+	}
+	if spec.Process == nil || len(spec.Process.Args) == 0 {
+		return nil, nil, fmt.Errorf("%w: no process", ErrSpec)
+	}
+	if spec.Root == nil || spec.Root.Path == "" {
+		return nil, nil, fmt.Errorf("%w: no root filesystem", ErrSpec)
+	}
+	if !path.IsAbs(bundle) {
+		return nil, nil, fmt.Errorf("%w: bundle path %q is not absolute", ErrSpec, bundle)
+	}
+
+	c := &converter{params: &sandbox.Params{Ops: new(sandbox.Ops)}, bundle: bundle}
+	linux := spec.Linux
+	if linux == nil {
+		linux = new(Linux)
+	}
+
+	rootFlags := sandbox.BindWritable
+	if spec.Root.Readonly {
+		rootFlags = 0
+	}
+	c.params.Bind(c.source(spec.Root.Path), "/", rootFlags)
+	for i := range spec.Mounts {
+		c.mount(&spec.Mounts[i])
+	}
+	for i := range linux.Devices {
+		c.device(&linux.Devices[i])
+	}
+	c.maskedPaths(linux.MaskedPaths)
+	c.readonlyPaths(linux.ReadonlyPaths)
+
+	if err := c.process(spec.Process); err != nil {
+		return nil, nil, err
+	}
+	c.params.Hostname = spec.Hostname
+	if spec.Domainname != "" {
+		c.unsupported("domainname", spec.Domainname)
+	}
+	if len(spec.Hooks) > 0 && string(spec.Hooks) != "null" {
+		c.unsupported("hooks", "")
+	}
+
+	if err := c.linux(linux); err != nil {
+		return nil, nil, err
+	}
+	return c.params, c.u, nil
+}
+
+type converter struct {
+	params *sandbox.Params
+	bundle string
+	u      []*Unsupported
+}
+
+func (c *converter) unsupported(field, value string) {
+	c.u = append(c.u, &Unsupported{field, value})
+}
+
+// source returns the host path of a path relative to the bundle directory unless absolute.
+func (c *converter) source(name string) string {
+	if path.IsAbs(name) {
+		return path.Clean(name)
+	}
+	return path.Join(c.bundle, name)
+}
+
+// device binds the host node of d if it matches the type and number of d.
+func (c *converter) device(d *Device) {
+	desc := fmt.Sprintf("%s %s %d:%d", d.Path, d.Type, d.Major, d.Minor)
+
+	var mode uint32
+	switch d.Type {
+	case "c", "u":
+		mode = syscall.S_IFCHR
+	case "b":
+		mode = syscall.S_IFBLK
+	case "p":
+		mode = syscall.S_IFIFO
+	default:
+		c.unsupported("linux.devices", desc)
+		return
+	}
+
+	var st syscall.Stat_t
+	if !path.IsAbs(d.Path) || syscall.Lstat(d.Path, &st) != nil || st.Mode&syscall.S_IFMT != mode {
+		c.unsupported("linux.devices", desc)
+		return
+	}
+	if mode != syscall.S_IFIFO {
+		// linux/kdev_t.h
+		rdev := uint64(st.Rdev)
+		major := int64((rdev>>8)&0xfff | (rdev>>32)&^0xfff)
+		minor := int64(rdev&0xff | (rdev>>12)&^0xff)
+		if major != d.Major || minor != d.Minor {
+			c.unsupported("linux.devices", desc)
+			return
+		}
+	}
+	c.params.Bind(d.Path, d.Path, sandbox.BindDevice|sandbox.BindWritable)
+}
+
+// commonOptions are mount options satisfied by every mount set up by the container.
+var commonOptions = []string{
+	"nosuid", "nodev",
+	"strictatime", "relatime", "noatime", "nodiratime",
+	"private", "rprivate", "slave", "rslave",
+}
+
+// options reports options of the mount on dest neither common nor accepted.
+func (c *converter) options(dest string, options []string, accept ...string) {
+	for _, opt := range options {
+		name, _, _ := strings.Cut(opt, "=")
+		if !slices.Contains(commonOptions, opt) && !slices.Contains(accept, name) {
+			c.unsupported("mounts", dest+" option "+opt)
+		}
+	}
+}
+
+func (c *converter) mount(m *Mount) {
+	if !path.IsAbs(m.Destination) {
+		c.unsupported("mounts", m.Destination)
+		return
+	}
+	dest := path.Clean(m.Destination)
+
+	switch {
+	case m.Type == "bind" || slices.Contains(m.Options, "bind") || slices.Contains(m.Options, "rbind"):
+		source := c.source(m.Source)
+		flags := sandbox.BindWritable
+		if slices.Contains(m.Options, "dev") ||
+			strings.HasPrefix(source, "/dev/") && !slices.Contains(m.Options, "nodev") {
+			flags |= sandbox.BindDevice
+		}
+		for _, opt := range m.Options {
+			switch opt {
+			case "ro":
+				flags &^= sandbox.BindWritable
+			case "rw":
+				flags |= sandbox.BindWritable
+			}
+		}
+		c.options(dest, m.Options, "bind", "rbind", "ro", "rw", "dev", "exec")
+		c.params.Bind(source, dest, flags)
+
+	case m.Type == "proc":
+		c.options(dest, m.Options, "noexec", "rw")
+		c.params.Proc(dest)
+
+	case m.Type == "tmpfs" && dest == "/dev":
+		// standard device nodes, devpts and the shm directory are provided by the dev mount
+		c.params.Dev(dest)
+
+	case m.Type == "devpts" && dest == "/dev/pts" && c.devMounted(dest):
+		// provided by the dev mount
+
+	case m.Type == "tmpfs":
+		size, perm := 0, os.FileMode(01777)
+		for _, opt := range m.Options {
+			name, value, _ := strings.Cut(opt, "=")
+			switch name {
+			case "size":
+				if v, ok := parseSize(value); ok {
+					size = v
+				} else {
+					c.unsupported("mounts", dest+" option "+opt)
+				}
+			case "mode":
+				if v, err := strconv.ParseUint(value, 8, 32); err == nil && v <= 07777 {
+					perm = os.FileMode(v)
+				} else {
+					c.unsupported("mounts", dest+" option "+opt)
+				}
+			}
+		}
+		c.options(dest, m.Options, "size", "mode", "rw", "exec")
+		c.params.Tmpfs(dest, size, perm)
+
+	case m.Type == "mqueue":
+		c.options(dest, m.Options, "noexec", "rw")
+		c.params.Mqueue(dest)
+
+	default:
+		c.unsupported("mounts", m.Type+" on "+dest)
+	}
+}
+
+// devMounted returns whether container path name is covered by a dev mount.
+func (c *converter) devMounted(name string) bool {
+	op, _ := c.cover(name)
+	_, ok := op.(sandbox.MountDev)
+	return ok
+}
+
+// parseSize parses the value of a tmpfs size option not relative to the size of memory.
+func parseSize(s string) (int, bool) {
+	shift := 0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			shift = 10
+		case 'm', 'M':
+			shift = 20
+		case 'g', 'G':
+			shift = 30
+		}
+	}
+	if shift != 0 {
+		s = s[:len(s)-1]
+	}
+	v, err := strconv.ParseUint(s, 10, 63-shift)
+	if err != nil || v == 0 {
+		return 0, false
+	}
+	return int(v << shift), true
+}
+
+// cover returns the last op mounting a filesystem on container path name or one of its parents,
+// and the path of name relative to it.
+func (c *converter) cover(name string) (sandbox.Op, string) {
+	covers := func(target string) (string, bool) {
+		rel, ok := strings.CutPrefix(name, target)
+		return rel, ok && (target == "/" || rel == "" || rel[0] == '/')
+	}
+	ops := *c.params.Ops
+	for i := len(ops) - 1; i >= 0; i-- {
+		var target string
+		switch op := ops[i].(type) {
+		case *sandbox.BindMount:
+			target = op.Target
+		case sandbox.MountProc:
+			target = string(op)
+		case sandbox.MountDev:
+			target = string(op)
+		case sandbox.MountMqueue:
+			target = string(op)
+		case *sandbox.MountTmpfs:
+			target = op.Path
+		case *sandbox.MountOverlay:
+			target = op.Target
+		default:
+			continue
+		}
+		if rel, ok := covers(target); ok {
+			return ops[i], rel
+		}
+	}
+	return nil, ""
+}
+
+// hostPath returns the host path of container path name backed by a bind mount or proc.
+// Symlinks are never followed as they would be resolved against the host filesystem.
+func (c *converter) hostPath(name string) (string, bool) {
+	switch op, rel := c.cover(name); op := op.(type) {
+	case *sandbox.BindMount:
+		return path.Join(op.Source, rel), true
+	case sandbox.MountProc:
+		return path.Join("/proc", rel), true
+	default:
+		return "", false
+	}
+}
+
+// maskedPaths hides existing files behind /dev/null and directories behind an empty tmpfs.
+func (c *converter) maskedPaths(paths []string) {
+	for _, p := range paths {
+		p = path.Clean(p)
+		host, ok := c.hostPath(p)
+		if !ok {
+			continue
+		}
+		if fi, err := os.Lstat(host); err != nil {
+			continue
+		} else if fi.IsDir() {
+			c.params.Tmpfs(p, 0, 0)
+		} else {
+			c.params.Bind("/dev/null", p, sandbox.BindDevice)
+		}
+	}
+}
+
+// readonlyPaths bind mounts existing paths on themselves without write access.
+func (c *converter) readonlyPaths(paths []string) {
+	for _, p := range paths {
+		p = path.Clean(p)
+		op, _ := c.cover(p)
+		if _, ok := op.(sandbox.MountProc); ok {
+			// proc files outside the namespaces of the container are never writable from its user namespace
+			continue
+		}
+		b, ok := op.(*sandbox.BindMount)
+		if !ok {
+			continue
+		}
+		host, _ := c.hostPath(p)
+		if _, err := os.Lstat(host); err != nil {
+			continue
+		}
+		c.params.Bind(host, p, b.Flags&sandbox.BindDevice)
+	}
+}
+
+func (c *converter) process(p *Process) error {
+	params := c.params
+
+	params.Args = slices.Clone(p.Args)
+	params.Env = slices.Clone(p.Env)
+	if params.Env == nil {
+		params.Env = make([]string, 0)
+	}
+	params.Dir = p.Cwd
+	if params.Dir == "" {
+		params.Dir = "/"
+	} else if !path.IsAbs(params.Dir) {
+		return fmt.Errorf("%w: working directory %q is not absolute", ErrSpec, p.Cwd)
+	}
+	if name, err := c.lookPath(p.Args[0], params.Env, params.Dir); err != nil {
+		return err
+	} else {
+		params.Path = name
+	}
+
+	if p.Terminal {
+		params.Flags |= sandbox.FAllowTTY
+	}
+
+	if p.User.UID == 0 {
+		c.unsupported("process.user.uid", "0")
+	} else {
+		params.Uid = int(p.User.UID)
+	}
+	if p.User.GID == 0 {
+		c.unsupported("process.user.gid", "0")
+	} else {
+		params.Gid = int(p.User.GID)
+	}
+	if p.User.Umask != nil {
+		c.unsupported("process.user.umask", fmt.Sprintf("%04o", *p.User.Umask))
+	}
+	for _, gid := range p.User.AdditionalGids {
+		c.unsupported("process.user.additionalGids", strconv.FormatUint(uint64(gid), 10))
+	}
+
+	if caps := p.Capabilities; caps != nil {
+		var v []string
+		for _, set := range [][]string{caps.Bounding, caps.Effective, caps.Inheritable, caps.Permitted, caps.Ambient} {
+			v = append(v, set...)
+		}
+		slices.Sort(v)
+		for _, capability := range slices.Compact(v) {
+			c.unsupported("process.capabilities", capability)
+		}
+	}
+
+	for _, r := range p.Rlimits {
+		if resource, ok := rlimits[r.Type]; ok {
+			params.Rlimits = append(params.Rlimits, sandbox.Rlimit{Resource: resource, Cur: r.Soft, Max: r.Hard})
+		} else {
+			c.unsupported("process.rlimits", r.Type)
+		}
+	}
+
+	if p.ApparmorProfile != "" {
+		c.unsupported("process.apparmorProfile", p.ApparmorProfile)
+	}
+	if p.SelinuxLabel != "" {
+		c.unsupported("process.selinuxLabel", p.SelinuxLabel)
+	}
+	if p.OOMScoreAdj != nil {
+		c.unsupported("process.oomScoreAdj", strconv.Itoa(*p.OOMScoreAdj))
+	}
+	return nil
+}
+
+// lookPath returns the container path of executable name, searching PATH of env if it contains no slash.
+func (c *converter) lookPath(name string, env []string, dir string) (string, error) {
+	if strings.Contains(name, "/") {
+		if !path.IsAbs(name) {
+			name = path.Join(dir, name)
+		}
+		return name, nil
+	}
+
+	pathEnv := defaultPath
+	for _, e := range env {
+		if v, ok := strings.CutPrefix(e, "PATH="); ok {
+			pathEnv = v
+		}
+	}
+	for _, d := range filepath.SplitList(pathEnv) {
+		if !path.IsAbs(d) {
+			continue
+		}
+		p := path.Join(d, name)
+		host, ok := c.hostPath(p)
+		if !ok {
+			continue
+		}
+		if fi, err := os.Lstat(host); err == nil &&
+			(fi.Mode()&os.ModeSymlink != 0 || fi.Mode().IsRegular() && fi.Mode()&0111 != 0) {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("%w: %q not found in PATH", ErrSpec, name)
+}
+
+func (c *converter) linux(linux *Linux) error {
+	unshared := make(map[string]bool)
+	for _, ns := range linux.Namespaces {
+		switch ns.Type {
+		case "pid", "network", "ipc", "uts", "mount", "user", "cgroup":
+			unshared[ns.Type] = true
+		default:
+			c.unsupported("linux.namespaces", ns.Type)
+			continue
+		}
+		if ns.Path != "" {
+			// a new namespace is created instead
+			c.unsupported("linux.namespaces", ns.Type+" at "+ns.Path)
+		}
+	}
+	if !unshared["network"] {
+		c.params.Flags |= sandbox.FAllowNet
+	}
+	for _, t := range []string{"pid", "ipc", "uts", "mount", "user", "cgroup"} {
+		if !unshared[t] {
+			c.unsupported("linux.namespaces", "host "+t)
+		}
+	}
+
+	// only the process user and group are mapped
+	for _, f := range []struct {
+		field    string
+		mappings []IDMapping
+	}{
+		{"linux.uidMappings", linux.UIDMappings},
+		{"linux.gidMappings", linux.GIDMappings},
+	} {
+		for _, m := range f.mappings {
+			if m.Size != 1 {
+				c.unsupported(f.field, fmt.Sprintf("%d:%d:%d", m.ContainerID, m.HostID, m.Size))
+			}
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(linux.Sysctl)) {
+		c.unsupported("linux.sysctl", key+"="+linux.Sysctl[key])
+	}
+	if len(linux.Resources) > 0 && string(linux.Resources) != "null" {
+		c.unsupported("linux.resources", "")
+	}
+	if linux.CgroupsPath != "" {
+		c.unsupported("linux.cgroupsPath", linux.CgroupsPath)
+	}
+	if linux.MountLabel != "" {
+		c.unsupported("linux.mountLabel", linux.MountLabel)
+	}
+	if len(linux.Personality) > 0 && string(linux.Personality) != "null" {
+		c.unsupported("linux.personality", "")
+	}
+
+	if linux.Seccomp != nil {
+		profile, u, err := SeccompProfile(linux.Seccomp)
+		if err != nil {
+			return err
+		}
+		c.u = append(c.u, u...)
+		if prog, err := profile.Export(); err != nil {
+			return err
+		} else {
+			c.params.SeccompPrograms = append(c.params.SeccompPrograms, prog)
+		}
+	}
+	return nil
+}
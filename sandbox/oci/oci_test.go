@@ -0,0 +1,209 @@
+package oci_test
+
+import (
+	"errors"
+	"os"
+	"path"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/sandbox"
+	"git.gensokyo.uk/security/fortify/sandbox/oci"
+)
+
+func TestConvert(t *testing.T) {
+	bundle := t.TempDir()
+	rootfs := path.Join(bundle, "rootfs")
+	for _, name := range []string{"usr/bin", "etc", "private", "proc", "dev"} {
+		if err := os.MkdirAll(path.Join(rootfs, name), 0755); err != nil {
+			t.Fatalf("MkdirAll: error = %v", err)
+		}
+	}
+	for name, perm := range map[string]os.FileMode{"usr/bin/app": 0755, "usr/bin/data": 0644, "secret": 0600} {
+		if err := os.WriteFile(path.Join(rootfs, name), nil, perm); err != nil {
+			t.Fatalf("WriteFile: error = %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name    string
+		config  string
+		want    *sandbox.Params
+		wantU   []*oci.Unsupported
+		wantErr error
+	}{
+		{"full", `{
+	"ociVersion": "1.2.0",
+	"process": {
+		"terminal": true,
+		"user": {"uid": 0, "gid": 0},
+		"args": ["app", "-v"],
+		"env": ["PATH=/nonexistent:/usr/bin", "TERM=xterm"],
+		"cwd": "/",
+		"capabilities": {"bounding": ["CAP_KILL", "CAP_AUDIT_WRITE"], "effective": ["CAP_KILL"]},
+		"rlimits": [{"type": "RLIMIT_NOFILE", "hard": 1024, "soft": 512}, {"type": "RLIMIT_BOGUS", "hard": 1, "soft": 1}],
+		"noNewPrivileges": true
+	},
+	"root": {"path": "rootfs"},
+	"hostname": "runc",
+	"mounts": [
+		{"destination": "/proc", "type": "proc", "source": "proc", "options": ["nosuid", "noexec", "nodev"]},
+		{"destination": "/dev", "type": "tmpfs", "source": "tmpfs", "options": ["nosuid", "strictatime", "mode=755", "size=65536k"]},
+		{"destination": "/dev/pts", "type": "devpts", "source": "devpts", "options": ["nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"]},
+		{"destination": "/dev/shm", "type": "tmpfs", "source": "shm", "options": ["nosuid", "noexec", "nodev", "mode=1777", "size=65536k"]},
+		{"destination": "/dev/mqueue", "type": "mqueue", "source": "mqueue", "options": ["nosuid", "noexec", "nodev"]},
+		{"destination": "/sys", "type": "sysfs", "source": "sysfs", "options": ["nosuid", "noexec", "nodev", "ro"]},
+		{"destination": "/data", "type": "bind", "source": "data", "options": ["rbind", "ro"]},
+		{"destination": "/dev/kvm", "source": "/dev/kvm", "options": ["bind"]},
+		{"destination": "/tmp", "type": "tmpfs", "options": ["size=10%"]}
+	],
+	"linux": {
+		"uidMappings": [{"containerID": 0, "hostID": 1000, "size": 1}],
+		"gidMappings": [{"containerID": 0, "hostID": 1000, "size": 65536}],
+		"sysctl": {"net.ipv4.ip_forward": "1"},
+		"namespaces": [{"type": "pid"}, {"type": "network"}, {"type": "ipc"}, {"type": "uts"}, {"type": "mount"}, {"type": "user"}],
+		"devices": [
+			{"path": "/dev/null", "type": "c", "major": 1, "minor": 3},
+			{"path": "/dev/zero", "type": "c", "major": 1, "minor": 3},
+			{"path": "/dev/null", "type": "b", "major": 1, "minor": 3},
+			{"path": "/nonexistent", "type": "c", "major": 10, "minor": 229}
+		],
+		"maskedPaths": ["/secret", "/private", "/nonexistent", "/sys/firmware"],
+		"readonlyPaths": ["/proc/sys", "/etc", "/nonexistent"],
+		"seccomp": {"defaultAction": "SCMP_ACT_ERRNO", "syscalls": [{"names": ["read"], "action": "SCMP_ACT_ALLOW"}]}
+	}
+}`, &sandbox.Params{
+			Dir:  "/",
+			Env:  []string{"PATH=/nonexistent:/usr/bin", "TERM=xterm"},
+			Path: "/usr/bin/app",
+			Args: []string{"app", "-v"},
+
+			Hostname: "runc",
+			Rlimits:  []sandbox.Rlimit{{Resource: syscall.RLIMIT_NOFILE, Cur: 512, Max: 1024}},
+			Ops: new(sandbox.Ops).
+				Bind(rootfs, "/", sandbox.BindWritable).
+				Proc("/proc").
+				Dev("/dev").
+				Tmpfs("/dev/shm", 65536<<10, 01777).
+				Mqueue("/dev/mqueue").
+				Bind(path.Join(bundle, "data"), "/data", 0).
+				Bind("/dev/kvm", "/dev/kvm", sandbox.BindWritable|sandbox.BindDevice).
+				Tmpfs("/tmp", 0, 01777).
+				Bind("/dev/null", "/dev/null", sandbox.BindDevice|sandbox.BindWritable).
+				Bind("/dev/null", "/secret", sandbox.BindDevice).
+				Tmpfs("/private", 0, 0).
+				Bind(path.Join(rootfs, "etc"), "/etc", 0),
+			Flags: sandbox.FAllowTTY,
+		}, []*oci.Unsupported{
+			{"mounts", "/dev/shm option noexec"},
+			{"mounts", "sysfs on /sys"},
+			{"mounts", "/tmp option size=10%"},
+			{"linux.devices", "/dev/zero c 1:3"},
+			{"linux.devices", "/dev/null b 1:3"},
+			{"linux.devices", "/nonexistent c 10:229"},
+			{"process.user.uid", "0"},
+			{"process.user.gid", "0"},
+			{"process.capabilities", "CAP_AUDIT_WRITE"},
+			{"process.capabilities", "CAP_KILL"},
+			{"process.rlimits", "RLIMIT_BOGUS"},
+			{"linux.namespaces", "host cgroup"},
+			{"linux.gidMappings", "0:1000:65536"},
+			{"linux.sysctl", "net.ipv4.ip_forward=1"},
+		}, nil},
+
+		{"minimal", `{
+	"ociVersion": "1.0.2",
+	"process": {"user": {"uid": 1000, "gid": 100}, "args": ["./usr/bin/app"], "cwd": "/"},
+	"root": {"path": "` + rootfs + `", "readonly": true},
+	"domainname": "example.org",
+	"hooks": {"prestart": []}
+}`, &sandbox.Params{
+			Dir:   "/",
+			Env:   []string{},
+			Path:  "/usr/bin/app",
+			Args:  []string{"./usr/bin/app"},
+			Uid:   1000,
+			Gid:   100,
+			Ops:   new(sandbox.Ops).Bind(rootfs, "/", 0),
+			Flags: sandbox.FAllowNet,
+		}, []*oci.Unsupported{
+			{"domainname", "example.org"},
+			{"hooks", ""},
+			{"linux.namespaces", "host pid"},
+			{"linux.namespaces", "host ipc"},
+			{"linux.namespaces", "host uts"},
+			{"linux.namespaces", "host mount"},
+			{"linux.namespaces", "host user"},
+			{"linux.namespaces", "host cgroup"},
+		}, nil},
+
+		{"not executable", `{"ociVersion": "1.0.0", "process": {"args": ["data"], "cwd": "/"}, "root": {"path": "rootfs"}}`,
+			nil, nil, oci.ErrSpec},
+		{"relative cwd", `{"ociVersion": "1.0.0", "process": {"args": ["/bin/sh"], "cwd": "tmp"}, "root": {"path": "rootfs"}}`,
+			nil, nil, oci.ErrSpec},
+		{"no process", `{"ociVersion": "1.0.0", "root": {"path": "rootfs"}}`, nil, nil, oci.ErrSpec},
+		{"no root", `{"ociVersion": "1.0.0", "process": {"args": ["/bin/sh"], "cwd": "/"}}`, nil, nil, oci.ErrSpec},
+		{"version", `{"ociVersion": "2.0.0", "process": {"args": ["/bin/sh"], "cwd": "/"}, "root": {"path": "rootfs"}}`,
+			nil, nil, oci.ErrSpec},
+		{"seccomp", `{"ociVersion": "1.0.0", "process": {"args": ["/bin/sh"], "cwd": "/"}, "root": {"path": "rootfs"},
+			"linux": {"seccomp": {"defaultAction": "SCMP_ACT_BOGUS"}}}`, nil, nil, oci.ErrSpec},
+		{"malformed", `{"ociVersion": 1}`, nil, nil, oci.ErrSpec},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := os.WriteFile(path.Join(bundle, oci.ConfigName), []byte(tc.config), 0644); err != nil {
+				t.Fatalf("WriteFile: error = %v", err)
+			}
+			spec, err := oci.Load(bundle)
+			if err != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("Load: error = %v, wantErr %v", err, tc.wantErr)
+				}
+				return
+			}
+
+			got, u, err := oci.Convert(spec, bundle)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Convert: error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if got != nil && spec.Linux != nil && spec.Linux.Seccomp != nil {
+				if len(got.SeccompPrograms) != 1 || len(got.SeccompPrograms[0]) == 0 {
+					t.Errorf("Convert: seccomp programs %v", got.SeccompPrograms)
+				}
+				got.SeccompPrograms = nil
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Convert:\n%#v, want\n%#v", got, tc.want)
+				if got != nil && tc.want != nil {
+					t.Errorf("Ops: %q, want %q", got.Ops.Describe(), tc.want.Ops.Describe())
+				}
+			}
+			if !reflect.DeepEqual(u, tc.wantU) {
+				t.Errorf("Convert: unsupported %s, want %s", u, tc.wantU)
+			}
+		})
+	}
+
+	t.Run("relative bundle", func(t *testing.T) {
+		spec := &oci.Spec{Version: "1.0.0", Process: &oci.Process{Args: []string{"/bin/sh"}}, Root: &oci.Root{Path: "rootfs"}}
+		if _, _, err := oci.Convert(spec, "bundle"); !errors.Is(err, oci.ErrSpec) {
+			t.Errorf("Convert: error = %v, want %v", err, oci.ErrSpec)
+		}
+	})
+}
+
+func TestUnsupportedString(t *testing.T) {
+	testCases := []struct {
+		u    *oci.Unsupported
+		want string
+	}{
+		{&oci.Unsupported{Field: "hooks"}, "hooks"},
+		{&oci.Unsupported{Field: "mounts", Value: "sysfs on /sys"}, "mounts: sysfs on /sys"},
+	}
+	for _, tc := range testCases {
+		if got := tc.u.String(); got != tc.want {
+			t.Errorf("String: %q, want %q", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package oci
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+)
+
+// seccompAction is the equivalent of a seccomp action of the runtime configuration.
+type seccompAction struct {
+	action seccomp.Action
+	// overrides errnoRet
+	errno int
+	// whether the action behaves identically, otherwise it is reported
+	exact bool
+}
+
+var seccompActions = map[string]seccompAction{
+	"SCMP_ACT_ALLOW": {seccomp.ActionAllow, 0, true},
+	"SCMP_ACT_ERRNO": {seccomp.ActionErrno, 0, true},
+	// the container process is killed regardless of the thread making the syscall
+	"SCMP_ACT_KILL":         {seccomp.ActionKill, 0, true},
+	"SCMP_ACT_KILL_THREAD":  {seccomp.ActionKill, 0, true},
+	"SCMP_ACT_KILL_PROCESS": {seccomp.ActionKill, 0, true},
+	// no tracer is ever attached to the container process
+	"SCMP_ACT_TRACE": {seccomp.ActionErrno, int(syscall.ENOSYS), true},
+
+	"SCMP_ACT_TRAP":   {seccomp.ActionKill, 0, false},
+	"SCMP_ACT_LOG":    {seccomp.ActionAllow, 0, false},
+	"SCMP_ACT_NOTIFY": {seccomp.ActionErrno, 0, false},
+}
+
+var seccompOps = map[string]seccomp.CmpOp{
+	"SCMP_CMP_NE":        seccomp.CmpNE,
+	"SCMP_CMP_LT":        seccomp.CmpLT,
+	"SCMP_CMP_LE":        seccomp.CmpLE,
+	"SCMP_CMP_EQ":        seccomp.CmpEQ,
+	"SCMP_CMP_GE":        seccomp.CmpGE,
+	"SCMP_CMP_GT":        seccomp.CmpGT,
+	"SCMP_CMP_MASKED_EQ": seccomp.CmpMaskedEQ,
+}
+
+// errnoRet returns the errno value of a, taking errnoRet if set.
+func (a seccompAction) errnoRet(errnoRet *uint) int {
+	if a.action != seccomp.ActionErrno {
+		return 0
+	}
+	if a.errno == 0 && errnoRet != nil {
+		return int(*errnoRet)
+	}
+	return a.errno
+}
+
+// SeccompProfile returns a syscall filter profile equivalent to s, and the settings it was unable to apply.
+// Actions without an equivalent are replaced by the closest action, which is reported.
+// The profile applies to the native architecture and its compatibility architecture
+// regardless of architectures of s, and syscalls not known on the native architecture are omitted.
+func SeccompProfile(s *Seccomp) (*seccomp.Profile, []*Unsupported, error) {
+	var u []*Unsupported
+	unsupported := func(field, value string) { u = append(u, &Unsupported{field, value}) }
+
+	a, ok := seccompActions[s.DefaultAction]
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: unknown seccomp action %q", ErrSpec, s.DefaultAction)
+	} else if !a.exact {
+		unsupported("linux.seccomp.defaultAction", s.DefaultAction)
+	}
+	profile := &seccomp.Profile{DefaultAction: a.action, DefaultErrno: a.errnoRet(s.DefaultErrnoRet)}
+
+	for _, flag := range s.Flags {
+		unsupported("linux.seccomp.flags", flag)
+	}
+	if s.ListenerPath != "" {
+		unsupported("linux.seccomp.listenerPath", s.ListenerPath)
+	}
+
+rules:
+	for _, sc := range s.Syscalls {
+		names := strings.Join(sc.Names, ",")
+		a, ok = seccompActions[sc.Action]
+		if !ok {
+			unsupported("linux.seccomp.syscalls", names+" "+sc.Action)
+			continue
+		} else if !a.exact {
+			unsupported("linux.seccomp.syscalls", names+" "+sc.Action)
+		}
+
+		var (
+			args     []seccomp.ArgCmp
+			repeated bool
+		)
+		if len(sc.Args) > 0 {
+			args = make([]seccomp.ArgCmp, len(sc.Args))
+		}
+		for i, arg := range sc.Args {
+			op, ok := seccompOps[arg.Op]
+			if !ok {
+				unsupported("linux.seccomp.syscalls", names+" "+arg.Op)
+				continue rules
+			}
+			args[i] = seccomp.ArgCmp{Index: arg.Index, Op: op, Value: arg.Value}
+			if op == seccomp.CmpMaskedEQ {
+				args[i].Mask, args[i].Value = arg.Value, arg.ValueTwo
+			}
+			for j := range i {
+				repeated = repeated || args[j].Index == arg.Index
+			}
+		}
+
+		// comparisons of the same argument match if any of them is true
+		groups := [][]seccomp.ArgCmp{args}
+		if repeated {
+			groups = make([][]seccomp.ArgCmp, len(args))
+			for i := range args {
+				groups[i] = args[i : i+1]
+			}
+		}
+
+		for _, name := range sc.Names {
+			if !seccomp.Known(name) {
+				continue
+			}
+			for _, g := range groups {
+				profile.Rules = append(profile.Rules, seccomp.Rule{
+					Syscall: name,
+					Action:  a.action,
+					Errno:   a.errnoRet(sc.ErrnoRet),
+					Args:    g,
+				})
+			}
+		}
+	}
+	return profile, u, nil
+}
@@ -0,0 +1,99 @@
+package oci_test
+
+import (
+	"errors"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/sandbox/oci"
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+)
+
+func TestSeccompProfile(t *testing.T) {
+	enosys := uint(syscall.ENOSYS)
+	testCases := []struct {
+		name    string
+		s       *oci.Seccomp
+		want    *seccomp.Profile
+		wantU   []*oci.Unsupported
+		wantErr error
+	}{
+		{"allow list", &oci.Seccomp{
+			DefaultAction:   "SCMP_ACT_ERRNO",
+			DefaultErrnoRet: &enosys,
+			Architectures:   []string{"SCMP_ARCH_X86_64", "SCMP_ARCH_X86"},
+			Syscalls: []oci.Syscall{
+				{Names: []string{"read", "write", "nonexistent"}, Action: "SCMP_ACT_ALLOW"},
+				{Names: []string{"personality"}, Action: "SCMP_ACT_ALLOW", Args: []oci.SeccompArg{
+					{Index: 0, Value: 0, Op: "SCMP_CMP_EQ"},
+					{Index: 0, Value: 8, Op: "SCMP_CMP_EQ"},
+				}},
+				{Names: []string{"clone"}, Action: "SCMP_ACT_ALLOW", Args: []oci.SeccompArg{
+					{Index: 0, Value: 0x7e020000, ValueTwo: 0, Op: "SCMP_CMP_MASKED_EQ"},
+				}},
+				{Names: []string{"ptrace"}, Action: "SCMP_ACT_ERRNO", ErrnoRet: &enosys},
+				{Names: []string{"kcmp"}, Action: "SCMP_ACT_TRACE"},
+			},
+		}, &seccomp.Profile{
+			DefaultAction: seccomp.ActionErrno,
+			DefaultErrno:  int(syscall.ENOSYS),
+			Rules: []seccomp.Rule{
+				{Syscall: "read", Action: seccomp.ActionAllow},
+				{Syscall: "write", Action: seccomp.ActionAllow},
+				{Syscall: "personality", Action: seccomp.ActionAllow, Args: []seccomp.ArgCmp{{Index: 0, Op: seccomp.CmpEQ}}},
+				{Syscall: "personality", Action: seccomp.ActionAllow, Args: []seccomp.ArgCmp{{Index: 0, Op: seccomp.CmpEQ, Value: 8}}},
+				{Syscall: "clone", Action: seccomp.ActionAllow, Args: []seccomp.ArgCmp{
+					{Index: 0, Op: seccomp.CmpMaskedEQ, Mask: 0x7e020000}}},
+				{Syscall: "ptrace", Action: seccomp.ActionErrno, Errno: int(syscall.ENOSYS)},
+				{Syscall: "kcmp", Action: seccomp.ActionErrno, Errno: int(syscall.ENOSYS)},
+			},
+		}, nil, nil},
+
+		{"deny list", &oci.Seccomp{
+			DefaultAction: "SCMP_ACT_LOG",
+			Flags:         []string{"SECCOMP_FILTER_FLAG_LOG"},
+			ListenerPath:  "/run/seccomp.sock",
+			Syscalls: []oci.Syscall{
+				{Names: []string{"ptrace"}, Action: "SCMP_ACT_KILL"},
+				{Names: []string{"mount"}, Action: "SCMP_ACT_TRAP"},
+				{Names: []string{"umount2"}, Action: "SCMP_ACT_BOGUS"},
+				{Names: []string{"chown"}, Action: "SCMP_ACT_ERRNO", Args: []oci.SeccompArg{{Index: 1, Op: "SCMP_CMP_BOGUS"}}},
+			},
+		}, &seccomp.Profile{
+			DefaultAction: seccomp.ActionAllow,
+			Rules: []seccomp.Rule{
+				{Syscall: "ptrace", Action: seccomp.ActionKill},
+				{Syscall: "mount", Action: seccomp.ActionKill},
+			},
+		}, []*oci.Unsupported{
+			{"linux.seccomp.defaultAction", "SCMP_ACT_LOG"},
+			{"linux.seccomp.flags", "SECCOMP_FILTER_FLAG_LOG"},
+			{"linux.seccomp.listenerPath", "/run/seccomp.sock"},
+			{"linux.seccomp.syscalls", "mount SCMP_ACT_TRAP"},
+			{"linux.seccomp.syscalls", "umount2 SCMP_ACT_BOGUS"},
+			{"linux.seccomp.syscalls", "chown SCMP_CMP_BOGUS"},
+		}, nil},
+
+		{"unknown default action", &oci.Seccomp{DefaultAction: "SCMP_ACT_BOGUS"}, nil, nil, oci.ErrSpec},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, u, err := oci.SeccompProfile(tc.s)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("SeccompProfile: error = %v, wantErr %v", err, tc.wantErr)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("SeccompProfile:\n%#v, want\n%#v", got, tc.want)
+			}
+			if !reflect.DeepEqual(u, tc.wantU) {
+				t.Errorf("SeccompProfile: unsupported %s, want %s", u, tc.wantU)
+			}
+			if got != nil {
+				if _, err = got.Export(); err != nil {
+					t.Errorf("Export: error = %v", err)
+				}
+			}
+		})
+	}
+}
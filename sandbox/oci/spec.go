@@ -0,0 +1,135 @@
+package oci
+
+import "encoding/json"
+
+// Spec is the subset of the OCI runtime configuration (config.json) considered by [Convert].
+// Fields not described here are ignored.
+type Spec struct {
+	Version     string            `json:"ociVersion"`
+	Process     *Process          `json:"process,omitempty"`
+	Root        *Root             `json:"root,omitempty"`
+	Hostname    string            `json:"hostname,omitempty"`
+	Domainname  string            `json:"domainname,omitempty"`
+	Mounts      []Mount           `json:"mounts,omitempty"`
+	Hooks       json.RawMessage   `json:"hooks,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Linux       *Linux            `json:"linux,omitempty"`
+}
+
+// Process describes the container process.
+type Process struct {
+	Terminal        bool          `json:"terminal,omitempty"`
+	User            User          `json:"user"`
+	Args            []string      `json:"args,omitempty"`
+	Env             []string      `json:"env,omitempty"`
+	Cwd             string        `json:"cwd"`
+	Capabilities    *Capabilities `json:"capabilities,omitempty"`
+	Rlimits         []Rlimit      `json:"rlimits,omitempty"`
+	NoNewPrivileges bool          `json:"noNewPrivileges,omitempty"`
+	ApparmorProfile string        `json:"apparmorProfile,omitempty"`
+	OOMScoreAdj     *int          `json:"oomScoreAdj,omitempty"`
+	SelinuxLabel    string        `json:"selinuxLabel,omitempty"`
+}
+
+// User specifies user information of the container process.
+type User struct {
+	UID            uint32   `json:"uid"`
+	GID            uint32   `json:"gid"`
+	Umask          *uint32  `json:"umask,omitempty"`
+	AdditionalGids []uint32 `json:"additionalGids,omitempty"`
+}
+
+// Capabilities holds the capability sets of the container process.
+type Capabilities struct {
+	Bounding    []string `json:"bounding,omitempty"`
+	Effective   []string `json:"effective,omitempty"`
+	Inheritable []string `json:"inheritable,omitempty"`
+	Permitted   []string `json:"permitted,omitempty"`
+	Ambient     []string `json:"ambient,omitempty"`
+}
+
+// Rlimit is a POSIX resource limit of the container process.
+type Rlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+// Root is the root filesystem of the container.
+type Root struct {
+	// relative to the bundle directory unless absolute
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly,omitempty"`
+}
+
+// Mount is a mount point in the container.
+type Mount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type,omitempty"`
+	Source      string   `json:"source,omitempty"`
+	Options     []string `json:"options,omitempty"`
+}
+
+// Linux holds Linux specific configuration.
+type Linux struct {
+	UIDMappings   []IDMapping       `json:"uidMappings,omitempty"`
+	GIDMappings   []IDMapping       `json:"gidMappings,omitempty"`
+	Sysctl        map[string]string `json:"sysctl,omitempty"`
+	Resources     json.RawMessage   `json:"resources,omitempty"`
+	CgroupsPath   string            `json:"cgroupsPath,omitempty"`
+	Namespaces    []Namespace       `json:"namespaces,omitempty"`
+	Devices       []Device          `json:"devices,omitempty"`
+	Seccomp       *Seccomp          `json:"seccomp,omitempty"`
+	MaskedPaths   []string          `json:"maskedPaths,omitempty"`
+	ReadonlyPaths []string          `json:"readonlyPaths,omitempty"`
+	MountLabel    string            `json:"mountLabel,omitempty"`
+	Personality   json.RawMessage   `json:"personality,omitempty"`
+}
+
+// IDMapping maps a range of container ids to host ids.
+type IDMapping struct {
+	ContainerID uint32 `json:"containerID"`
+	HostID      uint32 `json:"hostID"`
+	Size        uint32 `json:"size"`
+}
+
+// Namespace is a namespace the container is placed in, created unless Path is set.
+type Namespace struct {
+	Type string `json:"type"`
+	Path string `json:"path,omitempty"`
+}
+
+// Device is a device node created in the container.
+type Device struct {
+	Path string `json:"path"`
+	// c, u, b or p
+	Type  string `json:"type"`
+	Major int64  `json:"major,omitempty"`
+	Minor int64  `json:"minor,omitempty"`
+}
+
+// Seccomp is the syscall filter of the container process.
+type Seccomp struct {
+	DefaultAction   string    `json:"defaultAction"`
+	DefaultErrnoRet *uint     `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string  `json:"architectures,omitempty"`
+	Flags           []string  `json:"flags,omitempty"`
+	ListenerPath    string    `json:"listenerPath,omitempty"`
+	Syscalls        []Syscall `json:"syscalls,omitempty"`
+}
+
+// Syscall is a rule of a [Seccomp] filter matching syscalls of Names.
+type Syscall struct {
+	Names    []string     `json:"names"`
+	Action   string       `json:"action"`
+	ErrnoRet *uint        `json:"errnoRet,omitempty"`
+	Args     []SeccompArg `json:"args,omitempty"`
+}
+
+// SeccompArg compares a syscall argument.
+type SeccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo,omitempty"`
+	Op       string `json:"op"`
+}
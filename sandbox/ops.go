@@ -21,6 +21,9 @@ type (
 		// apply is called in intermediate root.
 		apply(params *Params) error
 
+		// landlock returns the container path made accessible by the op and its access rights.
+		landlock() (string, landlockAccess)
+
 		prefix() string
 		Is(op Op) bool
 		fmt.Stringer
@@ -29,6 +32,15 @@ type (
 
 func (f *Ops) Grow(n int) { *f = slices.Grow(*f, n) }
 
+// Describe returns a description of each op in the order they are applied by init.
+func (f *Ops) Describe() []string {
+	v := make([]string, len(*f))
+	for i, op := range *f {
+		v[i] = op.prefix() + " " + op.String()
+	}
+	return v
+}
+
 func init() { gob.Register(new(BindMount)) }
 
 // BindMount bind mounts host path Source on container path Target.
@@ -102,6 +114,19 @@ func (b *BindMount) apply(*Params) error {
 	return hostProc.bindMount(source, target, flags, b.SourceFinal == b.Target)
 }
 
+func (b *BindMount) landlock() (string, landlockAccess) {
+	switch {
+	case b.SourceFinal == "\x00":
+		return "", landlockNone
+	case b.Flags&BindDevice != 0:
+		return b.Target, landlockDevice
+	case b.Flags&BindWritable != 0:
+		return b.Target, landlockWrite
+	default:
+		return b.Target, landlockRead
+	}
+}
+
 func (b *BindMount) Is(op Op) bool { vb, ok := op.(*BindMount); return ok && *b == *vb }
 func (*BindMount) prefix() string  { return "mounting" }
 func (b *BindMount) String() string {
@@ -138,6 +163,8 @@ func (p MountProc) apply(params *Params) error {
 		fmt.Sprintf("cannot mount proc on %q:", v))
 }
 
+func (p MountProc) landlock() (string, landlockAccess) { return string(p), landlockWrite }
+
 func (p MountProc) Is(op Op) bool  { vp, ok := op.(MountProc); return ok && p == vp }
 func (MountProc) prefix() string   { return "mounting" }
 func (p MountProc) String() string { return fmt.Sprintf("proc on %q", string(p)) }
@@ -237,6 +264,8 @@ func (d MountDev) apply(params *Params) error {
 	return nil
 }
 
+func (d MountDev) landlock() (string, landlockAccess) { return string(d), landlockDevice }
+
 func (d MountDev) Is(op Op) bool  { vd, ok := op.(MountDev); return ok && d == vd }
 func (MountDev) prefix() string   { return "mounting" }
 func (d MountDev) String() string { return fmt.Sprintf("dev on %q", string(d)) }
@@ -268,6 +297,8 @@ func (m MountMqueue) apply(params *Params) error {
 		fmt.Sprintf("cannot mount mqueue on %q:", v))
 }
 
+func (m MountMqueue) landlock() (string, landlockAccess) { return string(m), landlockWrite }
+
 func (m MountMqueue) Is(op Op) bool  { vm, ok := op.(MountMqueue); return ok && m == vm }
 func (MountMqueue) prefix() string   { return "mounting" }
 func (m MountMqueue) String() string { return fmt.Sprintf("mqueue on %q", string(m)) }
@@ -298,6 +329,8 @@ func (t *MountTmpfs) apply(*Params) error {
 	return mountTmpfs("tmpfs", t.Path, t.Size, t.Perm)
 }
 
+func (t *MountTmpfs) landlock() (string, landlockAccess) { return t.Path, landlockWrite }
+
 func (t *MountTmpfs) Is(op Op) bool  { vt, ok := op.(*MountTmpfs); return ok && *t == *vt }
 func (*MountTmpfs) prefix() string   { return "mounting" }
 func (t *MountTmpfs) String() string { return fmt.Sprintf("tmpfs on %q size %d", t.Path, t.Size) }
@@ -306,6 +339,136 @@ func (f *Ops) Tmpfs(dest string, size int, perm os.FileMode) *Ops {
 	return f
 }
 
+func init() { gob.Register(new(MountOverlay)) }
+
+// MountOverlay mounts an overlay on container path Target with host paths Lower as lower directories.
+// If Upper is empty, the upper and work directories are created on the intermediate root
+// and writes are discarded when the container exits, otherwise host paths Upper and Work are used.
+type MountOverlay struct {
+	Target string
+	Lower  []string
+	// must be on the same filesystem as Work
+	Upper, Work string
+
+	lowerFinal            []string
+	upperFinal, workFinal string
+}
+
+func (o *MountOverlay) early(*Params) error {
+	if len(o.Lower) == 0 {
+		return msg.WrapErr(syscall.EBADE,
+			fmt.Sprintf("overlay on %q has no lower directories", o.Target))
+	}
+	if (o.Upper == "") != (o.Work == "") {
+		return msg.WrapErr(syscall.EBADE,
+			fmt.Sprintf("overlay on %q has incomplete upper directory", o.Target))
+	}
+	for _, name := range append([]string{o.Upper, o.Work}, o.Lower...) {
+		if name != "" && !path.IsAbs(name) {
+			return msg.WrapErr(syscall.EBADE,
+				fmt.Sprintf("path %q is not absolute", name))
+		}
+	}
+
+	o.lowerFinal = make([]string, len(o.Lower))
+	for i, name := range o.Lower {
+		if v, err := filepath.EvalSymlinks(name); err != nil {
+			return wrapErrSelf(err)
+		} else {
+			o.lowerFinal[i] = v
+		}
+	}
+
+	if o.Upper == "" {
+		return nil
+	}
+	for _, p := range [][2]*string{{&o.Upper, &o.upperFinal}, {&o.Work, &o.workFinal}} {
+		if err := os.MkdirAll(*p[0], 0700); err != nil {
+			return wrapErrSelf(err)
+		}
+		if v, err := filepath.EvalSymlinks(*p[0]); err != nil {
+			return wrapErrSelf(err)
+		} else {
+			*p[1] = v
+		}
+	}
+	return nil
+}
+
+func (o *MountOverlay) apply(params *Params) error {
+	if !path.IsAbs(o.Target) {
+		return msg.WrapErr(syscall.EBADE,
+			fmt.Sprintf("path %q is not absolute", o.Target))
+	}
+	if len(o.lowerFinal) != len(o.Lower) {
+		// unreachable
+		return syscall.EBADE
+	}
+
+	var upper, work string
+	if o.Upper == "" {
+		if name, err := os.MkdirTemp("/", "overlay.*"); err != nil {
+			return wrapErrSelf(err)
+		} else {
+			upper, work = path.Join(name, "upper"), path.Join(name, "work")
+		}
+		for _, name := range []string{upper, work} {
+			if err := os.Mkdir(name, 0700); err != nil {
+				return wrapErrSelf(err)
+			}
+		}
+	} else {
+		upper, work = toHost(o.upperFinal), toHost(o.workFinal)
+	}
+
+	target := toSysroot(o.Target)
+	if err := os.MkdirAll(target, params.ParentPerm); err != nil {
+		return wrapErrSelf(err)
+	}
+
+	lower := make([]string, len(o.lowerFinal))
+	for i, name := range o.lowerFinal {
+		lower[i] = escapeOverlayDataSegment(toHost(name))
+	}
+	data := "lowerdir=" + strings.Join(lower, ":") +
+		",upperdir=" + escapeOverlayDataSegment(upper) +
+		",workdir=" + escapeOverlayDataSegment(work)
+	if !params.Privileged {
+		// trusted xattrs are not available in a user namespace
+		data += ",userxattr"
+	}
+
+	return wrapErrSuffix(syscall.Mount("overlay", target, "overlay",
+		syscall.MS_NOSUID|syscall.MS_NODEV, data),
+		fmt.Sprintf("cannot mount overlay on %q:", o.Target))
+}
+
+// escapeOverlayDataSegment escapes characters with special meaning in overlayfs mount data.
+func escapeOverlayDataSegment(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `:`, `\:`, `,`, `\,`).Replace(s)
+}
+
+func (o *MountOverlay) landlock() (string, landlockAccess) { return o.Target, landlockWrite }
+
+func (o *MountOverlay) Is(op Op) bool {
+	vo, ok := op.(*MountOverlay)
+	return ok &&
+		o.Target == vo.Target &&
+		slices.Equal(o.Lower, vo.Lower) &&
+		o.Upper == vo.Upper && o.Work == vo.Work
+}
+func (*MountOverlay) prefix() string { return "mounting" }
+func (o *MountOverlay) String() string {
+	if o.Upper == "" {
+		return fmt.Sprintf("ephemeral overlay on %q lower %q", o.Target, o.Lower)
+	}
+	return fmt.Sprintf("overlay on %q lower %q upper %q", o.Target, o.Lower, o.Upper)
+}
+func (f *Ops) Overlay(target string, lower []string, upper, work string) *Ops {
+	*f = append(*f, &MountOverlay{Target: target, Lower: lower, Upper: upper, Work: work})
+	return f
+}
+
 func init() { gob.Register(new(Symlink)) }
 
 // Symlink creates a symlink in the container filesystem.
@@ -343,6 +506,8 @@ func (l *Symlink) apply(params *Params) error {
 	return nil
 }
 
+func (*Symlink) landlock() (string, landlockAccess) { return "", landlockNone }
+
 func (l *Symlink) Is(op Op) bool  { vl, ok := op.(*Symlink); return ok && *l == *vl }
 func (*Symlink) prefix() string   { return "creating" }
 func (l *Symlink) String() string { return fmt.Sprintf("symlink on %q target %q", l[1], l[0]) }
@@ -372,6 +537,8 @@ func (m *Mkdir) apply(*Params) error {
 	return nil
 }
 
+func (m *Mkdir) landlock() (string, landlockAccess) { return m.Path, landlockRead }
+
 func (m *Mkdir) Is(op Op) bool  { vm, ok := op.(*Mkdir); return ok && m == vm }
 func (*Mkdir) prefix() string   { return "creating" }
 func (m *Mkdir) String() string { return fmt.Sprintf("directory %q perm %s", m.Path, m.Perm) }
@@ -424,6 +591,8 @@ func (t *Tmpfile) apply(params *Params) error {
 	return nil
 }
 
+func (t *Tmpfile) landlock() (string, landlockAccess) { return t.Path, landlockRead }
+
 func (t *Tmpfile) Is(op Op) bool {
 	vt, ok := op.(*Tmpfile)
 	return ok && t.Path == vt.Path && slices.Equal(t.Data, vt.Data)
@@ -484,6 +653,9 @@ func (e *AutoEtc) apply(*Params) error {
 func (e *AutoEtc) hostPath() string { return "/etc/" + e.hostRel() }
 func (e *AutoEtc) hostRel() string  { return ".host/" + e.Prefix }
 
+// landlock returns no path as host /etc is made accessible by the preceding bind mount.
+func (*AutoEtc) landlock() (string, landlockAccess) { return "", landlockNone }
+
 func (e *AutoEtc) Is(op Op) bool {
 	ve, ok := op.(*AutoEtc)
 	return ok && ((e == nil && ve == nil) || (e != nil && ve != nil && *e == *ve))
@@ -0,0 +1,73 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// rightsSocket returns both ends of a socket for passing a file descriptor described by name from init.
+// The child end is placed in the extra files of init and must be closed after the container starts.
+func rightsSocket(name string) (parent, child *os.File, err error) {
+	if fd, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET|syscall.SOCK_CLOEXEC, 0); err != nil {
+		return nil, nil, wrapErrSuffix(err,
+			fmt.Sprintf("cannot create %s socket:", name))
+	} else {
+		return os.NewFile(uintptr(fd[0]), name), os.NewFile(uintptr(fd[1]), name), nil
+	}
+}
+
+// sendRights sends file descriptor rights described by name over socket fd.
+func sendRights(fd, rights int, name string) error {
+	msg.Verbosef("sending %s", name)
+	return wrapErrSuffix(syscall.Sendmsg(fd, []byte{0}, syscall.UnixRights(rights), nil, 0),
+		fmt.Sprintf("cannot send %s:", name))
+}
+
+// receiveRights blocks until a file descriptor described by name is received over f, then closes f.
+func receiveRights(f *os.File, name string) (int, error) {
+	defer func() { _ = f.Close() }()
+	return readRights(f, name)
+}
+
+// readRights blocks until a file descriptor described by name is received over f.
+func readRights(f *os.File, name string) (int, error) {
+	var (
+		n, oobn int
+		buf     = make([]byte, 1)
+		oob     = make([]byte, syscall.CmsgSpace(4))
+	)
+	if c, err := f.SyscallConn(); err != nil {
+		return -1, wrapErrSelf(err)
+	} else if rerr := c.Read(func(fd uintptr) bool {
+		n, oobn, _, _, err = syscall.Recvmsg(int(fd), buf, oob, syscall.MSG_CMSG_CLOEXEC)
+		return !errors.Is(err, syscall.EAGAIN)
+	}); rerr != nil {
+		return -1, wrapErrSelf(rerr)
+	} else if err != nil {
+		return -1, wrapErrSuffix(err,
+			fmt.Sprintf("cannot receive %s:", name))
+	}
+	if n != 1 {
+		return -1, msg.WrapErr(syscall.EPIPE,
+			fmt.Sprintf("socket closed before receiving %s", name))
+	}
+
+	if msgs, err := syscall.ParseSocketControlMessage(oob[:oobn]); err != nil {
+		return -1, wrapErrSelf(err)
+	} else if len(msgs) != 1 {
+		return -1, msg.WrapErr(syscall.EBADMSG,
+			"unexpected control messages")
+	} else if fds, err := syscall.ParseUnixRights(&msgs[0]); err != nil {
+		return -1, wrapErrSelf(err)
+	} else if len(fds) != 1 {
+		for _, fd := range fds {
+			_ = syscall.Close(fd)
+		}
+		return -1, msg.WrapErr(syscall.EBADMSG,
+			fmt.Sprintf("received %d file descriptors", len(fds)))
+	} else {
+		return fds[0], nil
+	}
+}
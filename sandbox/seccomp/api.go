@@ -13,11 +13,40 @@ const (
 	PresetCommon = PresetStrict | FilterMultiarch
 )
 
-// New returns an inactive Encoder instance.
-func New(opts FilterOpts) *Encoder { return &Encoder{newExporter(opts)} }
+// New returns an inactive Encoder instance producing a filter of opts merged with rules.
+func New(opts FilterOpts, rules []Rule) *Encoder { return &Encoder{newExporter(opts, rules)} }
 
-// Load loads a filter into the kernel.
-func Load(opts FilterOpts) error { return buildFilter(-1, opts) }
+// Load loads a filter of opts merged with rules into the kernel.
+// Filters passing syscalls to a supervisor must be loaded via [LoadNotify] instead.
+func Load(opts FilterOpts, rules []Rule) error {
+	if Notifies(opts, rules) {
+		return &LibraryError{Prefix: "cannot load filter without a listener", Errno: syscall.EINVAL}
+	}
+	_, err := buildFilter(-1, opts, rules)
+	return err
+}
+
+// LoadNotify loads a filter of opts merged with rules into the kernel and returns its listener file descriptor.
+// The filter must pass syscalls to a supervisor as reported by [Notifies].
+func LoadNotify(opts FilterOpts, rules []Rule) (int, error) {
+	if !Notifies(opts, rules) {
+		return -1, &LibraryError{Prefix: "cannot load filter with a listener", Errno: syscall.EINVAL}
+	}
+	return buildFilter(-1, opts, rules)
+}
+
+// Notifies returns whether a filter of opts merged with rules passes syscalls to a supervisor.
+func Notifies(opts FilterOpts, rules []Rule) bool {
+	if opts&FilterAudit != 0 {
+		return true
+	}
+	for i := range rules {
+		if rules[i].Action == ActionNotify {
+			return true
+		}
+	}
+	return false
+}
 
 /*
 An Encoder writes a BPF program to an output stream.
@@ -47,17 +76,18 @@ func (e *Encoder) Close() error {
 }
 
 // NewFile returns an instance of exporter implementing [proc.File].
-func NewFile(opts FilterOpts) proc.File { return &File{opts: opts} }
+func NewFile(opts FilterOpts, rules []Rule) proc.File { return &File{opts: opts, rules: rules} }
 
 // File implements [proc.File] and provides access to the read end of exporter pipe.
 type File struct {
-	opts FilterOpts
+	opts  FilterOpts
+	rules []Rule
 	proc.BaseFile
 }
 
 func (f *File) ErrCount() int { return 2 }
 func (f *File) Fulfill(ctx context.Context, dispatchErr func(error)) error {
-	e := newExporter(f.opts)
+	e := newExporter(f.opts, f.rules)
 	if err := e.prepare(); err != nil {
 		return err
 	}
@@ -0,0 +1,201 @@
+package seccomp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+)
+
+// linux/filter.h
+const (
+	bpfLD   = 0x00
+	bpfLDX  = 0x01
+	bpfST   = 0x02
+	bpfSTX  = 0x03
+	bpfALU  = 0x04
+	bpfJMP  = 0x05
+	bpfRET  = 0x06
+	bpfMISC = 0x07
+
+	bpfW   = 0x00
+	bpfIMM = 0x00
+	bpfABS = 0x20
+	bpfMEM = 0x60
+	bpfLEN = 0x80
+
+	bpfK = 0x00
+	bpfX = 0x08
+	bpfA = 0x10
+
+	bpfTAX = 0x00
+	bpfTXA = 0x80
+
+	bpfMemWords = 16
+)
+
+// linux/seccomp.h
+const (
+	SECCOMP_RET_ERRNO = 0x00050000
+	SECCOMP_RET_LOG   = 0x7ffc0000
+	SECCOMP_RET_ALLOW = 0x7fff0000
+
+	SECCOMP_RET_ACTION_FULL = 0xffff0000
+	SECCOMP_RET_DATA        = 0x0000ffff
+
+	// sizeof(struct seccomp_data)
+	seccompDataLen = 64
+)
+
+// errProgram is returned for a malformed or unsupported BPF program.
+var errProgram = syscall.EINVAL
+
+// runFilter returns the return value of the classic BPF program prog for data as evaluated by the kernel.
+func runFilter(prog []byte, data *NotifData) (uint32, error) {
+	if len(prog) == 0 || len(prog)%8 != 0 {
+		return 0, errProgram
+	}
+
+	var d [seccompDataLen]byte
+	binary.NativeEndian.PutUint32(d[0:], uint32(data.Nr))
+	binary.NativeEndian.PutUint32(d[4:], data.Arch)
+	binary.NativeEndian.PutUint64(d[8:], data.InstructionPointer)
+	for i, v := range data.Args {
+		binary.NativeEndian.PutUint64(d[16+8*i:], v)
+	}
+
+	var (
+		a, x uint32
+		mem  [bpfMemWords]uint32
+	)
+	// jumps are always forward, so the program terminates
+	for pc := 0; pc < len(prog)/8; pc++ {
+		ins := prog[pc*8 : pc*8+8]
+		code := binary.NativeEndian.Uint16(ins[0:])
+		jt, jf := int(ins[2]), int(ins[3])
+		k := binary.NativeEndian.Uint32(ins[4:])
+
+		switch code & 0x07 {
+		case bpfLD, bpfLDX:
+			var v uint32
+			switch code & 0xe0 {
+			case bpfIMM:
+				v = k
+			case bpfABS:
+				if code&0x07 != bpfLD || code&0x18 != bpfW || k%4 != 0 || k >= seccompDataLen {
+					return 0, errProgram
+				}
+				v = binary.NativeEndian.Uint32(d[k:])
+			case bpfMEM:
+				if k >= bpfMemWords {
+					return 0, errProgram
+				}
+				v = mem[k]
+			case bpfLEN:
+				v = seccompDataLen
+			default:
+				return 0, errProgram
+			}
+			if code&0x07 == bpfLD {
+				a = v
+			} else {
+				x = v
+			}
+
+		case bpfST, bpfSTX:
+			if k >= bpfMemWords {
+				return 0, errProgram
+			}
+			if code&0x07 == bpfST {
+				mem[k] = a
+			} else {
+				mem[k] = x
+			}
+
+		case bpfALU:
+			v := k
+			if code&bpfX != 0 {
+				v = x
+			}
+			switch code & 0xf0 {
+			case 0x00:
+				a += v
+			case 0x10:
+				a -= v
+			case 0x20:
+				a *= v
+			case 0x30:
+				if v == 0 {
+					return 0, nil
+				}
+				a /= v
+			case 0x40:
+				a |= v
+			case 0x50:
+				a &= v
+			case 0x60:
+				a <<= v
+			case 0x70:
+				a >>= v
+			case 0x80:
+				a = -a
+			case 0x90:
+				if v == 0 {
+					return 0, nil
+				}
+				a %= v
+			case 0xa0:
+				a ^= v
+			default:
+				return 0, errProgram
+			}
+
+		case bpfJMP:
+			v := k
+			if code&bpfX != 0 {
+				v = x
+			}
+			var cond bool
+			switch code & 0xf0 {
+			case 0x00:
+				pc += int(k)
+				continue
+			case 0x10:
+				cond = a == v
+			case 0x20:
+				cond = a > v
+			case 0x30:
+				cond = a >= v
+			case 0x40:
+				cond = a&v != 0
+			default:
+				return 0, errProgram
+			}
+			if cond {
+				pc += jt
+			} else {
+				pc += jf
+			}
+
+		case bpfRET:
+			switch code & 0x18 {
+			case bpfK:
+				return k, nil
+			case bpfA:
+				return a, nil
+			default:
+				return 0, errProgram
+			}
+
+		case bpfMISC:
+			switch code & 0xf8 {
+			case bpfTAX:
+				x = a
+			case bpfTXA:
+				a = x
+			default:
+				return 0, errProgram
+			}
+		}
+	}
+	return 0, fmt.Errorf("program ends without returning: %w", errProgram)
+}
@@ -7,8 +7,9 @@ import (
 )
 
 type exporter struct {
-	opts FilterOpts
-	r, w *os.File
+	opts  FilterOpts
+	rules []Rule
+	r, w  *os.File
 
 	prepareOnce sync.Once
 	prepareErr  error
@@ -28,7 +29,8 @@ func (e *exporter) prepare() error {
 
 		ec := make(chan error, 1)
 		go func(fd uintptr) {
-			ec <- buildFilter(int(fd), e.opts)
+			_, err := buildFilter(int(fd), e.opts, e.rules)
+			ec <- err
 			close(ec)
 			_ = e.closeWrite()
 			runtime.KeepAlive(e.w)
@@ -53,6 +55,6 @@ func (e *exporter) closeWrite() error {
 	return e.closeErr
 }
 
-func newExporter(opts FilterOpts) *exporter {
-	return &exporter{opts: opts}
+func newExporter(opts FilterOpts, rules []Rule) *exporter {
+	return &exporter{opts: opts, rules: rules}
 }
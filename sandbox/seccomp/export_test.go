@@ -91,7 +91,7 @@ func TestExport(t *testing.T) {
 			seccomp.SetOutput(t.Log)
 			t.Cleanup(func() { seccomp.SetOutput(oldF) })
 
-			e := seccomp.New(tc.opts)
+			e := seccomp.New(tc.opts, nil)
 			digest := sha512.New()
 
 			if _, err := io.CopyBuffer(digest, e, buf); (err != nil) != tc.wantErr {
@@ -111,7 +111,7 @@ func TestExport(t *testing.T) {
 	}
 
 	t.Run("close without use", func(t *testing.T) {
-		e := seccomp.New(0)
+		e := seccomp.New(0, nil)
 		if err := e.Close(); !errors.Is(err, syscall.EINVAL) {
 			t.Errorf("Close: error = %v", err)
 			return
@@ -119,7 +119,7 @@ func TestExport(t *testing.T) {
 	})
 
 	t.Run("close partial read", func(t *testing.T) {
-		e := seccomp.New(0)
+		e := seccomp.New(0, nil)
 		if _, err := e.Read(nil); err != nil {
 			t.Errorf("Read: error = %v", err)
 			return
@@ -137,10 +137,10 @@ func TestExport(t *testing.T) {
 func BenchmarkExport(b *testing.B) {
 	buf := make([]byte, 8)
 	for i := 0; i < b.N; i++ {
-		e := seccomp.New(seccomp.FilterExt |
-			seccomp.FilterDenyNS | seccomp.FilterDenyTTY | seccomp.FilterDenyDevel |
-			seccomp.FilterMultiarch | seccomp.FilterLinux32 | seccomp.FilterCan |
-			seccomp.FilterBluetooth)
+		e := seccomp.New(seccomp.FilterExt|
+			seccomp.FilterDenyNS|seccomp.FilterDenyTTY|seccomp.FilterDenyDevel|
+			seccomp.FilterMultiarch|seccomp.FilterLinux32|seccomp.FilterCan|
+			seccomp.FilterBluetooth, nil)
 		if _, err := io.CopyBuffer(io.Discard, e, buf); err != nil {
 			b.Fatalf("cannot export: %v", err)
 		}
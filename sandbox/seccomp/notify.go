@@ -0,0 +1,186 @@
+package seccomp
+
+/*
+#include <stdlib.h>
+#include "seccomp-build.h"
+*/
+import "C"
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// linux/seccomp.h
+const (
+	SECCOMP_IOCTL_NOTIF_RECV     = 0xc0502100
+	SECCOMP_IOCTL_NOTIF_SEND     = 0xc0182101
+	SECCOMP_IOCTL_NOTIF_ID_VALID = 0x40082102
+
+	// NotifFlagContinue causes the kernel to execute the syscall as if it was allowed.
+	NotifFlagContinue = 1 << 0
+)
+
+type (
+	// NotifData is the syscall of a [Notif], struct seccomp_data.
+	NotifData struct {
+		Nr                 int32
+		Arch               uint32
+		InstructionPointer uint64
+		Args               [6]uint64
+	}
+
+	// Notif is a seccomp user notification, struct seccomp_notif.
+	Notif struct {
+		ID    uint64
+		Pid   uint32
+		Flags uint32
+		Data  NotifData
+	}
+
+	// NotifResp is the response to a [Notif], struct seccomp_notif_resp.
+	NotifResp struct {
+		ID    uint64
+		Val   int64
+		Error int32
+		Flags uint32
+	}
+)
+
+// Listener receives notifications of a filter loaded via [LoadNotify].
+// Methods of Listener are safe for concurrent use.
+type Listener struct {
+	f *os.File
+	c syscall.RawConn
+}
+
+// NewListener returns a [Listener] taking ownership of fd.
+func NewListener(fd int) (*Listener, error) {
+	// the listener file is registered with the runtime poller
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		_ = syscall.Close(fd)
+		return nil, err
+	}
+	l := &Listener{f: os.NewFile(uintptr(fd), "seccomp listener")}
+	if c, err := l.f.SyscallConn(); err != nil {
+		_ = l.f.Close()
+		return nil, err
+	} else {
+		l.c = c
+	}
+	return l, nil
+}
+
+type pollFd struct {
+	fd      int32
+	events  int16
+	revents int16
+}
+
+const (
+	pollIn  = 0x1
+	pollHup = 0x10
+)
+
+// Receive blocks until a notification is received into n.
+// Receive returns [io.EOF] once no process is using the filter, or [os.ErrClosed] after [Listener.Close].
+func (l *Listener) Receive(n *Notif) error {
+	var err error
+	if rerr := l.c.Read(func(fd uintptr) bool {
+		for {
+			p := pollFd{int32(fd), pollIn, 0}
+			var ts syscall.Timespec
+			if _, _, errno := syscall.Syscall6(syscall.SYS_PPOLL,
+				uintptr(unsafe.Pointer(&p)), 1, uintptr(unsafe.Pointer(&ts)), 0, 0, 0); errno != 0 {
+				if errno == syscall.EINTR {
+					continue
+				}
+				err = errno
+				return true
+			}
+			if p.revents&pollIn == 0 {
+				if p.revents&pollHup != 0 {
+					err = io.EOF
+					return true
+				}
+				// wait for the runtime poller
+				return false
+			}
+
+			*n = Notif{}
+			if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd,
+				SECCOMP_IOCTL_NOTIF_RECV, uintptr(unsafe.Pointer(n))); errno != 0 {
+				// ENOENT: process was killed before the notification is received
+				if errno == syscall.EINTR || errno == syscall.ENOENT {
+					continue
+				}
+				err = errno
+			}
+			return true
+		}
+	}); rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// Respond sends a response to a notification received by [Listener.Receive].
+// Respond returns [syscall.ENOENT] if the process was killed or its syscall interrupted.
+func (l *Listener) Respond(r *NotifResp) error {
+	var err error
+	if cerr := l.c.Control(func(fd uintptr) {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd,
+			SECCOMP_IOCTL_NOTIF_SEND, uintptr(unsafe.Pointer(r))); errno != 0 {
+			err = errno
+		}
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
+// Valid returns whether the notification of id is still pending.
+// This must be checked after reading memory of the notifying process.
+func (l *Listener) Valid(id uint64) bool {
+	var err error
+	if cerr := l.c.Control(func(fd uintptr) {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd,
+			SECCOMP_IOCTL_NOTIF_ID_VALID, uintptr(unsafe.Pointer(&id))); errno != 0 {
+			err = errno
+		}
+	}); cerr != nil {
+		return false
+	}
+	return err == nil
+}
+
+// Close closes the listener, unblocking pending calls to [Listener.Receive].
+func (l *Listener) Close() error { return l.f.Close() }
+
+// SyscallName returns the name of syscall nr on the audit architecture arch.
+func SyscallName(arch uint32, nr int32) string {
+	if s := C.seccomp_syscall_resolve_num_arch(C.uint32_t(arch), C.int(nr)); s != nil {
+		defer C.free(unsafe.Pointer(s))
+		return C.GoString(s)
+	}
+	return "syscall_" + strconv.Itoa(int(nr))
+}
+
+// ArchName returns a string representation of the audit architecture arch.
+func ArchName(arch uint32) string {
+	switch arch {
+	case C.SCMP_ARCH_X86_64:
+		return "x86_64"
+	case C.SCMP_ARCH_X86:
+		return "x86"
+	case C.SCMP_ARCH_AARCH64:
+		return "aarch64"
+	case C.SCMP_ARCH_ARM:
+		return "arm"
+	default:
+		return "arch_" + strconv.FormatUint(uint64(arch), 16)
+	}
+}
@@ -0,0 +1,109 @@
+package seccomp
+
+/*
+#include "seccomp-build.h"
+*/
+import "C"
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+)
+
+// Profile is a standalone syscall filter taking DefaultAction for syscalls not matched by any of its rules.
+// Unlike rules merged with [FilterOpts] presets, a profile is never combined with preset rules:
+// it is meant to be loaded in addition to the preset filter, where the most restrictive action applies.
+type Profile struct {
+	DefaultAction Action `json:"default_action"`
+	// errno value for errno default action, defaults to EPERM
+	DefaultErrno int `json:"default_errno,omitempty"`
+	// rules taking the default action are redundant and omitted
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+// Validate returns an error wrapping [ErrInvalidRule] if p cannot be exported.
+// Rules of a profile may refer to the same syscall more than once and allow syscalls conditionally,
+// but cannot pass syscalls to a supervisor.
+func (p *Profile) Validate() error {
+	switch p.DefaultAction {
+	case ActionErrno:
+		if p.DefaultErrno < 0 || p.DefaultErrno > 0xffff {
+			return fmt.Errorf("%w: default errno %d out of range", ErrInvalidRule, p.DefaultErrno)
+		}
+	case ActionKill, ActionAllow:
+		if p.DefaultErrno != 0 {
+			return fmt.Errorf("%w: default errno set for %s action", ErrInvalidRule, p.DefaultAction)
+		}
+	default:
+		return fmt.Errorf("%w: unsupported default action %q", ErrInvalidRule, p.DefaultAction)
+	}
+
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if _, ok := resolveSyscall(r.Syscall); !ok {
+			return fmt.Errorf("%w %d: unknown syscall %q", ErrInvalidRule, i, r.Syscall)
+		}
+		switch r.Action {
+		case ActionErrno:
+			if r.Errno < 0 || r.Errno > 0xffff {
+				return fmt.Errorf("%w %d: errno %d out of range", ErrInvalidRule, i, r.Errno)
+			}
+		case ActionKill, ActionAllow:
+			if r.Errno != 0 {
+				return fmt.Errorf("%w %d: errno set for %s action", ErrInvalidRule, i, r.Action)
+			}
+		default:
+			return fmt.Errorf("%w %d: unsupported action %q", ErrInvalidRule, i, r.Action)
+		}
+		if err := validateArgs(i, r.Args); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Export returns the BPF program of p for the native architecture and its compatibility architecture.
+func (p *Profile) Export() ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	rulesC := convertRules(p.Rules)
+	var rulesP *C.struct_f_rule
+	if len(rulesC) > 0 {
+		rulesP = &rulesC[0]
+	}
+
+	defaultErrno := p.DefaultErrno
+	if defaultErrno == 0 {
+		defaultErrno = int(syscall.EPERM)
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	type result struct {
+		data []byte
+		err  error
+	}
+	rc := make(chan result, 1)
+	go func() { data, err := io.ReadAll(r); rc <- result{data, err} }()
+
+	arch, multiarch := nativeArch()
+	var ret C.int
+	res, err := C.f_build_profile(&ret, C.int(w.Fd()), arch, multiarch,
+		actionC(p.DefaultAction, defaultErrno), rulesP, C.size_t(len(rulesC)))
+	_ = w.Close()
+	v := <-rc
+	if prefix := resPrefix[res]; prefix != "" {
+		return nil, &LibraryError{
+			prefix,
+			-syscall.Errno(ret),
+			err,
+		}
+	}
+	return v.data, v.err
+}
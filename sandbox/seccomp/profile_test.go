@@ -0,0 +1,91 @@
+package seccomp_test
+
+import (
+	"bytes"
+	"errors"
+	"syscall"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+)
+
+func TestProfileValidate(t *testing.T) {
+	testCases := []struct {
+		name    string
+		p       seccomp.Profile
+		wantErr bool
+	}{
+		{"allow list", seccomp.Profile{DefaultAction: seccomp.ActionErrno, DefaultErrno: int(syscall.ENOSYS), Rules: []seccomp.Rule{
+			{Syscall: "read", Action: seccomp.ActionAllow},
+			{Syscall: "personality", Action: seccomp.ActionAllow, Args: []seccomp.ArgCmp{{Index: 0, Op: seccomp.CmpEQ}}},
+			{Syscall: "personality", Action: seccomp.ActionAllow, Args: []seccomp.ArgCmp{{Index: 0, Op: seccomp.CmpEQ, Value: 8}}},
+		}}, false},
+		{"deny list", seccomp.Profile{DefaultAction: seccomp.ActionAllow, Rules: []seccomp.Rule{
+			{Syscall: "ptrace", Action: seccomp.ActionKill},
+		}}, false},
+
+		{"default action", seccomp.Profile{DefaultAction: seccomp.ActionNotify}, true},
+		{"default errno range", seccomp.Profile{DefaultAction: seccomp.ActionErrno, DefaultErrno: -1}, true},
+		{"default errno kill", seccomp.Profile{DefaultAction: seccomp.ActionKill, DefaultErrno: 1}, true},
+		{"notify", seccomp.Profile{DefaultAction: seccomp.ActionAllow, Rules: []seccomp.Rule{
+			{Syscall: "ptrace", Action: seccomp.ActionNotify},
+		}}, true},
+		{"unknown syscall", seccomp.Profile{DefaultAction: seccomp.ActionAllow, Rules: []seccomp.Rule{
+			{Syscall: "nonexistent", Action: seccomp.ActionKill},
+		}}, true},
+		{"index repeated", seccomp.Profile{DefaultAction: seccomp.ActionAllow, Rules: []seccomp.Rule{
+			{Syscall: "ptrace", Action: seccomp.ActionKill, Args: []seccomp.ArgCmp{
+				{Index: 0, Op: seccomp.CmpGE}, {Index: 0, Op: seccomp.CmpLE, Value: 1}}},
+		}}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := tc.p.Validate(); tc.wantErr != (err != nil) {
+				t.Errorf("Validate: error = %v, wantErr %v", err, tc.wantErr)
+			} else if err != nil && !errors.Is(err, seccomp.ErrInvalidRule) {
+				t.Errorf("Validate: error = %v, want %v", err, seccomp.ErrInvalidRule)
+			}
+		})
+	}
+}
+
+func TestProfileExport(t *testing.T) {
+	export := func(t *testing.T, p *seccomp.Profile) []byte {
+		v, err := p.Export()
+		if err != nil {
+			t.Fatalf("Export: error = %v", err)
+		}
+		if len(v) == 0 || len(v)%8 != 0 {
+			t.Fatalf("Export: invalid program of length %d", len(v))
+		}
+		return v
+	}
+
+	base := export(t, &seccomp.Profile{DefaultAction: seccomp.ActionErrno})
+	testCases := []struct {
+		name  string
+		p     *seccomp.Profile
+		equal bool
+	}{
+		{"default errno", &seccomp.Profile{DefaultAction: seccomp.ActionErrno, DefaultErrno: int(syscall.EPERM)}, true},
+		{"redundant", &seccomp.Profile{DefaultAction: seccomp.ActionErrno, Rules: []seccomp.Rule{
+			{Syscall: "ptrace", Action: seccomp.ActionErrno}}}, true},
+		{"errno", &seccomp.Profile{DefaultAction: seccomp.ActionErrno, DefaultErrno: int(syscall.ENOSYS)}, false},
+		{"allow", &seccomp.Profile{DefaultAction: seccomp.ActionErrno, Rules: []seccomp.Rule{
+			{Syscall: "read", Action: seccomp.ActionAllow}}}, false},
+		{"kill", &seccomp.Profile{DefaultAction: seccomp.ActionKill}, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := export(t, tc.p); bytes.Equal(got, base) != tc.equal {
+				t.Errorf("Export: equal %v, want %v", !tc.equal, tc.equal)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		if _, err := (&seccomp.Profile{DefaultAction: "trap"}).Export(); !errors.Is(err, seccomp.ErrInvalidRule) {
+			t.Errorf("Export: error = %v, want %v", err, seccomp.ErrInvalidRule)
+		}
+	})
+}
@@ -0,0 +1,246 @@
+package seccomp
+
+/*
+#include <stdlib.h>
+#include "seccomp-build.h"
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// ErrInvalidRule is returned for a [Rule] that cannot be merged with the preset filter.
+var ErrInvalidRule = errors.New("invalid seccomp rule")
+
+// Action is the action taken by the kernel when a [Rule] matches.
+type Action string
+
+const (
+	// ActionErrno fails the syscall with [Rule.Errno].
+	ActionErrno Action = "errno"
+	// ActionKill kills the process.
+	ActionKill Action = "kill"
+	// ActionAllow exempts the syscall from rules added by [FilterOpts] presets.
+	ActionAllow Action = "allow"
+	// ActionNotify passes the syscall to the supervisor holding the [Listener] of the filter.
+	ActionNotify Action = "notify"
+)
+
+// CmpOp is the comparison operator of an [ArgCmp].
+type CmpOp string
+
+const (
+	CmpNE       CmpOp = "ne"
+	CmpLT       CmpOp = "lt"
+	CmpLE       CmpOp = "le"
+	CmpEQ       CmpOp = "eq"
+	CmpGE       CmpOp = "ge"
+	CmpGT       CmpOp = "gt"
+	CmpMaskedEQ CmpOp = "masked_eq"
+)
+
+var cmpOps = map[CmpOp]C.enum_scmp_compare{
+	CmpNE:       C.SCMP_CMP_NE,
+	CmpLT:       C.SCMP_CMP_LT,
+	CmpLE:       C.SCMP_CMP_LE,
+	CmpEQ:       C.SCMP_CMP_EQ,
+	CmpGE:       C.SCMP_CMP_GE,
+	CmpGT:       C.SCMP_CMP_GT,
+	CmpMaskedEQ: C.SCMP_CMP_MASKED_EQ,
+}
+
+// ArgCmp compares a syscall argument against Value.
+type ArgCmp struct {
+	// argument index, 0 to 5
+	Index uint   `json:"index"`
+	Op    CmpOp  `json:"op"`
+	Value uint64 `json:"value"`
+	// argument is masked before comparison, only valid for masked_eq
+	Mask uint64 `json:"mask,omitempty"`
+}
+
+// Rule is a user-defined seccomp rule. A Rule of [ActionAllow] or [ActionNotify] exempts its syscall from all
+// [FilterOpts] presets, rules of other actions are added on top of preset rules of the same syscall.
+type Rule struct {
+	// syscall name as known to libseccomp
+	Syscall string `json:"syscall"`
+	Action  Action `json:"action"`
+	// errno value for errno action, defaults to EPERM
+	Errno int `json:"errno,omitempty"`
+	// rule only matches if all comparisons are true
+	Args []ArgCmp `json:"args,omitempty"`
+}
+
+func (r *Rule) String() string {
+	s := fmt.Sprintf("%s %s", r.Syscall, r.Action)
+	if r.Action == ActionErrno {
+		s += fmt.Sprintf("(%d)", r.errno())
+	}
+	for _, a := range r.Args {
+		if a.Op == CmpMaskedEQ {
+			s += fmt.Sprintf(" arg%d&%#x==%#x", a.Index, a.Mask, a.Value)
+		} else {
+			s += fmt.Sprintf(" arg%d %s %#x", a.Index, a.Op, a.Value)
+		}
+	}
+	return s
+}
+
+func (r *Rule) errno() int {
+	if r.Errno == 0 {
+		return int(syscall.EPERM)
+	}
+	return r.Errno
+}
+
+// resolveSyscall returns the syscall number of name on the native architecture.
+func resolveSyscall(name string) (int, bool) {
+	s := C.CString(name)
+	defer C.free(unsafe.Pointer(s))
+	nr := C.seccomp_syscall_resolve_name(s)
+	return int(nr), nr != C.__NR_SCMP_ERROR
+}
+
+// Known returns whether name is a syscall known to libseccomp on the native architecture.
+func Known(name string) bool { _, ok := resolveSyscall(name); return ok }
+
+// ValidateRules returns an error wrapping [ErrInvalidRule] if rules cannot be merged with the preset filter.
+func ValidateRules(rules []Rule) error {
+	// whether syscall has an unconditional rule, conditional rules are false
+	seen := make(map[string]bool, len(rules))
+	for i := range rules {
+		r := &rules[i]
+		if _, ok := resolveSyscall(r.Syscall); !ok {
+			return fmt.Errorf("%w %d: unknown syscall %q", ErrInvalidRule, i, r.Syscall)
+		}
+
+		switch r.Action {
+		case ActionErrno:
+			if r.Errno < 0 || r.Errno > 0xffff {
+				return fmt.Errorf("%w %d: errno %d out of range", ErrInvalidRule, i, r.Errno)
+			}
+		case ActionKill, ActionNotify:
+		case ActionAllow:
+			if len(r.Args) != 0 {
+				return fmt.Errorf("%w %d: allow action does not support argument comparisons", ErrInvalidRule, i)
+			}
+		default:
+			return fmt.Errorf("%w %d: unsupported action %q", ErrInvalidRule, i, r.Action)
+		}
+		if r.Action != ActionErrno && r.Errno != 0 {
+			return fmt.Errorf("%w %d: errno set for %s action", ErrInvalidRule, i, r.Action)
+		}
+
+		if err := validateArgs(i, r.Args); err != nil {
+			return err
+		}
+
+		// libseccomp silently discards rules overlapping an unconditional rule
+		unconditional := len(r.Args) == 0
+		if prev, ok := seen[r.Syscall]; ok && (prev || unconditional) {
+			return fmt.Errorf("%w %d: conflicting rules for syscall %q", ErrInvalidRule, i, r.Syscall)
+		}
+		seen[r.Syscall] = unconditional
+	}
+	return nil
+}
+
+// validateArgs returns an error wrapping [ErrInvalidRule] if args of rule i are invalid.
+func validateArgs(i int, args []ArgCmp) error {
+	if len(args) > 6 {
+		return fmt.Errorf("%w %d: too many argument comparisons", ErrInvalidRule, i)
+	}
+	var indices [6]bool
+	for _, a := range args {
+		if a.Index > 5 {
+			return fmt.Errorf("%w %d: argument index %d out of range", ErrInvalidRule, i, a.Index)
+		}
+		if indices[a.Index] {
+			return fmt.Errorf("%w %d: argument %d compared more than once", ErrInvalidRule, i, a.Index)
+		}
+		indices[a.Index] = true
+		if _, ok := cmpOps[a.Op]; !ok {
+			return fmt.Errorf("%w %d: unsupported comparison %q", ErrInvalidRule, i, a.Op)
+		}
+		if a.Op != CmpMaskedEQ && a.Mask != 0 {
+			return fmt.Errorf("%w %d: mask set for %s comparison", ErrInvalidRule, i, a.Op)
+		}
+	}
+	return nil
+}
+
+// match returns whether the syscall argument v satisfies a.
+func (a *ArgCmp) match(v uint64) bool {
+	switch a.Op {
+	case CmpNE:
+		return v != a.Value
+	case CmpLT:
+		return v < a.Value
+	case CmpLE:
+		return v <= a.Value
+	case CmpEQ:
+		return v == a.Value
+	case CmpGE:
+		return v >= a.Value
+	case CmpGT:
+		return v > a.Value
+	case CmpMaskedEQ:
+		return v&a.Mask == a.Value
+	default:
+		return false
+	}
+}
+
+// buildRules returns rules as passed to f_build_filter, or nil if rules is empty.
+func buildRules(rules []Rule) ([]C.struct_f_rule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	if err := ValidateRules(rules); err != nil {
+		return nil, err
+	}
+	return convertRules(rules), nil
+}
+
+// convertRules returns validated rules as passed to libseccomp.
+func convertRules(rules []Rule) []C.struct_f_rule {
+	rulesC := make([]C.struct_f_rule, len(rules))
+	for i := range rules {
+		r, rc := &rules[i], &rulesC[i]
+		nr, _ := resolveSyscall(r.Syscall)
+		rc.syscall = C.int(nr)
+		rc.action = actionC(r.Action, r.errno())
+		rc.arg_cnt = C.uint(len(r.Args))
+		for j, a := range r.Args {
+			rc.args[j].arg = C.uint(a.Index)
+			rc.args[j].op = cmpOps[a.Op]
+			if a.Op == CmpMaskedEQ {
+				rc.args[j].datum_a = C.scmp_datum_t(a.Mask)
+				rc.args[j].datum_b = C.scmp_datum_t(a.Value)
+			} else {
+				rc.args[j].datum_a = C.scmp_datum_t(a.Value)
+			}
+		}
+	}
+	return rulesC
+}
+
+// actionC returns the libseccomp action of a valid action, taking errno for [ActionErrno].
+func actionC(action Action, errno int) C.uint32_t {
+	switch action {
+	case ActionErrno:
+		return C.f_act_errno(C.uint16_t(errno))
+	case ActionKill:
+		return C.SCMP_ACT_KILL_PROCESS
+	case ActionAllow:
+		return C.SCMP_ACT_ALLOW
+	case ActionNotify:
+		return C.SCMP_ACT_NOTIFY
+	default:
+		panic("invalid action " + string(action))
+	}
+}
@@ -0,0 +1,149 @@
+package seccomp_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+)
+
+func TestValidateRules(t *testing.T) {
+	testCases := []struct {
+		name    string
+		rules   []seccomp.Rule
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"errno", []seccomp.Rule{{Syscall: "ptrace", Action: seccomp.ActionErrno, Errno: int(syscall.ENOSYS)}}, false},
+		{"kill conditional", []seccomp.Rule{
+			{Syscall: "ioctl", Action: seccomp.ActionKill, Args: []seccomp.ArgCmp{
+				{Index: 1, Op: seccomp.CmpMaskedEQ, Mask: 0xffffffff, Value: 0x5412}}},
+			{Syscall: "ioctl", Action: seccomp.ActionErrno, Args: []seccomp.ArgCmp{
+				{Index: 1, Op: seccomp.CmpEQ, Value: 0x541c}}},
+		}, false},
+		{"allow", []seccomp.Rule{{Syscall: "chown32", Action: seccomp.ActionAllow}}, false},
+		{"notify", []seccomp.Rule{{Syscall: "mknod", Action: seccomp.ActionNotify}}, false},
+
+		{"unknown syscall", []seccomp.Rule{{Syscall: "nonexistent", Action: seccomp.ActionKill}}, true},
+		{"unknown action", []seccomp.Rule{{Syscall: "ptrace", Action: "trace"}}, true},
+		{"errno range", []seccomp.Rule{{Syscall: "ptrace", Action: seccomp.ActionErrno, Errno: 1 << 16}}, true},
+		{"errno kill", []seccomp.Rule{{Syscall: "ptrace", Action: seccomp.ActionKill, Errno: 1}}, true},
+		{"allow conditional", []seccomp.Rule{{Syscall: "ptrace", Action: seccomp.ActionAllow, Args: []seccomp.ArgCmp{
+			{Index: 0, Op: seccomp.CmpEQ}}}}, true},
+		{"index range", []seccomp.Rule{{Syscall: "ptrace", Action: seccomp.ActionKill, Args: []seccomp.ArgCmp{
+			{Index: 6, Op: seccomp.CmpEQ}}}}, true},
+		{"index repeated", []seccomp.Rule{{Syscall: "ptrace", Action: seccomp.ActionKill, Args: []seccomp.ArgCmp{
+			{Index: 0, Op: seccomp.CmpGE}, {Index: 0, Op: seccomp.CmpLE, Value: 1}}}}, true},
+		{"unknown comparison", []seccomp.Rule{{Syscall: "ptrace", Action: seccomp.ActionKill, Args: []seccomp.ArgCmp{
+			{Index: 0, Op: "and"}}}}, true},
+		{"mask", []seccomp.Rule{{Syscall: "ptrace", Action: seccomp.ActionKill, Args: []seccomp.ArgCmp{
+			{Index: 0, Op: seccomp.CmpEQ, Mask: 1}}}}, true},
+		{"conflict", []seccomp.Rule{
+			{Syscall: "ptrace", Action: seccomp.ActionKill, Args: []seccomp.ArgCmp{{Index: 0, Op: seccomp.CmpEQ}}},
+			{Syscall: "ptrace", Action: seccomp.ActionErrno},
+		}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := seccomp.ValidateRules(tc.rules); tc.wantErr != (err != nil) {
+				t.Errorf("ValidateRules: error = %v, wantErr %v", err, tc.wantErr)
+			} else if err != nil && !errors.Is(err, seccomp.ErrInvalidRule) {
+				t.Errorf("ValidateRules: error = %v, want %v", err, seccomp.ErrInvalidRule)
+			}
+		})
+	}
+}
+
+func TestRuleMerge(t *testing.T) {
+	export := func(t *testing.T, opts seccomp.FilterOpts, rules []seccomp.Rule) []byte {
+		e := seccomp.New(opts, rules)
+		p, err := io.ReadAll(e)
+		if err != nil {
+			t.Fatalf("cannot export: %v", err)
+		}
+		if err = e.Close(); err != nil {
+			t.Fatalf("Close: error = %v", err)
+		}
+		return p
+	}
+
+	base := export(t, seccomp.FilterExt, nil)
+	testCases := []struct {
+		name  string
+		opts  seccomp.FilterOpts
+		rules []seccomp.Rule
+		equal bool
+	}{
+		{"allow unfiltered", seccomp.FilterExt,
+			[]seccomp.Rule{{Syscall: "ptrace", Action: seccomp.ActionAllow}}, true},
+		{"allow filtered", seccomp.FilterExt,
+			[]seccomp.Rule{{Syscall: "syslog", Action: seccomp.ActionAllow}}, false},
+		{"errno", seccomp.FilterExt,
+			[]seccomp.Rule{{Syscall: "ptrace", Action: seccomp.ActionErrno}}, false},
+		{"override preset", seccomp.FilterExt | seccomp.FilterDenyDevel,
+			[]seccomp.Rule{{Syscall: "ptrace", Action: seccomp.ActionAllow}, {Syscall: "perf_event_open", Action: seccomp.ActionAllow},
+				{Syscall: "personality", Action: seccomp.ActionAllow}}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := export(t, tc.opts, tc.rules); bytes.Equal(got, base) != tc.equal {
+				t.Errorf("Export: equal %v, want %v", !tc.equal, tc.equal)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		e := seccomp.New(0, []seccomp.Rule{{Syscall: "nonexistent", Action: seccomp.ActionKill}})
+		if _, err := e.Read(make([]byte, 8)); err == nil {
+			// write end is closed on failure
+			t.Errorf("Read: unexpected success")
+		}
+		if err := e.Close(); !errors.Is(err, seccomp.ErrInvalidRule) {
+			t.Errorf("Close: error = %v, want %v", err, seccomp.ErrInvalidRule)
+		}
+	})
+}
+
+func TestRuleLoad(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=TestHelperRuleLoad", "--", "load")
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		t.Errorf("helper: %v", err)
+	}
+}
+
+func TestHelperRuleLoad(t *testing.T) {
+	if len(os.Args) != 4 || os.Args[3] != "load" {
+		return
+	}
+
+	// filter only applies to the calling thread
+	runtime.LockOSThread()
+	if err := seccomp.Load(seccomp.PresetStrict, []seccomp.Rule{
+		{Syscall: "ioctl", Action: seccomp.ActionErrno, Errno: int(syscall.ENOSYS), Args: []seccomp.ArgCmp{
+			{Index: 1, Op: seccomp.CmpEQ, Value: syscall.TIOCINQ}}},
+	}); err != nil {
+		t.Fatalf("Load: error = %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		req  uintptr
+		want syscall.Errno
+	}{
+		{"preset", syscall.TIOCSTI, syscall.EPERM},
+		{"rule", syscall.TIOCINQ, syscall.ENOSYS},
+		{"unfiltered", syscall.TCGETS, syscall.EBADF},
+	}
+	for _, tc := range testCases {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, ^uintptr(0), tc.req, 0); errno != tc.want {
+			t.Errorf("ioctl %s: error = %v, want %v", tc.name, errno, tc.want)
+		}
+	}
+}
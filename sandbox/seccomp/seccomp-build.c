@@ -27,15 +27,41 @@ struct f_syscall_act {
 
 #define LEN(arr) (sizeof(arr) / sizeof((arr)[0]))
 
+// user rules allowing the syscall or passing it to the supervisor exempt the syscall from all preset rules,
+// the supervisor decides calls matching none of its rules according to the preset rules;
+// other user rules are added on top of preset rules
+static int f_rule_exempt(const struct f_rule *rules, size_t rules_len, int syscall) {
+  for (size_t i = 0; i < rules_len; i++)
+    if (rules[i].syscall == syscall && (rules[i].action == SCMP_ACT_ALLOW || rules[i].action == SCMP_ACT_NOTIFY))
+      return 1;
+  return 0;
+}
+
+// whether the filter passes any syscall to a supervisor
+static int f_notify(f_filter_opts opts, const struct f_rule *rules, size_t rules_len) {
+  if (opts & F_AUDIT)
+    return 1;
+  for (size_t i = 0; i < rules_len; i++)
+    if (rules[i].action == SCMP_ACT_NOTIFY)
+      return 1;
+  return 0;
+}
+
+// denied syscalls are passed to the supervisor in audit mode
+
+#define F_ACT_DENY(m_errno) ((opts & F_AUDIT) ? SCMP_ACT_NOTIFY : SCMP_ACT_ERRNO(m_errno))
+
 #define SECCOMP_RULESET_ADD(ruleset) do {                                                                         \
   if (opts & F_VERBOSE) f_println("adding seccomp ruleset \"" #ruleset "\"");                                     \
   for (int i = 0; i < LEN(ruleset); i++) {                                                                        \
     assert(ruleset[i].m_errno == EPERM || ruleset[i].m_errno == ENOSYS);                                          \
+    if (f_rule_exempt(rules, rules_len, ruleset[i].syscall))                                                      \
+      continue;                                                                                                   \
                                                                                                                   \
     if (ruleset[i].arg)                                                                                           \
-      *ret_p = seccomp_rule_add(ctx, SCMP_ACT_ERRNO(ruleset[i].m_errno), ruleset[i].syscall, 1, *ruleset[i].arg); \
+      *ret_p = seccomp_rule_add(ctx, F_ACT_DENY(ruleset[i].m_errno), ruleset[i].syscall, 1, *ruleset[i].arg);     \
     else                                                                                                          \
-      *ret_p = seccomp_rule_add(ctx, SCMP_ACT_ERRNO(ruleset[i].m_errno), ruleset[i].syscall, 0);                  \
+      *ret_p = seccomp_rule_add(ctx, F_ACT_DENY(ruleset[i].m_errno), ruleset[i].syscall, 0);                      \
                                                                                                                   \
     if (*ret_p == -EFAULT) {                                                                                      \
       res = 4;                                                                                                    \
@@ -47,7 +73,8 @@ struct f_syscall_act {
   }                                                                                                               \
 } while (0)
 
-int32_t f_build_filter(int *ret_p, int fd, uint32_t arch, uint32_t multiarch, f_filter_opts opts) {
+int32_t f_build_filter(int *ret_p, int fd, uint32_t arch, uint32_t multiarch, f_filter_opts opts,
+                       const struct f_rule *rules, size_t rules_len, int *notify_fd_p) {
   int32_t res = 0; // refer to resErr for meaning
   int allow_multiarch = opts & F_MULTIARCH;
   int allowed_personality = PER_LINUX;
@@ -262,23 +289,42 @@ int32_t f_build_filter(int *ret_p, int fd, uint32_t arch, uint32_t multiarch, f_
     if (!allow_multiarch) SECCOMP_RULESET_ADD(deny_emu_ext);
   }
 
+  if (rules_len > 0 && (opts & F_VERBOSE)) f_println("adding user seccomp rules");
+  for (size_t i = 0; i < rules_len; i++) {
+    // default action, the syscall is only exempt from preset rules
+    if (rules[i].action == SCMP_ACT_ALLOW)
+      continue;
+
+    *ret_p = seccomp_rule_add_array(ctx, (opts & F_AUDIT) ? SCMP_ACT_NOTIFY : rules[i].action,
+                                    rules[i].syscall, rules[i].arg_cnt, rules[i].args);
+    if (*ret_p == -EFAULT) {
+      res = 4;
+      goto out;
+    } else if (*ret_p < 0) {
+      res = 8;
+      goto out;
+    }
+  }
+
   // Socket filtering doesn't work on e.g. i386, so ignore failures here
   // However, we need to user seccomp_rule_add_exact to avoid libseccomp doing
   // something else: https://github.com/seccomp/libseccomp/issues/8
-  int last_allowed_family = -1;
-  for (int i = 0; i < LEN(socket_family_allowlist); i++) {
-    if (socket_family_allowlist[i].flags_mask != 0 &&
-        (socket_family_allowlist[i].flags_mask & opts) != socket_family_allowlist[i].flags_mask)
-      continue;
-
-    for (int disallowed = last_allowed_family + 1; disallowed < socket_family_allowlist[i].family; disallowed++) {
-      // Blocklist the in-between valid families
-      seccomp_rule_add_exact(ctx, SCMP_ACT_ERRNO(EAFNOSUPPORT), SCMP_SYS(socket), 1, SCMP_A0(SCMP_CMP_EQ, disallowed));
+  if (!f_rule_exempt(rules, rules_len, SCMP_SYS(socket))) {
+    int last_allowed_family = -1;
+    for (int i = 0; i < LEN(socket_family_allowlist); i++) {
+      if (socket_family_allowlist[i].flags_mask != 0 &&
+          (socket_family_allowlist[i].flags_mask & opts) != socket_family_allowlist[i].flags_mask)
+        continue;
+
+      for (int disallowed = last_allowed_family + 1; disallowed < socket_family_allowlist[i].family; disallowed++) {
+        // Blocklist the in-between valid families
+        seccomp_rule_add_exact(ctx, F_ACT_DENY(EAFNOSUPPORT), SCMP_SYS(socket), 1, SCMP_A0(SCMP_CMP_EQ, disallowed));
+      }
+      last_allowed_family = socket_family_allowlist[i].family;
     }
-    last_allowed_family = socket_family_allowlist[i].family;
+    // Blocklist the rest
+    seccomp_rule_add_exact(ctx, F_ACT_DENY(EAFNOSUPPORT), SCMP_SYS(socket), 1, SCMP_A0(SCMP_CMP_GE, last_allowed_family + 1));
   }
-  // Blocklist the rest
-  seccomp_rule_add_exact(ctx, SCMP_ACT_ERRNO(EAFNOSUPPORT), SCMP_SYS(socket), 1, SCMP_A0(SCMP_CMP_GE, last_allowed_family + 1));
 
   if (fd < 0) {
     *ret_p = seccomp_load(ctx);
@@ -286,6 +332,16 @@ int32_t f_build_filter(int *ret_p, int fd, uint32_t arch, uint32_t multiarch, f_
       res = 7;
       goto out;
     }
+
+    if (f_notify(opts, rules, rules_len)) {
+      *ret_p = seccomp_notify_fd(ctx);
+      if (*ret_p < 0) {
+        res = 9;
+        goto out;
+      }
+      *notify_fd_p = *ret_p;
+      *ret_p = 0;
+    }
   } else {
     *ret_p = seccomp_export_bpf(ctx, fd);
     if (*ret_p != 0) {
@@ -300,3 +356,58 @@ out:
 
   return res;
 }
+
+int32_t f_build_profile(int *ret_p, int fd, uint32_t arch, uint32_t multiarch, uint32_t default_action,
+                        const struct f_rule *rules, size_t rules_len) {
+  int32_t res = 0; // refer to resErr for meaning
+
+  scmp_filter_ctx ctx = seccomp_init(default_action);
+  if (ctx == NULL) {
+    res = 1;
+    goto out;
+  } else
+    errno = 0;
+
+  // the preset filter loaded alongside the profile decides which architectures are usable
+  if (arch != 0) {
+    *ret_p = seccomp_arch_add(ctx, arch);
+    if (*ret_p < 0 && *ret_p != -EEXIST) {
+      res = 2;
+      goto out;
+    }
+    if (multiarch != 0) {
+      *ret_p = seccomp_arch_add(ctx, multiarch);
+      if (*ret_p < 0 && *ret_p != -EEXIST) {
+        res = 3;
+        goto out;
+      }
+    }
+  }
+
+  for (size_t i = 0; i < rules_len; i++) {
+    // libseccomp rejects rules taking the default action
+    if (rules[i].action == default_action)
+      continue;
+
+    *ret_p = seccomp_rule_add_array(ctx, rules[i].action, rules[i].syscall, rules[i].arg_cnt, rules[i].args);
+    if (*ret_p == -EFAULT) {
+      res = 4;
+      goto out;
+    } else if (*ret_p < 0) {
+      res = 8;
+      goto out;
+    }
+  }
+
+  *ret_p = seccomp_export_bpf(ctx, fd);
+  if (*ret_p != 0) {
+    res = 6;
+    goto out;
+  }
+
+out:
+  if (ctx)
+    seccomp_release(ctx);
+
+  return res;
+}
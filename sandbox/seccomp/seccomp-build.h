@@ -1,4 +1,5 @@
 #include <stdint.h>
+#include <stddef.h>
 #include <seccomp.h>
 
 #if (SCMP_VER_MAJOR < 2) || \
@@ -17,7 +18,20 @@ typedef enum {
   F_LINUX32    = 1 << 6,
   F_CAN        = 1 << 7,
   F_BLUETOOTH  = 1 << 8,
+  F_AUDIT      = 1 << 9,
 } f_filter_opts;
 
+struct f_rule {
+  int                 syscall;
+  uint32_t            action;
+  unsigned int        arg_cnt;
+  struct scmp_arg_cmp args[6];
+};
+
+static inline uint32_t f_act_errno(uint16_t m_errno) { return SCMP_ACT_ERRNO(m_errno); }
+
 extern void f_println(char *v);
-int32_t f_build_filter(int *ret_p, int fd, uint32_t arch, uint32_t multiarch, f_filter_opts opts);
\ No newline at end of file
+int32_t f_build_filter(int *ret_p, int fd, uint32_t arch, uint32_t multiarch, f_filter_opts opts,
+                       const struct f_rule *rules, size_t rules_len, int *notify_fd_p);
+int32_t f_build_profile(int *ret_p, int fd, uint32_t arch, uint32_t multiarch, uint32_t default_action,
+                        const struct f_rule *rules, size_t rules_len);
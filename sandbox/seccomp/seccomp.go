@@ -55,6 +55,8 @@ var resPrefix = [...]string{
 	5: "seccomp_rule_add failed",
 	6: "seccomp_export_bpf failed",
 	7: "seccomp_load failed",
+	8: "seccomp_rule_add failed (user rule)",
+	9: "seccomp_notify_fd failed",
 }
 
 type FilterOpts = C.f_filter_opts
@@ -77,40 +79,61 @@ const (
 	FilterCan FilterOpts = C.F_CAN
 	// FilterBluetooth allows AF_BLUETOOTH.
 	FilterBluetooth FilterOpts = C.F_BLUETOOTH
+	// FilterAudit passes syscalls denied by the filter to a supervisor via a [Listener] instead.
+	// The filter is no longer enforced unless the supervisor fails them.
+	FilterAudit FilterOpts = C.F_AUDIT
 )
 
-func buildFilter(fd int, opts FilterOpts) error {
-	var (
-		arch      C.uint32_t = 0
-		multiarch C.uint32_t = 0
-	)
-	switch runtime.GOARCH {
-	case "386":
-		arch = C.SCMP_ARCH_X86
-	case "amd64":
-		arch = C.SCMP_ARCH_X86_64
-		multiarch = C.SCMP_ARCH_X86
-	case "arm":
-		arch = C.SCMP_ARCH_ARM
-	case "arm64":
-		arch = C.SCMP_ARCH_AARCH64
-		multiarch = C.SCMP_ARCH_ARM
+// buildFilter exports the filter to fd, or loads it if fd is negative.
+// The returned listener file descriptor is only valid when loading a filter for which [Notifies] returns true.
+func buildFilter(fd int, opts FilterOpts, rules []Rule) (int, error) {
+	if fd >= 0 && Notifies(opts, rules) {
+		// without SECCOMP_FILTER_FLAG_NEW_LISTENER every notification fails with ENOSYS
+		return -1, &LibraryError{Prefix: "cannot export filter passing syscalls to a supervisor", Errno: syscall.EOPNOTSUPP}
 	}
 
+	rulesC, err := buildRules(rules)
+	if err != nil {
+		return -1, err
+	}
+	var rulesP *C.struct_f_rule
+	if len(rulesC) > 0 {
+		rulesP = &rulesC[0]
+	}
+
+	arch, multiarch := nativeArch()
+
 	// this removes repeated transitions between C and Go execution
 	// when producing log output via F_println and CPrintln is nil
 	if fp := printlnP.Load(); fp != nil {
 		opts |= filterVerbose
 	}
 
-	var ret C.int
-	res, err := C.f_build_filter(&ret, C.int(fd), arch, multiarch, opts)
+	var ret, notifyFd C.int = 0, -1
+	res, err := C.f_build_filter(&ret, C.int(fd), arch, multiarch, opts, rulesP, C.size_t(len(rulesC)), &notifyFd)
 	if prefix := resPrefix[res]; prefix != "" {
-		return &LibraryError{
+		return -1, &LibraryError{
 			prefix,
 			-syscall.Errno(ret),
 			err,
 		}
 	}
-	return err
+	return int(notifyFd), err
+}
+
+// nativeArch returns the libseccomp architecture of the running program and its compatibility architecture.
+func nativeArch() (arch, multiarch C.uint32_t) {
+	switch runtime.GOARCH {
+	case "386":
+		arch = C.SCMP_ARCH_X86
+	case "amd64":
+		arch = C.SCMP_ARCH_X86_64
+		multiarch = C.SCMP_ARCH_X86
+	case "arm":
+		arch = C.SCMP_ARCH_ARM
+	case "arm64":
+		arch = C.SCMP_ARCH_AARCH64
+		multiarch = C.SCMP_ARCH_ARM
+	}
+	return
 }
@@ -0,0 +1,252 @@
+package seccomp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"syscall"
+)
+
+// Response is the response of a [Supervisor] to a syscall matching a [NotifyRule].
+type Response string
+
+const (
+	// ResponseContinue executes the syscall as if it was allowed by the filter.
+	// The syscall is performed by the calling process and permission checks of the kernel still apply,
+	// so privileged operations such as mknod or mount of a filesystem type requiring privileges cannot be
+	// emulated by the supervisor.
+	ResponseContinue Response = "continue"
+	// ResponseErrno fails the syscall with [NotifyRule.Errno].
+	ResponseErrno Response = "errno"
+	// ResponseReturn skips the syscall and returns [NotifyRule.Value] to the caller.
+	ResponseReturn Response = "return"
+)
+
+// maxStringArg is the maximum length of a string argument read by [Supervisor].
+const maxStringArg = 4096
+
+// StringArg matches a NUL-terminated string pointed to by a syscall argument.
+//
+// The string is read from the memory of the calling process before the syscall is continued,
+// so another thread of the process might change it in between. StringArg must not be relied upon
+// as a security boundary.
+type StringArg struct {
+	// argument index, 0 to 5
+	Index uint `json:"index"`
+	// matches if the string is equal to any of Values
+	Values []string `json:"values"`
+}
+
+// NotifyRule describes the response of a [Supervisor] to a syscall passed to it by the filter.
+type NotifyRule struct {
+	// syscall name as known to libseccomp
+	Syscall  string   `json:"syscall"`
+	Response Response `json:"response"`
+	// errno value for errno response, defaults to EPERM
+	Errno int `json:"errno,omitempty"`
+	// return value for return response
+	Value int64 `json:"value,omitempty"`
+	// rule only matches if all comparisons are true
+	Args []ArgCmp `json:"args,omitempty"`
+	// rule only matches if the string argument is one of the specified values
+	Strings *StringArg `json:"strings,omitempty"`
+}
+
+func (r *NotifyRule) String() string {
+	s := fmt.Sprintf("%s %s", r.Syscall, r.Response)
+	switch r.Response {
+	case ResponseErrno:
+		s += fmt.Sprintf("(%d)", r.errno())
+	case ResponseReturn:
+		s += fmt.Sprintf("(%d)", r.Value)
+	}
+	for _, a := range r.Args {
+		if a.Op == CmpMaskedEQ {
+			s += fmt.Sprintf(" arg%d&%#x==%#x", a.Index, a.Mask, a.Value)
+		} else {
+			s += fmt.Sprintf(" arg%d %s %#x", a.Index, a.Op, a.Value)
+		}
+	}
+	if r.Strings != nil {
+		s += fmt.Sprintf(" arg%d in %q", r.Strings.Index, r.Strings.Values)
+	}
+	return s
+}
+
+func (r *NotifyRule) errno() int {
+	if r.Errno == 0 {
+		return int(syscall.EPERM)
+	}
+	return r.Errno
+}
+
+// ValidateNotifyRules returns an error wrapping [ErrInvalidRule] if rules cannot be used by a [Supervisor].
+func ValidateNotifyRules(rules []NotifyRule) error {
+	for i := range rules {
+		r := &rules[i]
+		if _, ok := resolveSyscall(r.Syscall); !ok {
+			return fmt.Errorf("%w %d: unknown syscall %q", ErrInvalidRule, i, r.Syscall)
+		}
+
+		switch r.Response {
+		case ResponseErrno:
+			if r.Errno < 0 || r.Errno > 0xfff {
+				return fmt.Errorf("%w %d: errno %d out of range", ErrInvalidRule, i, r.Errno)
+			}
+		case ResponseContinue, ResponseReturn:
+		default:
+			return fmt.Errorf("%w %d: unsupported response %q", ErrInvalidRule, i, r.Response)
+		}
+		if r.Response != ResponseErrno && r.Errno != 0 {
+			return fmt.Errorf("%w %d: errno set for %s response", ErrInvalidRule, i, r.Response)
+		}
+		if r.Response != ResponseReturn && r.Value != 0 {
+			return fmt.Errorf("%w %d: value set for %s response", ErrInvalidRule, i, r.Response)
+		}
+
+		if err := validateArgs(i, r.Args); err != nil {
+			return err
+		}
+		if r.Strings != nil {
+			if r.Strings.Index > 5 {
+				return fmt.Errorf("%w %d: argument index %d out of range", ErrInvalidRule, i, r.Strings.Index)
+			}
+			if len(r.Strings.Values) == 0 {
+				return fmt.Errorf("%w %d: no string values", ErrInvalidRule, i)
+			}
+			for _, v := range r.Strings.Values {
+				if len(v) >= maxStringArg || bytes.IndexByte([]byte(v), 0) != -1 {
+					return fmt.Errorf("%w %d: invalid string value %q", ErrInvalidRule, i, v)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// NotifyFilterRules returns rules passing every syscall referred to by rules to the supervisor.
+// Syscalls passed to the supervisor are exempt from [FilterOpts] presets: a [Supervisor] responds to calls
+// not matching any rule according to the filter without the rules returned here.
+func NotifyFilterRules(rules []NotifyRule) []Rule {
+	filterRules := make([]Rule, 0, len(rules))
+	for i := range rules {
+		if !slices.ContainsFunc(filterRules, func(r Rule) bool { return r.Syscall == rules[i].Syscall }) {
+			filterRules = append(filterRules, Rule{Syscall: rules[i].Syscall, Action: ActionNotify})
+		}
+	}
+	return filterRules
+}
+
+// Supervisor responds to syscalls passed to the [Listener] of a filter according to a set of [NotifyRule].
+// Methods of Supervisor are safe for concurrent use.
+type Supervisor struct {
+	l *Listener
+	// rules by syscall name, in order of precedence
+	rules map[string][]*NotifyRule
+	// filter deciding syscalls not matching any rule
+	fallback []byte
+}
+
+// NewSupervisor returns a [Supervisor] of l responding according to rules. The filter of l is of opts merged
+// with filterRules, which pass syscalls referred to by rules to the supervisor.
+// Rules are evaluated in order and the first matching rule determines the response.
+func NewSupervisor(l *Listener, opts FilterOpts, filterRules []Rule, rules []NotifyRule) (*Supervisor, error) {
+	if err := ValidateNotifyRules(rules); err != nil {
+		return nil, err
+	}
+
+	// the filter of l with no syscall passed to the supervisor
+	fallbackRules := make([]Rule, 0, len(filterRules))
+	for _, r := range filterRules {
+		if r.Action != ActionNotify {
+			fallbackRules = append(fallbackRules, r)
+		}
+	}
+	e := New(opts&^FilterAudit, fallbackRules)
+	fallback, err := io.ReadAll(e)
+	if closeErr := e.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Supervisor{l, make(map[string][]*NotifyRule, len(rules)), fallback}
+	for i := range rules {
+		s.rules[rules[i].Syscall] = append(s.rules[rules[i].Syscall], &rules[i])
+	}
+	return s, nil
+}
+
+// Fallback returns the response to n of the filter without syscalls passed to the supervisor,
+// for a syscall not matching any rule. Syscalls killing the process are failed with EPERM instead.
+func (s *Supervisor) Fallback(n *Notif) *NotifResp {
+	resp := &NotifResp{ID: n.ID, Error: -int32(syscall.EPERM)}
+	if ret, err := runFilter(s.fallback, &n.Data); err == nil {
+		switch ret & SECCOMP_RET_ACTION_FULL {
+		case SECCOMP_RET_ALLOW, SECCOMP_RET_LOG:
+			resp.Error, resp.Flags = 0, NotifFlagContinue
+		case SECCOMP_RET_ERRNO:
+			resp.Error = -int32(ret & SECCOMP_RET_DATA)
+		}
+	}
+	return resp
+}
+
+// Match returns the response to n for syscall name, or nil if no rule matches.
+// Match returns [syscall.ENOENT] if the calling process is no longer waiting on the response.
+func (s *Supervisor) Match(n *Notif, name string) (*NotifResp, error) {
+	for _, r := range s.rules[name] {
+		if !slices.ContainsFunc(r.Args, func(a ArgCmp) bool { return !a.match(n.Data.Args[a.Index]) }) {
+			if r.Strings != nil {
+				if v, err := s.readString(n, n.Data.Args[r.Strings.Index]); err != nil {
+					return nil, err
+				} else if !slices.Contains(r.Strings.Values, v) {
+					continue
+				}
+			}
+
+			resp := &NotifResp{ID: n.ID}
+			switch r.Response {
+			case ResponseContinue:
+				resp.Flags = NotifFlagContinue
+			case ResponseErrno:
+				resp.Error = -int32(r.errno())
+			case ResponseReturn:
+				resp.Val = r.Value
+			}
+			return resp, nil
+		}
+	}
+	return nil, nil
+}
+
+// readString reads a NUL-terminated string at addr from the memory of the process of n.
+func (s *Supervisor) readString(n *Notif, addr uint64) (string, error) {
+	f, err := os.Open("/proc/" + strconv.Itoa(int(n.Pid)) + "/mem")
+	if err != nil {
+		if !s.l.Valid(n.ID) {
+			return "", syscall.ENOENT
+		}
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	// the process might have exited and its pid reused since the notification is received
+	if !s.l.Valid(n.ID) {
+		return "", syscall.ENOENT
+	}
+
+	buf := make([]byte, maxStringArg)
+	v, err := f.ReadAt(buf, int64(addr))
+	if i := bytes.IndexByte(buf[:v], 0); i != -1 {
+		// a string might end before an unmapped page
+		return string(buf[:i]), nil
+	} else if err != nil {
+		return "", err
+	}
+	return string(buf[:v]), nil
+}
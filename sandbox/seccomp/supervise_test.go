@@ -0,0 +1,120 @@
+package seccomp_test
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"syscall"
+	"testing"
+
+	"git.gensokyo.uk/security/fortify/sandbox/seccomp"
+)
+
+func TestValidateNotifyRules(t *testing.T) {
+	testCases := []struct {
+		name    string
+		rules   []seccomp.NotifyRule
+		wantErr bool
+	}{
+		{"nil", nil, false},
+		{"continue", []seccomp.NotifyRule{{Syscall: "personality", Response: seccomp.ResponseContinue,
+			Args: []seccomp.ArgCmp{{Index: 0, Op: seccomp.CmpEQ, Value: 0x0008}}}}, false},
+		{"errno", []seccomp.NotifyRule{{Syscall: "mount", Response: seccomp.ResponseErrno, Errno: int(syscall.ENODEV)}}, false},
+		{"return", []seccomp.NotifyRule{{Syscall: "mknod", Response: seccomp.ResponseReturn}}, false},
+		{"strings", []seccomp.NotifyRule{{Syscall: "mount", Response: seccomp.ResponseContinue,
+			Strings: &seccomp.StringArg{Index: 2, Values: []string{"tmpfs", "fuse"}}}}, false},
+
+		{"unknown syscall", []seccomp.NotifyRule{{Syscall: "nonexistent", Response: seccomp.ResponseContinue}}, true},
+		{"unknown response", []seccomp.NotifyRule{{Syscall: "mknod", Response: "kill"}}, true},
+		{"errno range", []seccomp.NotifyRule{{Syscall: "mknod", Response: seccomp.ResponseErrno, Errno: 1 << 12}}, true},
+		{"errno return", []seccomp.NotifyRule{{Syscall: "mknod", Response: seccomp.ResponseReturn, Errno: 1}}, true},
+		{"value errno", []seccomp.NotifyRule{{Syscall: "mknod", Response: seccomp.ResponseErrno, Value: 1}}, true},
+		{"index range", []seccomp.NotifyRule{{Syscall: "mknod", Response: seccomp.ResponseContinue,
+			Args: []seccomp.ArgCmp{{Index: 6, Op: seccomp.CmpEQ}}}}, true},
+		{"strings index range", []seccomp.NotifyRule{{Syscall: "mount", Response: seccomp.ResponseContinue,
+			Strings: &seccomp.StringArg{Index: 6, Values: []string{"tmpfs"}}}}, true},
+		{"strings empty", []seccomp.NotifyRule{{Syscall: "mount", Response: seccomp.ResponseContinue,
+			Strings: &seccomp.StringArg{Index: 2}}}, true},
+		{"strings nul", []seccomp.NotifyRule{{Syscall: "mount", Response: seccomp.ResponseContinue,
+			Strings: &seccomp.StringArg{Index: 2, Values: []string{"tmp\x00fs"}}}}, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := seccomp.ValidateNotifyRules(tc.rules); tc.wantErr != (err != nil) {
+				t.Errorf("ValidateNotifyRules: error = %v, wantErr %v", err, tc.wantErr)
+			} else if err != nil && !errors.Is(err, seccomp.ErrInvalidRule) {
+				t.Errorf("ValidateNotifyRules: error = %v, want %v", err, seccomp.ErrInvalidRule)
+			}
+		})
+	}
+}
+
+func TestNotifyFilterRules(t *testing.T) {
+	rules := []seccomp.NotifyRule{
+		{Syscall: "mount", Response: seccomp.ResponseContinue,
+			Strings: &seccomp.StringArg{Index: 2, Values: []string{"tmpfs"}}},
+		{Syscall: "personality", Response: seccomp.ResponseReturn},
+		{Syscall: "mount", Response: seccomp.ResponseErrno, Errno: int(syscall.ENODEV)},
+	}
+	want := []seccomp.Rule{
+		{Syscall: "mount", Action: seccomp.ActionNotify},
+		{Syscall: "personality", Action: seccomp.ActionNotify},
+	}
+	if got := seccomp.NotifyFilterRules(rules); !reflect.DeepEqual(got, want) {
+		t.Errorf("NotifyFilterRules: %v, want %v", got, want)
+	}
+
+	t.Run("export", func(t *testing.T) {
+		e := seccomp.New(seccomp.FilterExt, want)
+		if _, err := e.Read(make([]byte, 8)); err == nil {
+			t.Errorf("Read: unexpected success")
+		}
+		if err := e.Close(); !errors.Is(err, syscall.EOPNOTSUPP) {
+			t.Errorf("Close: error = %v, want %v", err, syscall.EOPNOTSUPP)
+		}
+	})
+
+	t.Run("load", func(t *testing.T) {
+		if err := seccomp.Load(seccomp.FilterExt, want); !errors.Is(err, syscall.EINVAL) {
+			t.Errorf("Load: error = %v, want %v", err, syscall.EINVAL)
+		}
+	})
+}
+
+func TestSupervisorFallback(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("test data is specific to amd64")
+	}
+	const archX86_64 = 0xc000003e
+
+	rules := []seccomp.NotifyRule{{Syscall: "ioctl", Response: seccomp.ResponseReturn,
+		Args: []seccomp.ArgCmp{{Index: 1, Op: seccomp.CmpEQ, Value: syscall.TIOCINQ}}}}
+	filterRules := append([]seccomp.Rule{{Syscall: "chdir", Action: seccomp.ActionErrno, Errno: int(syscall.ENOSYS)}},
+		seccomp.NotifyFilterRules(rules)...)
+	s, err := seccomp.NewSupervisor(nil, seccomp.PresetStrict|seccomp.FilterAudit, filterRules, rules)
+	if err != nil {
+		t.Fatalf("NewSupervisor: error = %v", err)
+	}
+
+	testCases := []struct {
+		name string
+		data seccomp.NotifData
+		want *seccomp.NotifResp
+	}{
+		{"allow", seccomp.NotifData{Nr: syscall.SYS_IOCTL, Arch: archX86_64, Args: [6]uint64{0, syscall.TCGETS}},
+			&seccomp.NotifResp{ID: 0xfd, Flags: seccomp.NotifFlagContinue}},
+		{"preset", seccomp.NotifData{Nr: syscall.SYS_IOCTL, Arch: archX86_64, Args: [6]uint64{0, syscall.TIOCSTI}},
+			&seccomp.NotifResp{ID: 0xfd, Error: -int32(syscall.EPERM)}},
+		{"rule", seccomp.NotifData{Nr: syscall.SYS_CHDIR, Arch: archX86_64},
+			&seccomp.NotifResp{ID: 0xfd, Error: -int32(syscall.ENOSYS)}},
+		{"unknown arch", seccomp.NotifData{Nr: syscall.SYS_IOCTL, Arch: 0xbad},
+			&seccomp.NotifResp{ID: 0xfd, Error: -int32(syscall.EPERM)}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := s.Fallback(&seccomp.Notif{ID: 0xfd, Data: tc.data}); !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Fallback: %#v, want %#v", got, tc.want)
+			}
+		})
+	}
+}
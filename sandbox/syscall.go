@@ -1,6 +1,7 @@
 package sandbox
 
 import (
+	"runtime"
 	"syscall"
 	"unsafe"
 )
@@ -10,8 +11,10 @@ const (
 
 	PR_SET_NO_NEW_PRIVS = 0x26
 
-	CAP_SYS_ADMIN = 0x15
-	CAP_SETPCAP   = 0x8
+	CAP_SYS_ADMIN    = 0x15
+	CAP_SETPCAP      = 0x8
+	CAP_DAC_OVERRIDE = 0x1
+	CAP_NET_ADMIN    = 0xc
 )
 
 const (
@@ -64,6 +67,46 @@ func capset(hdrp *capHeader, datap *[2]capData) error {
 	return nil
 }
 
+const (
+	PR_SET_SECCOMP      = 0x16
+	SECCOMP_MODE_FILTER = 0x2
+
+	// size of struct sock_filter
+	sockFilterSize = 8
+	// BPF_MAXINSNS in linux/bpf_common.h
+	bpfMaxInsns = 4096
+)
+
+// sockFprog is struct sock_fprog in linux/filter.h.
+type sockFprog struct {
+	len    uint16
+	_      [6]byte
+	filter uintptr
+}
+
+// checkSeccompProgram returns [syscall.EINVAL] if prog is not a whole number of instructions within bounds.
+func checkSeccompProgram(prog []byte) error {
+	if len(prog) == 0 || len(prog)%sockFilterSize != 0 || len(prog)/sockFilterSize > bpfMaxInsns {
+		return syscall.EINVAL
+	}
+	return nil
+}
+
+// loadSeccompProgram loads a classic BPF program as a syscall filter of the calling thread.
+func loadSeccompProgram(prog []byte) error {
+	if err := checkSeccompProgram(prog); err != nil {
+		return err
+	}
+	fprog := sockFprog{len: uint16(len(prog) / sockFilterSize), filter: uintptr(unsafe.Pointer(&prog[0]))}
+	_, _, errno := syscall.Syscall(syscall.SYS_PRCTL, PR_SET_SECCOMP, SECCOMP_MODE_FILTER,
+		uintptr(unsafe.Pointer(&fprog)))
+	runtime.KeepAlive(prog)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
 // IgnoringEINTR makes a function call and repeats it if it returns an
 // EINTR error. This appears to be required even though we install all
 // signal handlers with SA_RESTART: see #22838, #38033, #38836, #40846.
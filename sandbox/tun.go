@@ -0,0 +1,201 @@
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	// tunDevice is the path to the TUN/TAP clone device in host root.
+	tunDevice = "/dev/net/tun"
+
+	_TUNSETIFF = 0x400454ca
+	_IFF_TUN   = 0x1
+	_IFF_NO_PI = 0x1000
+)
+
+// TunConfig describes a TUN device created in the private network namespace of the container.
+// The device file descriptor is made available via [Container.ReceiveTun].
+type TunConfig struct {
+	// Interface name.
+	Name string
+	// Interface address and prefix length.
+	Addr netip.Prefix
+	// Address of the default gateway.
+	Gateway netip.Addr
+	// Interface MTU.
+	MTU int
+}
+
+func (t *TunConfig) String() string {
+	return fmt.Sprintf("tun %q addr %s gateway %s mtu %d", t.Name, t.Addr, t.Gateway, t.MTU)
+}
+
+func (t *TunConfig) valid() bool {
+	return t != nil && t.Name != "" && len(t.Name) < syscall.IFNAMSIZ &&
+		t.Addr.IsValid() && t.Addr.Addr().Is4() &&
+		t.Gateway.IsValid() && t.Gateway.Is4() && t.Addr.Contains(t.Gateway) &&
+		t.MTU >= 576 && t.MTU <= 65535
+}
+
+// ifreq is the generic layout of struct ifreq in linux/if.h.
+type ifreq struct {
+	name [syscall.IFNAMSIZ]byte
+	data [24]byte
+}
+
+func newIfreq(name string) *ifreq {
+	r := new(ifreq)
+	copy(r.name[:syscall.IFNAMSIZ-1], name)
+	return r
+}
+
+func (r *ifreq) setShort(v uint16) { *(*uint16)(unsafe.Pointer(&r.data[0])) = v }
+func (r *ifreq) short() uint16     { return *(*uint16)(unsafe.Pointer(&r.data[0])) }
+func (r *ifreq) setInt(v int32)    { *(*int32)(unsafe.Pointer(&r.data[0])) = v }
+func (r *ifreq) setAddr(a netip.Addr) {
+	*(*syscall.RawSockaddrInet4)(unsafe.Pointer(&r.data[0])) = rawInet4(a)
+}
+
+// rtentry is the layout of struct rtentry in linux/route.h.
+type rtentry struct {
+	pad1    uintptr
+	dst     syscall.RawSockaddrInet4
+	gateway syscall.RawSockaddrInet4
+	genmask syscall.RawSockaddrInet4
+	flags   uint16
+	pad2    int16
+	pad3    uintptr
+	pad4    uintptr
+	metric  int16
+	dev     *byte
+	mtu     uintptr
+	window  uintptr
+	irtt    uint16
+}
+
+func rawInet4(a netip.Addr) syscall.RawSockaddrInet4 {
+	return syscall.RawSockaddrInet4{Family: syscall.AF_INET, Addr: a.As4()}
+}
+
+func ioctl(fd int, req uintptr, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), req, uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// setupTun creates and configures the TUN device described by t in the current network namespace
+// and sends its file descriptor over unix socket fd. This must be called in host root.
+func (t *TunConfig) setupTun(fd int) error {
+	if !t.valid() {
+		return msg.WrapErr(syscall.EINVAL,
+			fmt.Sprintf("invalid tun configuration: %s", t))
+	}
+
+	var tun int
+	if err := IgnoringEINTR(func() (err error) {
+		tun, err = syscall.Open(tunDevice, syscall.O_RDWR|syscall.O_CLOEXEC, 0)
+		return
+	}); err != nil {
+		return wrapErrSuffix(err,
+			fmt.Sprintf("cannot open %q:", tunDevice))
+	}
+	defer func() { _ = syscall.Close(tun) }()
+
+	r := newIfreq(t.Name)
+	r.setShort(_IFF_TUN | _IFF_NO_PI)
+	if err := ioctl(tun, _TUNSETIFF, unsafe.Pointer(r)); err != nil {
+		return wrapErrSuffix(err,
+			fmt.Sprintf("cannot create tun device %q:", t.Name))
+	}
+
+	s, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return wrapErrSuffix(err,
+			"cannot create configuration socket:")
+	}
+	defer func() { _ = syscall.Close(s) }()
+
+	if err = ifup(s, "lo"); err != nil {
+		return err
+	}
+
+	r = newIfreq(t.Name)
+	r.setInt(int32(t.MTU))
+	if err = ioctl(s, syscall.SIOCSIFMTU, unsafe.Pointer(r)); err != nil {
+		return wrapErrSuffix(err,
+			fmt.Sprintf("cannot set mtu of %q:", t.Name))
+	}
+	r = newIfreq(t.Name)
+	r.setAddr(t.Addr.Addr())
+	if err = ioctl(s, syscall.SIOCSIFADDR, unsafe.Pointer(r)); err != nil {
+		return wrapErrSuffix(err,
+			fmt.Sprintf("cannot set address of %q:", t.Name))
+	}
+	r = newIfreq(t.Name)
+	var mask [4]byte
+	for i := 0; i < t.Addr.Bits(); i++ {
+		mask[i/8] |= 0x80 >> (i % 8)
+	}
+	r.setAddr(netip.AddrFrom4(mask))
+	if err = ioctl(s, syscall.SIOCSIFNETMASK, unsafe.Pointer(r)); err != nil {
+		return wrapErrSuffix(err,
+			fmt.Sprintf("cannot set netmask of %q:", t.Name))
+	}
+	if err = ifup(s, t.Name); err != nil {
+		return err
+	}
+
+	dev := append([]byte(t.Name), 0)
+	rt := &rtentry{
+		dst:     rawInet4(netip.IPv4Unspecified()),
+		gateway: rawInet4(t.Gateway),
+		genmask: rawInet4(netip.IPv4Unspecified()),
+		flags:   syscall.RTF_UP | syscall.RTF_GATEWAY,
+		dev:     &dev[0],
+	}
+	if err = ioctl(s, syscall.SIOCADDRT, unsafe.Pointer(rt)); err != nil {
+		return wrapErrSuffix(err,
+			fmt.Sprintf("cannot add default route via %s:", t.Gateway))
+	}
+
+	return sendRights(fd, tun, fmt.Sprintf("tun device %q", t.Name))
+}
+
+// ifup brings up interface name via socket s.
+func ifup(s int, name string) error {
+	r := newIfreq(name)
+	if err := ioctl(s, syscall.SIOCGIFFLAGS, unsafe.Pointer(r)); err != nil {
+		return wrapErrSuffix(err,
+			fmt.Sprintf("cannot get flags of %q:", name))
+	}
+	r.setShort(r.short() | syscall.IFF_UP | syscall.IFF_RUNNING)
+	return wrapErrSuffix(ioctl(s, syscall.SIOCSIFFLAGS, unsafe.Pointer(r)),
+		fmt.Sprintf("cannot bring up %q:", name))
+}
+
+// ReceiveTun blocks until the TUN device described by [Params.Tun] is received from the container.
+// This must be called after a successful call to [Container.Serve].
+func (p *Container) ReceiveTun() (*os.File, error) {
+	if p.tun == nil {
+		return nil, errors.New("sandbox: tun device not configured")
+	}
+	f := p.tun
+	p.tun = nil
+
+	if fd, err := receiveRights(f, "tun device"); err != nil {
+		return nil, err
+	} else {
+		// the returned file is registered with the runtime poller
+		if err = syscall.SetNonblock(fd, true); err != nil {
+			_ = syscall.Close(fd)
+			return nil, wrapErrSelf(err)
+		}
+		return os.NewFile(uintptr(fd), tunDevice), nil
+	}
+}
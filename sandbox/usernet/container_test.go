@@ -0,0 +1,140 @@
+package usernet_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"testing"
+	"time"
+
+	"git.gensokyo.uk/security/fortify/internal"
+	"git.gensokyo.uk/security/fortify/internal/fmsg"
+	"git.gensokyo.uk/security/fortify/ldd"
+	"git.gensokyo.uk/security/fortify/sandbox"
+	"git.gensokyo.uk/security/fortify/sandbox/usernet"
+)
+
+func TestContainer(t *testing.T) {
+	if _, err := os.Stat("/dev/net/tun"); err != nil {
+		t.Skipf("tun device unavailable: %v", err)
+	}
+
+	{
+		oldVerbose := fmsg.Load()
+		oldOutput := sandbox.GetOutput()
+		internal.InstallFmsg(true)
+		t.Cleanup(func() { fmsg.Store(oldVerbose) })
+		t.Cleanup(func() { sandbox.SetOutput(oldOutput); usernet.SetOutput(nil) })
+	}
+
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(conn, conn); _ = conn.Close() }()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	c := usernet.NewConfig()
+	c.HostLoopback = true
+
+	container := sandbox.New(ctx, "/usr/bin/usernet.test", "-test.v",
+		"-test.run=TestHelperDial", "--", "dial", strconv.Itoa(l.Addr().(*net.TCPAddr).Port))
+	container.CommandContext = commandContext
+	container.Stdout, container.Stderr = os.Stdout, os.Stderr
+	container.Tun = c.Tun()
+	container.
+		Tmpfs("/tmp", 0, 0755).
+		Bind(os.Args[0], os.Args[0], 0).
+		Mkdir("/usr/bin", 0755).
+		Link(os.Args[0], "/usr/bin/usernet.test")
+	// in case test has cgo enabled
+	if entries, err := ldd.ExecFilter(ctx,
+		commandContext,
+		func(v []byte) []byte {
+			return bytes.SplitN(v, []byte("TestHelperInit\n"), 2)[1]
+		}, os.Args[0]); err != nil {
+		log.Fatalf("ldd: %v", err)
+	} else {
+		for _, name := range ldd.Path(entries) {
+			container.Bind(name, name, 0)
+		}
+	}
+
+	if err = container.Start(); err != nil {
+		fmsg.PrintBaseError(err, "start:")
+		t.Fatalf("cannot start container: %v", err)
+	} else if err = container.Serve(); err != nil {
+		fmsg.PrintBaseError(err, "serve:")
+		t.Fatalf("cannot serve setup params: %v", err)
+	}
+
+	tun, err := container.ReceiveTun()
+	if err != nil {
+		fmsg.PrintBaseError(err, "receive:")
+		t.Fatalf("cannot receive tun device: %v", err)
+	}
+	s, err := usernet.New(c, tun)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx) }()
+
+	if err = container.Wait(); err != nil {
+		t.Errorf("wait: %v", err)
+	}
+	_ = tun.Close()
+	if err = <-done; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+}
+
+func TestHelperInit(t *testing.T) {
+	if len(os.Args) != 5 || os.Args[4] != "init" {
+		return
+	}
+	sandbox.SetOutput(fmsg.Output{})
+	sandbox.Init(fmsg.Prepare, internal.InstallFmsg)
+}
+
+func TestHelperDial(t *testing.T) {
+	if len(os.Args) != 6 || os.Args[4] != "dial" {
+		return
+	}
+
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(usernet.DefaultGateway.String(), os.Args[5]), 5*time.Second)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	want := bytes.Repeat([]byte("echo "), 1<<12)
+	go func() { _, _ = conn.Write(want); _ = conn.(*net.TCPConn).CloseWrite() }()
+	if got, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	} else if !bytes.Equal(got, want) {
+		t.Errorf("echo: got %d bytes, want %d", len(got), len(want))
+	}
+	if err = conn.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+}
+
+func commandContext(ctx context.Context) *exec.Cmd {
+	return exec.CommandContext(ctx, os.Args[0], "-test.v",
+		"-test.run=TestHelperInit", "--", "init")
+}
@@ -0,0 +1,142 @@
+package usernet
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	dnsHeaderLen = 12
+
+	dnsTypeA     = 1
+	dnsTypeCNAME = 5
+	dnsClassIN   = 1
+)
+
+// dnsAnswer is an address record resolved for the question of a DNS response.
+type dnsAnswer struct {
+	// lower case question name without the trailing dot
+	name string
+	addr netip.Addr
+	ttl  time.Duration
+}
+
+// parseDNSResponse returns address records of a DNS response with a single question,
+// following CNAME records in its answer section.
+func parseDNSResponse(p []byte) ([]dnsAnswer, bool) {
+	if len(p) < dnsHeaderLen ||
+		p[2]&0x80 == 0 || // not a response
+		p[3]&0xf != 0 || // rcode
+		binary.BigEndian.Uint16(p[4:]) != 1 {
+		return nil, false
+	}
+	count := int(binary.BigEndian.Uint16(p[6:]))
+
+	question, off, ok := dnsName(p, dnsHeaderLen)
+	if !ok || off+4 > len(p) {
+		return nil, false
+	}
+	off += 4 // type, class
+
+	type record struct {
+		name, target string
+		addr         netip.Addr
+		ttl          time.Duration
+	}
+	records := make([]record, 0, count)
+	for i := 0; i < count; i++ {
+		var r record
+		if r.name, off, ok = dnsName(p, off); !ok || off+10 > len(p) {
+			return nil, false
+		}
+		typ := binary.BigEndian.Uint16(p[off:])
+		class := binary.BigEndian.Uint16(p[off+2:])
+		r.ttl = time.Duration(binary.BigEndian.Uint32(p[off+4:])) * time.Second
+		length := int(binary.BigEndian.Uint16(p[off+8:]))
+		off += 10
+		if off+length > len(p) {
+			return nil, false
+		}
+
+		if class == dnsClassIN {
+			switch typ {
+			case dnsTypeA:
+				if length != 4 {
+					return nil, false
+				}
+				r.addr = netip.AddrFrom4([4]byte(p[off : off+4]))
+				records = append(records, r)
+			case dnsTypeCNAME:
+				if r.target, _, ok = dnsName(p, off); !ok {
+					return nil, false
+				}
+				records = append(records, r)
+			}
+		}
+		off += length
+	}
+
+	// names resolving to the question name through CNAME records
+	chain := []string{question}
+	for changed := true; changed; {
+		changed = false
+		for _, r := range records {
+			if r.target != "" && slices.Contains(chain, r.name) && !slices.Contains(chain, r.target) {
+				chain = append(chain, r.target)
+				changed = true
+			}
+		}
+	}
+
+	var answers []dnsAnswer
+	for _, r := range records {
+		if r.addr.IsValid() && slices.Contains(chain, r.name) {
+			answers = append(answers, dnsAnswer{question, r.addr, r.ttl})
+		}
+	}
+	return answers, true
+}
+
+// dnsName returns the lower case domain name at off and the offset following it.
+func dnsName(p []byte, off int) (string, int, bool) {
+	var (
+		labels []string
+		// offset following the name, set at the first compression pointer
+		next = -1
+		// bounds pointer loops
+		jumps int
+	)
+	for {
+		if off >= len(p) {
+			return "", 0, false
+		}
+		n := int(p[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.ToLower(strings.Join(labels, ".")), next, true
+		case n&0xc0 == 0xc0:
+			if off+2 > len(p) || jumps > 16 {
+				return "", 0, false
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(p[off:]) & 0x3fff)
+			jumps++
+		case n&0xc0 != 0:
+			return "", 0, false
+		default:
+			if off+1+n > len(p) {
+				return "", 0, false
+			}
+			labels = append(labels, string(p[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
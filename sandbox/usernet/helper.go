@@ -0,0 +1,73 @@
+package usernet
+
+import (
+	"context"
+	"encoding/gob"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"git.gensokyo.uk/security/fortify/helper"
+)
+
+// TunFd is the file descriptor of the TUN device in the helper process.
+const TunFd = 3
+
+// HelperMain is the main function of the user-mode networking helper process.
+// Its [Config] is read from argsFd, and it exits when statFd is closed by the parent.
+func HelperMain(argsFd, statFd int, prepare func(prefix string), setVerbose func(verbose bool)) {
+	prepare("usernet")
+
+	if argsFd < 0 || statFd < 0 {
+		log.Fatal("invalid helper file descriptors")
+	}
+
+	c := new(Config)
+	if f := os.NewFile(uintptr(argsFd), "args"); f == nil {
+		log.Fatal("invalid args descriptor")
+	} else if err := gob.NewDecoder(f).Decode(c); err != nil {
+		log.Fatalf("cannot decode helper config: %v", err)
+	} else if err = f.Close(); err != nil {
+		log.Printf("cannot close args pipe: %v", err)
+		// not fatal
+	}
+	setVerbose(c.Verbose)
+
+	// the runtime poller is only used for non-blocking files
+	if err := syscall.SetNonblock(TunFd, true); err != nil {
+		log.Fatalf("cannot set tun device non-blocking: %v", err)
+	}
+	tun := os.NewFile(TunFd, "tun")
+
+	s, err := New(c, tun)
+	if err != nil {
+		msg.PrintBaseErr(err, "cannot initialise network stack:")
+		msg.BeforeExit()
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	stat := os.NewFile(uintptr(statFd), "stat")
+	if _, err = stat.Write([]byte{'x'}); err != nil {
+		log.Fatalf("cannot write to status pipe: %v", err)
+	}
+	go func() {
+		// parent closes the read end of the status pipe to request exit
+		if err := helper.WaitClose(statFd); err != nil {
+			log.Fatalf("cannot poll status pipe: %v", err)
+		}
+		stop()
+	}()
+	go func() { <-ctx.Done(); _ = tun.Close() }()
+
+	msg.Verbosef("serving %s, gateway %s, dns %s", c.Guest, c.Gateway, c.DNS)
+	if err = s.Serve(ctx); err != nil {
+		msg.PrintBaseErr(err, "cannot serve network stack:")
+		msg.BeforeExit()
+		os.Exit(1)
+	}
+	msg.BeforeExit()
+}
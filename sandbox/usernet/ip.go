@@ -0,0 +1,83 @@
+package usernet
+
+import (
+	"encoding/binary"
+	"net/netip"
+)
+
+const (
+	protoICMP = 1
+	protoTCP  = 6
+	protoUDP  = 17
+
+	ipv4HeaderLen = 20
+	ipv4TTL       = 64
+
+	// more fragments flag and fragment offset mask
+	ipv4FragMask = 0x3fff
+)
+
+type ipv4Header struct {
+	proto    byte
+	src, dst netip.Addr
+}
+
+// parseIPv4 returns the header and payload of an unfragmented IPv4 packet.
+func parseIPv4(p []byte) (*ipv4Header, []byte, bool) {
+	if len(p) < ipv4HeaderLen || p[0]>>4 != 4 {
+		return nil, nil, false
+	}
+	ihl := int(p[0]&0xf) * 4
+	total := int(binary.BigEndian.Uint16(p[2:]))
+	if ihl < ipv4HeaderLen || total < ihl || total > len(p) ||
+		binary.BigEndian.Uint16(p[6:])&ipv4FragMask != 0 ||
+		checksum(p[:ihl], 0) != 0 {
+		return nil, nil, false
+	}
+	return &ipv4Header{
+		proto: p[9],
+		src:   netip.AddrFrom4([4]byte(p[12:16])),
+		dst:   netip.AddrFrom4([4]byte(p[16:20])),
+	}, p[ihl:total], true
+}
+
+// buildIPv4 returns an IPv4 packet carrying payload.
+func buildIPv4(src, dst netip.Addr, proto byte, id uint16, payload []byte) []byte {
+	p := make([]byte, ipv4HeaderLen+len(payload))
+	p[0] = 4<<4 | ipv4HeaderLen/4
+	binary.BigEndian.PutUint16(p[2:], uint16(len(p)))
+	binary.BigEndian.PutUint16(p[4:], id)
+	p[8] = ipv4TTL
+	p[9] = proto
+	s, d := src.As4(), dst.As4()
+	copy(p[12:], s[:])
+	copy(p[16:], d[:])
+	binary.BigEndian.PutUint16(p[10:], checksum(p[:ipv4HeaderLen], 0))
+	copy(p[ipv4HeaderLen:], payload)
+	return p
+}
+
+// checksum returns the internet checksum of p with initial partial sum.
+func checksum(p []byte, sum uint32) uint16 {
+	for len(p) >= 2 {
+		sum += uint32(p[0])<<8 | uint32(p[1])
+		p = p[2:]
+	}
+	if len(p) == 1 {
+		sum += uint32(p[0]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+// pseudoSum returns the partial checksum of the IPv4 pseudo header.
+func pseudoSum(src, dst netip.Addr, proto byte, length int) uint32 {
+	s, d := src.As4(), dst.As4()
+	return (uint32(s[0])<<8 | uint32(s[1])) +
+		(uint32(s[2])<<8 | uint32(s[3])) +
+		(uint32(d[0])<<8 | uint32(d[1])) +
+		(uint32(d[2])<<8 | uint32(d[3])) +
+		uint32(proto) + uint32(length)
+}
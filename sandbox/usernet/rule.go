@@ -0,0 +1,159 @@
+package usernet
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// ProxyPort is the port on the gateway address relayed to [Config.Proxy].
+	ProxyPort = 3128
+
+	// dnsMinTTL is the minimum duration addresses learned from DNS responses remain permitted.
+	dnsMinTTL = 10 * time.Minute
+)
+
+// ErrRule is returned by [ParseRule] for a malformed rule.
+var ErrRule = errors.New("invalid destination rule")
+
+// Rule describes a permitted outbound destination.
+type Rule struct {
+	// Host name, prefixed with "*." to match all subdomains.
+	// Addresses of a host are learned from responses relayed by the DNS forwarder.
+	Host string
+	// Destination address prefix, only used if Host is empty.
+	Prefix netip.Prefix
+	// Destination port, any port if zero.
+	Port uint16
+}
+
+// ParseRule parses a rule of the form host:port, address, address:port, CIDR or CIDR:port.
+func ParseRule(s string) (Rule, error) {
+	var r Rule
+	host := s
+	if h, p, err := net.SplitHostPort(s); err == nil {
+		if v, err := strconv.ParseUint(p, 10, 16); err != nil || v == 0 {
+			return r, fmt.Errorf("%w %q: bad port %q", ErrRule, s, p)
+		} else {
+			host, r.Port = h, uint16(v)
+		}
+	}
+
+	if p, err := netip.ParsePrefix(host); err == nil {
+		r.Prefix = p.Masked()
+	} else if a, err := netip.ParseAddr(host); err == nil {
+		r.Prefix = netip.PrefixFrom(a, a.BitLen())
+	} else if name, ok := strings.CutPrefix(strings.ToLower(host), "*."); validHost(name) {
+		if ok {
+			name = "*." + name
+		}
+		r.Host = name
+		if r.Port == 0 {
+			return r, fmt.Errorf("%w %q: host name requires a port", ErrRule, s)
+		}
+		return r, nil
+	} else {
+		return r, fmt.Errorf("%w %q", ErrRule, s)
+	}
+
+	if !r.Prefix.Addr().Is4() {
+		return r, fmt.Errorf("%w %q: not an IPv4 destination", ErrRule, s)
+	}
+	return r, nil
+}
+
+func (r Rule) String() string {
+	var host string
+	if r.Host != "" {
+		host = r.Host
+	} else if r.Prefix.IsSingleIP() {
+		host = r.Prefix.Addr().String()
+	} else {
+		host = r.Prefix.String()
+	}
+	if r.Port == 0 {
+		return host
+	}
+	return host + ":" + strconv.Itoa(int(r.Port))
+}
+
+// matchHost reports whether r applies to fully qualified host name.
+func (r Rule) matchHost(name string) bool {
+	if suffix, ok := strings.CutPrefix(r.Host, "*"); ok {
+		return strings.HasSuffix(name, suffix)
+	}
+	return r.Host != "" && r.Host == name
+}
+
+// validHost reports whether name is a syntactically valid host name.
+func validHost(name string) bool {
+	if name == "" || len(name) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// learn records addresses of host names matching rules from a DNS response.
+func (s *Stack) learn(p []byte) {
+	answers, ok := parseDNSResponse(p)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ans := range answers {
+		for i, r := range s.c.Allow {
+			if !r.matchHost(ans.name) {
+				continue
+			}
+			if s.learned[ans.addr] == nil {
+				s.learned[ans.addr] = make(map[int]time.Time)
+			}
+			expiry := now.Add(max(ans.ttl, dnsMinTTL))
+			if expiry.After(s.learned[ans.addr][i]) {
+				msg.Verbosef("permitting %s as %s", ans.addr, r)
+				s.learned[ans.addr][i] = expiry
+			}
+		}
+	}
+}
+
+// permit reports whether an outbound connection to dst is permitted by [Config.Allow].
+// Must be called with mu held.
+func (s *Stack) permit(dst netip.AddrPort) bool {
+	if s.c.Allow == nil {
+		return true
+	}
+
+	now := time.Now()
+	for i, r := range s.c.Allow {
+		if r.Port != 0 && r.Port != dst.Port() {
+			continue
+		}
+		if r.Host == "" {
+			if r.Prefix.Contains(dst.Addr()) {
+				return true
+			}
+		} else if expiry, ok := s.learned[dst.Addr()][i]; ok && now.Before(expiry) {
+			return true
+		}
+	}
+	return false
+}
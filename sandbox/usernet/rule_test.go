@@ -0,0 +1,168 @@
+package usernet
+
+import (
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	testCases := []struct {
+		s       string
+		want    Rule
+		wantErr bool
+		str     string
+	}{
+		{"example.org:443", Rule{Host: "example.org", Port: 443}, false, ""},
+		{"*.Example.org:443", Rule{Host: "*.example.org", Port: 443}, false, "*.example.org:443"},
+		{"192.0.2.1", Rule{Prefix: netip.MustParsePrefix("192.0.2.1/32")}, false, ""},
+		{"192.0.2.1:22", Rule{Prefix: netip.MustParsePrefix("192.0.2.1/32"), Port: 22}, false, ""},
+		{"192.0.2.0/24", Rule{Prefix: netip.MustParsePrefix("192.0.2.0/24")}, false, ""},
+		{"192.0.2.7/24:80", Rule{Prefix: netip.MustParsePrefix("192.0.2.0/24"), Port: 80}, false, "192.0.2.0/24:80"},
+
+		{"example.org", Rule{}, true, ""},
+		{"example.org:0", Rule{}, true, ""},
+		{"example.org:65536", Rule{}, true, ""},
+		{"-example.org:443", Rule{}, true, ""},
+		{"exa_mple.org:443", Rule{}, true, ""},
+		{"[2001:db8::1]:443", Rule{}, true, ""},
+		{"2001:db8::/32", Rule{}, true, ""},
+		{"", Rule{}, true, ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.s, func(t *testing.T) {
+			got, err := ParseRule(tc.s)
+			if tc.wantErr {
+				if !errors.Is(err, ErrRule) {
+					t.Errorf("ParseRule: error = %v, want %v", err, ErrRule)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseRule: error = %v", err)
+			}
+			if got != tc.want {
+				t.Errorf("ParseRule: %#v, want %#v", got, tc.want)
+			}
+			str := tc.str
+			if str == "" {
+				str = tc.s
+			}
+			if got.String() != str {
+				t.Errorf("String: %q, want %q", got.String(), str)
+			}
+		})
+	}
+}
+
+func TestPermit(t *testing.T) {
+	c := NewConfig()
+	c.Restrict = true
+	for _, v := range []string{"example.org:443", "*.example.net:80", "198.51.100.0/24", "203.0.113.9:22"} {
+		if r, err := ParseRule(v); err != nil {
+			t.Fatalf("ParseRule: error = %v", err)
+		} else {
+			c.Allow = append(c.Allow, r)
+		}
+	}
+	s, err := New(c, nil)
+	if err != nil {
+		t.Fatalf("New: error = %v", err)
+	}
+
+	s.learn(dnsResponse("Example.org", 60, [][2]string{{"example.org", "192.0.2.1"}}))
+	s.learn(dnsResponse("www.example.net", 0, [][2]string{
+		{"www.example.net", "cdn.example.com"},
+		{"cdn.example.com", "192.0.2.2"},
+		{"unrelated.example.com", "192.0.2.3"},
+	}))
+	s.learn(dnsResponse("example.com", 60, [][2]string{{"example.com", "192.0.2.4"}}))
+
+	testCases := []struct {
+		dst  string
+		want bool
+	}{
+		{"192.0.2.1:443", true},
+		{"192.0.2.1:80", false},
+		{"192.0.2.2:80", true},
+		{"192.0.2.2:443", false},
+		{"192.0.2.3:80", false},
+		{"192.0.2.4:443", false},
+		{"198.51.100.200:1", true},
+		{"198.51.101.1:1", false},
+		{"203.0.113.9:22", true},
+		{"203.0.113.9:23", false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.dst, func(t *testing.T) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if got := s.permit(netip.MustParseAddrPort(tc.dst)); got != tc.want {
+				t.Errorf("permit: %v, want %v", got, tc.want)
+			}
+		})
+	}
+
+	t.Run("expiry", func(t *testing.T) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		a := netip.MustParseAddr("192.0.2.1")
+		if d := time.Until(s.learned[a][0]); d < dnsMinTTL-time.Minute || d > dnsMinTTL {
+			t.Errorf("expiry: %v", d)
+		}
+		s.learned[a][0] = time.Now().Add(-time.Second)
+		if s.permit(netip.AddrPortFrom(a, 443)) {
+			t.Errorf("permit: expired address permitted")
+		}
+	})
+}
+
+// dnsResponse returns a DNS response to question with answer records of the form owner, target.
+// Targets parsing as addresses produce A records, CNAME records otherwise.
+func dnsResponse(question string, ttl uint32, records [][2]string) []byte {
+	name := func(p []byte, v string) []byte {
+		for _, label := range strings.Split(v, ".") {
+			p = append(p, byte(len(label)))
+			p = append(p, label...)
+		}
+		return append(p, 0)
+	}
+
+	p := []byte{0xf0, 0x0f, 0x81, 0x80, 0, 1, 0, byte(len(records)), 0, 0, 0, 0}
+	p = name(p, question)
+	p = append(p, 0, dnsTypeA, 0, dnsClassIN)
+	for _, r := range records {
+		p = name(p, r[0])
+		if a, err := netip.ParseAddr(r[1]); err == nil {
+			p = append(p, 0, dnsTypeA, 0, dnsClassIN)
+			p = binary.BigEndian.AppendUint32(p, ttl)
+			p = append(p, 0, 4)
+			p = append(p, a.AsSlice()...)
+		} else {
+			rdata := name(nil, r[1])
+			p = append(p, 0, dnsTypeCNAME, 0, dnsClassIN)
+			p = binary.BigEndian.AppendUint32(p, ttl)
+			p = binary.BigEndian.AppendUint16(p, uint16(len(rdata)))
+			p = append(p, rdata...)
+		}
+	}
+	return p
+}
+
+func TestDNSName(t *testing.T) {
+	// name at offset 2, then a compressed name pointing into it at offset 15
+	p := []byte{0, 0, 3, 'W', 'w', 'w', 3, 'o', 'r', 'g', 0, 0, 0, 0, 0, 3, 'f', 'o', 'o', 0xc0, 6}
+	if name, off, ok := dnsName(p, 2); !ok || name != "www.org" || off != 11 {
+		t.Errorf("dnsName: %q %d %v", name, off, ok)
+	}
+	if name, off, ok := dnsName(p, 15); !ok || name != "foo.org" || off != 21 {
+		t.Errorf("dnsName: %q %d %v", name, off, ok)
+	}
+	// pointer loop
+	if _, _, ok := dnsName([]byte{0xc0, 0}, 0); ok {
+		t.Errorf("dnsName: accepted pointer loop")
+	}
+}
@@ -0,0 +1,533 @@
+package usernet
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+)
+
+const (
+	tcpFIN = 1 << iota
+	tcpSYN
+	tcpRST
+	tcpPSH
+	tcpACK
+
+	tcpHeaderLen = 20
+	// tcpOptMSS is the kind of the maximum segment size option.
+	tcpOptMSS = 2
+	// tcpDefaultMSS is the maximum segment size assumed in absence of the option.
+	tcpDefaultMSS = 536
+	// tcpMinMSS is the smallest maximum segment size accepted from the container, as enforced by Linux.
+	tcpMinMSS = 48
+	// tcpWindow is the maximum amount of data received from the container pending write to the host.
+	// Window scaling is not negotiated.
+	tcpWindow = 1<<16 - 1
+
+	// tcpRTO is the initial retransmission timeout.
+	tcpRTO = time.Second
+	// tcpMaxRTO is the upper bound of the retransmission timeout after backoff.
+	tcpMaxRTO = 30 * time.Second
+	// tcpMaxRetries is the number of retransmissions before a connection is reset.
+	tcpMaxRetries = 8
+	// retransmitInterval is the interval between checks for expired retransmission timers.
+	retransmitInterval = 100 * time.Millisecond
+	// maxFlows is the maximum number of concurrent TCP connections or UDP flows.
+	maxFlows = 1 << 12
+)
+
+// seqLT reports whether sequence number a precedes b.
+func seqLT(a, b uint32) bool { return int32(a-b) < 0 }
+
+type tcpSegment struct {
+	src, dst netip.AddrPort
+	seq, ack uint32
+	flags    byte
+	window   uint16
+	mss      int
+	payload  []byte
+}
+
+// len returns the sequence space occupied by seg.
+func (seg *tcpSegment) len() uint32 {
+	n := uint32(len(seg.payload))
+	if seg.flags&tcpSYN != 0 {
+		n++
+	}
+	if seg.flags&tcpFIN != 0 {
+		n++
+	}
+	return n
+}
+
+func parseTCP(h *ipv4Header, p []byte) (*tcpSegment, bool) {
+	if len(p) < tcpHeaderLen {
+		return nil, false
+	}
+	off := int(p[12]>>4) * 4
+	if off < tcpHeaderLen || off > len(p) ||
+		checksum(p, pseudoSum(h.src, h.dst, protoTCP, len(p))) != 0 {
+		return nil, false
+	}
+	seg := &tcpSegment{
+		src:     netip.AddrPortFrom(h.src, binary.BigEndian.Uint16(p[0:])),
+		dst:     netip.AddrPortFrom(h.dst, binary.BigEndian.Uint16(p[2:])),
+		seq:     binary.BigEndian.Uint32(p[4:]),
+		ack:     binary.BigEndian.Uint32(p[8:]),
+		flags:   p[13] & 0x1f,
+		window:  binary.BigEndian.Uint16(p[14:]),
+		mss:     tcpDefaultMSS,
+		payload: p[off:],
+	}
+
+	for opt := p[tcpHeaderLen:off]; len(opt) > 0; {
+		switch opt[0] {
+		case 0: // end of option list
+			opt = nil
+		case 1: // no-operation
+			opt = opt[1:]
+		default:
+			if len(opt) < 2 || int(opt[1]) < 2 || int(opt[1]) > len(opt) {
+				return nil, false
+			}
+			if opt[0] == tcpOptMSS && opt[1] == 4 {
+				// a tiny or zero mss would stall sending to the container
+				seg.mss = max(int(binary.BigEndian.Uint16(opt[2:])), tcpMinMSS)
+			}
+			opt = opt[opt[1]:]
+		}
+	}
+	return seg, true
+}
+
+// buildTCP returns a TCP segment from src to dst, mss is only included if non-zero.
+func buildTCP(src, dst netip.AddrPort, seq, ack uint32, flags byte, window uint16, mss int, payload []byte) []byte {
+	off := tcpHeaderLen
+	if mss != 0 {
+		off += 4
+	}
+	p := make([]byte, off+len(payload))
+	binary.BigEndian.PutUint16(p[0:], src.Port())
+	binary.BigEndian.PutUint16(p[2:], dst.Port())
+	binary.BigEndian.PutUint32(p[4:], seq)
+	binary.BigEndian.PutUint32(p[8:], ack)
+	p[12] = byte(off/4) << 4
+	p[13] = flags
+	binary.BigEndian.PutUint16(p[14:], window)
+	if mss != 0 {
+		p[20], p[21] = tcpOptMSS, 4
+		binary.BigEndian.PutUint16(p[22:], uint16(mss))
+	}
+	copy(p[off:], payload)
+	binary.BigEndian.PutUint16(p[16:], checksum(p, pseudoSum(src.Addr(), dst.Addr(), protoTCP, len(p))))
+	return p
+}
+
+// tcpConn relays a TCP connection from the container over a host connection.
+// The container is always the active opener.
+type tcpConn struct {
+	s   *Stack
+	key flowKey
+
+	mu sync.Mutex
+	// broadcast on any state change
+	cond *sync.Cond
+
+	// nil while dialing
+	conn net.Conn
+	// maximum size of segments sent to the container
+	mss int
+
+	// initial send sequence number
+	iss uint32
+	// oldest unacknowledged and next sequence number
+	sndUna, sndNxt uint32
+	// window advertised by the container
+	sndWnd uint32
+	// data sent but not yet acknowledged, starting at sndUna
+	unacked []byte
+	// whether SYN, FIN were acknowledged by the container
+	synAcked, finAcked bool
+	// whether FIN was sent to the container
+	finSent bool
+
+	// next sequence number expected from the container
+	rcvNxt uint32
+	// data received from the container pending write
+	pending [][]byte
+	// total length of pending
+	queued int
+	// whether FIN was received from the container
+	finRecv bool
+	// whether the write side of conn is shut down
+	writeDone bool
+
+	// time of last transmission of the oldest unacknowledged segment
+	lastSend time.Time
+	// current retransmission timeout and number of retransmissions
+	rto     time.Duration
+	retries int
+
+	// set once the connection is torn down
+	closed bool
+}
+
+func (s *Stack) handleTCP(h *ipv4Header, p []byte) {
+	seg, ok := parseTCP(h, p)
+	if !ok {
+		return
+	}
+	key := flowKey{seg.src, seg.dst}
+
+	s.mu.Lock()
+	c, ok := s.tcp[key]
+	if !ok && seg.flags&(tcpSYN|tcpACK|tcpRST) == tcpSYN && len(s.tcp) < maxFlows {
+		if network, address, ok := s.upstream(seg.dst, "tcp"); ok {
+			c = &tcpConn{s: s, key: key,
+				mss:    min(seg.mss, s.c.MTU-ipv4HeaderLen-tcpHeaderLen),
+				sndWnd: uint32(seg.window),
+				rcvNxt: seg.seq + 1,
+				rto:    tcpRTO,
+			}
+			c.cond = sync.NewCond(&c.mu)
+			s.tcp[key] = c
+			s.mu.Unlock()
+
+			msg.Verbosef("relaying tcp connection %s -> %s via %s", seg.src, seg.dst, address)
+			go c.dial(network, address)
+			return
+		}
+	}
+	s.mu.Unlock()
+
+	if c == nil {
+		s.reset(seg)
+		return
+	}
+	c.handle(seg)
+}
+
+// reset responds to seg with a reset.
+func (s *Stack) reset(seg *tcpSegment) {
+	if seg.flags&tcpRST != 0 {
+		return
+	}
+	if seg.flags&tcpACK != 0 {
+		s.write(seg.dst.Addr(), seg.src.Addr(), protoTCP,
+			buildTCP(seg.dst, seg.src, seg.ack, 0, tcpRST, 0, 0, nil))
+	} else {
+		s.write(seg.dst.Addr(), seg.src.Addr(), protoTCP,
+			buildTCP(seg.dst, seg.src, 0, seg.seq+seg.len(), tcpRST|tcpACK, 0, 0, nil))
+	}
+}
+
+func (c *tcpConn) dial(network, address string) {
+	conn, err := c.s.dial(network, address)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		msg.Verbosef("cannot connect to %s: %v", address, err)
+		if !c.closed {
+			c.closed = true
+			c.s.write(c.key.dst.Addr(), c.key.src.Addr(), protoTCP,
+				buildTCP(c.key.dst, c.key.src, 0, c.rcvNxt, tcpRST|tcpACK, 0, 0, nil))
+		}
+		go c.remove()
+		return
+	}
+	if c.closed {
+		_ = conn.Close()
+		return
+	}
+
+	c.conn = conn
+	c.iss = rand.Uint32()
+	c.sndUna, c.sndNxt = c.iss, c.iss+1
+	c.lastSend = time.Now()
+	c.sendSynAck()
+	go c.write()
+}
+
+// handle processes a segment from the container.
+func (c *tcpConn) handle(seg *tcpSegment) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	if seg.flags&tcpRST != 0 {
+		c.abortLocked()
+		go c.remove()
+		return
+	}
+	if c.conn == nil {
+		// still dialing, retransmitted SYN is ignored
+		return
+	}
+	if seg.flags&tcpSYN != 0 {
+		if !c.synAcked {
+			c.sendSynAck()
+		}
+		return
+	}
+	if seg.flags&tcpACK == 0 {
+		return
+	}
+
+	if !c.synAcked {
+		if seg.ack != c.iss+1 {
+			return
+		}
+		c.synAcked = true
+		c.sndUna = seg.ack
+		c.retries, c.rto = 0, tcpRTO
+		go c.read()
+	} else if seqLT(c.sndUna, seg.ack) && !seqLT(c.sndNxt, seg.ack) {
+		n := int(seg.ack - c.sndUna)
+		if n > len(c.unacked) {
+			// acknowledges FIN
+			c.finAcked = true
+			n = len(c.unacked)
+		}
+		c.unacked = c.unacked[n:]
+		c.sndUna = seg.ack
+		c.retries, c.rto = 0, tcpRTO
+		c.lastSend = time.Now()
+	}
+	c.sndWnd = uint32(seg.window)
+	c.cond.Broadcast()
+
+	if len(seg.payload) > 0 || seg.flags&tcpFIN != 0 {
+		if seg.seq != c.rcvNxt || c.finRecv {
+			// out of order or retransmitted
+			c.send(tcpACK, c.sndNxt, nil)
+			return
+		}
+		if len(seg.payload) > 0 {
+			if c.queued+len(seg.payload) > tcpWindow {
+				// exceeds advertised window, container will retransmit
+				c.send(tcpACK, c.sndNxt, nil)
+				return
+			}
+			c.pending = append(c.pending, append([]byte(nil), seg.payload...))
+			c.queued += len(seg.payload)
+			c.rcvNxt += uint32(len(seg.payload))
+		}
+		if seg.flags&tcpFIN != 0 {
+			c.finRecv = true
+			c.rcvNxt++
+		}
+		c.send(tcpACK, c.sndNxt, nil)
+	}
+
+	c.checkDoneLocked()
+}
+
+// send transmits a segment to the container, must be called with mu held.
+func (c *tcpConn) send(flags byte, seq uint32, payload []byte) {
+	window := tcpWindow - c.queued
+	c.s.write(c.key.dst.Addr(), c.key.src.Addr(), protoTCP,
+		buildTCP(c.key.dst, c.key.src, seq, c.rcvNxt, flags, uint16(max(window, 0)), 0, payload))
+}
+
+func (c *tcpConn) sendSynAck() {
+	c.s.write(c.key.dst.Addr(), c.key.src.Addr(), protoTCP,
+		buildTCP(c.key.dst, c.key.src, c.iss, c.rcvNxt, tcpSYN|tcpACK, tcpWindow, c.s.c.MTU-ipv4HeaderLen-tcpHeaderLen, nil))
+}
+
+// read relays data from the host connection to the container.
+func (c *tcpConn) read() {
+	buf := make([]byte, c.mss)
+	for {
+		c.mu.Lock()
+		for !c.closed && c.sndNxt-c.sndUna >= c.sndWnd {
+			c.cond.Wait()
+		}
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		n := min(int(c.sndWnd-(c.sndNxt-c.sndUna)), len(buf))
+		c.mu.Unlock()
+
+		n, err := c.conn.Read(buf[:n])
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		if n > 0 {
+			if c.sndUna == c.sndNxt {
+				c.lastSend = time.Now()
+			}
+			c.unacked = append(c.unacked, buf[:n]...)
+			c.send(tcpACK|tcpPSH, c.sndNxt, buf[:n])
+			c.sndNxt += uint32(n)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				if c.sndUna == c.sndNxt {
+					c.lastSend = time.Now()
+				}
+				c.finSent = true
+				c.send(tcpFIN|tcpACK, c.sndNxt, nil)
+				c.sndNxt++
+				c.mu.Unlock()
+				return
+			}
+			msg.Verbosef("cannot read from %s: %v", c.conn.RemoteAddr(), err)
+			c.send(tcpRST|tcpACK, c.sndNxt, nil)
+			c.abortLocked()
+			c.mu.Unlock()
+			c.remove()
+			return
+		}
+		c.mu.Unlock()
+	}
+}
+
+// write relays data received from the container to the host connection.
+func (c *tcpConn) write() {
+	for {
+		c.mu.Lock()
+		for !c.closed && len(c.pending) == 0 && !c.finRecv {
+			c.cond.Wait()
+		}
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		if len(c.pending) == 0 {
+			// FIN received and all pending data written
+			if cw, ok := c.conn.(interface{ CloseWrite() error }); ok {
+				_ = cw.CloseWrite()
+			}
+			c.writeDone = true
+			c.checkDoneLocked()
+			c.mu.Unlock()
+			return
+		}
+		p := c.pending[0]
+		c.pending = c.pending[1:]
+		c.mu.Unlock()
+
+		_, err := c.conn.Write(p)
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			return
+		}
+		if err != nil {
+			msg.Verbosef("cannot write to %s: %v", c.conn.RemoteAddr(), err)
+			c.send(tcpRST|tcpACK, c.sndNxt, nil)
+			c.abortLocked()
+			c.mu.Unlock()
+			c.remove()
+			return
+		}
+		full := c.queued+c.mss > tcpWindow
+		c.queued -= len(p)
+		if full {
+			// window update
+			c.send(tcpACK, c.sndNxt, nil)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// retransmitLocked resends the oldest unacknowledged segment if its timer expired,
+// must be called with mu held.
+func (c *tcpConn) retransmitLocked(now time.Time) {
+	if c.closed || c.conn == nil || c.sndUna == c.sndNxt || now.Sub(c.lastSend) < c.rto {
+		return
+	}
+	if c.retries++; c.retries > tcpMaxRetries {
+		msg.Verbosef("tcp connection %s -> %s timed out", c.key.src, c.key.dst)
+		c.send(tcpRST|tcpACK, c.sndNxt, nil)
+		c.abortLocked()
+		go c.remove()
+		return
+	}
+	c.lastSend = now
+	c.rto = min(c.rto*2, tcpMaxRTO)
+
+	switch {
+	case !c.synAcked:
+		c.sendSynAck()
+	case len(c.unacked) > 0:
+		c.send(tcpACK|tcpPSH, c.sndUna, c.unacked[:min(len(c.unacked), c.mss)])
+	case c.finSent:
+		c.send(tcpFIN|tcpACK, c.sndNxt-1, nil)
+	}
+}
+
+// checkDoneLocked tears down the connection once both directions are shut down,
+// must be called with mu held.
+func (c *tcpConn) checkDoneLocked() {
+	if c.finAcked && c.writeDone && !c.closed {
+		c.abortLocked()
+		go c.remove()
+	}
+}
+
+func (c *tcpConn) abort() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.abortLocked()
+}
+
+func (c *tcpConn) abortLocked() {
+	if c.closed {
+		return
+	}
+	c.closed = true
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+	c.cond.Broadcast()
+}
+
+// remove removes c from its [Stack], must not be called with mu held.
+func (c *tcpConn) remove() {
+	c.s.mu.Lock()
+	defer c.s.mu.Unlock()
+	if c.s.tcp[c.key] == c {
+		delete(c.s.tcp, c.key)
+	}
+}
+
+// retransmit periodically checks retransmission timers of all connections.
+func (s *Stack) retransmit(ctx context.Context) {
+	t := time.NewTicker(retransmitInterval)
+	defer t.Stop()
+
+	var conns []*tcpConn
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			s.mu.Lock()
+			conns = conns[:0]
+			for _, c := range s.tcp {
+				conns = append(conns, c)
+			}
+			s.mu.Unlock()
+
+			for _, c := range conns {
+				c.mu.Lock()
+				c.retransmitLocked(now)
+				c.mu.Unlock()
+			}
+		}
+	}
+}
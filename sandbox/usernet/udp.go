@@ -0,0 +1,124 @@
+package usernet
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+const udpHeaderLen = 8
+
+// udpFlow relays datagrams between a pair of addresses over a connected host socket.
+type udpFlow struct {
+	conn net.Conn
+	// unix time in nanoseconds of the last datagram in either direction
+	last atomic.Int64
+}
+
+func parseUDP(h *ipv4Header, p []byte) (src, dst netip.AddrPort, payload []byte, ok bool) {
+	if len(p) < udpHeaderLen {
+		return
+	}
+	length := int(binary.BigEndian.Uint16(p[4:]))
+	if length < udpHeaderLen || length > len(p) {
+		return
+	}
+	// zero checksum indicates none was computed
+	if binary.BigEndian.Uint16(p[6:]) != 0 &&
+		checksum(p[:length], pseudoSum(h.src, h.dst, protoUDP, length)) != 0 {
+		return
+	}
+	return netip.AddrPortFrom(h.src, binary.BigEndian.Uint16(p[0:])),
+		netip.AddrPortFrom(h.dst, binary.BigEndian.Uint16(p[2:])),
+		p[udpHeaderLen:length], true
+}
+
+func buildUDP(src, dst netip.AddrPort, payload []byte) []byte {
+	p := make([]byte, udpHeaderLen+len(payload))
+	binary.BigEndian.PutUint16(p[0:], src.Port())
+	binary.BigEndian.PutUint16(p[2:], dst.Port())
+	binary.BigEndian.PutUint16(p[4:], uint16(len(p)))
+	copy(p[udpHeaderLen:], payload)
+	c := checksum(p, pseudoSum(src.Addr(), dst.Addr(), protoUDP, len(p)))
+	if c == 0 {
+		c = 0xffff
+	}
+	binary.BigEndian.PutUint16(p[6:], c)
+	return p
+}
+
+func (s *Stack) handleUDP(h *ipv4Header, p []byte) {
+	src, dst, payload, ok := parseUDP(h, p)
+	if !ok {
+		return
+	}
+	key := flowKey{src, dst}
+
+	s.mu.Lock()
+	f, ok := s.udp[key]
+	if !ok {
+		if len(s.udp) >= maxFlows {
+			s.mu.Unlock()
+			return
+		}
+		network, address, ok := s.upstream(dst, "udp")
+		if !ok {
+			s.mu.Unlock()
+			return
+		}
+		conn, err := s.dial(network, address)
+		if err != nil {
+			s.mu.Unlock()
+			msg.Verbosef("cannot connect to %s: %v", address, err)
+			return
+		}
+		msg.Verbosef("relaying udp flow %s -> %s via %s", src, dst, address)
+		f = &udpFlow{conn: conn}
+		f.last.Store(time.Now().UnixNano())
+		s.udp[key] = f
+		go s.readUDP(key, f)
+	}
+	s.mu.Unlock()
+
+	f.last.Store(time.Now().UnixNano())
+	if _, err := f.conn.Write(payload); err != nil {
+		msg.Verbosef("cannot write to %s: %v", f.conn.RemoteAddr(), err)
+	}
+}
+
+// readUDP relays datagrams from the host socket to the container until the flow is idle.
+func (s *Stack) readUDP(key flowKey, f *udpFlow) {
+	defer func() {
+		_ = f.conn.Close()
+		s.mu.Lock()
+		if s.udp[key] == f {
+			delete(s.udp, key)
+		}
+		s.mu.Unlock()
+	}()
+
+	buf := make([]byte, s.c.MTU-ipv4HeaderLen-udpHeaderLen)
+	for {
+		last := time.Unix(0, f.last.Load())
+		if err := f.conn.SetReadDeadline(last.Add(udpTimeout)); err != nil {
+			return
+		}
+		n, err := f.conn.Read(buf)
+		if err != nil {
+			if errors.Is(err, os.ErrDeadlineExceeded) && time.Unix(0, f.last.Load()).After(last) {
+				// datagram sent by the container since the deadline was set
+				continue
+			}
+			return
+		}
+		f.last.Store(time.Now().UnixNano())
+		if s.c.Restrict && key.dst.Addr() == s.c.DNS {
+			s.learn(buf[:n])
+		}
+		s.write(key.dst.Addr(), key.src.Addr(), protoUDP, buildUDP(key.dst, key.src, buf[:n]))
+	}
+}
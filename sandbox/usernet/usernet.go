@@ -0,0 +1,308 @@
+// Package usernet implements slirp-style user-mode networking for a container with a private network namespace.
+//
+// Packets read from a TUN device in the container are terminated in userspace and their payload
+// is relayed over ordinary sockets in the network namespace of the calling process.
+package usernet
+
+import (
+	"bufio"
+	"context"
+	"encoding/gob"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"git.gensokyo.uk/security/fortify/sandbox"
+)
+
+const (
+	// TunName is the name of the TUN device in the container.
+	TunName = "tap0"
+	// DefaultMTU is the MTU of the TUN device in the container.
+	DefaultMTU = 1500
+
+	// dialTimeout is the maximum duration of an outbound connection attempt.
+	dialTimeout = 30 * time.Second
+	// udpTimeout is the idle duration after which a UDP flow is discarded.
+	udpTimeout = 60 * time.Second
+)
+
+var (
+	// DefaultGuest is the address and prefix of the container.
+	DefaultGuest = netip.MustParsePrefix("10.0.2.100/24")
+	// DefaultGateway is the address of the virtual gateway, optionally mapped to host loopback.
+	DefaultGateway = netip.MustParseAddr("10.0.2.2")
+	// DefaultDNS is the address of the virtual DNS forwarder.
+	DefaultDNS = netip.MustParseAddr("10.0.2.3")
+
+	// hostLoopback is the address connections to the gateway are relayed to when permitted.
+	hostLoopback = netip.MustParseAddr("127.0.0.1")
+)
+
+// Config holds user-mode networking configuration and is safe to serialise.
+type Config struct {
+	// Address and prefix of the container.
+	Guest netip.Prefix
+	// Address of the virtual gateway.
+	Gateway netip.Addr
+	// Address of the virtual DNS forwarder.
+	DNS netip.Addr
+	// Upstream nameserver, first nameserver in host resolv.conf if empty.
+	Nameserver string
+	// MTU of the TUN device.
+	MTU int
+	// Relay connections to the gateway address to host loopback.
+	HostLoopback bool
+	// Only permit outbound connections to destinations in Allow.
+	// The DNS forwarder remains reachable.
+	Restrict bool
+	// Permitted destinations, only used if Restrict is true.
+	Allow []Rule
+	// Relay connections to the gateway address at [ProxyPort] to this address and reject all other traffic,
+	// an absolute pathname is interpreted as a unix socket.
+	Proxy string
+
+	// verbosity pass through
+	Verbose bool
+}
+
+// NewConfig returns the address of a new [Config] with default values.
+func NewConfig() *Config {
+	return &Config{Guest: DefaultGuest, Gateway: DefaultGateway, DNS: DefaultDNS, MTU: DefaultMTU}
+}
+
+// Tun returns the configuration of the TUN device in the container.
+func (c *Config) Tun() *sandbox.TunConfig {
+	return &sandbox.TunConfig{Name: TunName, Addr: c.Guest, Gateway: c.Gateway, MTU: c.MTU}
+}
+
+// WriteTo serialises c for the helper process.
+func (c *Config) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	err := gob.NewEncoder(cw).Encode(c)
+	return cw.n, err
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return
+}
+
+func (c *Config) valid() bool {
+	return c != nil && c.Guest.IsValid() && c.Guest.Addr().Is4() &&
+		c.Gateway.Is4() && c.Guest.Contains(c.Gateway) && c.Gateway != c.Guest.Addr() &&
+		c.DNS.Is4() && c.Guest.Contains(c.DNS) && c.DNS != c.Guest.Addr() &&
+		c.MTU >= 576 && c.MTU <= 65535 &&
+		(c.Proxy == "" || !c.HostLoopback && !c.Restrict)
+}
+
+// hostNameserver returns the address of the first nameserver in host resolv.conf.
+func hostNameserver() (string, error) {
+	f, err := os.Open("/etc/resolv.conf")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "nameserver" {
+			continue
+		}
+		if a, err := netip.ParseAddr(fields[1]); err == nil && a.Is4() {
+			return netip.AddrPortFrom(a, 53).String(), nil
+		}
+	}
+	if err = s.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("no IPv4 nameserver in resolv.conf")
+}
+
+// flowKey identifies a TCP connection or UDP flow originating from the container.
+type flowKey struct{ src, dst netip.AddrPort }
+
+// Stack terminates IPv4 traffic of a TUN device and relays its payload over host sockets.
+type Stack struct {
+	// Dial establishes outbound connections, [net.Dialer.DialContext] if nil.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+
+	c   *Config
+	tun io.ReadWriter
+	// serialises writes to tun
+	wmu sync.Mutex
+	// ipv4 identification field of the next outgoing packet
+	id atomic.Uint32
+
+	mu  sync.Mutex
+	tcp map[flowKey]*tcpConn
+	udp map[flowKey]*udpFlow
+	// expiry of addresses learned from DNS responses, per index into [Config.Allow]
+	learned map[netip.Addr]map[int]time.Time
+
+	ctx context.Context
+}
+
+// New returns the address of a new [Stack] serving packets on tun.
+func New(c *Config, tun io.ReadWriter) (*Stack, error) {
+	if !c.valid() {
+		return nil, msg.WrapErr(os.ErrInvalid, "invalid user-mode networking configuration")
+	}
+	return &Stack{c: c, tun: tun,
+		tcp:     make(map[flowKey]*tcpConn),
+		udp:     make(map[flowKey]*udpFlow),
+		learned: make(map[netip.Addr]map[int]time.Time),
+	}, nil
+}
+
+// Serve relays packets until ctx is done or tun is closed.
+func (s *Stack) Serve(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.ctx = ctx
+
+	go s.retransmit(ctx)
+
+	buf := make([]byte, s.c.MTU)
+	for {
+		n, err := s.tun.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, os.ErrClosed) || errors.Is(err, io.EOF) {
+				s.closeAll()
+				return nil
+			}
+			s.closeAll()
+			return wrapErrSuffix(err,
+				"cannot read from tun device:")
+		}
+		s.handle(buf[:n])
+	}
+}
+
+// handle processes a single packet from the container.
+func (s *Stack) handle(p []byte) {
+	h, payload, ok := parseIPv4(p)
+	if !ok || h.src != s.c.Guest.Addr() {
+		return
+	}
+
+	switch h.proto {
+	case protoICMP:
+		s.handleICMP(h, payload)
+	case protoTCP:
+		s.handleTCP(h, payload)
+	case protoUDP:
+		s.handleUDP(h, payload)
+	}
+}
+
+// upstream returns the host address traffic to dst is relayed to, must be called with mu held.
+func (s *Stack) upstream(dst netip.AddrPort, network string) (string, string, bool) {
+	a := dst.Addr()
+	if s.c.Proxy != "" {
+		if network != "tcp" || a != s.c.Gateway || dst.Port() != ProxyPort {
+			return "", "", false
+		}
+		if path.IsAbs(s.c.Proxy) {
+			return "unix", s.c.Proxy, true
+		}
+		return network, s.c.Proxy, true
+	}
+
+	var address netip.AddrPort
+	switch {
+	case a == s.c.DNS:
+		if dst.Port() != 53 {
+			return "", "", false
+		}
+		if s.c.Nameserver != "" {
+			return network, s.c.Nameserver, true
+		}
+		ns, err := hostNameserver()
+		if err != nil {
+			msg.Verbosef("cannot resolve upstream nameserver for %s: %v", network, err)
+			return "", "", false
+		}
+		return network, ns, true
+	case a == s.c.Gateway:
+		if !s.c.HostLoopback {
+			return "", "", false
+		}
+		address = netip.AddrPortFrom(hostLoopback, dst.Port())
+	case s.c.Guest.Contains(a), !a.IsGlobalUnicast():
+		return "", "", false
+	default:
+		address = dst
+	}
+
+	if s.c.Restrict && !s.permit(address) {
+		msg.Verbosef("denied %s connection to %s", network, address)
+		return "", "", false
+	}
+	return network, address.String(), true
+}
+
+func (s *Stack) dial(network, address string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, dialTimeout)
+	defer cancel()
+	if s.Dial != nil {
+		return s.Dial(ctx, network, address)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, network, address)
+}
+
+// write sends an IPv4 packet carrying payload to the container.
+func (s *Stack) write(src, dst netip.Addr, proto byte, payload []byte) {
+	p := buildIPv4(src, dst, proto, uint16(s.id.Add(1)), payload)
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+	if _, err := s.tun.Write(p); err != nil && s.ctx.Err() == nil {
+		msg.Verbosef("cannot write to tun device: %v", err)
+	}
+}
+
+func (s *Stack) closeAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for k, c := range s.tcp {
+		c.abort()
+		delete(s.tcp, k)
+	}
+	for k, f := range s.udp {
+		_ = f.conn.Close()
+		delete(s.udp, k)
+	}
+}
+
+// handleICMP answers echo requests addressed to the virtual gateway and DNS forwarder.
+func (s *Stack) handleICMP(h *ipv4Header, p []byte) {
+	if h.dst != s.c.Gateway && h.dst != s.c.DNS {
+		return
+	}
+	// echo request with at least type, code, checksum, identifier and sequence number
+	if len(p) < 8 || p[0] != 8 || p[1] != 0 || checksum(p, 0) != 0 {
+		return
+	}
+	r := make([]byte, len(p))
+	copy(r, p)
+	r[0] = 0
+	r[2], r[3] = 0, 0
+	c := checksum(r, 0)
+	r[2], r[3] = byte(c>>8), byte(c)
+	s.write(h.dst, h.src, protoICMP, r)
+}
@@ -0,0 +1,372 @@
+package usernet
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// guest drives a [Stack] over a SOCK_SEQPACKET socket in place of a TUN device.
+type guest struct {
+	t   *testing.T
+	f   *os.File
+	id  uint16
+	buf []byte
+}
+
+func newTestStack(t *testing.T, c *Config) *guest {
+	fd, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET|syscall.SOCK_NONBLOCK|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		t.Fatalf("Socketpair: %v", err)
+	}
+	tun, g := os.NewFile(uintptr(fd[0]), "tun"), os.NewFile(uintptr(fd[1]), "guest")
+
+	s, err := New(c, tun)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Serve(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		_ = tun.Close()
+		if err := <-done; err != nil {
+			t.Errorf("Serve: %v", err)
+		}
+		_ = g.Close()
+	})
+	return &guest{t: t, f: g, buf: make([]byte, c.MTU)}
+}
+
+func (g *guest) send(dst netip.Addr, proto byte, payload []byte) {
+	g.id++
+	if _, err := g.f.Write(buildIPv4(DefaultGuest.Addr(), dst, proto, g.id, payload)); err != nil {
+		g.t.Fatalf("cannot write packet: %v", err)
+	}
+}
+
+func (g *guest) recv(want byte) (*ipv4Header, []byte) {
+	for {
+		if err := g.f.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+			g.t.Fatalf("SetReadDeadline: %v", err)
+		}
+		n, err := g.f.Read(g.buf)
+		if err != nil {
+			g.t.Fatalf("cannot read packet: %v", err)
+		}
+		h, payload, ok := parseIPv4(g.buf[:n])
+		if !ok {
+			g.t.Fatalf("invalid packet %x", g.buf[:n])
+		}
+		if h.dst != DefaultGuest.Addr() {
+			g.t.Fatalf("packet addressed to %s", h.dst)
+		}
+		if h.proto == want {
+			return h, append([]byte(nil), payload...)
+		}
+	}
+}
+
+func (g *guest) sendTCP(src, dst netip.AddrPort, seq, ack uint32, flags byte, payload []byte) {
+	mss := 0
+	if flags&tcpSYN != 0 {
+		mss = DefaultMTU - ipv4HeaderLen - tcpHeaderLen
+	}
+	g.send(dst.Addr(), protoTCP, buildTCP(src, dst, seq, ack, flags, tcpWindow, mss, payload))
+}
+
+func (g *guest) recvTCP() *tcpSegment {
+	h, p := g.recv(protoTCP)
+	seg, ok := parseTCP(h, p)
+	if !ok {
+		g.t.Fatalf("invalid segment %x", p)
+	}
+	return seg
+}
+
+func echoServer(t *testing.T) (tcp, udp netip.AddrPort) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() { _, _ = io.Copy(conn, conn); _ = conn.Close() }()
+		}
+	}()
+
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket: %v", err)
+	}
+	t.Cleanup(func() { _ = pc.Close() })
+	go func() {
+		buf := make([]byte, 1<<16)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = pc.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	return l.Addr().(*net.TCPAddr).AddrPort(), pc.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func TestTCP(t *testing.T) {
+	addr, _ := echoServer(t)
+	c := NewConfig()
+	c.HostLoopback = true
+	g := newTestStack(t, c)
+
+	src := netip.AddrPortFrom(DefaultGuest.Addr(), 40000)
+	dst := netip.AddrPortFrom(DefaultGateway, addr.Port())
+	const isn = 0xfffffff0 // wraps around during the exchange
+
+	g.sendTCP(src, dst, isn, 0, tcpSYN, nil)
+	seg := g.recvTCP()
+	if seg.flags != tcpSYN|tcpACK || seg.ack != isn+1 || seg.src != dst || seg.dst != src {
+		t.Fatalf("handshake: flags %#x ack %d, src %s dst %s", seg.flags, seg.ack, seg.src, seg.dst)
+	}
+	if seg.mss != DefaultMTU-ipv4HeaderLen-tcpHeaderLen {
+		t.Errorf("mss: %d", seg.mss)
+	}
+	rcvNxt, sndNxt := seg.seq+1, uint32(isn+1)
+
+	want := bytes.Repeat([]byte("fortify usernet "), 1<<8)
+	for p := want; len(p) > 0; {
+		n := min(len(p), 1<<10)
+		g.sendTCP(src, dst, sndNxt, rcvNxt, tcpACK|tcpPSH, p[:n])
+		sndNxt += uint32(n)
+		p = p[n:]
+	}
+
+	got := make([]byte, 0, len(want))
+	for len(got) < len(want) {
+		seg = g.recvTCP()
+		if seg.flags&(tcpRST|tcpSYN|tcpFIN) != 0 {
+			t.Fatalf("unexpected flags %#x", seg.flags)
+		}
+		if len(seg.payload) == 0 {
+			continue
+		}
+		if seg.seq != rcvNxt {
+			t.Fatalf("seq: %d, want %d", seg.seq, rcvNxt)
+		}
+		got = append(got, seg.payload...)
+		rcvNxt += uint32(len(seg.payload))
+		g.sendTCP(src, dst, sndNxt, rcvNxt, tcpACK, nil)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("echo: %q, want %q", got, want)
+	}
+
+	// echo server closes its end after receiving FIN
+	g.sendTCP(src, dst, sndNxt, rcvNxt, tcpFIN|tcpACK, nil)
+	sndNxt++
+	for {
+		seg = g.recvTCP()
+		if seg.flags&tcpFIN != 0 {
+			break
+		}
+		if seg.flags&tcpRST != 0 {
+			t.Fatalf("unexpected reset")
+		}
+	}
+	if seg.ack != sndNxt {
+		t.Errorf("FIN ack: %d, want %d", seg.ack, sndNxt)
+	}
+	g.sendTCP(src, dst, sndNxt, seg.seq+1, tcpACK, nil)
+}
+
+func TestTCPMSS(t *testing.T) {
+	src := netip.AddrPortFrom(DefaultGuest.Addr(), 40000)
+	dst := netip.AddrPortFrom(DefaultGateway, 80)
+	h := &ipv4Header{src: src.Addr(), dst: dst.Addr(), proto: protoTCP}
+
+	testCases := []struct {
+		name string
+		mss  int
+		want int
+	}{
+		{"absent", 0, tcpDefaultMSS},
+		{"zero", -1, tcpMinMSS},
+		{"tiny", 1, tcpMinMSS},
+		{"ethernet", 1460, 1460},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var p []byte
+			if tc.mss < 0 {
+				p = buildTCP(src, dst, 0, 0, tcpSYN, tcpWindow, 1, nil)
+				p[16], p[17], p[22], p[23] = 0, 0, 0, 0
+				binary.BigEndian.PutUint16(p[16:], checksum(p, pseudoSum(src.Addr(), dst.Addr(), protoTCP, len(p))))
+			} else {
+				p = buildTCP(src, dst, 0, 0, tcpSYN, tcpWindow, tc.mss, nil)
+			}
+			if seg, ok := parseTCP(h, p); !ok {
+				t.Fatalf("parseTCP: invalid segment %x", p)
+			} else if seg.mss != tc.want {
+				t.Errorf("parseTCP: mss = %d, want %d", seg.mss, tc.want)
+			}
+		})
+	}
+}
+
+func TestTCPReset(t *testing.T) {
+	// obtain a port with nothing listening on it
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	port := l.Addr().(*net.TCPAddr).AddrPort().Port()
+	if err = l.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	testCases := []struct {
+		name         string
+		hostLoopback bool
+		allow        []Rule
+		flags        byte
+	}{
+		{"refused", true, nil, tcpSYN},
+		{"gateway", false, nil, tcpSYN},
+		{"unknown", true, nil, tcpACK},
+		{"denied", true, []Rule{{Prefix: netip.MustParsePrefix("127.0.0.1/32"), Port: port + 1}}, tcpSYN},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewConfig()
+			c.HostLoopback = tc.hostLoopback
+			c.Restrict = tc.allow != nil
+			c.Allow = tc.allow
+			g := newTestStack(t, c)
+
+			src := netip.AddrPortFrom(DefaultGuest.Addr(), 40001)
+			g.sendTCP(src, netip.AddrPortFrom(DefaultGateway, port), 1, 1, tc.flags, nil)
+			if seg := g.recvTCP(); seg.flags&tcpRST == 0 {
+				t.Errorf("flags: %#x", seg.flags)
+			}
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+	name := path.Join(t.TempDir(), "proxy")
+	l, err := net.Listen("unix", name)
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			_, _ = conn.Write([]byte("proxy"))
+			_ = conn.Close()
+		}
+	}()
+
+	c := NewConfig()
+	c.Proxy = name
+	g := newTestStack(t, c)
+
+	src := netip.AddrPortFrom(DefaultGuest.Addr(), 40003)
+	t.Run("denied", func(t *testing.T) {
+		g.sendTCP(src, netip.AddrPortFrom(DefaultGateway, ProxyPort+1), 1, 0, tcpSYN, nil)
+		if seg := g.recvTCP(); seg.flags&tcpRST == 0 {
+			t.Errorf("flags: %#x", seg.flags)
+		}
+	})
+
+	dst := netip.AddrPortFrom(DefaultGateway, ProxyPort)
+	g.sendTCP(src, dst, 1, 0, tcpSYN, nil)
+	seg := g.recvTCP()
+	if seg.flags != tcpSYN|tcpACK {
+		t.Fatalf("handshake: flags %#x", seg.flags)
+	}
+	g.sendTCP(src, dst, 2, seg.seq+1, tcpACK, nil)
+	for seg = g.recvTCP(); len(seg.payload) == 0; seg = g.recvTCP() {
+	}
+	if string(seg.payload) != "proxy" {
+		t.Errorf("payload: %q", seg.payload)
+	}
+}
+
+func TestUDP(t *testing.T) {
+	_, addr := echoServer(t)
+	c := NewConfig()
+	c.HostLoopback = true
+	g := newTestStack(t, c)
+
+	src := netip.AddrPortFrom(DefaultGuest.Addr(), 40002)
+	dst := netip.AddrPortFrom(DefaultGateway, addr.Port())
+	for i := 0; i < 4; i++ {
+		want := []byte("datagram " + strconv.Itoa(i))
+		g.send(DefaultGateway, protoUDP, buildUDP(src, dst, want))
+
+		h, p := g.recv(protoUDP)
+		if gotSrc, gotDst, got, ok := parseUDP(h, p); !ok {
+			t.Fatalf("invalid datagram %x", p)
+		} else if gotSrc != dst || gotDst != src || !bytes.Equal(got, want) {
+			t.Errorf("datagram: %s -> %s %q, want %s -> %s %q", gotSrc, gotDst, got, dst, src, want)
+		}
+	}
+}
+
+func TestICMP(t *testing.T) {
+	g := newTestStack(t, NewConfig())
+
+	req := []byte{8, 0, 0, 0, 0xf0, 0x0f, 0, 1, 'p', 'i', 'n', 'g'}
+	c := checksum(req, 0)
+	req[2], req[3] = byte(c>>8), byte(c)
+	g.send(DefaultDNS, protoICMP, req)
+
+	h, p := g.recv(protoICMP)
+	if h.src != DefaultDNS || p[0] != 0 || checksum(p, 0) != 0 || !bytes.Equal(p[4:], req[4:]) {
+		t.Errorf("reply: %s %x", h.src, p)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	testCases := []struct {
+		name string
+		f    func(c *Config)
+		want bool
+	}{
+		{"default", func(*Config) {}, true},
+		{"gateway outside", func(c *Config) { c.Gateway = netip.MustParseAddr("10.0.3.2") }, false},
+		{"dns is guest", func(c *Config) { c.DNS = c.Guest.Addr() }, false},
+		{"ipv6", func(c *Config) { c.Guest = netip.MustParsePrefix("fd00::100/64") }, false},
+		{"mtu", func(c *Config) { c.MTU = 1 << 8 }, false},
+		{"proxy", func(c *Config) { c.Proxy = "/run/proxy" }, true},
+		{"proxy loopback", func(c *Config) { c.Proxy = "/run/proxy"; c.HostLoopback = true }, false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewConfig()
+			tc.f(c)
+			if _, err := New(c, nil); (err == nil) != tc.want {
+				t.Errorf("New: error = %v, want valid %v", err, tc.want)
+			} else if err != nil && !errors.Is(err, os.ErrInvalid) {
+				t.Errorf("New: error = %v", err)
+			}
+		})
+	}
+}
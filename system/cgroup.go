@@ -0,0 +1,140 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// cgroupRemoveAttempts is the maximum number of attempts at removing a cgroup with exiting processes.
+	cgroupRemoveAttempts = 1 << 5
+	// cgroupRemoveInterval is the time to wait between attempts at removing a cgroup.
+	cgroupRemoveInterval = 1 << 4 * time.Millisecond
+)
+
+// Cgroup registers an Op that creates cgroup v2 directory name through the life of et,
+// enables controllers required by interface files in its parent, and writes values to interface files.
+func (sys *I) Cgroup(et Enablement, name string, files [][2]string) *I {
+	sys.lock.Lock()
+	defer sys.lock.Unlock()
+
+	sys.ops = append(sys.ops, &Cgroup{et, name, files})
+
+	return sys
+}
+
+type Cgroup struct {
+	et    Enablement
+	path  string
+	files [][2]string
+}
+
+func (c *Cgroup) Type() Enablement { return c.et }
+
+func (c *Cgroup) apply(*I) error {
+	msg.Verbose("creating cgroup", c)
+
+	parent := path.Dir(c.path)
+	if p, err := os.ReadFile(path.Join(parent, "cgroup.subtree_control")); err != nil {
+		return wrapErrSuffix(err,
+			fmt.Sprintf("cannot read controllers of %q:", parent))
+	} else {
+		enabled := strings.Fields(string(p))
+		var enable []string
+		for _, controller := range c.controllers() {
+			if !slices.Contains(enabled, controller) {
+				enable = append(enable, "+"+controller)
+			}
+		}
+		if len(enable) > 0 {
+			msg.Verbosef("enabling controllers %s in %q", strings.Join(enable, " "), parent)
+			if err = writeCgroupFile(path.Join(parent, "cgroup.subtree_control"),
+				strings.Join(enable, " ")); err != nil {
+				return wrapErrSuffix(err,
+					fmt.Sprintf("cannot enable controllers in %q:", parent))
+			}
+		}
+	}
+
+	if err := os.Mkdir(c.path, 0755); err != nil {
+		return wrapErrSuffix(err,
+			fmt.Sprintf("cannot create cgroup %q:", c.path))
+	}
+	for _, pair := range c.files {
+		msg.Verbosef("writing %q to %s", pair[1], pair[0])
+		if err := writeCgroupFile(path.Join(c.path, pair[0]), pair[1]); err != nil {
+			return wrapErrSuffix(err,
+				fmt.Sprintf("cannot write %s of %q:", pair[0], c.path))
+		}
+	}
+	return nil
+}
+
+func (c *Cgroup) revert(_ *I, ec *Criteria) error {
+	if !ec.hasType(c) {
+		msg.Verbose("skipping cgroup", c)
+		return nil
+	}
+
+	msg.Verbose("removing cgroup", c)
+	// cgroup.kill is not available prior to Linux 5.14
+	if err := writeCgroupFile(path.Join(c.path, "cgroup.kill"), "1"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return wrapErrSuffix(err,
+			fmt.Sprintf("cannot kill processes in cgroup %q:", c.path))
+	}
+
+	// processes killed above might not have exited yet
+	var err error
+	for i := 0; i < cgroupRemoveAttempts; i++ {
+		if err = os.Remove(c.path); !errors.Is(err, syscall.EBUSY) {
+			break
+		}
+		time.Sleep(cgroupRemoveInterval)
+	}
+	return wrapErrSuffix(err,
+		fmt.Sprintf("cannot remove cgroup %q:", c.path))
+}
+
+// writeCgroupFile writes v to an existing cgroup interface file.
+func writeCgroupFile(name, v string) error {
+	f, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(v)
+	return errors.Join(err, f.Close())
+}
+
+// controllers returns controllers required by interface files, in order of appearance.
+func (c *Cgroup) controllers() []string {
+	controllers := make([]string, 0, len(c.files))
+	for _, pair := range c.files {
+		if controller, _, ok := strings.Cut(pair[0], "."); ok && !slices.Contains(controllers, controller) {
+			controllers = append(controllers, controller)
+		}
+	}
+	return controllers
+}
+
+func (c *Cgroup) Is(o Op) bool {
+	c0, ok := o.(*Cgroup)
+	return ok && c0 != nil &&
+		c.et == c0.et && c.path == c0.path &&
+		slices.Equal(c.files, c0.files)
+}
+
+func (c *Cgroup) Path() string { return c.path }
+
+func (c *Cgroup) String() string {
+	files := make([]string, len(c.files))
+	for i, pair := range c.files {
+		files[i] = pair[0] + "=" + pair[1]
+	}
+	return fmt.Sprintf("%q %s", c.path, strings.Join(files, " "))
+}
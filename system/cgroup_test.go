@@ -0,0 +1,47 @@
+package system
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestCgroup(t *testing.T) {
+	testCases := []struct {
+		name  string
+		files [][2]string
+		want  []string
+	}{
+		{"none", nil, []string{}},
+		{"memory", [][2]string{{"memory.max", "1073741824"}}, []string{"memory"}},
+		{"all", [][2]string{
+			{"memory.max", "1073741824"},
+			{"pids.max", "512"},
+			{"cpu.weight", "50"},
+			{"io.weight", "default 50"},
+		}, []string{"memory", "pids", "cpu", "io"}},
+		{"duplicate", [][2]string{
+			{"memory.high", "536870912"},
+			{"memory.max", "1073741824"},
+		}, []string{"memory"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sys := New(150)
+			name := "/sys/fs/cgroup/user.slice/fortify.ec07546a772a07cde87389afc84ffd13"
+			sys.Cgroup(Process, name, tc.files)
+			(&tcOp{Process, name}).test(t, sys.ops, []Op{&Cgroup{Process, name, tc.files}}, "Cgroup")
+
+			if got := sys.ops[0].(*Cgroup).controllers(); !slices.Equal(got, tc.want) {
+				t.Errorf("controllers: %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCgroupString(t *testing.T) {
+	c := &Cgroup{Process, "/sys/fs/cgroup/fortify.0", [][2]string{{"pids.max", "512"}, {"cpu.weight", "50"}}}
+	want := `"/sys/fs/cgroup/fortify.0" pids.max=512 cpu.weight=50`
+	if got := c.String(); got != want {
+		t.Errorf("String: %s, want %s", got, want)
+	}
+}
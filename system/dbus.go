@@ -3,8 +3,11 @@ package system
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
+	"os"
 	"strings"
 	"sync"
 	"syscall"
@@ -25,7 +28,27 @@ func (sys *I) MustProxyDBus(sessionPath string, session *dbus.Config, systemPath
 }
 
 func (sys *I) ProxyDBus(session, system *dbus.Config, sessionPath, systemPath string) (func(), error) {
-	d := new(DBus)
+	return sys.proxyDBus(session, system, sessionPath, systemPath, "")
+}
+
+// LearnDBus is like ProxyDBus, but the proxies permit all traffic, and a configuration
+// permitting the traffic observed by them is written to pathname on revert.
+func (sys *I) LearnDBus(session, system *dbus.Config, sessionPath, systemPath, pathname string) (func(), error) {
+	if session != nil {
+		v := *session
+		v.Learn = true
+		session = &v
+	}
+	if system != nil {
+		v := *system
+		v.Learn = true
+		system = &v
+	}
+	return sys.proxyDBus(session, system, sessionPath, systemPath, pathname)
+}
+
+func (sys *I) proxyDBus(session, system *dbus.Config, sessionPath, systemPath, learn string) (func(), error) {
+	d := &DBus{learn: learn}
 
 	// session bus is required as otherwise this is effectively a very expensive noop
 	if session == nil {
@@ -69,6 +92,8 @@ type DBus struct {
 	out   *scanToFmsg
 	// whether system bus proxy is enabled
 	system bool
+	// path to write the learned configuration to, empty outside learn mode
+	learn string
 
 	sessionBus, systemBus dbus.ProxyPair
 }
@@ -101,7 +126,38 @@ func (d *DBus) revert(*I, *Criteria) error {
 		msg.Verbose("message bus proxy canceled upstream")
 		err = nil
 	}
-	return wrapErrSuffix(err, "message bus proxy error:")
+	if err != nil {
+		return wrapErrSuffix(err, "message bus proxy error:")
+	}
+
+	if d.learn != "" {
+		return d.writeLearned()
+	}
+	return nil
+}
+
+// learnedConfig is the serialised form of configurations learned by the message bus proxy,
+// field names match those of the app configuration.
+type learnedConfig struct {
+	SessionBus *dbus.Config `json:"session_bus,omitempty"`
+	SystemBus  *dbus.Config `json:"system_bus,omitempty"`
+}
+
+func (d *DBus) writeLearned() error {
+	var v learnedConfig
+	if session, system, err := d.proxy.Learned(); err != nil {
+		return wrapErrSuffix(err, "cannot read learned message bus proxy configuration:")
+	} else {
+		v.SessionBus, v.SystemBus = session, system
+	}
+
+	if data, err := json.MarshalIndent(&v, "", "  "); err != nil {
+		return wrapErrSuffix(err, "cannot serialise learned message bus proxy configuration:")
+	} else if err = os.WriteFile(d.learn, append(data, '\n'), 0644); err != nil {
+		return wrapErrSuffix(err, "cannot write learned message bus proxy configuration:")
+	}
+	msg.Verbosef("learned message bus proxy configuration written to %q", d.learn)
+	return nil
 }
 
 func (d *DBus) Is(o Op) bool {
@@ -116,6 +172,13 @@ func (d *DBus) Path() string {
 }
 
 func (d *DBus) String() string {
+	if d.proxy == nil {
+		// not yet applied
+		if d.system {
+			return fmt.Sprintf("session bus proxy on %q, system bus proxy on %q", d.sessionBus[1], d.systemBus[1])
+		}
+		return fmt.Sprintf("session bus proxy on %q", d.sessionBus[1])
+	}
 	return d.proxy.String()
 }
 
@@ -13,6 +13,7 @@ const (
 	EX11
 	EDBus
 	EPulse
+	EPipeWire
 
 	EM
 )
@@ -29,6 +30,8 @@ func (e Enablement) String() string {
 		return "dbus"
 	case EPulse:
 		return "pulseaudio"
+	case EPipeWire:
+		return "pipewire"
 	default:
 		buf := new(strings.Builder)
 		buf.Grow(32)
@@ -27,8 +27,10 @@ func TestEnablementString(t *testing.T) {
 		{system.EWayland | system.EDBus | system.EPulse, "wayland, dbus, pulseaudio"},
 		{system.EX11 | system.EDBus | system.EPulse, "x11, dbus, pulseaudio"},
 		{system.EWayland | system.EX11 | system.EDBus | system.EPulse, "wayland, x11, dbus, pulseaudio"},
+		{system.EPipeWire, "pipewire"},
+		{system.EPulse | system.EPipeWire, "pulseaudio, pipewire"},
+		{system.EWayland | system.EX11 | system.EDBus | system.EPulse | system.EPipeWire, "wayland, x11, dbus, pulseaudio, pipewire"},
 
-		{1 << 5, "e20"},
 		{1 << 6, "e40"},
 		{1 << 7, "e80"},
 	}
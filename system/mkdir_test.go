@@ -55,6 +55,7 @@ func TestMkdirString(t *testing.T) {
 		{"x11", true, EX11},
 		{"dbus", true, EDBus},
 		{"pulseaudio", true, EPulse},
+		{"pipewire", true, EPipeWire},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.want, func(t *testing.T) {
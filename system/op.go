@@ -5,6 +5,7 @@ import (
 	"context"
 	"errors"
 	"log"
+	"slices"
 	"strings"
 	"sync"
 )
@@ -89,6 +90,39 @@ type I struct {
 
 func (sys *I) UID() int { return sys.uid }
 
+// Ops returns a copy of all [Op] held by [I] in the order they are applied.
+func (sys *I) Ops() []Op {
+	sys.lock.Lock()
+	defer sys.lock.Unlock()
+	return slices.Clone(sys.ops)
+}
+
+// OpName returns a short name describing the kind of o.
+func OpName(o Op) string {
+	switch o.(type) {
+	case *ACL:
+		return "acl"
+	case *Cgroup:
+		return "cgroup"
+	case *DBus:
+		return "dbus"
+	case *Hardlink:
+		return "link"
+	case *Mkdir:
+		return "mkdir"
+	case *PipeWire:
+		return "pipewire"
+	case *Tmpfile:
+		return "tmpfile"
+	case *Wayland:
+		return "wayland"
+	case XHost:
+		return "xhost"
+	default:
+		return "unknown"
+	}
+}
+
 // Equal returns whether all [Op] instances held by v is identical to that of sys.
 func (sys *I) Equal(v *I) bool {
 	if v == nil || sys.uid != v.uid || len(sys.ops) != len(v.ops) {
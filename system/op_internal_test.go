@@ -49,8 +49,8 @@ func (ptc tcOp) test(t *testing.T, gotOps []Op, wantOps []Op, fn string) {
 			}{
 				{"nil", nil, ptc.et != User},
 				{"self", newCriteria(ptc.et), true},
-				{"all", newCriteria(EWayland | EX11 | EDBus | EPulse | User | Process), true},
-				{"enablements", newCriteria(EWayland | EX11 | EDBus | EPulse), ptc.et != User && ptc.et != Process},
+				{"all", newCriteria(EWayland | EX11 | EDBus | EPulse | EPipeWire | User | Process), true},
+				{"enablements", newCriteria(EWayland | EX11 | EDBus | EPulse | EPipeWire), ptc.et != User && ptc.et != Process},
 			}
 
 			for _, tc := range testCases {
@@ -37,11 +37,13 @@ func TestTypeString(t *testing.T) {
 		{system.EX11, system.EX11.String()},
 		{system.EDBus, system.EDBus.String()},
 		{system.EPulse, system.EPulse.String()},
+		{system.EPipeWire, system.EPipeWire.String()},
 		{system.User, "user"},
 		{system.Process, "process"},
 		{system.User | system.Process, "user, process"},
 		{system.EWayland | system.User | system.Process, "wayland, user, process"},
 		{system.EX11 | system.Process, "x11, process"},
+		{system.EPipeWire | system.Process, "pipewire, process"},
 	}
 
 	for _, tc := range testCases {
@@ -54,6 +56,26 @@ func TestTypeString(t *testing.T) {
 	}
 }
 
+func TestOpName(t *testing.T) {
+	testCases := []struct {
+		op   system.Op
+		want string
+	}{
+		{new(system.Hardlink), "link"},
+		{new(system.PipeWire), "pipewire"},
+		{new(system.Wayland), "wayland"},
+		{system.XHost("chronos"), "xhost"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.want, func(t *testing.T) {
+			if got := system.OpName(tc.op); got != tc.want {
+				t.Errorf("OpName: %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
 func TestI_Equal(t *testing.T) {
 	testCases := []struct {
 		name string
@@ -0,0 +1,66 @@
+package system
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"git.gensokyo.uk/security/fortify/acl"
+)
+
+// PipeWire appends an Op exposing the PipeWire socket at src as dst.
+func (sys *I) PipeWire(dst, src string) *I {
+	sys.lock.Lock()
+	defer sys.lock.Unlock()
+
+	sys.ops = append(sys.ops, &PipeWire{dst, src})
+
+	return sys
+}
+
+// PipeWire hard links the PipeWire socket into a process-specific directory and grants access to it.
+// The link is always removed on revert, while the ACL entry is only stripped once no instance
+// with the PipeWire enablement remains.
+type PipeWire struct {
+	dst, src string
+}
+
+func (p *PipeWire) Type() Enablement { return EPipeWire }
+
+func (p *PipeWire) apply(sys *I) error {
+	msg.Verbose("linking pipewire socket", p)
+	if err := os.Link(p.src, p.dst); err != nil {
+		return wrapErrSuffix(err,
+			fmt.Sprintf("cannot link pipewire socket %q:", p.src))
+	}
+	return wrapErrSuffix(acl.Update(p.src, sys.uid, acl.Read, acl.Write),
+		fmt.Sprintf("cannot apply ACL entry to %q:", p.src))
+}
+
+func (p *PipeWire) revert(sys *I, ec *Criteria) error {
+	msg.Verbosef("removing pipewire socket link %q", p.dst)
+	var errs [2]error
+	if err := os.Remove(p.dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs[0] = wrapErrSuffix(err,
+			fmt.Sprintf("cannot remove pipewire socket link %q:", p.dst))
+	}
+
+	if ec.hasType(p) {
+		msg.Verbosef("stripping ACL entry from %q", p.src)
+		err := acl.Update(p.src, sys.uid)
+		if errors.Is(err, os.ErrNotExist) {
+			// the ACL is effectively stripped if the socket no longer exists
+			msg.Verbosef("pipewire socket %q no longer exists", p.src)
+			err = nil
+		}
+		errs[1] = wrapErrSuffix(err,
+			fmt.Sprintf("cannot strip ACL entry from %q:", p.src))
+	} else {
+		msg.Verbosef("skipping ACL entry on %q", p.src)
+	}
+	return errors.Join(errs[:]...)
+}
+
+func (p *PipeWire) Is(o Op) bool   { p0, ok := o.(*PipeWire); return ok && p0 != nil && *p == *p0 }
+func (p *PipeWire) Path() string   { return p.src }
+func (p *PipeWire) String() string { return fmt.Sprintf("%q from %q", p.dst, p.src) }
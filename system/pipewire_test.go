@@ -0,0 +1,32 @@
+package system
+
+import (
+	"testing"
+)
+
+func TestPipeWire(t *testing.T) {
+	testCases := []struct {
+		dst, src string
+	}{
+		{"/run/user/1971/fortify/fcb8a12f7c482d183ade8288c3de78b5/pipewire", "/run/user/1971/pipewire-0"},
+		{"/run/user/1971/fortify/fcb8a12f7c482d183ade8288c3de78b5/pipewire", "/run/pipewire/pipewire-0"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			sys := New(150)
+			sys.PipeWire(tc.dst, tc.src)
+			(&tcOp{EPipeWire, tc.src}).test(t, sys.ops, []Op{&PipeWire{tc.dst, tc.src}}, "PipeWire")
+		})
+	}
+}
+
+func TestPipeWireString(t *testing.T) {
+	want := `"/run/user/1971/fortify/fcb8a12f7c482d183ade8288c3de78b5/pipewire" from "/run/user/1971/pipewire-0"`
+	if got := (&PipeWire{
+		"/run/user/1971/fortify/fcb8a12f7c482d183ade8288c3de78b5/pipewire",
+		"/run/user/1971/pipewire-0",
+	}).String(); got != want {
+		t.Errorf("String: %q, want %q", got, want)
+	}
+}
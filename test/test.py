@@ -153,7 +153,7 @@ fortify('-v run --wayland --dbus notify-send -a "NixOS Tests" "Test notification
 machine.wait_for_file("/tmp/dbus-ok", timeout=15)
 collect_state_ui("dbus_notify_exited")
 # not in pid namespace, verify termination
-machine.wait_until_fails("pgrep xdg-dbus-proxy")
+machine.wait_until_fails("pgrep -f 'fortify[ ]dbus'")
 machine.succeed("pkill -9 mako")
 
 # Check revert type selection: